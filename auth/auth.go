@@ -0,0 +1,188 @@
+// Package auth authenticates requests to ollama.com the way the ollama CLI does: with an
+// API key from OLLAMA_API_KEY, or by signing each request with ~/.ollama/id_ed25519.
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoKey is returned when a request needs authentication but no key is configured.
+var ErrNoKey = errors.New("no Ollama key found: set OLLAMA_API_KEY or create ~/.ollama/id_ed25519 (started once by `ollama serve`)")
+
+// Authenticator adds credentials to requests for hosts that need them.
+type Authenticator struct {
+	// APIKey is sent as a bearer token when set.
+	APIKey string
+	// KeyPath is the OpenSSH ed25519 private key used to sign requests otherwise.
+	KeyPath string
+	// Always authenticates every request, not just those to ollama.com.
+	Always bool
+
+	now func() time.Time
+}
+
+// FromEnv returns an Authenticator using OLLAMA_API_KEY and the default key location.
+func FromEnv() *Authenticator {
+	a := &Authenticator{APIKey: os.Getenv("OLLAMA_API_KEY")}
+	if home, err := os.UserHomeDir(); err == nil {
+		a.KeyPath = filepath.Join(home, ".ollama", "id_ed25519")
+	}
+	return a
+}
+
+// Authorize adds an Authorization header to req if its host requires one.
+func (a *Authenticator) Authorize(req *http.Request) error {
+	if !a.Always && !isOllamaCom(req.URL.Hostname()) {
+		return nil
+	}
+	if a.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.APIKey)
+		return nil
+	}
+
+	key, err := a.loadKey()
+	if err != nil {
+		return err
+	}
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+	ts := strconv.FormatInt(now().Unix(), 10)
+	q := req.URL.Query()
+	q.Set("ts", ts)
+	req.URL.RawQuery = q.Encode()
+
+	challenge := fmt.Sprintf("%s,%s?ts=%s", req.Method, req.URL.Path, ts)
+	req.Header.Set("Authorization", Sign(key, []byte(challenge)))
+	return nil
+}
+
+// Sign returns the "<public key>:<signature>" token Ollama expects for data.
+func Sign(key ed25519.PrivateKey, data []byte) string {
+	sig := ed25519.Sign(key, data)
+	return PublicKey(key.Public().(ed25519.PublicKey)) + ":" + base64.StdEncoding.EncodeToString(sig)
+}
+
+// PublicKey encodes pub in SSH wire format, base64 encoded, as in an authorized_keys line
+// without the "ssh-ed25519" prefix.
+func PublicKey(pub ed25519.PublicKey) string {
+	var b bytes.Buffer
+	writeString(&b, []byte("ssh-ed25519"))
+	writeString(&b, pub)
+	return base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+func (a *Authenticator) loadKey() (ed25519.PrivateKey, error) {
+	if a.KeyPath == "" {
+		return nil, ErrNoKey
+	}
+	data, err := os.ReadFile(a.KeyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading Ollama key: %w", err)
+	}
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", a.KeyPath, err)
+	}
+	return key, nil
+}
+
+func isOllamaCom(host string) bool {
+	return host == "ollama.com" || strings.HasSuffix(host, ".ollama.com")
+}
+
+const opensshMagic = "openssh-key-v1\x00"
+
+// ParsePrivateKey reads an unencrypted OpenSSH ed25519 private key, the format Ollama
+// generates on first start.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		return nil, errors.New("not an OpenSSH private key")
+	}
+	rest, ok := bytes.CutPrefix(block.Bytes, []byte(opensshMagic))
+	if !ok {
+		return nil, errors.New("invalid OpenSSH key header")
+	}
+
+	var cipher, kdf, private []byte
+	var err error
+	if cipher, rest, err = readString(rest); err != nil {
+		return nil, err
+	}
+	if kdf, rest, err = readString(rest); err != nil {
+		return nil, err
+	}
+	if string(cipher) != "none" || string(kdf) != "none" {
+		return nil, errors.New("encrypted keys are not supported")
+	}
+	if _, rest, err = readString(rest); err != nil { // KDF options
+		return nil, err
+	}
+	if len(rest) < 4 || binary.BigEndian.Uint32(rest) != 1 {
+		return nil, errors.New("expected exactly one key")
+	}
+	rest = rest[4:]
+	if _, rest, err = readString(rest); err != nil { // Public key
+		return nil, err
+	}
+	if private, _, err = readString(rest); err != nil {
+		return nil, err
+	}
+
+	// Private section: two check ints, key type, public key, private key, comment, padding.
+	if len(private) < 8 || binary.BigEndian.Uint32(private) != binary.BigEndian.Uint32(private[4:]) {
+		return nil, errors.New("corrupt private key section")
+	}
+	private = private[8:]
+	keyType, private, err := readString(private)
+	if err != nil {
+		return nil, err
+	}
+	if string(keyType) != "ssh-ed25519" {
+		return nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+	if _, private, err = readString(private); err != nil {
+		return nil, err
+	}
+	priv, _, err := readString(private)
+	if err != nil {
+		return nil, err
+	}
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key length")
+	}
+	return ed25519.PrivateKey(priv), nil
+}
+
+func readString(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, errors.New("truncated key data")
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return nil, nil, errors.New("truncated key data")
+	}
+	return b[4 : 4+n], b[4+n:], nil
+}
+
+func writeString(b *bytes.Buffer, s []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
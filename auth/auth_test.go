@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// marshalKey encodes priv in the unencrypted OpenSSH format written by ssh-keygen and Ollama.
+func marshalKey(priv ed25519.PrivateKey) []byte {
+	pub := priv.Public().(ed25519.PublicKey)
+	var pubBlob bytes.Buffer
+	writeString(&pubBlob, []byte("ssh-ed25519"))
+	writeString(&pubBlob, pub)
+
+	var private bytes.Buffer
+	binary.Write(&private, binary.BigEndian, uint32(42))
+	binary.Write(&private, binary.BigEndian, uint32(42))
+	writeString(&private, []byte("ssh-ed25519"))
+	writeString(&private, pub)
+	writeString(&private, priv)
+	writeString(&private, []byte("test"))
+	for i := byte(1); private.Len()%8 != 0; i++ {
+		private.WriteByte(i)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(opensshMagic)
+	writeString(&b, []byte("none"))
+	writeString(&b, []byte("none"))
+	writeString(&b, nil)
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	writeString(&b, pubBlob.Bytes())
+	writeString(&b, private.Bytes())
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: b.Bytes()})
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	parsed, err := ParsePrivateKey(marshalKey(priv))
+	assert.NoError(t, err)
+	assert.Equal(t, priv, parsed)
+
+	_, err = ParsePrivateKey([]byte("not a key"))
+	assert.Error(t, err)
+}
+
+func TestAuthorize_SignsOllamaComRequests(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	assert.NoError(t, os.WriteFile(keyPath, marshalKey(priv), 0600))
+
+	a := &Authenticator{KeyPath: keyPath, now: func() time.Time { return time.Unix(1700000000, 0) }}
+	req, _ := http.NewRequest(http.MethodPost, "https://ollama.com/api/pull", nil)
+	assert.NoError(t, a.Authorize(req))
+
+	assert.Equal(t, "1700000000", req.URL.Query().Get("ts"))
+	token := req.Header.Get("Authorization")
+	pubKey, sig, ok := strings.Cut(token, ":")
+	assert.True(t, ok, "Token should be <pubkey>:<signature>")
+	assert.Equal(t, PublicKey(priv.Public().(ed25519.PublicKey)), pubKey)
+	rawSig, err := base64.StdEncoding.DecodeString(sig)
+	assert.NoError(t, err)
+	assert.True(t, ed25519.Verify(priv.Public().(ed25519.PublicKey), []byte("POST,/api/pull?ts=1700000000"), rawSig))
+}
+
+func TestAuthorize_APIKeyAndLocalHosts(t *testing.T) {
+	a := &Authenticator{APIKey: "secret"}
+
+	local, _ := http.NewRequest(http.MethodPost, "http://localhost:11434/api/pull", nil)
+	assert.NoError(t, a.Authorize(local))
+	assert.Empty(t, local.Header.Get("Authorization"), "Local hosts should not get credentials")
+
+	remote, _ := http.NewRequest(http.MethodPost, "https://ollama.com/api/pull", nil)
+	assert.NoError(t, a.Authorize(remote))
+	assert.Equal(t, "Bearer secret", remote.Header.Get("Authorization"))
+}
+
+func TestAuthorize_MissingKey(t *testing.T) {
+	a := &Authenticator{KeyPath: filepath.Join(t.TempDir(), "missing")}
+	req, _ := http.NewRequest(http.MethodPost, "https://ollama.com/api/pull", nil)
+	assert.ErrorIs(t, a.Authorize(req), ErrNoKey)
+}
+
+// newProvider serves OIDC discovery, a device flow that is approved on the second poll,
+// and refresh grants.
+func newProvider(t *testing.T) *httptest.Server {
+	var polls int
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"device_authorization_endpoint":"%[1]s/device","token_endpoint":"%[1]s/token"}`, srv.URL)
+		case "/device":
+			assert.Equal(t, "openid offline_access", r.FormValue("scope"))
+			w.Write([]byte(`{"device_code":"dev","user_code":"ABCD-EFGH","verification_uri":"https://idp/activate","interval":0,"expires_in":60}`))
+		case "/token":
+			switch r.FormValue("grant_type") {
+			case "urn:ietf:params:oauth:grant-type:device_code":
+				if polls++; polls < 2 {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`{"error":"authorization_pending"}`))
+					return
+				}
+				w.Write([]byte(`{"access_token":"first","refresh_token":"r1","expires_in":3600}`))
+			case "refresh_token":
+				assert.Equal(t, "r1", r.FormValue("refresh_token"))
+				w.Write([]byte(`{"access_token":"second","expires_in":3600}`))
+			}
+		}
+	}))
+	return srv
+}
+
+func TestOIDC_DeviceFlowAndRefresh(t *testing.T) {
+	srv := newProvider(t)
+	defer srv.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cache := filepath.Join(t.TempDir(), "token.json")
+	o := &OIDC{Issuer: srv.URL, ClientID: "cli", Scopes: []string{"offline_access"}, Header: "cf-access-token", CachePath: cache, now: func() time.Time { return now }}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://ollama.internal/api/pull", nil)
+	assert.ErrorIs(t, o.Authorize(req), ErrNotLoggedIn)
+
+	dc, err := o.StartDevice(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ABCD-EFGH", dc.UserCode)
+	o.pollInterval = time.Millisecond
+	_, err = o.WaitDevice(context.Background(), dc)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(cache)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "The token is private to the user")
+
+	// A fresh instance reads the cache, as a later run would.
+	o = &OIDC{Issuer: srv.URL, ClientID: "cli", Header: "cf-access-token", CachePath: cache, now: func() time.Time { return now }}
+	assert.NoError(t, o.Authorize(req))
+	assert.Equal(t, "first", req.Header.Get("cf-access-token"))
+
+	// An hour later, mid-download, the token is refreshed and the refresh token kept.
+	now = now.Add(time.Hour)
+	assert.NoError(t, o.Authorize(req))
+	assert.Equal(t, "second", req.Header.Get("cf-access-token"))
+	assert.Equal(t, "r1", o.token.RefreshToken)
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	hook := func(name string) func(*http.Request) error {
+		return func(*http.Request) error { calls = append(calls, name); return nil }
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://h/", nil)
+	assert.NoError(t, Chain(hook("a"), nil, hook("b"))(req))
+	assert.Equal(t, []string{"a", "b"}, calls)
+}
@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/useragent"
+)
+
+// ErrNotLoggedIn is returned when OIDC is configured but no usable token is cached.
+var ErrNotLoggedIn = errors.New("not logged in: run `login` first")
+
+// refreshMargin is how long before expiry a token is refreshed, so it does not run out
+// between being attached to a request and the request reaching the proxy.
+const refreshMargin = time.Minute
+
+// Token is an OIDC token as cached on disk.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// valid reports whether the token can be used at now.
+func (t *Token) valid(now time.Time) bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || now.Add(refreshMargin).Before(t.Expiry))
+}
+
+// DeviceCode is the start of a device authorization flow: the user opens
+// VerificationURI and enters UserCode while the tool waits for approval.
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// OIDC authenticates requests with a token from an identity provider, e.g. one guarding
+// Ollama behind Cloudflare Access. Tokens are obtained with the device authorization flow
+// (RFC 8628), cached in CachePath and refreshed when they expire, also in the middle of
+// a long batch of pulls.
+type OIDC struct {
+	// Issuer is the identity provider's issuer URL, used for discovery.
+	Issuer string
+	// ClientID identifies this tool to the identity provider.
+	ClientID string
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+	// Header carries the token; "cf-access-token" for Cloudflare Access. Empty sends it
+	// as a bearer token in Authorization.
+	Header string
+	// CachePath is the file the token is kept in.
+	CachePath string
+	// HTTPClient talks to the identity provider; nil uses http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	token     *Token
+	endpoints *providerEndpoints
+	now       func() time.Time
+	// pollInterval, if set, replaces the provider's polling interval in tests.
+	pollInterval time.Duration
+}
+
+// providerEndpoints is the part of the OpenID discovery document the flow needs.
+type providerEndpoints struct {
+	DeviceAuthorization string `json:"device_authorization_endpoint"`
+	Token               string `json:"token_endpoint"`
+}
+
+// tokenResponse is the token endpoint's answer, successful or not.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// Authorize attaches the cached token to req, refreshing it first if it is about to
+// expire.
+func (o *OIDC) Authorize(req *http.Request) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.token == nil {
+		t, err := o.loadToken()
+		if err != nil {
+			return err
+		}
+		o.token = t
+	}
+	if !o.token.valid(o.clock()) {
+		if o.token.RefreshToken == "" {
+			return ErrNotLoggedIn
+		}
+		if err := o.refresh(req.Context()); err != nil {
+			return fmt.Errorf("refreshing login: %w", err)
+		}
+	}
+	if o.Header == "" || strings.EqualFold(o.Header, "Authorization") {
+		req.Header.Set("Authorization", "Bearer "+o.token.AccessToken)
+	} else {
+		req.Header.Set(o.Header, o.token.AccessToken)
+	}
+	return nil
+}
+
+// StartDevice begins a device authorization flow.
+func (o *OIDC) StartDevice(ctx context.Context) (*DeviceCode, error) {
+	ep, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ep.DeviceAuthorization == "" {
+		return nil, errors.New("identity provider does not support the device flow")
+	}
+	form := url.Values{"client_id": {o.ClientID}, "scope": {o.scope()}}
+	var dc DeviceCode
+	if err := o.postForm(ctx, ep.DeviceAuthorization, form, &dc); err != nil {
+		return nil, fmt.Errorf("starting device login: %w", err)
+	}
+	if dc.DeviceCode == "" {
+		return nil, errors.New("starting device login: no device code in response")
+	}
+	return &dc, nil
+}
+
+// WaitDevice polls until the user approved dc, then caches and returns the token.
+func (o *OIDC) WaitDevice(ctx context.Context, dc *DeviceCode) (*Token, error) {
+	ep, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if o.pollInterval > 0 {
+		interval = o.pollInterval
+	}
+	if dc.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(dc.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	form := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {dc.DeviceCode},
+		"client_id":   {o.ClientID},
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for login: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+		var res tokenResponse
+		if err := o.postForm(ctx, ep.Token, form, &res); err != nil {
+			return nil, fmt.Errorf("waiting for login: %w", err)
+		}
+		switch res.Error {
+		case "":
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			return o.store(res)
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("login failed: %s", res.describe())
+		}
+	}
+}
+
+// refresh replaces the expired token using its refresh token. The caller holds o.mu.
+func (o *OIDC) refresh(ctx context.Context) error {
+	ep, err := o.discover(ctx)
+	if err != nil {
+		return err
+	}
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {o.token.RefreshToken},
+		"client_id":     {o.ClientID},
+	}
+	var res tokenResponse
+	if err := o.postForm(ctx, ep.Token, form, &res); err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return fmt.Errorf("%s (run `login` again)", res.describe())
+	}
+	if res.RefreshToken == "" {
+		// Providers may keep the refresh token unchanged.
+		res.RefreshToken = o.token.RefreshToken
+	}
+	_, err = o.store(res)
+	return err
+}
+
+// store caches the token from res in memory and on disk.
+func (o *OIDC) store(res tokenResponse) (*Token, error) {
+	t := &Token{AccessToken: res.AccessToken, RefreshToken: res.RefreshToken}
+	if t.AccessToken == "" {
+		// Cloudflare Access and some providers expect the ID token.
+		t.AccessToken = res.IDToken
+	}
+	if res.ExpiresIn > 0 {
+		t.Expiry = o.clock().Add(time.Duration(res.ExpiresIn) * time.Second)
+	}
+	o.token = t
+	if err := o.saveToken(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (o *OIDC) discover(ctx context.Context) (*providerEndpoints, error) {
+	if o.endpoints != nil {
+		return o.endpoints, nil
+	}
+	u := strings.TrimSuffix(o.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("discovering identity provider: %w", err)
+	}
+	var ep providerEndpoints
+	if err := o.do(req, &ep); err != nil {
+		return nil, fmt.Errorf("discovering identity provider: %w", err)
+	}
+	if ep.Token == "" {
+		return nil, errors.New("discovering identity provider: no token endpoint")
+	}
+	o.endpoints = &ep
+	return &ep, nil
+}
+
+// postForm posts form to u and decodes the JSON answer into out. OAuth error responses
+// (400 with an "error" field) are decoded too, so callers can inspect them.
+func (o *OIDC) postForm(ctx context.Context, u string, form url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return o.do(req, out)
+}
+
+func (o *OIDC) do(req *http.Request, out any) error {
+	req.Header.Set("Accept", "application/json")
+	useragent.Set(req)
+	c := o.HTTPClient
+	if c == nil {
+		c = http.DefaultClient
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s returned status %d", req.URL.Host, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response from %s: %w", req.URL.Host, err)
+	}
+	return nil
+}
+
+func (o *OIDC) loadToken() (*Token, error) {
+	data, err := os.ReadFile(o.CachePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotLoggedIn
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading login token: %w", err)
+	}
+	var t Token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("parsing login token %s: %w", o.CachePath, err)
+	}
+	return &t, nil
+}
+
+// saveToken writes t to the cache, readable only by the user.
+func (o *OIDC) saveToken(t *Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("encoding login token: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(o.CachePath), 0700); err != nil {
+		return fmt.Errorf("saving login token: %w", err)
+	}
+	tmp := o.CachePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("saving login token: %w", err)
+	}
+	if err := os.Rename(tmp, o.CachePath); err != nil {
+		return fmt.Errorf("saving login token: %w", err)
+	}
+	return nil
+}
+
+func (o *OIDC) scope() string {
+	scopes := []string{"openid"}
+	for _, s := range o.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+func (o *OIDC) clock() time.Time {
+	if o.now != nil {
+		return o.now()
+	}
+	return time.Now()
+}
+
+func (r tokenResponse) describe() string {
+	if r.Description != "" {
+		return r.Error + ": " + r.Description
+	}
+	return r.Error
+}
+
+// Chain returns a request hook that runs each non-nil hook in turn.
+func Chain(hooks ...func(*http.Request) error) func(*http.Request) error {
+	return func(req *http.Request) error {
+		for _, h := range hooks {
+			if h == nil {
+				continue
+			}
+			if err := h(req); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// soundTimeout bounds how long the program waits for a sound to finish before exiting.
+const soundTimeout = 10 * time.Second
+
+// bellFlags are the flags that ask for a sound when a pull ends.
+type bellFlags struct {
+	bell  *bool
+	sound *string
+}
+
+func addBellFlags(fs *flag.FlagSet) bellFlags {
+	return bellFlags{
+		bell:  fs.Bool("bell", false, "Ring the terminal bell when the pull succeeds or fails"),
+		sound: fs.String("bell-sound", "", "Also play this sound file when the pull ends (implies --bell)"),
+	}
+}
+
+// ring rings the bell on w if asked to, and plays the sound file. A sound that cannot
+// be played is only logged.
+func (b bellFlags) ring(w io.Writer) {
+	if !*b.bell && *b.sound == "" {
+		return
+	}
+	fmt.Fprint(w, "\a")
+	if *b.sound == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), soundTimeout)
+	defer cancel()
+	if err := playSound(ctx, *b.sound); err != nil {
+		log.Printf("Playing %s: %v", *b.sound, err)
+	}
+}
+
+// playSound plays file with the platform's command-line player and waits for it.
+func playSound(ctx context.Context, file string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.CommandContext(ctx, "afplay", file)
+	case "windows":
+		// The path goes in through the environment rather than into the script, so it
+		// needs no quoting.
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command",
+			"(New-Object Media.SoundPlayer $env:OLLAMA_DL_SOUND).PlaySync()")
+		cmd.Env = append(os.Environ(), "OLLAMA_DL_SOUND="+file)
+	default:
+		for _, player := range []string{"paplay", "pw-play", "aplay", "play"} {
+			if path, err := exec.LookPath(player); err == nil {
+				cmd = exec.CommandContext(ctx, path, file)
+				break
+			}
+		}
+		if cmd == nil {
+			return errors.New("no sound player found (tried paplay, pw-play, aplay and play)")
+		}
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Path, err, out)
+	}
+	return nil
+}
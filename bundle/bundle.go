@@ -0,0 +1,252 @@
+// Package bundle packs a model from the registry into a tar file that can be carried to
+// a machine without internet access, and loads such a file into an Ollama host. It also
+// copies models straight from another machine's model store (see Transfer).
+//
+// A bundle starts with bundle.json, which names the model and holds its manifest,
+// followed by every blob as blobs/sha256-<hex> in manifest order.
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/registry"
+)
+
+const indexName = "bundle.json"
+
+// maxTextLayer bounds the template, system prompt and other text layers read into memory.
+const maxTextLayer = 1 << 20
+
+// Media types of the layers of an Ollama model.
+const (
+	mediaModel     = "application/vnd.ollama.image.model"
+	mediaProjector = "application/vnd.ollama.image.projector"
+	mediaAdapter   = "application/vnd.ollama.image.adapter"
+	mediaTemplate  = "application/vnd.ollama.image.template"
+	mediaSystem    = "application/vnd.ollama.image.system"
+	mediaParams    = "application/vnd.ollama.image.params"
+	mediaLicense   = "application/vnd.ollama.image.license"
+	mediaMessages  = "application/vnd.ollama.image.messages"
+)
+
+// Index is the first entry of a bundle.
+type Index struct {
+	// Model is the name the model is created under by default, e.g. "llama3:latest".
+	Model    string            `json:"model"`
+	Manifest registry.Manifest `json:"manifest"`
+}
+
+// Progress is called as blob bytes are copied, with the bytes done so far and the size
+// of all blobs.
+type Progress func(done, total int64)
+
+// Export writes a bundle of name to w, downloading every blob from reg and checking it
+// against its digest.
+func Export(ctx context.Context, reg *registry.Client, name registry.Name, w io.Writer, progress Progress) error {
+	m, err := reg.Manifest(ctx, name)
+	if err != nil {
+		return err
+	}
+	index, err := json.Marshal(Index{Model: name.String(), Manifest: m})
+	if err != nil {
+		return fmt.Errorf("encoding bundle index: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	if err := tw.WriteHeader(&tar.Header{Name: indexName, Mode: 0644, Size: int64(len(index)), ModTime: now}); err != nil {
+		return fmt.Errorf("writing bundle: %w", err)
+	}
+	if _, err := tw.Write(index); err != nil {
+		return fmt.Errorf("writing bundle: %w", err)
+	}
+
+	counter := &counter{total: m.TotalSize(), progress: progress}
+	for _, l := range append([]registry.Layer{m.Config}, m.Layers...) {
+		if err := exportBlob(ctx, reg, name, l, tw, now, counter); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("writing bundle: %w", err)
+	}
+	return nil
+}
+
+func exportBlob(ctx context.Context, reg *registry.Client, name registry.Name, l registry.Layer, tw *tar.Writer, now time.Time, c *counter) error {
+	body, err := reg.Blob(ctx, name, l.Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	hdr := &tar.Header{Name: blobPath(l.Digest), Mode: 0644, Size: l.Size, ModTime: now}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("writing bundle: %w", err)
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(tw, io.TeeReader(c.reader(body), h), l.Size); err != nil {
+		return fmt.Errorf("downloading blob %s: %w", l.Digest, err)
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != l.Digest {
+		return fmt.Errorf("blob %s has digest %s", l.Digest, got)
+	}
+	return nil
+}
+
+// Import uploads the blobs of the bundle read from r to host and creates the model there
+// as model, or under the bundled name if model is empty. It returns the model's name.
+// Blobs the host already has are not uploaded again.
+func Import(ctx context.Context, r io.Reader, host, model string, progress Progress) (string, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != indexName {
+		return "", errors.New("not a model bundle: it does not start with " + indexName)
+	}
+	var index Index
+	if err := json.NewDecoder(tr).Decode(&index); err != nil {
+		return "", fmt.Errorf("reading bundle index: %w", err)
+	}
+	if model == "" {
+		model = index.Model
+	}
+
+	layers := map[string]registry.Layer{index.Manifest.Config.Digest: index.Manifest.Config}
+	for _, l := range index.Manifest.Layers {
+		layers[l.Digest] = l
+	}
+	req := client.CreateRequest{Model: model, Files: map[string]string{}, Adapters: map[string]string{}}
+	counter := &counter{total: index.Manifest.TotalSize(), progress: progress}
+	seen := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading bundle: %w", err)
+		}
+		digest, ok := blobDigest(hdr.Name)
+		l, known := layers[digest]
+		if !ok || !known || l.Size != hdr.Size {
+			return "", fmt.Errorf("reading bundle: unexpected entry %s", hdr.Name)
+		}
+		seen[digest] = true
+		if err := importLayer(ctx, host, l, counter.reader(tr), &req); err != nil {
+			return "", err
+		}
+		counter.skip(l.Size) // Counts whatever part of the layer was not read.
+	}
+	for digest := range layers {
+		if !seen[digest] {
+			return "", fmt.Errorf("bundle is incomplete: blob %s is missing", digest)
+		}
+	}
+
+	if err := client.CreateModel(ctx, host, req); err != nil {
+		return "", err
+	}
+	return model, nil
+}
+
+// importLayer uploads a weights layer, or reads a text layer into req.
+func importLayer(ctx context.Context, host string, l registry.Layer, r io.Reader, req *client.CreateRequest) error {
+	switch l.MediaType {
+	case mediaModel, mediaProjector, mediaAdapter:
+		// Ollama names blobs sha256-<hex> on disk; any unique file name will do.
+		file := strings.Replace(l.Digest, ":", "-", 1) + ".gguf"
+		if l.MediaType == mediaAdapter {
+			req.Adapters[file] = l.Digest
+		} else {
+			req.Files[file] = l.Digest
+		}
+		have, err := client.HasBlob(ctx, host, l.Digest)
+		if err != nil {
+			return err
+		}
+		if have {
+			return nil
+		}
+		return client.PushBlob(ctx, host, l.Digest, r, l.Size)
+	case mediaTemplate, mediaSystem, mediaLicense, mediaParams, mediaMessages:
+		if l.Size > maxTextLayer {
+			return fmt.Errorf("layer %s of type %s is too large (%d bytes)", l.Digest, l.MediaType, l.Size)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("reading bundle: %w", err)
+		}
+		switch l.MediaType {
+		case mediaTemplate:
+			req.Template = string(data)
+		case mediaSystem:
+			req.System = string(data)
+		case mediaLicense:
+			req.License = append(req.License, string(data))
+		case mediaParams:
+			if err := json.Unmarshal(data, &req.Parameters); err != nil {
+				return fmt.Errorf("parsing parameters: %w", err)
+			}
+		case mediaMessages:
+			req.Messages = data
+		}
+	}
+	// The config and unknown layers are derived again by /api/create.
+	return nil
+}
+
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "-", 1)
+}
+
+func blobDigest(path string) (string, bool) {
+	name, ok := strings.CutPrefix(path, "blobs/sha256-")
+	return "sha256:" + name, ok
+}
+
+// counter tracks the bytes of all blobs processed for progress reports.
+type counter struct {
+	done, total int64
+	// layerRead is how much of the current layer was read, for skip.
+	layerRead int64
+	progress  Progress
+}
+
+func (c *counter) reader(r io.Reader) io.Reader {
+	c.layerRead = 0
+	return readFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		c.layerRead += int64(n)
+		c.add(int64(n))
+		return n, err
+	})
+}
+
+// skip counts the rest of a layer of size that was not read, e.g. because the host had it.
+func (c *counter) skip(size int64) {
+	if rest := size - c.layerRead; rest > 0 {
+		c.add(rest)
+	}
+	c.layerRead = size
+}
+
+func (c *counter) add(n int64) {
+	c.done += n
+	if c.progress != nil && n > 0 {
+		c.progress(c.done, c.total)
+	}
+}
+
+type readFunc func([]byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,189 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/registry"
+)
+
+func digestOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// newRegistry serves a model with weights, a template, parameters and a config blob.
+func newRegistry(t *testing.T, blobs map[string]string) (*httptest.Server, registry.Manifest) {
+	layer := func(mediaType, data string) registry.Layer {
+		d := digestOf(data)
+		blobs[d] = data
+		return registry.Layer{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+	}
+	m := registry.Manifest{
+		Config: layer("application/vnd.docker.container.image.v1+json", `{"model_format":"gguf"}`),
+		Layers: []registry.Layer{
+			layer(mediaModel, strings.Repeat("w", 4096)),
+			layer(mediaTemplate, "{{ .Prompt }}"),
+			layer(mediaParams, `{"stop":["<|end|>"]}`),
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/manifests/latest") {
+			json.NewEncoder(w).Encode(m)
+			return
+		}
+		digest := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		data, ok := blobs[digest]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	return srv, m
+}
+
+func TestExportImport(t *testing.T) {
+	blobs := make(map[string]string)
+	reg, m := newRegistry(t, blobs)
+	defer reg.Close()
+
+	var bundle bytes.Buffer
+	var exported int64
+	err := Export(context.Background(), &registry.Client{BaseURL: reg.URL, HTTPClient: reg.Client()},
+		registry.ParseName("tiny"), &bundle, func(done, total int64) { exported = done })
+	assert.NoError(t, err)
+	assert.Equal(t, m.TotalSize(), exported)
+
+	var mu sync.Mutex
+	uploaded := make(map[string]string)
+	var created client.CreateRequest
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasPrefix(r.URL.Path, "/api/blobs/"):
+			data, _ := io.ReadAll(r.Body)
+			uploaded[strings.TrimPrefix(r.URL.Path, "/api/blobs/")] = string(data)
+			w.WriteHeader(http.StatusCreated)
+		case r.URL.Path == "/api/create":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.Write([]byte(`{"status":"success"}`))
+		}
+	}))
+	defer ollama.Close()
+
+	var imported int64
+	name, err := Import(context.Background(), bytes.NewReader(bundle.Bytes()), ollama.URL, "",
+		func(done, total int64) { imported = done })
+	assert.NoError(t, err)
+	assert.Equal(t, "tiny:latest", name)
+	assert.Equal(t, m.TotalSize(), imported)
+
+	weights := m.Layers[0].Digest
+	assert.Equal(t, map[string]string{weights: blobs[weights]}, uploaded, "Only the weights are uploaded as blobs")
+	assert.Equal(t, "tiny:latest", created.Model)
+	assert.Equal(t, map[string]string{strings.Replace(weights, ":", "-", 1) + ".gguf": weights}, created.Files)
+	assert.Equal(t, "{{ .Prompt }}", created.Template)
+	assert.Equal(t, []any{"<|end|>"}, created.Parameters["stop"])
+}
+
+func TestExport_DigestMismatch(t *testing.T) {
+	blobs := make(map[string]string)
+	reg, m := newRegistry(t, blobs)
+	defer reg.Close()
+	blobs[m.Layers[0].Digest] = strings.Repeat("x", 4096)
+
+	err := Export(context.Background(), &registry.Client{BaseURL: reg.URL, HTTPClient: reg.Client()},
+		registry.ParseName("tiny"), io.Discard, nil)
+	assert.ErrorContains(t, err, "has digest")
+}
+
+func TestImport_NotABundle(t *testing.T) {
+	_, err := Import(context.Background(), strings.NewReader("not a tar"), "http://localhost", "", nil)
+	assert.ErrorContains(t, err, "not a model bundle")
+}
+
+// fakeSource is a model store in memory that records which blobs were read.
+type fakeSource struct {
+	m      registry.Manifest
+	blobs  map[string]string
+	opened []string
+}
+
+func (s *fakeSource) Manifest(ctx context.Context, name registry.Name) (registry.Manifest, error) {
+	return s.m, nil
+}
+
+func (s *fakeSource) Open(ctx context.Context, digest string) (io.ReadCloser, error) {
+	s.opened = append(s.opened, digest)
+	return io.NopCloser(strings.NewReader(s.blobs[digest])), nil
+}
+
+func TestTransfer_SkipsBlobsTheHostHas(t *testing.T) {
+	blobs := make(map[string]string)
+	reg, m := newRegistry(t, blobs)
+	reg.Close()
+	src := &fakeSource{m: m, blobs: blobs}
+
+	var created client.CreateRequest
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+			// The destination already has the weights.
+		case strings.HasPrefix(r.URL.Path, "/api/blobs/"):
+			t.Errorf("Unexpected upload of %s", r.URL.Path)
+		case r.URL.Path == "/api/create":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.Write([]byte(`{"status":"success"}`))
+		}
+	}))
+	defer ollama.Close()
+
+	var done, total int64
+	err := Transfer(context.Background(), src, registry.ParseName("tiny"), ollama.URL, func(d, tt int64) { done, total = d, tt })
+	assert.NoError(t, err)
+	assert.Equal(t, total, done)
+	assert.Equal(t, []string{m.Layers[1].Digest, m.Layers[2].Digest}, src.opened, "Only the text layers are read")
+	assert.Equal(t, "{{ .Prompt }}", created.Template)
+	assert.Len(t, created.Files, 1)
+}
+
+func TestManifestPath(t *testing.T) {
+	assert.Equal(t, ".ollama/models/manifests/registry.ollama.ai/library/llama3/8b",
+		manifestPath(DefaultModelsDir, registry.ParseName("llama3:8b")))
+	assert.Equal(t, "/srv/models/manifests/hf.co/user/model/latest",
+		manifestPath("/srv/models", registry.ParseName("hf.co/user/model")))
+	assert.Equal(t, `~/'a b/it'\''s'`, shellQuote("~/a b/it's"))
+}
+
+func TestSSHSource_DestIsNotAnOption(t *testing.T) {
+	// A fake ssh that prints its arguments, one per line.
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ssh"), []byte("#!/bin/sh\nprintf '%s\\n' \"$@\"\n"), 0755))
+	t.Setenv("PATH", dir)
+
+	rc, err := SSHSource{Dest: "-oProxyCommand=touch /tmp/pwned", Dir: ".ollama/models"}.Open(context.Background(), "sha256:abc")
+	require.NoError(t, err)
+	out, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "-o\nBatchMode=yes\n--\n-oProxyCommand=touch /tmp/pwned\ncat -- '.ollama/models/blobs/sha256-abc'\n", string(out))
+}
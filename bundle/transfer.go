@@ -0,0 +1,177 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/registry"
+)
+
+// DefaultModelsDir is where Ollama keeps models, relative to the user's home directory.
+const DefaultModelsDir = ".ollama/models"
+
+// Source is another machine's Ollama model store.
+type Source interface {
+	// Manifest reads the manifest of an installed model.
+	Manifest(ctx context.Context, name registry.Name) (registry.Manifest, error)
+	// Open reads the blob with digest.
+	Open(ctx context.Context, digest string) (io.ReadCloser, error)
+}
+
+// Transfer copies the model name from src to host without going through the registry,
+// creating it there as name. Blobs host already has are not copied.
+func Transfer(ctx context.Context, src Source, name registry.Name, host string, progress Progress) error {
+	m, err := src.Manifest(ctx, name)
+	if err != nil {
+		return err
+	}
+	req := client.CreateRequest{Model: name.String(), Files: map[string]string{}, Adapters: map[string]string{}}
+	counter := &counter{total: m.TotalSize(), progress: progress}
+	for _, l := range m.Layers {
+		if err := transferLayer(ctx, src, host, l, counter, &req); err != nil {
+			return err
+		}
+		counter.skip(l.Size)
+	}
+	counter.add(m.Config.Size) // The config is derived again by /api/create.
+	return client.CreateModel(ctx, host, req)
+}
+
+func transferLayer(ctx context.Context, src Source, host string, l registry.Layer, c *counter, req *client.CreateRequest) error {
+	// Open blobs lazily, so those the host already has are never read.
+	r := &lazyBlob{open: func() (io.ReadCloser, error) { return src.Open(ctx, l.Digest) }}
+	defer r.Close()
+	return importLayer(ctx, host, l, c.reader(r), req)
+}
+
+// lazyBlob opens its blob on the first Read.
+type lazyBlob struct {
+	open func() (io.ReadCloser, error)
+	rc   io.ReadCloser
+}
+
+func (b *lazyBlob) Read(p []byte) (int, error) {
+	if b.rc == nil {
+		rc, err := b.open()
+		if err != nil {
+			return 0, err
+		}
+		b.rc = rc
+	}
+	return b.rc.Read(p)
+}
+
+func (b *lazyBlob) Close() error {
+	if b.rc == nil {
+		return nil
+	}
+	return b.rc.Close()
+}
+
+// SSHSource reads the model store of another machine with `ssh <Dest> cat`.
+type SSHSource struct {
+	// Dest is the ssh destination, e.g. "user@gpu-box".
+	Dest string
+	// Dir is the models directory on Dest, relative to the home directory unless absolute.
+	Dir string
+}
+
+// Manifest reads the manifest of name from the store.
+func (s SSHSource) Manifest(ctx context.Context, name registry.Name) (registry.Manifest, error) {
+	rc, err := s.cat(ctx, manifestPath(s.Dir, name))
+	if err != nil {
+		return registry.Manifest{}, err
+	}
+	var m registry.Manifest
+	err = json.NewDecoder(rc).Decode(&m)
+	// A failed ssh command explains more than the empty output it left.
+	if closeErr := rc.Close(); closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return registry.Manifest{}, fmt.Errorf("reading manifest of %s on %s: %w", name, s.Dest, err)
+	}
+	return m, nil
+}
+
+// Open streams the blob with digest from the store.
+func (s SSHSource) Open(ctx context.Context, digest string) (io.ReadCloser, error) {
+	return s.cat(ctx, path.Join(s.Dir, "blobs", strings.Replace(digest, ":", "-", 1)))
+}
+
+func (s SSHSource) cat(ctx context.Context, file string) (io.ReadCloser, error) {
+	// "--" keeps a destination such as "-oProxyCommand=..." from being read as an option.
+	cmd := exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", "--", s.Dest, "cat -- "+shellQuote(file))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("running ssh: %w", err)
+	}
+	return &sshReader{ReadCloser: out, cmd: cmd, stderr: &stderr, file: file}, nil
+}
+
+// sshReader reports a failed ssh command, e.g. a missing file, when it is closed.
+type sshReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	file   string
+	done   bool
+	err    error
+}
+
+func (r *sshReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		// A command that failed writes nothing; report why instead of an empty file.
+		if waitErr := r.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (r *sshReader) Close() error {
+	r.ReadCloser.Close()
+	return r.wait()
+}
+
+func (r *sshReader) wait() error {
+	if !r.done {
+		r.done = true
+		if err := r.cmd.Wait(); err != nil {
+			r.err = fmt.Errorf("reading %s over ssh: %w: %s", r.file, err, strings.TrimSpace(r.stderr.String()))
+		}
+	}
+	return r.err
+}
+
+// manifestPath is where Ollama stores the manifest of name under dir.
+func manifestPath(dir string, name registry.Name) string {
+	ns := name.Namespace
+	// Models from other registries, e.g. hf.co/user/model, start with the registry host.
+	if first, _, _ := strings.Cut(ns, "/"); !strings.Contains(first, ".") {
+		ns = path.Join("registry.ollama.ai", ns)
+	}
+	return path.Join(dir, "manifests", ns, name.Model, name.Tag)
+}
+
+// shellQuote quotes s for a POSIX shell, leaving a leading "~/" to be expanded.
+func shellQuote(s string) string {
+	prefix := ""
+	if rest, ok := strings.CutPrefix(s, "~/"); ok {
+		prefix, s = "~/", rest
+	}
+	return prefix + "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
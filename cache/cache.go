@@ -0,0 +1,676 @@
+// Package cache is a caching proxy for the Ollama registry. Machines that pull through it
+// share one copy of every blob, so a room full of them downloads each model from the
+// internet once.
+//
+// Ollama downloads a blob as many byte ranges in parallel. The proxy fills its copy the
+// same way: a range nobody has asked for yet is fetched from upstream while it is
+// streamed to the client, and a client asking for a range that is being fetched waits for
+// those bytes instead of fetching them again. Once every byte is there the blob is
+// checked against its digest and kept. Complete blobs are evicted least recently used
+// first when the cache grows beyond its size limit.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/ref"
+	"ollama-downloader-v2/useragent"
+)
+
+// chunkSize is how much of a fetched range is written before waiting clients are woken.
+const chunkSize = 256 << 10
+
+var (
+	routePattern  = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs)/([^/]+)$`)
+	digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Server serves the registry API from the cache, filling it from Upstream.
+type Server struct {
+	// Upstream is the registry being cached, e.g. registry.DefaultURL.
+	Upstream string
+	// HTTPClient talks to Upstream; nil uses http.DefaultClient.
+	HTTPClient *http.Client
+	// Logger receives hits, misses and evictions; nil uses slog.Default().
+	Logger *slog.Logger
+
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	filling map[string]*fill   // blobs being filled, by digest
+	blobs   map[string]*cached // complete blobs, by digest
+	used    int64
+}
+
+// cached is a complete blob on disk.
+type cached struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// New returns a server caching upstream in dir, keeping at most maxSize bytes of blobs
+// (0 means no limit). Blobs already in dir are kept; partly filled ones are discarded.
+func New(dir, upstream string, maxSize int64) (*Server, error) {
+	s := &Server{
+		Upstream: strings.TrimSuffix(upstream, "/"),
+		dir:      dir,
+		maxSize:  maxSize,
+		filling:  make(map[string]*fill),
+		blobs:    make(map[string]*cached),
+	}
+	for _, d := range []string{s.blobDir(), filepath.Join(dir, "manifests")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, fmt.Errorf("creating cache directory: %w", err)
+		}
+	}
+	entries, err := os.ReadDir(s.blobDir())
+	if err != nil {
+		return nil, fmt.Errorf("reading cache: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".partial") {
+			os.Remove(filepath.Join(s.blobDir(), name))
+			continue
+		}
+		info, err := e.Info()
+		digest := strings.Replace(name, "-", ":", 1)
+		if err != nil || !digestPattern.MatchString(digest) {
+			continue
+		}
+		s.blobs[digest] = &cached{size: info.Size(), lastUsed: info.ModTime()}
+		s.used += info.Size()
+	}
+	s.evict("")
+	return s, nil
+}
+
+// Usage returns the number of complete blobs in the cache and their size.
+func (s *Server) Usage() (blobs int, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.blobs), s.used
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v2/" || r.URL.Path == "/v2" {
+		// The API version check registry clients start with.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	m := routePattern.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// Names are checked before they become paths in the cache directory.
+	if !ref.ValidRepository(m[1]) {
+		http.Error(w, "invalid repository name", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "the cache is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	if m[2] == "manifests" {
+		if !ref.ValidTag(m[3]) && !digestPattern.MatchString(m[3]) {
+			http.Error(w, "invalid tag or digest", http.StatusBadRequest)
+			return
+		}
+		s.serveManifest(w, r, m[1], m[3])
+		return
+	}
+	if !digestPattern.MatchString(m[3]) {
+		http.Error(w, "invalid digest", http.StatusBadRequest)
+		return
+	}
+	s.serveBlob(w, r, m[1], m[3])
+}
+
+// serveManifest relays the manifest from upstream, since tags move, and keeps a copy to
+// answer with when upstream cannot be reached.
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, repo, reference string) {
+	path := filepath.Join(s.dir, "manifests", filepath.FromSlash(repo), reference)
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, s.Upstream+r.URL.Path, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Accept", r.Header.Get("Accept"))
+	useragent.Set(req)
+	resp, err := s.client().Do(req)
+	if err == nil && resp.StatusCode < 500 {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || r.Method == http.MethodHead {
+			copyHeader(w, resp, "Content-Type", "Content-Length", "Docker-Content-Digest")
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			http.Error(w, "reading manifest from upstream: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if err := writeFile(path, body); err != nil {
+			s.logger().Warn("cannot keep manifest", "repo", repo, "ref", reference, "error", err.Error())
+		}
+		copyHeader(w, resp, "Content-Type", "Docker-Content-Digest")
+		w.Write(body)
+		return
+	}
+	if err == nil {
+		resp.Body.Close()
+		err = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	body, readErr := os.ReadFile(path)
+	if readErr != nil {
+		http.Error(w, "registry unreachable: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	s.logger().Warn("registry unreachable, serving cached manifest", "repo", repo, "ref", reference, "error", err.Error())
+	w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	w.Write(body)
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, repo, digest string) {
+	if f, info := s.openCached(digest); f != nil {
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", digest)
+		http.ServeContent(w, r, "", info.ModTime(), f)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		// A HEAD needs no bytes, so it is answered from upstream without starting a fill
+		// that nothing would read.
+		size, err := s.blobSize(r.Context(), s.blobURL(repo, digest))
+		if err != nil {
+			http.Error(w, err.Error(), statusOf(err))
+			return
+		}
+		writeBlobHeader(w, r, digest, size)
+		return
+	}
+
+	fl, err := s.startFill(r.Context(), repo, digest)
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	defer s.release(fl)
+
+	start, end, ok := writeBlobHeader(w, r, digest, fl.size)
+	if !ok {
+		return
+	}
+	if err := fl.serve(r.Context(), w, start, end); err != nil && r.Context().Err() == nil {
+		// The status is sent; cutting the response short is all that is left.
+		s.logger().Warn("serving blob failed", "digest", digest, "error", err.Error())
+	}
+}
+
+// writeBlobHeader writes the header of a response with the bytes of a blob of size that
+// r's Range asks for, and returns them as [start, end). It answers an invalid range itself
+// and reports false.
+func writeBlobHeader(w http.ResponseWriter, r *http.Request, digest string, size int64) (start, end int64, ok bool) {
+	start, end, partial, ok := parseRange(r.Header.Get("Range"), size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "invalid range", http.StatusRequestedRangeNotSatisfiable)
+		return 0, 0, false
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Docker-Content-Digest", digest)
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Length", strconv.FormatInt(end-start, 10))
+	status := http.StatusOK
+	if partial {
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	return start, end, true
+}
+
+// openCached opens a complete blob and marks it used.
+func (s *Server) openCached(digest string) (*os.File, os.FileInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.blobs[digest]
+	if !ok {
+		return nil, nil
+	}
+	f, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		// Removed behind our back; fill it again.
+		delete(s.blobs, digest)
+		s.used -= c.size
+		return nil, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil
+	}
+	c.lastUsed = time.Now()
+	os.Chtimes(s.blobPath(digest), c.lastUsed, c.lastUsed) // Keeps the order across restarts.
+	s.logger().Debug("cache hit", "digest", digest)
+	return f, info
+}
+
+// startFill returns the fill of digest, starting one if none is running. The caller must
+// release it.
+func (s *Server) startFill(ctx context.Context, repo, digest string) (*fill, error) {
+	s.mu.Lock()
+	if fl, ok := s.filling[digest]; ok {
+		fl.refs++
+		s.mu.Unlock()
+		return fl, nil
+	}
+	s.mu.Unlock()
+
+	// Ask upstream for the size outside the lock; a concurrent request may do the same.
+	src := s.blobURL(repo, digest)
+	size, err := s.blobSize(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fl, ok := s.filling[digest]; ok {
+		fl.refs++
+		return fl, nil
+	}
+	f, err := os.OpenFile(s.blobPath(digest)+".partial", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("creating cache file: %w", err)
+	}
+	fl := &fill{server: s, digest: digest, src: src, size: size, f: f, refs: 1}
+	fl.cond = sync.NewCond(&fl.mu)
+	s.filling[digest] = fl
+	s.logger().Info("cache miss", "digest", digest, "size", size)
+	return fl, nil
+}
+
+func (s *Server) blobURL(repo, digest string) string {
+	return s.Upstream + "/v2/" + repo + "/blobs/" + digest
+}
+
+func (s *Server) blobSize(ctx context.Context, src string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, src, nil)
+	if err != nil {
+		return 0, err
+	}
+	useragent.Set(req)
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return 0, &upstreamError{err: err}
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, &upstreamError{status: resp.StatusCode}
+	}
+	if resp.ContentLength < 0 {
+		return 0, &upstreamError{err: errors.New("upstream did not report the blob size")}
+	}
+	return resp.ContentLength, nil
+}
+
+// release drops a request's reference to fl, closing its file after the last one once
+// the fill is finished. A fill that failed is discarded with the last reference, so the
+// next request starts over.
+func (s *Server) release(fl *fill) {
+	s.mu.Lock()
+	fl.refs--
+	discarded := s.discardFailed(fl)
+	last := fl.refs == 0 && fl.finished && !discarded
+	s.mu.Unlock()
+	if last {
+		fl.f.Close()
+	}
+}
+
+// discardFailed removes fl and its partial file if a fetch of it failed, no request is
+// using it and no fetch is still running, and reports whether it did. The caller holds
+// s.mu.
+func (s *Server) discardFailed(fl *fill) bool {
+	if fl.refs > 0 || fl.finished {
+		return false
+	}
+	fl.mu.Lock()
+	err := fl.err
+	failed := err != nil && len(fl.fetches) == 0 && !fl.completing
+	fl.mu.Unlock()
+	if !failed {
+		return false
+	}
+	delete(s.filling, fl.digest)
+	fl.finished = true
+	fl.f.Close()
+	os.Remove(s.blobPath(fl.digest) + ".partial")
+	s.logger().Warn("discarding partly cached blob", "digest", fl.digest, "error", err.Error())
+	return true
+}
+
+// complete moves a fully filled blob into the cache after checking its digest.
+func (s *Server) complete(fl *fill) {
+	h := sha256.New()
+	_, err := io.Copy(h, io.NewSectionReader(fl.f, 0, fl.size))
+	if err == nil {
+		if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != fl.digest {
+			err = fmt.Errorf("blob %s has digest %s", fl.digest, got)
+		}
+	}
+	if err == nil {
+		err = os.Rename(s.blobPath(fl.digest)+".partial", s.blobPath(fl.digest))
+	}
+
+	s.mu.Lock()
+	delete(s.filling, fl.digest)
+	fl.finished = true
+	closeFile := fl.refs == 0
+	if err == nil {
+		s.blobs[fl.digest] = &cached{size: fl.size, lastUsed: time.Now()}
+		s.used += fl.size
+		s.evict(fl.digest)
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger().Error("discarding cached blob", "digest", fl.digest, "error", err.Error())
+		os.Remove(s.blobPath(fl.digest) + ".partial")
+		fl.fail(err)
+	} else {
+		s.logger().Info("blob cached", "digest", fl.digest, "size", fl.size)
+	}
+	if closeFile {
+		fl.f.Close()
+	}
+}
+
+// evict removes the least recently used blobs until the cache fits its limit, sparing
+// keep. The caller holds s.mu.
+func (s *Server) evict(keep string) {
+	for s.maxSize > 0 && s.used > s.maxSize {
+		var oldest string
+		for d, c := range s.blobs {
+			if d != keep && (oldest == "" || c.lastUsed.Before(s.blobs[oldest].lastUsed)) {
+				oldest = d
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		// Clients still reading it keep their open file.
+		os.Remove(s.blobPath(oldest))
+		s.used -= s.blobs[oldest].size
+		s.logger().Info("blob evicted", "digest", oldest, "size", s.blobs[oldest].size)
+		delete(s.blobs, oldest)
+	}
+}
+
+func (s *Server) blobDir() string { return filepath.Join(s.dir, "blobs") }
+
+func (s *Server) blobPath(digest string) string {
+	return filepath.Join(s.blobDir(), strings.Replace(digest, ":", "-", 1))
+}
+
+func (s *Server) client() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// fill is a blob being downloaded into the cache by the ranges clients ask for.
+type fill struct {
+	server *Server
+	digest string
+	src    string
+	size   int64
+	f      *os.File
+
+	// refs and finished are guarded by server.mu.
+	refs     int
+	finished bool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	have    intervals // bytes written to f
+	fetches intervals // ranges being fetched from upstream
+	err     error     // why the last fetch failed
+	// completing is set once every byte is there; broken once the blob was discarded.
+	completing, broken bool
+}
+
+// serve writes bytes [start, end) of the blob to w as they become available, fetching
+// those nobody is fetching yet.
+func (fl *fill) serve(ctx context.Context, w io.Writer, start, end int64) error {
+	stop := context.AfterFunc(ctx, func() {
+		fl.mu.Lock()
+		fl.cond.Broadcast()
+		fl.mu.Unlock()
+	})
+	defer stop()
+
+	fetched := false
+	for pos := start; pos < end; {
+		fl.mu.Lock()
+		avail := fl.have.coveredFrom(pos)
+		for avail == pos {
+			if err := ctx.Err(); err != nil {
+				fl.mu.Unlock()
+				return err
+			}
+			if fl.fetches.coveredFrom(pos) == pos {
+				if fl.broken || fetched && fl.err != nil {
+					err := fl.err
+					fl.mu.Unlock()
+					return err
+				}
+				// Fetch up to what is already there or on its way.
+				to := min(fl.have.nextStart(pos, end), fl.fetches.nextStart(pos, end))
+				fl.fetches.add(pos, to)
+				fetched = true
+				go fl.fetch(pos, to)
+			}
+			fl.cond.Wait()
+			avail = fl.have.coveredFrom(pos)
+		}
+		fl.mu.Unlock()
+
+		n := min(avail, end) - pos
+		if _, err := io.Copy(w, io.NewSectionReader(fl.f, pos, n)); err != nil {
+			return err
+		}
+		pos += n
+	}
+	return nil
+}
+
+// fetch downloads [start, end) from upstream into the file. It is not tied to the
+// request that started it, so the cache keeps the range even if that client goes away.
+func (fl *fill) fetch(start, end int64) {
+	off, err := fl.download(start, end)
+	if err == nil && off < end {
+		err = io.ErrUnexpectedEOF
+	}
+
+	fl.mu.Lock()
+	fl.fetches.remove(start, end)
+	if err != nil {
+		fl.err = fmt.Errorf("fetching %s from upstream: %w", fl.digest, err)
+	}
+	done := !fl.completing && fl.have.covers(fl.size)
+	if done {
+		fl.completing = true
+	}
+	fl.cond.Broadcast()
+	fl.mu.Unlock()
+
+	if done {
+		fl.server.complete(fl)
+		return
+	}
+	if err != nil {
+		// Nobody may be left to release the fill.
+		fl.server.mu.Lock()
+		fl.server.discardFailed(fl)
+		fl.server.mu.Unlock()
+	}
+}
+
+func (fl *fill) download(start, end int64) (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, fl.src, nil)
+	if err != nil {
+		return start, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	useragent.Set(req)
+	resp, err := fl.server.client().Do(req)
+	if err != nil {
+		return start, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && start == 0:
+		// No range support upstream; the blob arrives from the start.
+	default:
+		return start, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	buf := make([]byte, chunkSize)
+	off := start
+	for off < end {
+		n, err := io.ReadFull(resp.Body, buf[:min(int64(len(buf)), end-off)])
+		if n > 0 {
+			if _, werr := fl.f.WriteAt(buf[:n], off); werr != nil {
+				return off, werr
+			}
+			fl.mu.Lock()
+			fl.have.add(off, off+int64(n))
+			fl.cond.Broadcast()
+			fl.mu.Unlock()
+			off += int64(n)
+		}
+		if err != nil {
+			return off, err
+		}
+	}
+	return off, nil
+}
+
+// fail wakes everyone waiting on fl with err after the filled blob was discarded.
+func (fl *fill) fail(err error) {
+	fl.mu.Lock()
+	fl.err = err
+	fl.broken = true
+	fl.cond.Broadcast()
+	fl.mu.Unlock()
+}
+
+// upstreamError is a failure to reach the registry or a refusal from it.
+type upstreamError struct {
+	err    error
+	status int
+}
+
+func (e *upstreamError) Error() string {
+	if e.err != nil {
+		return "registry unreachable: " + e.err.Error()
+	}
+	return fmt.Sprintf("registry returned status %d", e.status)
+}
+
+func statusOf(err error) int {
+	var ue *upstreamError
+	if errors.As(err, &ue) && ue.status == http.StatusNotFound {
+		return http.StatusNotFound
+	}
+	if errors.As(err, &ue) && ue.status == http.StatusUnauthorized {
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadGateway
+}
+
+// parseRange parses a single-range Range header for a blob of size into [start, end).
+// partial is false when the whole blob is asked for.
+func parseRange(header string, size int64) (start, end int64, partial, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if header == "" || !found || strings.Contains(spec, ",") {
+		// No range, or several: send everything, which the standard allows.
+		return 0, size, false, true
+	}
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false, false
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, false
+		}
+		return max(size-n, 0), size, true, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, false
+	}
+	end = size
+	if last != "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < start {
+			return 0, 0, false, false
+		}
+		end = min(n+1, size)
+	}
+	return start, end, true, true
+}
+
+func copyHeader(w http.ResponseWriter, resp *http.Response, keys ...string) {
+	for _, k := range keys {
+		if v := resp.Header.Get(k); v != "" {
+			w.Header().Set(k, v)
+		}
+	}
+}
+
+// writeFile writes data to path atomically.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
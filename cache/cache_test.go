@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// upstream is a registry serving blobs with range support, counting the bytes it sends.
+type upstream struct {
+	*httptest.Server
+	blobs map[string][]byte
+	sent  atomic.Int64
+	down  atomic.Bool
+	// failGets refuses blob downloads while still answering HEAD.
+	failGets atomic.Bool
+}
+
+func newUpstream(t *testing.T, blobs ...[]byte) *upstream {
+	u := &upstream{blobs: make(map[string][]byte)}
+	for _, b := range blobs {
+		u.blobs[digestOf(b)] = b
+	}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u.down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		if u.failGets.Load() && r.Method == http.MethodGet {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/manifests/latest") {
+			w.Write([]byte(`{"layers":[]}`))
+			return
+		}
+		data, ok := u.blobs[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(&countingWriter{ResponseWriter: w, n: &u.sent}, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n.Add(int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+func get(t *testing.T, url, rng string) (int, []byte) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return 0, nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, body
+}
+
+func blobOf(size int, seed byte) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i*7) + seed
+	}
+	return b
+}
+
+func waitCached(t *testing.T, s *Server, blobs int) {
+	assert.Eventually(t, func() bool { n, _ := s.Usage(); return n == blobs }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServer_ParallelRangesFetchedOnce(t *testing.T) {
+	blob := blobOf(3*chunkSize+1234, 1)
+	up := newUpstream(t, blob)
+	s, err := New(t.TempDir(), up.URL, 0)
+	assert.NoError(t, err)
+	proxy := httptest.NewServer(s)
+	defer proxy.Close()
+	url := proxy.URL + "/v2/library/tiny/blobs/" + digestOf(blob)
+
+	// Like Ollama: parts of the blob in parallel, from two machines at once.
+	third := len(blob) / 3
+	var wg sync.WaitGroup
+	for range 2 {
+		for i := range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				start, end := i*third, (i+1)*third-1
+				if i == 2 {
+					end = len(blob) - 1
+				}
+				status, body := get(t, url, fmt.Sprintf("bytes=%d-%d", start, end))
+				assert.Equal(t, http.StatusPartialContent, status)
+				assert.Equal(t, blob[start:end+1], body)
+			}()
+		}
+	}
+	wg.Wait()
+	waitCached(t, s, 1)
+	assert.Equal(t, int64(len(blob)), up.sent.Load(), "Every byte is fetched from upstream once")
+
+	// Now from the cache, also with upstream gone.
+	up.down.Store(true)
+	status, body := get(t, url, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, blob, body)
+	status, body = get(t, url, "bytes=10-19")
+	assert.Equal(t, http.StatusPartialContent, status)
+	assert.Equal(t, blob[10:20], body)
+	assert.Equal(t, int64(len(blob)), up.sent.Load())
+}
+
+// partialFiles returns the partly filled blobs in the cache directory.
+func partialFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "blobs", "*.partial"))
+	assert.NoError(t, err)
+	return files
+}
+
+func TestServer_HeadDoesNotFill(t *testing.T) {
+	blob := blobOf(chunkSize+10, 2)
+	up := newUpstream(t, blob)
+	dir := t.TempDir()
+	s, err := New(dir, up.URL, 0)
+	assert.NoError(t, err)
+	proxy := httptest.NewServer(s)
+	defer proxy.Close()
+
+	resp, err := http.Head(proxy.URL + "/v2/library/tiny/blobs/" + digestOf(blob))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(len(blob)), resp.ContentLength)
+	assert.Equal(t, digestOf(blob), resp.Header.Get("Docker-Content-Digest"))
+
+	s.mu.Lock()
+	assert.Empty(t, s.filling, "A HEAD starts no fill")
+	s.mu.Unlock()
+	assert.Empty(t, partialFiles(t, dir))
+	assert.Zero(t, up.sent.Load())
+
+	resp, err = http.Head(proxy.URL + "/v2/library/tiny/blobs/" + digestOf([]byte("missing")))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServer_FailedFillDiscarded(t *testing.T) {
+	blob := blobOf(chunkSize+10, 3)
+	up := newUpstream(t, blob)
+	dir := t.TempDir()
+	s, err := New(dir, up.URL, 0)
+	assert.NoError(t, err)
+	proxy := httptest.NewServer(s)
+	defer proxy.Close()
+	url := proxy.URL + "/v2/library/tiny/blobs/" + digestOf(blob)
+
+	up.failGets.Store(true)
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Error(t, err, "The response is cut short")
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.filling) == 0
+	}, 5*time.Second, 10*time.Millisecond, "The failed fill is forgotten")
+	assert.Empty(t, partialFiles(t, dir))
+
+	up.failGets.Store(false)
+	status, body := get(t, url, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, blob, body)
+	waitCached(t, s, 1)
+}
+
+func TestServer_EvictsLeastRecentlyUsed(t *testing.T) {
+	a, b, c := blobOf(1000, 1), blobOf(1000, 2), blobOf(1000, 3)
+	up := newUpstream(t, a, b, c)
+	dir := t.TempDir()
+	s, err := New(dir, up.URL, 2500)
+	assert.NoError(t, err)
+	proxy := httptest.NewServer(s)
+	defer proxy.Close()
+	url := func(blob []byte) string { return proxy.URL + "/v2/library/tiny/blobs/" + digestOf(blob) }
+
+	get(t, url(a), "")
+	waitCached(t, s, 1)
+	get(t, url(b), "")
+	waitCached(t, s, 2)
+	get(t, url(a), "") // a is now used more recently than b.
+	get(t, url(c), "")
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_, hasB := s.blobs[digestOf(b)]
+		return len(s.blobs) == 2 && !hasB
+	}, 5*time.Second, 10*time.Millisecond)
+	_, size := s.Usage()
+	assert.Equal(t, int64(2000), size)
+
+	// The cache survives a restart.
+	s2, err := New(dir, up.URL, 2500)
+	assert.NoError(t, err)
+	n, size := s2.Usage()
+	assert.Equal(t, 2, n)
+	assert.Equal(t, int64(2000), size)
+}
+
+func TestServer_Manifests(t *testing.T) {
+	up := newUpstream(t)
+	s, err := New(t.TempDir(), up.URL, 0)
+	assert.NoError(t, err)
+	proxy := httptest.NewServer(s)
+	defer proxy.Close()
+
+	status, body := get(t, proxy.URL+"/v2/library/tiny/manifests/latest", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"layers":[]}`, string(body))
+
+	up.down.Store(true)
+	status, body = get(t, proxy.URL+"/v2/library/tiny/manifests/latest", "")
+	assert.Equal(t, http.StatusOK, status, "The kept copy is served while upstream is down")
+	assert.Equal(t, `{"layers":[]}`, string(body))
+
+	status, _ = get(t, proxy.URL+"/v2/library/tiny/manifests/8b", "")
+	assert.Equal(t, http.StatusBadGateway, status)
+	for _, path := range []string{"/v2/library/../manifests/latest", "/v2/library/tiny/manifests/..", "/v2/library/tiny/manifests/.hidden", "/v2/library//tiny/manifests/latest"} {
+		status, _ = get(t, proxy.URL+path, "")
+		assert.Equal(t, http.StatusBadRequest, status, path)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, tt := range []struct {
+		header      string
+		start, end  int64
+		partial, ok bool
+	}{
+		{"", 0, 100, false, true},
+		{"bytes=0-9", 0, 10, true, true},
+		{"bytes=90-", 90, 100, true, true},
+		{"bytes=-10", 90, 100, true, true},
+		{"bytes=50-500", 50, 100, true, true},
+		{"bytes=0-1,5-6", 0, 100, false, true},
+		{"bytes=100-", 0, 0, false, false},
+		{"bytes=9-1", 0, 0, false, false},
+	} {
+		start, end, partial, ok := parseRange(tt.header, 100)
+		assert.Equal(t, []any{tt.start, tt.end, tt.partial, tt.ok}, []any{start, end, partial, ok}, tt.header)
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	var iv intervals
+	iv.add(10, 20)
+	iv.add(30, 40)
+	iv.add(20, 30)
+	assert.Equal(t, intervals{{10, 40}}, iv)
+	assert.Equal(t, int64(40), iv.coveredFrom(15))
+	assert.Equal(t, int64(5), iv.coveredFrom(5))
+	assert.Equal(t, int64(10), iv.nextStart(0, 100))
+	assert.Equal(t, int64(100), iv.nextStart(10, 100))
+	iv.remove(20, 30)
+	assert.Equal(t, intervals{{10, 20}, {30, 40}}, iv)
+	assert.False(t, iv.covers(40))
+}
@@ -0,0 +1,74 @@
+package cache
+
+import "sort"
+
+// span is the half-open byte range [start, end).
+type span struct{ start, end int64 }
+
+// intervals is a set of byte ranges, kept sorted and merged.
+type intervals []span
+
+// add inserts [start, end), merging it with neighbours it touches.
+func (iv *intervals) add(start, end int64) {
+	if start >= end {
+		return
+	}
+	s := *iv
+	i := sort.Search(len(s), func(i int) bool { return s[i].end >= start })
+	j := i
+	for j < len(s) && s[j].start <= end {
+		start = min(start, s[j].start)
+		end = max(end, s[j].end)
+		j++
+	}
+	s = append(s[:i], append([]span{{start, end}}, s[j:]...)...)
+	*iv = s
+}
+
+// coveredFrom returns the end of the range containing pos, or pos if none does.
+func (iv intervals) coveredFrom(pos int64) int64 {
+	i := sort.Search(len(iv), func(i int) bool { return iv[i].end > pos })
+	if i < len(iv) && iv[i].start <= pos {
+		return iv[i].end
+	}
+	return pos
+}
+
+// nextStart returns the start of the first range after pos, or limit if there is none
+// before it.
+func (iv intervals) nextStart(pos, limit int64) int64 {
+	i := sort.Search(len(iv), func(i int) bool { return iv[i].start > pos })
+	if i < len(iv) && iv[i].start < limit {
+		return iv[i].start
+	}
+	return limit
+}
+
+// remove deletes the range [start, end) that was added as a whole.
+func (iv *intervals) remove(start, end int64) {
+	for i, s := range *iv {
+		if s.start <= start && end <= s.end {
+			rest := append(intervals{}, (*iv)[i+1:]...)
+			*iv = append((*iv)[:i], span{s.start, start}, span{end, s.end})
+			*iv = append(*iv, rest...)
+			iv.compact()
+			return
+		}
+	}
+}
+
+// compact drops empty ranges.
+func (iv *intervals) compact() {
+	out := (*iv)[:0]
+	for _, s := range *iv {
+		if s.start < s.end {
+			out = append(out, s)
+		}
+	}
+	*iv = out
+}
+
+// covers reports whether [0, size) is entirely in the set.
+func (iv intervals) covers(size int64) bool {
+	return iv.coveredFrom(0) >= size
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+
+	"ollama-downloader-v2/cache"
+	"ollama-downloader-v2/config"
+	"ollama-downloader-v2/registry"
+)
+
+// runCacheServerCommand serves the registry API from a local cache, so the Ollama
+// machines of a classroom or lab download each model from the internet once. It logs to
+// stderr, as a service does.
+func runCacheServerCommand(args []string) int {
+	fs := newFlagSet("cache-server")
+	listen := fs.String("listen", ":5000", "Address to serve the registry API on")
+	dir := fs.String("dir", "ollama-cache", "Directory to keep cached blobs and manifests in")
+	maxSize := fs.String("max-size", "", "Evict the least recently used blobs beyond this size (e.g. '500GB'); empty keeps everything")
+	upstream := fs.String("upstream", registry.DefaultURL, "Registry to cache")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	var limit int64
+	if *maxSize != "" {
+		var err error
+		if limit, err = config.ParseSize(*maxSize); err != nil {
+			logger.Error("invalid --max-size", "error", err)
+			return 1
+		}
+	}
+	s, err := cache.New(*dir, *upstream, limit)
+	if err != nil {
+		logger.Error("cannot open cache", "error", err)
+		return 1
+	}
+	s.Logger = logger
+	blobs, size := s.Usage()
+	logger.Info("serving registry cache", "listen", *listen, "upstream", *upstream, "dir", *dir,
+		"blobs", blobs, "size", size, "max_size", limit)
+	if err := http.ListenAndServe(*listen, s); err != nil {
+		logger.Error("cache server stopped", "error", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/config"
+	"ollama-downloader-v2/control"
+	"ollama-downloader-v2/ui"
+	"ollama-downloader-v2/useragent"
+)
+
+// command is a subcommand of the downloader.
+type command struct {
+	name string
+	// args is what follows the name in the usage line.
+	args    string
+	summary string
+	// ownLog is set for services, which log to stdout or stderr instead of the log file.
+	ownLog bool
+	run    func(args []string) int
+}
+
+// commands returns every subcommand in the order help lists them.
+func commands() []command {
+	return []command{
+		{name: "pull", args: "<model> [flags]", summary: "Download a model with a progress bar (the default command)", run: runPullCommand},
+		{name: "tags", args: "<model> [pull flags]", summary: "Pick a tag of a model from the registry and pull it", run: runTagsPullCommand},
+		{name: "list", args: "[--host <host>]", summary: "List the models installed on the host", run: runListCommand},
+		{name: "delete", args: "[--host <host>] <model>...", summary: "Delete models from the host", run: runDeleteCommand},
+		{name: "status", summary: "Show the progress of the running pull", run: noFlags("status", func() int { return runControlCommand(control.CommandStatus) })},
+		{name: "cancel", summary: "Cancel the running pull", run: noFlags("cancel", func() int { return runControlCommand(control.CommandCancel) })},
+		{name: "login", summary: "Log in to the identity provider in the config", run: noFlags("login", runLoginCommand)},
+		{name: "alias", args: "[add <name> <model> | remove <name>]", summary: "List, add or remove model aliases", run: runAliasCommand},
+		{name: "plan", args: "-f models.yaml [--prune] [--host <host>]", summary: "Show what apply would change on the host", run: runPlanCommand},
+		{name: "apply", args: "-f models.yaml [--prune] [--host <host>]", summary: "Pull and delete models until the host matches a manifest", run: runApplyCommand},
+		{name: "watch", args: "<model>... [--interval 6h] [--once] [--host <host>]", summary: "Pull models again whenever the registry publishes a new version", run: runWatchCommand},
+		{name: "monitor", args: "<model> [--host <host>]", summary: "Show the progress of a pull another client started on the host", run: runMonitorCommand},
+		{name: "doctor", args: "[--host <host>]", summary: "Check the connections to the host and the registry for common problems", run: runDoctorCommand},
+		{name: "usage", args: "[--monthly-cap 200GB]", summary: "Show how much data the pulls downloaded each month", run: runUsageCommand},
+		{name: "export", args: "<model> [-o model.tar]", summary: "Write a model from the registry to a bundle file", run: runExportCommand},
+		{name: "import-bundle", args: "<model.tar> [--name <model>] [--host <host>]", summary: "Load a bundle file into the host", run: runImportBundleCommand},
+		{name: "transfer", args: "--from <user@host> [--to <host>] <model>", summary: "Copy a model from another machine over ssh", run: runTransferCommand},
+		{name: "container", summary: "Pull the models in OLLAMA_DL_MODELS, logging JSON (for init containers)", ownLog: true, run: noFlags("container", runContainerCommand)},
+		{name: "cache-server", args: "[--listen :5000] [--dir <dir>] [--max-size 500GB]", summary: "Serve a caching proxy of the registry for a lab", ownLog: true, run: runCacheServerCommand},
+		{name: "help", args: "[command]", summary: "Show help for the downloader or a command", run: runHelpCommand},
+	}
+}
+
+// findCommand returns the subcommand called name, or nil.
+func findCommand(name string) *command {
+	for _, c := range commands() {
+		if c.name == name {
+			return &c
+		}
+	}
+	return nil
+}
+
+// globalFlags are accepted before the command name.
+type globalFlags struct {
+	host    string
+	config  string
+	logFile string
+	// debugDump writes a debug dump when the command ends.
+	debugDump  bool
+	userAgent  string
+	requestTag string
+	// logProgress is client.ProgressLogInterval.
+	logProgress time.Duration
+}
+
+func newGlobalFlagSet(g *globalFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(&g.host, "host", "", "Ollama API host for every command. Overrides OLLAMA_HOST.")
+	fs.StringVar(&g.config, "config", "", "Config file to use instead of the default ("+config.PathEnv+")")
+	fs.StringVar(&g.logFile, "log-file", "ollama-downloader.log", "File the log is appended to")
+	fs.BoolVar(&g.debugDump, "debug-dump", false, "Write a redacted debug dump for bug reports to the current directory when the command ends")
+	fs.StringVar(&g.userAgent, "user-agent", useragent.Default(), "User-Agent sent with every request to Ollama, the registry and the identity provider")
+	fs.StringVar(&g.requestTag, "request-tag", "", "Sent as the "+useragent.TagHeader+" header with every request, so server logs and proxies can attribute the traffic (e.g. 'ci-nightly')")
+	fs.DurationVar(&g.logProgress, "log-progress", 0, "Log a sample of Ollama's progress lines, with sizes and digests, at most once per interval (e.g. '1s') and on every phase change, to reconstruct a pull from the log")
+	return fs
+}
+
+// defaultHost is the host from the global --host flag; resolveHost prefers it to
+// OLLAMA_HOST.
+var defaultHost string
+
+// runCLI parses the global flags, sets up logging and runs the command args name.
+// Without a command, the arguments are those of pull, as before subcommands existed:
+// `ollama-downloader -model llama3`.
+func runCLI(args []string) int {
+	var g globalFlags
+	fs := newGlobalFlagSet(&g)
+	fs.SetOutput(io.Discard)
+	switch err := fs.Parse(args); {
+	case errors.Is(err, flag.ErrHelp):
+		printHelp(os.Stdout)
+		return 0
+	case err != nil:
+		// A flag of pull; parse everything as pull's.
+		g = globalFlags{logFile: "ollama-downloader.log", userAgent: useragent.Default()}
+	default:
+		args = fs.Args()
+	}
+	if len(args) == 0 {
+		printHelp(os.Stderr)
+		return 1
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil && !strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n", args[0])
+		printHelp(os.Stderr)
+		return 1
+	}
+	if cmd == nil {
+		cmd = findCommand("pull")
+	} else {
+		args = args[1:]
+	}
+
+	defaultHost = g.host
+	useragent.Value, useragent.Tag = g.userAgent, g.requestTag
+	client.ProgressLogInterval = g.logProgress
+	if g.config != "" {
+		os.Setenv(config.PathEnv, g.config)
+	}
+	if !cmd.ownLog {
+		logFile, err := os.OpenFile(g.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: opening log file:", err)
+			return 1
+		}
+		defer logFile.Close()
+		log.SetOutput(io.MultiWriter(logFile, dumps.Log))
+		logOutput = logFile
+	}
+	client.SessionRecorder = client.NewRecorder(dumps.API)
+	client.OnPanic = func(r any, stack []byte) { crash("the pull worker", r, stack) }
+	defer recoverCrash(cmd.name)
+	status := cmd.run(args)
+	if g.debugDump {
+		writeDump(fmt.Sprintf("requested with --debug-dump, %s exited with status %d", cmd.name, status))
+	}
+	return status
+}
+
+// newFlagSet returns the flag set of the named command, with help in the same format
+// for every command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		if cmd := findCommand(name); cmd != nil {
+			fmt.Fprintf(out, "Usage: %s\n\n%s.\n", strings.TrimSpace(os.Args[0]+" "+name+" "+cmd.args), cmd.summary)
+		}
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(out, "\nFlags:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// noFlags adapts a command without flags or arguments, so it still answers -h.
+func noFlags(name string, run func() int) func([]string) int {
+	return func(args []string) int {
+		fs := newFlagSet(name)
+		fs.Parse(args)
+		if fs.NArg() != 0 {
+			fs.Usage()
+			return 1
+		}
+		return run()
+	}
+}
+
+func printHelp(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [global flags] <command> [flags]\n", os.Args[0])
+	fmt.Fprintf(w, "       %s -model <model> [flags]   (same as pull)\n\n", os.Args[0])
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	for _, c := range commands() {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nGlobal flags:")
+	var g globalFlags
+	fs := newGlobalFlagSet(&g)
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the flags of a command.\n", os.Args[0])
+}
+
+// runHelpCommand prints the overview, or the help of one command.
+func runHelpCommand(args []string) int {
+	fs := newFlagSet("help")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		printHelp(os.Stdout)
+		return 0
+	}
+	cmd := findCommand(fs.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n", fs.Arg(0))
+		return 1
+	}
+	if cmd.name == "help" {
+		fs.Usage()
+		return 0
+	}
+	// Every command answers -h with its help and exits.
+	return cmd.run([]string{"-h"})
+}
+
+// runTagsPullCommand lets the user pick a tag of a model and pulls it with the flags
+// given after the model name.
+func runTagsPullCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs := newFlagSet("tags")
+		fs.Parse(args)
+		fs.Usage()
+		return 1
+	}
+	selected, code := runTagsCommand(args[0])
+	if selected == "" {
+		return code
+	}
+	return runPullCommand(append([]string{"-model", selected}, args[1:]...))
+}
+
+// runListCommand prints the models installed on the host.
+func runListCommand(args []string) int {
+	fs := newFlagSet("list")
+	host := fs.String("host", "", "Ollama API host. Overrides the global --host and OLLAMA_HOST.")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), client.RequestTimeout)
+	defer cancel()
+	models, err := client.ListModels(ctx, resolveHost(*host))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tID\tSIZE")
+	for _, m := range models {
+		id := m.Digest
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", m.Name, id, ui.FormatBytes(m.Size))
+	}
+	tw.Flush()
+	return 0
+}
+
+// runDeleteCommand deletes the named models from the host.
+func runDeleteCommand(args []string) int {
+	fs := newFlagSet("delete")
+	host := fs.String("host", "", "Ollama API host. Overrides the global --host and OLLAMA_HOST.")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	target := resolveHost(*host)
+	status := 0
+	for _, model := range fs.Args() {
+		ctx, cancel := context.WithTimeout(context.Background(), client.RequestTimeout)
+		err := client.DeleteModel(ctx, target, model)
+		cancel()
+		if err != nil {
+			log.Printf("Delete of %s failed: %v", model, err)
+			fmt.Printf("Error deleting %s: %v\n", model, err)
+			status = 1
+			continue
+		}
+		log.Printf("Deleted %s from %s", model, target)
+		fmt.Printf("Deleted %s.\n", model)
+	}
+	return status
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"ollama-downloader-v2/useragent"
+)
+
+// InstalledModel is one entry of GET /api/tags.
+type InstalledModel struct {
+	Name   string `json:"name"`
+	Model  string `json:"model"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// tagsResponse is the body of GET /api/tags, the list of installed models.
+type tagsResponse struct {
+	Models []InstalledModel `json:"models"`
+}
+
+// ListModels returns the models installed on host.
+func ListModels(ctx context.Context, host string) ([]InstalledModel, error) {
+	var tags tagsResponse
+	if err := getJSON(ctx, host, "/api/tags", &tags); err != nil {
+		return nil, err
+	}
+	return tags.Models, nil
+}
+
+// RunningModel is one entry of GET /api/ps, a model loaded in memory.
+type RunningModel struct {
+	Name  string `json:"name"`
+	Model string `json:"model"`
+	// Size is the memory the model takes, SizeVRAM the part of it on the GPU.
+	Size     int64 `json:"size"`
+	SizeVRAM int64 `json:"size_vram"`
+}
+
+// RunningModels returns the models host has loaded in memory.
+func RunningModels(ctx context.Context, host string) ([]RunningModel, error) {
+	var ps struct {
+		Models []RunningModel `json:"models"`
+	}
+	if err := getJSON(ctx, host, "/api/ps", &ps); err != nil {
+		return nil, err
+	}
+	return ps.Models, nil
+}
+
+// ModelDetails is the part of POST /api/show that describes an installed model.
+type ModelDetails struct {
+	Details struct {
+		Family            string   `json:"family"`
+		Families          []string `json:"families"`
+		ParameterSize     string   `json:"parameter_size"`
+		QuantizationLevel string   `json:"quantization_level"`
+	} `json:"details"`
+	// Capabilities are e.g. "completion", "embedding", "vision" and "tools". Ollama
+	// before 0.6.4 does not report them.
+	Capabilities []string `json:"capabilities"`
+}
+
+// Embedding reports whether the model only computes embeddings and cannot generate text.
+func (d ModelDetails) Embedding() bool {
+	return slices.Contains(d.Capabilities, "embedding") && !slices.Contains(d.Capabilities, "completion")
+}
+
+// ShowModel returns what host knows about the installed model.
+func ShowModel(ctx context.Context, host string, model string) (ModelDetails, error) {
+	var res ModelDetails
+	if err := postJSON(ctx, host, "/api/show", map[string]string{"model": model}, &res); err != nil {
+		return ModelDetails{}, fmt.Errorf("showing %s: %w", model, err)
+	}
+	return res, nil
+}
+
+// Version returns the Ollama version host runs, e.g. "0.5.7".
+func Version(ctx context.Context, host string) (string, error) {
+	var res struct {
+		Version string `json:"version"`
+	}
+	if err := getJSON(ctx, host, "/api/version", &res); err != nil {
+		return "", err
+	}
+	return res.Version, nil
+}
+
+// DeleteModel removes model from host.
+func DeleteModel(ctx context.Context, host string, model string) error {
+	body, err := json.Marshal(map[string]string{"model": model})
+	if err != nil {
+		return fmt.Errorf("error marshalling request: %w", err)
+	}
+	url, err := endpoint(host, "/api/delete")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doJSON(httpClient(host), req, nil)
+}
+
+// HasBlob reports whether host already stores the blob with digest.
+func HasBlob(ctx context.Context, host, digest string) (bool, error) {
+	url, err := endpoint(host, "/api/blobs/"+digest)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %w", err)
+	}
+	err = doJSON(httpClient(host), req, nil)
+	if errors.Is(err, ErrModelNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// PushBlob uploads size bytes from r to host as the blob with digest. Ollama checks the
+// digest and rejects the blob if it does not match.
+func PushBlob(ctx context.Context, host, digest string, r io.Reader, size int64) error {
+	url, err := endpoint(host, "/api/blobs/"+digest)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, r)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if err := doJSON(httpClient(host), req, nil); err != nil {
+		return fmt.Errorf("uploading blob %s: %w", digest, err)
+	}
+	return nil
+}
+
+// CreateRequest is the body of POST /api/create for a model assembled from uploaded
+// blobs. Files and Adapters map file names to blob digests.
+type CreateRequest struct {
+	Model      string            `json:"model"`
+	Files      map[string]string `json:"files,omitempty"`
+	Adapters   map[string]string `json:"adapters,omitempty"`
+	Template   string            `json:"template,omitempty"`
+	System     string            `json:"system,omitempty"`
+	License    []string          `json:"license,omitempty"`
+	Parameters map[string]any    `json:"parameters,omitempty"`
+	Messages   json.RawMessage   `json:"messages,omitempty"`
+	Stream     bool              `json:"stream"`
+}
+
+// CreateModel creates a model on host from blobs uploaded with PushBlob.
+func CreateModel(ctx context.Context, host string, req CreateRequest) error {
+	req.Stream = false
+	var res struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	if err := postJSON(ctx, host, "/api/create", req, &res); err != nil {
+		return fmt.Errorf("creating %s: %w", req.Model, err)
+	}
+	if res.Status != "success" {
+		return fmt.Errorf("creating %s: %s", req.Model, res.Error)
+	}
+	return nil
+}
+
+// getJSON requests path on host and decodes the JSON response into out.
+func getJSON(ctx context.Context, host, path string, out any) error {
+	url, err := endpoint(host, path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	return doJSON(httpClient(host), req, out)
+}
+
+// postJSON sends in as a JSON POST body to path on host and decodes the JSON response
+// into out.
+func postJSON(ctx context.Context, host, path string, in any, out any) error {
+	url, err := endpoint(host, path)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("error marshalling request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doJSON(httpClient(host), req, out)
+}
+
+// doJSON sends req with c and decodes a JSON response into out, unless out is nil.
+func doJSON(c *http.Client, req *http.Request, out any) error {
+	useragent.Set(req)
+	if Authorize != nil {
+		if err := Authorize(req); err != nil {
+			return err
+		}
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		if isTimeout(err) || errors.Is(err, context.Canceled) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrHostUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	// Uploading a blob answers 201 Created.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return &APIStatusError{Code: resp.StatusCode, Body: string(bodyBytes)}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
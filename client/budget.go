@@ -0,0 +1,75 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrRetryBudgetExhausted is returned when Pull would retry automatically but
+// Retries allows no more attempts.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudget caps automatic retries, per model and across every pull that shares it,
+// so a model that can never be pulled does not retry forever in a batch.
+// A nil *RetryBudget allows unlimited retries.
+type RetryBudget struct {
+	// PerModel is how many retries one model may use; 0 means no per-model limit.
+	PerModel int
+	// Total is how many retries all models together may use; 0 means no overall limit.
+	Total int
+
+	mu      sync.Mutex
+	used    int
+	byModel map[string]int
+	limits  map[string]int
+}
+
+// Retries, when set, is consulted by Pull before every automatic retry.
+var Retries *RetryBudget
+
+// Spend takes one retry for model from the budget, or returns an error wrapping
+// ErrRetryBudgetExhausted if none is left.
+func (b *RetryBudget) Spend(model string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limit := b.PerModel
+	if l, ok := b.limits[model]; ok {
+		limit = l
+	}
+	if limit > 0 && b.byModel[model] >= limit {
+		return fmt.Errorf("%w: %s failed %d retries", ErrRetryBudgetExhausted, model, limit)
+	}
+	if b.Total > 0 && b.used >= b.Total {
+		return fmt.Errorf("%w: all %d retries of the batch are used", ErrRetryBudgetExhausted, b.Total)
+	}
+	if b.byModel == nil {
+		b.byModel = make(map[string]int)
+	}
+	b.byModel[model]++
+	b.used++
+	return nil
+}
+
+// SetLimit overrides PerModel for one model; 0 means no limit for it.
+func (b *RetryBudget) SetLimit(model string, n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limits == nil {
+		b.limits = make(map[string]int)
+	}
+	b.limits[model] = n
+}
+
+// Used returns how many retries model has taken.
+func (b *RetryBudget) Used(model string) int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.byModel[model]
+}
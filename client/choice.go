@@ -0,0 +1,64 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// Choice is an answer of the UI to a pull, sent over PullOptions.Choices. The UI shows
+// its String as the menu label; the pull only ever compares the value. The zero Choice
+// is no choice.
+type Choice int
+
+const (
+	// ChoiceContinue retries after a timeout and asks again on the next one.
+	ChoiceContinue Choice = iota + 1
+	// ChoiceContinueUntilComplete retries after a timeout and every later one without
+	// asking, like PullOptions.AutoRetry.
+	ChoiceContinueUntilComplete
+	// ChoiceShorterTimeout retries after a timeout with half the request timeout, down to
+	// MinRequestTimeout, so a stalled stream is noticed and restarted sooner.
+	ChoiceShorterTimeout
+	// ChoiceChangeHost ends the pull so the caller can restart it against another host.
+	ChoiceChangeHost
+	// ChoiceFinishLayer asks Pull to let the layer currently downloading complete and then
+	// stop, so the partial blob Ollama keeps on disk is as large as possible.
+	ChoiceFinishLayer
+	// ChoiceQuit stops the pull.
+	ChoiceQuit
+)
+
+// MinRequestTimeout is as far as ChoiceShorterTimeout lowers the request timeout.
+var MinRequestTimeout = 5 * time.Second
+
+func (c Choice) String() string {
+	switch c {
+	case ChoiceContinue:
+		return "Continue (until next error)"
+	case ChoiceContinueUntilComplete:
+		return "Continue (until download completed)"
+	case ChoiceShorterTimeout:
+		return "Retry with a shorter timeout"
+	case ChoiceChangeHost:
+		return "Change host and retry"
+	case ChoiceFinishLayer:
+		return "Finish current layer, then quit"
+	case ChoiceQuit:
+		return "Quit"
+	case 0:
+		return ""
+	default:
+		return fmt.Sprintf("Choice(%d)", int(c))
+	}
+}
+
+// stops reports whether c ends the pull.
+func (c Choice) stops() bool {
+	return c == ChoiceQuit || c == ChoiceFinishLayer || c == ChoiceChangeHost
+}
+
+// ShortenTimeout halves timeout for ChoiceShorterTimeout, not going below
+// MinRequestTimeout.
+func ShortenTimeout(timeout time.Duration) time.Duration {
+	return max(timeout/2, min(timeout, MinRequestTimeout))
+}
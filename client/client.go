@@ -11,15 +11,20 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"strings"
+	"runtime/debug"
+	"syscall"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+
+	"ollama-downloader-v2/useragent"
 )
 
 type PullRequest struct {
 	Model  string `json:"model"`
 	Stream bool   `json:"stream"`
+	// Insecure lets Ollama pull from a registry over plain HTTP.
+	Insecure bool `json:"insecure,omitempty"`
 }
 
 type OllamaResponse struct {
@@ -27,118 +32,612 @@ type OllamaResponse struct {
 	Digest    string `json:"digest"`
 	Total     int64  `json:"total"`
 	Completed int64  `json:"completed"`
+	// Error is set on a line that reports a failure instead of progress, see OllamaError.
+	Error string `json:"error,omitempty"`
+}
+
+// OnPanic, when set, is called with a panic in one of the goroutines a pull runs and its
+// stack, so the program can restore the terminal and report the crash before it exits.
+// Without it the panic crashes the program as usual.
+var OnPanic func(recovered any, stack []byte)
+
+// recoverPanic passes a panic of the calling goroutine to onPanic. It must be deferred
+// directly.
+func recoverPanic(onPanic func(recovered any, stack []byte)) {
+	if onPanic == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		onPanic(r, debug.Stack())
+	}
 }
 
+// Authorize, when set, adds credentials to every request sent to the Ollama host.
+var Authorize func(req *http.Request) error
+
+// RequestTimeout bounds a single /api/pull attempt. When it expires the user is offered
+// to continue, which resumes the download where Ollama left off.
+var RequestTimeout = 30 * time.Second
+
+// ManifestTimeout bounds the "pulling manifest" phase that starts every attempt. A hang
+// there almost always means a mistyped model name or a registry outage, so it fails with
+// ErrManifestTimeout long before RequestTimeout.
+var ManifestTimeout = 10 * time.Second
+
+// RestartWait bounds how long Pull waits for a host that went away mid-pull, e.g. because
+// Ollama is being upgraded, to come back before it reports the error. Zero disables
+// waiting.
+var RestartWait = 2 * time.Minute
+
+// restartPoll is how often Pull tries to reconnect while the host restarts.
+var restartPoll = 2 * time.Second
+
+// QueueWait bounds how long Pull waits for the host to accept a pull it refused because
+// another pull is in progress there. Zero disables waiting.
+var QueueWait = 30 * time.Minute
+
+// queuePoll is how often Pull asks again while another pull runs on the host. Once the
+// host accepts, a pull of the same model joins the running download and streams its
+// progress.
+var queuePoll = 5 * time.Second
+
+// GatewayRetries is how often in a row Pull retries when a reverse proxy in front of the
+// host answers 502, 503 or 504, before it reports the error. With AutoRetry the retries
+// go on for as long as the retry budget allows.
+var GatewayRetries = 5
+
+// gatewayBackoff is the wait before the first retry after a gateway error; it doubles
+// with every further one up to maxGatewayBackoff, unless the proxy sends Retry-After.
+var gatewayBackoff = time.Second
+
+const maxGatewayBackoff = 30 * time.Second
+
+// statusPullingManifest is the status Ollama reports while it resolves the manifest.
+const statusPullingManifest = "pulling manifest"
+
+// MaxLineSize is the largest single line accepted from the streamed /api/pull response.
+// Error bodies and manifests can be far larger than bufio's default 64KB token limit.
+// Lines above this limit end the attempt with a StreamError wrapping bufio.ErrTooLong.
+var MaxLineSize = 4 * 1024 * 1024
+
 type ProgressMsg struct {
-	Status    string
+	Status string
+	// Digest is the layer the phase is about, e.g. "sha256:6a0746a1ec1a…", or empty in
+	// phases such as "pulling manifest".
+	Digest    string
 	Completed int64
 	Total     int64
+	// Layer numbers Digest from 1 in the order Ollama pulls the layers, one after
+	// another. Layers is how many layers the model has, or 0 if PullOptions.Layers was
+	// not set; both are 0 without a Digest.
+	Layer, Layers int
+}
+
+// TimeoutReason says why an attempt ended before the download completed.
+type TimeoutReason int
+
+const (
+	// ReasonDeadline is the request timeout of this client expiring, or another
+	// timeout on this side of the connection.
+	ReasonDeadline TimeoutReason = iota
+	// ReasonServerClosed is the host, or a proxy in between, breaking off the stream.
+	ReasonServerClosed
+	// ReasonStreamEnded is the stream ending cleanly but without a "success" status.
+	ReasonStreamEnded
+)
+
+func (r TimeoutReason) String() string {
+	switch r {
+	case ReasonServerClosed:
+		return "the server closed the stream"
+	case ReasonStreamEnded:
+		return "the stream ended without success"
+	default:
+		return "the request deadline expired"
+	}
 }
 
-type TimeoutMsg struct{}
+// TimeoutMsg reports an attempt that ended early, and asks for a Choice on how to go
+// on. Err is what ended it.
+type TimeoutMsg struct {
+	Reason TimeoutReason
+	Err    error
+}
 
 type ErrorMsg struct {
 	Err error
 }
 
-func PullModel(ctx context.Context, model string, host string, progressCh chan<- tea.Msg, continueUntilComplete bool, userChoiceCh <-chan string) {
+// QueuedMsg reports that the host refused the pull because another pull is in progress
+// there, and that Pull asks again until it is accepted, for up to Wait. Err is the
+// host's answer.
+type QueuedMsg struct {
+	Err  error
+	Wait time.Duration
+}
+
+// RetryingMsg reports that a reverse proxy in front of the host answered with a gateway
+// error, Err, and that Pull tries again after Delay. Attempt counts the retries in a row.
+type RetryingMsg struct {
+	Err     error
+	Attempt int
+	Delay   time.Duration
+}
+
+// RetryCause classifies why an attempt failed and Pull started another, see RetriedMsg.
+type RetryCause string
+
+const (
+	// CauseTimeout is the request deadline expiring while the download still advanced;
+	// long pulls take many attempts.
+	CauseTimeout RetryCause = "timeout"
+	// CauseStall is an attempt that made no progress before its deadline, or hung on
+	// the manifest.
+	CauseStall RetryCause = "stall"
+	// CauseReset is the connection dropped, refused or broken off by the host or a
+	// proxy, or a stream that ended without success.
+	CauseReset RetryCause = "reset"
+	// CauseGateway is a proxy in front of the host answering 502, 503 or 504.
+	CauseGateway RetryCause = "gateway"
+)
+
+// RetryCauseOf classifies err, the error that ended an attempt. It cannot tell a stall
+// from a timeout, which needs to know whether the attempt advanced.
+func RetryCauseOf(err error) RetryCause {
+	switch {
+	case errors.Is(err, ErrGatewayUnavailable):
+		return CauseGateway
+	case errors.Is(err, ErrManifestTimeout):
+		return CauseStall
+	case isTimeout(err):
+		return CauseTimeout
+	default:
+		return CauseReset
+	}
+}
+
+// RetriedMsg reports that Pull starts another attempt after one failed with Err. A host
+// restart counts once, however often Pull polls it; waiting for another pull on the host
+// to finish does not count.
+type RetriedMsg struct {
+	Cause RetryCause
+	Err   error
+}
+
+// RestartingMsg reports that the host dropped or refused the connection after the pull
+// had started, and that Pull waits up to Wait for it to come back. Err is what ended
+// the connection.
+type RestartingMsg struct {
+	Err  error
+	Wait time.Duration
+}
+
+// PullOptions configures Pull. Zero values fall back to the package-level settings, so
+// new settings can be added without breaking callers.
+type PullOptions struct {
+	// Model is the model to pull, e.g. "llama3:8b".
+	Model string
+	// Host is the Ollama host, in any form ParseHost accepts.
+	Host string
+	// Progress receives ProgressMsg, TimeoutMsg and ErrorMsg, and is closed when the pull
+	// ends.
+	Progress chan<- tea.Msg
+	// Choices carries the answers to TimeoutMsg and ChoiceFinishLayer. Nil never answers.
+	Choices <-chan Choice
+	// AutoRetry retries timeouts and unfinished streams on its own instead of sending
+	// TimeoutMsg and waiting for a choice.
+	AutoRetry bool
+	// Retries limits the automatic retries; nil uses the package's Retries.
+	Retries *RetryBudget
+	// Timeouts overrides RequestTimeout and ManifestTimeout, and what Tuner picks, where
+	// set.
+	Timeouts Timeouts
+	// Headers are sent with every request, before Authorize adds credentials.
+	Headers http.Header
+	// Authorize adds credentials to every request; nil uses the package's Authorize.
+	Authorize func(req *http.Request) error
+	// Insecure lets Ollama pull from a registry over plain HTTP, e.g. a cache-server.
+	Insecure bool
+	// Hold, if set, pauses the pull while it is held.
+	Hold *Hold
+	// Layers is how many layers the model has, e.g. from its manifest, so that
+	// ProgressMsg can say how many are left.
+	Layers int
+	// OnPanic is called with a panic in the pull's goroutines; nil uses the package's
+	// OnPanic.
+	OnPanic func(recovered any, stack []byte)
+}
+
+// Timeouts bounds the phases of a pull attempt. Zero fields use the package defaults.
+type Timeouts struct {
+	// Request bounds a whole attempt, like RequestTimeout.
+	Request time.Duration
+	// Manifest bounds the "pulling manifest" phase, like ManifestTimeout.
+	Manifest time.Duration
+}
+
+// EffectiveTimeouts returns the timeouts a pull with o starts with: o.Timeouts where set,
+// then what Tuner picks for o.Host, then RequestTimeout and ManifestTimeout.
+func (o PullOptions) EffectiveTimeouts() Timeouts {
+	timeouts := o.Timeouts
+	if Tuner != nil {
+		timeouts = timeouts.or(Tuner.Timeouts(o.Host))
+	}
+	return timeouts.or(Timeouts{Request: RequestTimeout, Manifest: ManifestTimeout})
+}
+
+// PullModel pulls model from host, reporting on progressCh.
+//
+// Deprecated: Use Pull, whose options can grow without changing its signature.
+func PullModel(ctx context.Context, model string, host string, progressCh chan<- tea.Msg, continueUntilComplete bool, userChoiceCh <-chan Choice) {
+	Pull(ctx, PullOptions{
+		Model:     model,
+		Host:      host,
+		Progress:  progressCh,
+		Choices:   userChoiceCh,
+		AutoRetry: continueUntilComplete,
+	})
+}
+
+// Pull starts pulling opts.Model with /api/pull in the background. Timeouts are either
+// retried or offered to the user (see PullOptions.AutoRetry); the pull ends when it
+// succeeds, fails, is cancelled through ctx, or the user quits. Cancelling ctx also
+// ends a pull whose Progress channel nobody reads any more. If the host drops or refuses
+// the connection once it has answered, Pull sends a RestartingMsg and reconnects on its
+// own for up to RestartWait.
+func Pull(ctx context.Context, opts PullOptions) {
+	model, host := opts.Model, opts.Host
+	progressCh, userChoiceCh := opts.Progress, opts.Choices
+	continueUntilComplete := opts.AutoRetry
+	tuner, timeouts := Tuner, opts.EffectiveTimeouts()
+	requestTimeout, manifestTimeout := timeouts.Request, timeouts.Manifest
+	authorize := opts.Authorize
+	if authorize == nil {
+		authorize = Authorize
+	}
+	retries := opts.Retries
+	if retries == nil {
+		retries = Retries
+	}
+	onPanic := opts.OnPanic
+	if onPanic == nil {
+		onPanic = OnPanic
+	}
 	go func() {
+		defer recoverPanic(onPanic)
 		// A single defer ensures the channel is always closed on exit.
 		defer close(progressCh)
 
 		pullReq := PullRequest{
-			Model:  model,
-			Stream: true,
+			Model:    model,
+			Stream:   true,
+			Insecure: opts.Insecure,
 		}
 
 		body, err := json.Marshal(pullReq)
 		if err != nil {
 			log.Printf("Error marshalling request: %v", err)
-			progressCh <- ErrorMsg{Err: fmt.Errorf("error marshalling request: %w", err)}
+			send(ctx, progressCh, ErrorMsg{Err: fmt.Errorf("error marshalling request: %w", err)})
 			return
 		}
 
-		// FIX: Use the default client so it can be configured in tests.
-		client := http.DefaultClient
+		// The default client, so it can be configured in tests, unless host is a Unix socket.
+		client := httpClient(host)
 		var downloadFinished bool
+		// finishLayer is set once the user asks to stop after the layer currently downloading.
+		var finishLayer bool
+		// connected is set once the host answered. restartSince is when it went away after
+		// that, backSince when it answered again; a host that drops the connection again
+		// soon after is still restarting.
+		var connected bool
+		var restartSince, backSince time.Time
+		// queuedSince is when the host first refused the pull for another one in progress.
+		var queuedSince time.Time
+		// gatewayFailures counts the gateway errors since the host last answered.
+		var gatewayFailures int
+		// progressed is set once the current attempt's download advanced.
+		var progressed bool
+		// layers numbers the digests in the order they appeared, across attempts.
+		layers := make(map[string]int)
+		var sampler *progressSampler
+		if ProgressLogInterval > 0 {
+			sampler = newProgressSampler(model, ProgressLogInterval)
+		}
+		// retried reports that another attempt follows one that failed for cause.
+		retried := func(cause RetryCause, err error) bool {
+			return send(ctx, progressCh, RetriedMsg{Cause: cause, Err: err})
+		}
+		// retryOrAsk handles an attempt that ended early for reason with err: it retries on
+		// its own with auto-retry, else asks with a TimeoutMsg. It reports whether to retry.
+		retryOrAsk := func(reason TimeoutReason, err error) bool {
+			cause := RetryCauseOf(err)
+			if reason == ReasonDeadline && !progressed {
+				cause = CauseStall
+			}
+			log.Printf("Attempt ended early, %s: %v. continueUntilComplete: %t", reason, err, continueUntilComplete)
+			if continueUntilComplete {
+				if spendErr := retries.Spend(model); spendErr != nil {
+					send(ctx, progressCh, ErrorMsg{Err: fmt.Errorf("%w (last attempt: %w)", spendErr, err)})
+					return false
+				}
+				return retried(cause, err) && sleep(ctx, time.Second)
+			}
+			if !send(ctx, progressCh, TimeoutMsg{Reason: reason, Err: err}) {
+				return false
+			}
+			select {
+			case choice := <-userChoiceCh:
+				switch choice {
+				case ChoiceContinue:
+					return retried(cause, err)
+				case ChoiceContinueUntilComplete:
+					continueUntilComplete = true
+					return retried(cause, err)
+				case ChoiceShorterTimeout:
+					requestTimeout = ShortenTimeout(requestTimeout)
+					log.Printf("Retrying with a request timeout of %s.", requestTimeout)
+					return retried(cause, err)
+				default:
+					return false
+				}
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// waitWhileHeld waits while opts.Hold is held, and reports whether to go on.
+		waitWhileHeld := func() bool {
+			for {
+				held, reason, changed := opts.Hold.state()
+				if !held {
+					return true
+				}
+				log.Printf("Pull paused: %s.", reason)
+				if !send(ctx, progressCh, PausedMsg{Reason: reason}) {
+					return false
+				}
+				select {
+				case <-changed:
+				case choice := <-userChoiceCh:
+					if choice.stops() {
+						log.Println("User chose to quit while paused.")
+						return false
+					}
+				case <-ctx.Done():
+					return false
+				}
+			}
+		}
 
 	retryLoop:
 		for {
+			if finishLayer {
+				log.Println("Stopping before the next attempt as requested.")
+				return
+			}
+
 			// Check for cancellation or user quit before starting a new attempt.
 			select {
 			case <-ctx.Done():
 				log.Println("Main context cancelled (top of loop).")
 				return
 			case choice := <-userChoiceCh:
-				if choice == "Quit" {
+				if choice.stops() {
 					log.Println("User chose to quit (top of loop).")
 					return
 				}
 			default:
 				// Continue
 			}
+			if !waitWhileHeld() {
+				return
+			}
 
 			// This anonymous function scopes a single download attempt,
 			// correctly managing its context and deferred calls.
-			err := func() error {
-				reqCtx, reqCancel := context.WithTimeout(ctx, 30*time.Second)
+			err := func() (err error) {
+				progressed = false
+				reqCtx, reqCancel := context.WithTimeout(ctx, requestTimeout)
 				defer reqCancel()
+				reqCtx, cancelManifest := context.WithCancelCause(reqCtx)
+				defer cancelManifest(nil)
+				manifestTimer := time.AfterFunc(manifestTimeout, func() { cancelManifest(ErrManifestTimeout) })
+				defer manifestTimer.Stop()
+				defer func() {
+					// Whatever the cancelled request surfaced as, report the manifest hang.
+					if err != nil && errors.Is(context.Cause(reqCtx), ErrManifestTimeout) {
+						err = ErrManifestTimeout
+					}
+				}()
 
-				req, err := http.NewRequestWithContext(reqCtx, "POST", host+"/api/pull", bytes.NewBuffer(body))
+				url, err := endpoint(host, "/api/pull")
+				if err != nil {
+					return err
+				}
+				SessionRecorder.StartAttempt()
+				req, err := http.NewRequestWithContext(reqCtx, "POST", url, bytes.NewBuffer(body))
 				if err != nil {
 					return fmt.Errorf("error creating request: %w", err)
 				}
+				useragent.Set(req)
+				for k, v := range opts.Headers {
+					req.Header[k] = v
+				}
 				req.Header.Set("Content-Type", "application/json")
+				if authorize != nil {
+					if err := authorize(req); err != nil {
+						return err
+					}
+				}
 
+				// answered is set once the first line of the stream arrives.
+				sent, answered := time.Now(), false
+				if tuner != nil {
+					defer func() {
+						if !answered && (isTimeout(err) || errors.Is(context.Cause(reqCtx), ErrManifestTimeout)) {
+							tuner.FirstByte(host, time.Since(sent), true)
+						}
+					}()
+				}
 				resp, err := client.Do(req)
 				if err != nil {
-					return err // Return error to the outer loop for timeout/retry logic.
+					if isTimeout(err) || errors.Is(err, context.Canceled) {
+						return err // Return error to the outer loop for timeout/retry logic.
+					}
+					return fmt.Errorf("%w: %w", ErrHostUnreachable, err)
 				}
 				defer resp.Body.Close()
+				SessionRecorder.RecordResponse(NewResponseInfo(resp))
 
 				if resp.StatusCode != http.StatusOK {
 					bodyBytes, _ := io.ReadAll(resp.Body)
-					return fmt.Errorf("ollama API returned status %d: %s", resp.StatusCode, string(bodyBytes))
+					SessionRecorder.RecordStatus(resp.StatusCode, bodyBytes)
+					return &APIStatusError{Code: resp.StatusCode, Body: string(bodyBytes), RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+				}
+				if !restartSince.IsZero() && backSince.IsZero() {
+					log.Printf("Host is back after %s.", time.Since(restartSince).Round(time.Second))
+					backSince = time.Now()
+				}
+				if !queuedSince.IsZero() {
+					log.Printf("Host accepted the pull after %s.", time.Since(queuedSince).Round(time.Second))
+					queuedSince = time.Time{}
+				}
+				connected = true
+				gatewayFailures = 0
+
+				if sampler != nil {
+					// The last line of an attempt shows where it ended.
+					defer func() {
+						for _, l := range sampler.flush() {
+							log.Print(l)
+						}
+					}()
 				}
 
 				// Decouple I/O to allow concurrent user input handling.
 				linesCh := make(chan []byte)
 				errCh := make(chan error, 1)
 				go func() {
+					defer recoverPanic(onPanic)
 					defer close(linesCh)
 					scanner := bufio.NewScanner(resp.Body)
+					scanner.Buffer(make([]byte, 0, 64*1024), MaxLineSize)
 					for scanner.Scan() {
 						lineCopy := make([]byte, len(scanner.Bytes()))
 						copy(lineCopy, scanner.Bytes())
-						linesCh <- lineCopy
+						select {
+						case linesCh <- lineCopy:
+						case <-reqCtx.Done():
+							// The attempt is over and nobody reads the rest.
+							return
+						}
+					}
+					err := scanner.Err()
+					if errors.Is(err, bufio.ErrTooLong) {
+						err = fmt.Errorf("response line exceeds %d bytes: %w", MaxLineSize, err)
 					}
-					errCh <- scanner.Err()
+					errCh <- err
 				}()
 
+				// Coalesce bursts of progress lines so a slow terminal never stalls the scanner.
+				updates := newCoalescer(ProgressUpdateInterval)
+				flushTicker := time.NewTicker(ProgressUpdateInterval)
+				defer flushTicker.Stop()
+				var last OllamaResponse
+				_, _, holdChanged := opts.Hold.state()
+
 			processingLoop:
 				for {
 					select {
 					case line, ok := <-linesCh:
 						if !ok {
+							if pending, ok := updates.flush(time.Now(), true); ok && !send(ctx, progressCh, pending) {
+								return ctx.Err()
+							}
 							break processingLoop // Stream finished.
 						}
+						SessionRecorder.Record(line)
+						if !answered {
+							answered = true
+							if tuner != nil {
+								tuner.FirstByte(host, time.Since(sent), false)
+							}
+						}
 						var msg OllamaResponse
 						if err := json.Unmarshal(line, &msg); err != nil {
 							log.Printf("Ignoring non-JSON line from Ollama API: %s", string(line))
 							continue
 						}
+						if msg.Error != "" {
+							log.Printf("Ollama reported an error in the stream: %s", msg.Error)
+							return &OllamaError{Message: msg.Error}
+						}
+						if sampler != nil {
+							for _, l := range sampler.offer(msg, time.Now()) {
+								log.Print(l)
+							}
+						}
 						if msg.Status == "success" {
 							downloadFinished = true
 						}
-						progressCh <- ProgressMsg{
+						if msg.Status != statusPullingManifest {
+							manifestTimer.Stop()
+						}
+						update := ProgressMsg{
 							Status:    msg.Status,
+							Digest:    msg.Digest,
 							Completed: msg.Completed,
 							Total:     msg.Total,
 						}
+						if msg.Digest != "" {
+							if _, ok := layers[msg.Digest]; !ok {
+								layers[msg.Digest] = len(layers) + 1
+							}
+							update.Layer = layers[msg.Digest]
+							if opts.Layers > 0 {
+								update.Layers = max(opts.Layers, len(layers))
+							}
+						}
+						for _, out := range updates.offer(update, time.Now()) {
+							if !send(ctx, progressCh, out) {
+								return ctx.Err()
+							}
+						}
+						if msg.Digest != "" && msg.Digest == last.Digest && msg.Completed > last.Completed {
+							progressed = true
+						}
+						last = msg
+						if finishLayer && !layerInFlight(last) {
+							if pending, ok := updates.flush(time.Now(), true); ok && !send(ctx, progressCh, pending) {
+								return ctx.Err()
+							}
+							log.Println("Current layer finished, stopping as requested.")
+							return errUserQuit
+						}
+					case <-flushTicker.C:
+						if pending, ok := updates.flush(time.Now(), false); ok && !send(ctx, progressCh, pending) {
+							return ctx.Err()
+						}
+					case <-holdChanged:
+						var held bool
+						if held, _, holdChanged = opts.Hold.state(); held {
+							if pending, ok := updates.flush(time.Now(), true); ok && !send(ctx, progressCh, pending) {
+								return ctx.Err()
+							}
+							return errPaused
+						}
 					case choice := <-userChoiceCh:
-						if choice == "Quit" {
+						if choice == ChoiceQuit {
 							log.Println("User chose to quit during download.")
-							return errors.New("user quit")
+							return errUserQuit
+						}
+						if choice == ChoiceFinishLayer {
+							finishLayer = true
+							if !layerInFlight(last) {
+								log.Println("No layer in flight, stopping as requested.")
+								return errUserQuit
+							}
+							log.Printf("Finishing layer %s before stopping.", last.Digest)
 						}
 					case <-ctx.Done():
 						log.Println("Main context cancelled during stream reading.")
@@ -147,52 +646,113 @@ func PullModel(ctx context.Context, model string, host string, progressCh chan<-
 				}
 
 				if err := <-errCh; err != nil {
-					return fmt.Errorf("error reading response stream: %w", err)
+					return &StreamError{Err: err}
 				}
 				return nil
 			}()
 
+			if errors.Is(err, errPaused) {
+				continue retryLoop
+			}
 			if err != nil {
 				// Handle errors from the download attempt.
-				if errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "user quit") {
+				if errors.Is(err, context.Canceled) || errors.Is(err, errUserQuit) {
 					log.Println("Exiting due to cancellation or user quit.")
 					return
 				}
 
-				isTimeout := errors.Is(err, context.DeadlineExceeded) || (func() bool {
-					netErr, ok := err.(net.Error)
-					return ok && netErr.Timeout()
-				}())
-
-				if isTimeout {
-					log.Printf("Request timed out. continueUntilComplete: %t", continueUntilComplete)
-					if continueUntilComplete {
-						time.Sleep(1 * time.Second) // Shorter sleep for tests
+				if connected && serverGone(err) {
+					if !backSince.IsZero() && time.Since(backSince) > RestartWait {
+						restartSince = time.Time{} // Up long enough; this is another restart.
+					}
+					backSince = time.Time{}
+					if restartSince.IsZero() {
+						log.Printf("Host went away mid-pull (%v), waiting up to %s for it to restart.", err, RestartWait)
+						restartSince = time.Now()
+						if RestartWait > 0 && (!send(ctx, progressCh, RestartingMsg{Err: err, Wait: RestartWait}) || !retried(CauseReset, err)) {
+							return
+						}
+					}
+					if time.Since(restartSince) < RestartWait {
+						if !sleep(ctx, restartPoll) {
+							return
+						}
 						continue retryLoop
 					}
+					if RestartWait > 0 {
+						err = fmt.Errorf("%w (the host did not come back within %s)", err, RestartWait)
+					}
+					send(ctx, progressCh, ErrorMsg{Err: err})
+					return
+				}
+				restartSince, backSince = time.Time{}, time.Time{}
 
-					progressCh <- TimeoutMsg{}
-					select {
-					case choice := <-userChoiceCh:
-						switch choice {
-						case "Continue (until next error)", "Continue (until download completed)":
-							if choice == "Continue (until download completed)" {
-								continueUntilComplete = true
-							}
-							continue retryLoop
-						case "Quit":
+				if errors.Is(err, ErrPullInProgress) {
+					if queuedSince.IsZero() {
+						log.Printf("Host is busy with another pull (%v), asking again for up to %s.", err, QueueWait)
+						queuedSince = time.Now()
+						if QueueWait > 0 && !send(ctx, progressCh, QueuedMsg{Err: err, Wait: QueueWait}) {
+							return
+						}
+					}
+					if time.Since(queuedSince) < QueueWait {
+						if !sleep(ctx, queuePoll) {
 							return
-						default:
+						}
+						continue retryLoop
+					}
+					if QueueWait > 0 {
+						err = fmt.Errorf("%w (still busy after %s)", err, QueueWait)
+					}
+					send(ctx, progressCh, ErrorMsg{Err: err})
+					return
+				}
+
+				if errors.Is(err, ErrGatewayUnavailable) {
+					if gatewayFailures >= GatewayRetries && !continueUntilComplete {
+						send(ctx, progressCh, ErrorMsg{Err: fmt.Errorf("%w (still failing after %d retries)", err, GatewayRetries)})
+						return
+					}
+					if continueUntilComplete {
+						if err := retries.Spend(model); err != nil {
+							send(ctx, progressCh, ErrorMsg{Err: err})
 							return
 						}
-					case <-ctx.Done():
+					}
+					delay := gatewayDelay(gatewayFailures, err)
+					gatewayFailures++
+					log.Printf("Gateway error (%v), retry %d in %s.", err, gatewayFailures, delay)
+					if !send(ctx, progressCh, RetryingMsg{Err: err, Attempt: gatewayFailures, Delay: delay}) || !retried(CauseGateway, err) {
+						return
+					}
+					if !sleep(ctx, delay) {
 						return
 					}
-				} else {
+					continue retryLoop
+				}
+
+				if errors.Is(err, ErrManifestTimeout) && !continueUntilComplete {
+					log.Printf("No manifest after %s.", manifestTimeout)
+					send(ctx, progressCh, ErrorMsg{Err: err})
+					return
+				}
+
+				var streamErr *StreamError
+				switch {
+				case isTimeout(err) || errors.Is(err, ErrManifestTimeout):
+					if !retryOrAsk(ReasonDeadline, err) {
+						return
+					}
+				case errors.As(err, &streamErr) && !errors.Is(err, bufio.ErrTooLong):
+					if !retryOrAsk(ReasonServerClosed, err) {
+						return
+					}
+				default:
 					// A different, non-timeout error occurred.
-					progressCh <- ErrorMsg{Err: err}
+					send(ctx, progressCh, ErrorMsg{Err: err})
 					return
 				}
+				continue retryLoop
 			}
 
 			if downloadFinished {
@@ -201,13 +761,73 @@ func PullModel(ctx context.Context, model string, host string, progressCh chan<-
 			}
 
 			// If we get here, the stream ended but not with a "success" message.
-			if continueUntilComplete {
-				time.Sleep(1 * time.Second)
-				continue retryLoop
-			} else {
-				progressCh <- ErrorMsg{Err: errors.New("download stream ended unexpectedly")}
+			if !retryOrAsk(ReasonStreamEnded, ErrStreamEnded) {
 				return
 			}
 		}
 	}()
 }
+
+// gatewayDelay returns the wait before the retry after failures earlier gateway errors
+// in a row and err: doubling from gatewayBackoff, or what err's Retry-After asks for,
+// never more than maxGatewayBackoff.
+func gatewayDelay(failures int, err error) time.Duration {
+	var statusErr *APIStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return min(statusErr.RetryAfter, maxGatewayBackoff)
+	}
+	delay := gatewayBackoff
+	for range failures {
+		delay *= 2
+		if delay >= maxGatewayBackoff {
+			return maxGatewayBackoff
+		}
+	}
+	return delay
+}
+
+// send delivers msg unless ctx is cancelled first, so a pull whose reader has gone away
+// ends instead of blocking forever. It reports whether msg was delivered.
+func send(ctx context.Context, ch chan<- tea.Msg, msg tea.Msg) bool {
+	select {
+	case ch <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleep waits for d unless ctx is cancelled first, and reports whether it waited.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// layerInFlight reports whether msg describes a layer that is partially downloaded.
+func layerInFlight(msg OllamaResponse) bool {
+	return msg.Digest != "" && msg.Total > 0 && msg.Completed < msg.Total
+}
+
+// serverGone reports whether err means the host closed, reset or refused the connection,
+// as it does while Ollama restarts, rather than timed out.
+func serverGone(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		// The Unix socket is removed while Ollama is down.
+		errors.Is(err, syscall.ENOENT)
+}
+
+// isTimeout reports whether err was caused by a request deadline or a network timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
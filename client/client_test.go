@@ -1,16 +1,29 @@
 package client
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"ollama-downloader-v2/useragent"
 )
 
 // TestPullModel_Success tests the successful download of a model.
@@ -29,7 +42,7 @@ func TestPullModel_Success(t *testing.T) {
 	defer server.Close()
 
 	progressCh := make(chan tea.Msg, 5)
-	userChoiceCh := make(chan string)
+	userChoiceCh := make(chan Choice)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -65,7 +78,7 @@ func TestPullModel_ServerError(t *testing.T) {
 	defer server.Close()
 
 	progressCh := make(chan tea.Msg, 1)
-	userChoiceCh := make(chan string)
+	userChoiceCh := make(chan Choice)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -78,8 +91,44 @@ func TestPullModel_ServerError(t *testing.T) {
 	wg.Wait()
 
 	assert.IsType(t, ErrorMsg{}, msg)
-	assert.Error(t, msg.(ErrorMsg).Err)
-	assert.Contains(t, msg.(ErrorMsg).Err.Error(), "ollama API returned status 500")
+	var statusErr *APIStatusError
+	assert.ErrorAs(t, msg.(ErrorMsg).Err, &statusErr)
+	assert.Equal(t, http.StatusInternalServerError, statusErr.Code)
+	assert.Equal(t, "internal server error", statusErr.Body)
+}
+
+// TestPullModel_ModelNotFound tests that a 404 response is reported as ErrModelNotFound.
+func TestPullModel_ModelNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"model not found"}`))
+	}))
+	defer server.Close()
+
+	progressCh := make(chan tea.Msg, 1)
+	userChoiceCh := make(chan Choice)
+
+	PullModel(context.Background(), "missing-model", server.URL, progressCh, false, userChoiceCh)
+
+	msg := <-progressCh
+	assert.IsType(t, ErrorMsg{}, msg)
+	assert.ErrorIs(t, msg.(ErrorMsg).Err, ErrModelNotFound)
+}
+
+// TestPullModel_HostUnreachable tests that a refused connection is reported as ErrHostUnreachable.
+func TestPullModel_HostUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	host := server.URL
+	server.Close() // Nothing listens on the address any more.
+
+	progressCh := make(chan tea.Msg, 1)
+	userChoiceCh := make(chan Choice)
+
+	PullModel(context.Background(), "test-model", host, progressCh, false, userChoiceCh)
+
+	msg := <-progressCh
+	assert.IsType(t, ErrorMsg{}, msg)
+	assert.ErrorIs(t, msg.(ErrorMsg).Err, ErrHostUnreachable)
 }
 
 // TestPullModel_TimeoutAndQuit tests the timeout scenario where the user chooses to quit.
@@ -98,7 +147,7 @@ func TestPullModel_TimeoutAndQuit(t *testing.T) {
 	defer func() { http.DefaultClient = originalClient }()
 
 	progressCh := make(chan tea.Msg, 1)
-	userChoiceCh := make(chan string, 1)
+	userChoiceCh := make(chan Choice, 1)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -111,9 +160,10 @@ func TestPullModel_TimeoutAndQuit(t *testing.T) {
 	msg, ok := <-progressCh
 	assert.True(t, ok, "Should receive a timeout message")
 	assert.IsType(t, TimeoutMsg{}, msg)
+	assert.Equal(t, ReasonDeadline, msg.(TimeoutMsg).Reason)
 
 	// Simulate user choosing to quit
-	userChoiceCh <- "Quit"
+	userChoiceCh <- ChoiceQuit
 
 	// The progress channel should be closed without further messages
 	_, ok = <-progressCh
@@ -133,7 +183,7 @@ func TestPullModel_ContextCancellation(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	progressCh := make(chan tea.Msg, 1)
-	userChoiceCh := make(chan string)
+	userChoiceCh := make(chan Choice)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -173,7 +223,7 @@ func TestPullModel_UserQuitDuringDownload(t *testing.T) {
 	defer server.Close()
 
 	progressCh := make(chan tea.Msg, 5)
-	userChoiceCh := make(chan string, 1)
+	userChoiceCh := make(chan Choice, 1)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -188,7 +238,7 @@ func TestPullModel_UserQuitDuringDownload(t *testing.T) {
 	<-progressCh
 
 	// Simulate user quitting
-	userChoiceCh <- "Quit"
+	userChoiceCh <- ChoiceQuit
 
 	// The progress channel should be closed
 	_, ok := <-progressCh
@@ -196,3 +246,1185 @@ func TestPullModel_UserQuitDuringDownload(t *testing.T) {
 
 	wg.Wait()
 }
+
+// TestCoalescer tests that bursts within a phase are rate-limited while phase changes pass through.
+func TestCoalescer(t *testing.T) {
+	c := newCoalescer(100 * time.Millisecond)
+	start := time.Now()
+
+	out := c.offer(ProgressMsg{Status: "pulling abc", Digest: "sha256:abc", Completed: 1, Total: 10}, start)
+	assert.Len(t, out, 1, "First update should be sent immediately")
+
+	out = c.offer(ProgressMsg{Status: "pulling abc", Digest: "sha256:abc", Completed: 2, Total: 10}, start.Add(10*time.Millisecond))
+	assert.Empty(t, out, "Update within the interval should be held back")
+	out = c.offer(ProgressMsg{Status: "pulling abc", Digest: "sha256:abc", Completed: 3, Total: 10}, start.Add(20*time.Millisecond))
+	assert.Empty(t, out, "Update within the interval should be held back")
+
+	_, ok := c.flush(start.Add(50*time.Millisecond), false)
+	assert.False(t, ok, "Pending update should not be flushed before the interval")
+	pending, ok := c.flush(start.Add(150*time.Millisecond), false)
+	assert.True(t, ok, "Pending update should be flushed after the interval")
+	assert.Equal(t, int64(3), pending.Completed, "Only the latest pending update should be kept")
+
+	c.offer(ProgressMsg{Status: "pulling abc", Digest: "sha256:abc", Completed: 9, Total: 10}, start.Add(160*time.Millisecond))
+	out = c.offer(ProgressMsg{Status: "pulling def", Digest: "sha256:def", Completed: 0, Total: 5}, start.Add(170*time.Millisecond))
+	assert.Len(t, out, 2, "Phase change should flush the pending update and send the new phase")
+	assert.Equal(t, int64(9), out[0].Completed)
+	assert.Equal(t, "pulling def", out[1].Status)
+}
+
+// TestProgressSampler tests that progress lines are logged at most once per interval
+// within a phase, and that every phase starts and ends with a logged line.
+func TestProgressSampler(t *testing.T) {
+	s := newProgressSampler("llama3", time.Second)
+	start := time.Now()
+	layer := func(completed int64) OllamaResponse {
+		return OllamaResponse{Status: "pulling abc", Digest: "sha256:abc", Completed: completed, Total: 100}
+	}
+
+	assert.Equal(t, []string{`Progress of llama3: status="pulling manifest"`}, s.offer(OllamaResponse{Status: "pulling manifest"}, start))
+	assert.Equal(t, []string{`Progress of llama3: status="pulling abc" digest=sha256:abc completed=0 total=100`}, s.offer(layer(0), start))
+	for i := int64(1); i <= 5; i++ {
+		assert.Empty(t, s.offer(layer(i), start.Add(time.Duration(i)*100*time.Millisecond)), "Lines within the interval should not be logged")
+	}
+	assert.Equal(t, []string{`Progress of llama3: status="pulling abc" digest=sha256:abc completed=50 total=100 skipped=5`},
+		s.offer(layer(50), start.Add(time.Second)))
+	s.offer(layer(60), start.Add(1100*time.Millisecond))
+	s.offer(layer(100), start.Add(1200*time.Millisecond))
+	assert.Equal(t, []string{
+		`Progress of llama3: status="pulling abc" digest=sha256:abc completed=100 total=100 skipped=1`,
+		`Progress of llama3: status="verifying sha256 digest"`,
+	}, s.offer(OllamaResponse{Status: "verifying sha256 digest"}, start.Add(1300*time.Millisecond)), "A phase change should log the end of the previous phase")
+	assert.Empty(t, s.flush(), "Nothing is pending after a phase change")
+}
+
+// TestPullModel_CoalescesBursts tests that a burst of progress lines is delivered as far fewer messages.
+func TestPullModel_CoalescesBursts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling manifest"})
+		for i := int64(1); i <= 1000; i++ {
+			json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling abc", Digest: "sha256:abc", Completed: i, Total: 1000})
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "success"})
+	}))
+	defer server.Close()
+
+	progressCh := make(chan tea.Msg, 1)
+	userChoiceCh := make(chan Choice)
+
+	PullModel(context.Background(), "test-model", server.URL, progressCh, false, userChoiceCh)
+
+	var receivedMsgs []ProgressMsg
+	for msg := range progressCh {
+		receivedMsgs = append(receivedMsgs, msg.(ProgressMsg))
+	}
+
+	assert.Less(t, len(receivedMsgs), 100, "Burst should be coalesced")
+	assert.Equal(t, "pulling manifest", receivedMsgs[0].Status)
+	last := receivedMsgs[len(receivedMsgs)-1]
+	assert.Equal(t, "success", last.Status)
+	prev := receivedMsgs[len(receivedMsgs)-2]
+	assert.Equal(t, int64(1000), prev.Completed, "Final value of a phase should not be dropped")
+}
+
+// TestPullModel_LargeLines tests that lines above bufio's default limit are accepted and
+// lines above MaxLineSize are reported explicitly.
+func TestPullModel_LargeLines(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling manifest", Digest: strings.Repeat("a", 100*1024)})
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "success"})
+	}))
+	defer server.Close()
+
+	progressCh := make(chan tea.Msg, 5)
+	PullModel(context.Background(), "test-model", server.URL, progressCh, false, make(chan Choice))
+
+	var receivedMsgs []tea.Msg
+	for msg := range progressCh {
+		receivedMsgs = append(receivedMsgs, msg)
+	}
+	assert.Len(t, receivedMsgs, 2, "Large line should not break the stream")
+	assert.Equal(t, "success", receivedMsgs[1].(ProgressMsg).Status)
+
+	originalMax := MaxLineSize
+	MaxLineSize = 64 * 1024
+	defer func() { MaxLineSize = originalMax }()
+
+	progressCh = make(chan tea.Msg, 5)
+	PullModel(context.Background(), "test-model", server.URL, progressCh, false, make(chan Choice))
+
+	msg := <-progressCh
+	assert.IsType(t, ErrorMsg{}, msg)
+	var streamErr *StreamError
+	assert.ErrorAs(t, msg.(ErrorMsg).Err, &streamErr)
+	assert.ErrorIs(t, msg.(ErrorMsg).Err, bufio.ErrTooLong)
+}
+
+// TestPull_TimeoutReasons tests that an attempt ending early asks with the reason it
+// ended: the stream closing without success, or the server breaking off the stream.
+func TestPull_TimeoutReasons(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    TimeoutReason
+	}{
+		{"stream ended", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling manifest"})
+		}, ReasonStreamEnded},
+		{"server closed", func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n")
+			buf.WriteString("1d\r\n{\"status\":\"pulling manifest\"}\n\r\nzz\r\n")
+			buf.Flush()
+		}, ReasonServerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			s := NewSession()
+			Pull(context.Background(), PullOptions{Model: "llama3", Host: server.URL, Progress: s.Progress(), Choices: s.Choices()})
+			var got []TimeoutMsg
+			for msg := range s.Progress() {
+				if m, ok := msg.(TimeoutMsg); ok {
+					got = append(got, m)
+					s.Choose(ChoiceQuit)
+				}
+			}
+			require.Len(t, got, 1)
+			assert.Equal(t, tt.want, got[0].Reason)
+			assert.Error(t, got[0].Err)
+		})
+	}
+}
+
+// TestPullModel_FinishLayer tests that a finish-layer request stops only after the active layer completes.
+func TestPullModel_FinishLayer(t *testing.T) {
+	choiceSent := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling abc", Digest: "sha256:abc", Completed: 10, Total: 100})
+		w.(http.Flusher).Flush()
+		<-choiceSent
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling abc", Digest: "sha256:abc", Completed: 60, Total: 100})
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling abc", Digest: "sha256:abc", Completed: 100, Total: 100})
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling def", Digest: "sha256:def", Completed: 0, Total: 100})
+		w.(http.Flusher).Flush()
+	}))
+	defer server.Close()
+
+	progressCh := make(chan tea.Msg, 10)
+	userChoiceCh := make(chan Choice)
+	PullModel(context.Background(), "test-model", server.URL, progressCh, false, userChoiceCh)
+
+	first := <-progressCh
+	assert.Equal(t, int64(10), first.(ProgressMsg).Completed)
+	userChoiceCh <- ChoiceFinishLayer
+	close(choiceSent)
+
+	var receivedMsgs []ProgressMsg
+	for msg := range progressCh {
+		receivedMsgs = append(receivedMsgs, msg.(ProgressMsg))
+	}
+
+	if assert.NotEmpty(t, receivedMsgs, "Active layer should keep downloading") {
+		last := receivedMsgs[len(receivedMsgs)-1]
+		assert.Equal(t, "pulling abc", last.Status, "Next layer should not be reported")
+		assert.Equal(t, int64(100), last.Completed, "Active layer should be reported as complete")
+	}
+}
+
+// newVerifyServer returns a fake Ollama host for Verify with the given generate output.
+func newVerifyServer(t *testing.T, installed string, output string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"` + installed + `","model":"` + installed + `","digest":"abc123"}]}`))
+		case "/api/generate":
+			var req generateRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			res := generateResponse{Done: true, LoadDuration: int64(1500 * time.Millisecond)}
+			if req.Prompt != "" {
+				res.Response = output
+			}
+			json.NewEncoder(w).Encode(res)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWarmup(t *testing.T) {
+	server := newVerifyServer(t, "llama3:latest", "ok")
+	d, err := Warmup(context.Background(), server.URL, "llama3")
+	assert.NoError(t, err)
+	assert.Equal(t, 1500*time.Millisecond, d, "Warmup should report Ollama's load duration")
+
+	_, err = Warmup(context.Background(), "http://127.0.0.1:1", "llama3")
+	assert.ErrorIs(t, err, ErrHostUnreachable)
+}
+
+func TestTestPrompt(t *testing.T) {
+	out, err := TestPrompt(context.Background(), newVerifyServer(t, "llama3:latest", " Hi there! ").URL, "llama3", "Say hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hi there!", out)
+
+	_, err = TestPrompt(context.Background(), newVerifyServer(t, "llama3:latest", "").URL, "llama3", "Say hi")
+	assert.ErrorContains(t, err, "generated no output")
+}
+
+// TestVerify tests each verification policy against a fake host.
+func TestVerify(t *testing.T) {
+	server := newVerifyServer(t, "llama3:latest", "ok")
+	for _, policy := range []VerifyPolicy{VerifyDigest, VerifyLoad, VerifyGenerate} {
+		assert.NoError(t, Verify(context.Background(), server.URL, "llama3", "sha256:abc123", policy), "policy %s", policy)
+	}
+
+	var verifyErr *VerifyError
+	err := Verify(context.Background(), server.URL, "mistral", "sha256:abc123", VerifyDigest)
+	assert.ErrorAs(t, err, &verifyErr)
+	assert.Equal(t, VerifyDigest, verifyErr.Step)
+	assert.ErrorIs(t, err, ErrModelNotFound)
+
+	err = Verify(context.Background(), server.URL, "llama3", "sha256:def456", VerifyDigest)
+	assert.ErrorAs(t, err, &verifyErr)
+	assert.Equal(t, VerifyDigest, verifyErr.Step)
+	assert.ErrorIs(t, err, ErrDigestMismatch, "A digest other than the registry's fails")
+	assert.Error(t, Verify(context.Background(), server.URL, "llama3", "", VerifyDigest), "Without the registry's digest nothing is verified")
+
+	silent := newVerifyServer(t, "llama3:latest", "")
+	assert.NoError(t, Verify(context.Background(), silent.URL, "llama3", "abc123", VerifyLoad))
+	err = Verify(context.Background(), silent.URL, "llama3", "abc123", VerifyGenerate)
+	assert.ErrorAs(t, err, &verifyErr)
+	assert.Equal(t, VerifyGenerate, verifyErr.Step)
+}
+
+func TestShowModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/show", r.URL.Path)
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["model"] == "nomic-embed-text" {
+			w.Write([]byte(`{"details":{"family":"nomic-bert","parameter_size":"137M","quantization_level":"F16"},"capabilities":["embedding"]}`))
+			return
+		}
+		w.Write([]byte(`{"details":{"family":"llama","families":["llama","clip"]},"capabilities":["completion","vision"]}`))
+	}))
+	defer server.Close()
+
+	details, err := ShowModel(context.Background(), server.URL, "llava")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"llama", "clip"}, details.Details.Families)
+	assert.Equal(t, []string{"completion", "vision"}, details.Capabilities)
+	assert.False(t, details.Embedding())
+
+	details, err = ShowModel(context.Background(), server.URL, "nomic-embed-text")
+	assert.NoError(t, err)
+	assert.Equal(t, "137M", details.Details.ParameterSize)
+	assert.True(t, details.Embedding())
+}
+
+// TestDigestPin tests comparing digests and checking the installed digest.
+func TestDigestPin(t *testing.T) {
+	assert.True(t, SameDigest("sha256:abc123", "abc123"))
+	assert.False(t, SameDigest("", "sha256:"))
+
+	server := newVerifyServer(t, "llama3:latest", "ok")
+	assert.NoError(t, CheckDigest(context.Background(), server.URL, "llama3", "sha256:abc123"))
+	assert.ErrorIs(t, CheckDigest(context.Background(), server.URL, "llama3", "sha256:def456"), ErrDigestMismatch)
+	assert.ErrorIs(t, CheckDigest(context.Background(), server.URL, "mistral", "sha256:abc123"), ErrModelNotFound)
+}
+
+// TestParseVerifyPolicy tests parsing of the --verify flag value.
+func TestParseVerifyPolicy(t *testing.T) {
+	policy, err := ParseVerifyPolicy("load")
+	assert.NoError(t, err)
+	assert.Equal(t, VerifyLoad, policy)
+
+	_, err = ParseVerifyPolicy("paranoid")
+	assert.Error(t, err)
+}
+
+// TestPullModel_Authorize tests that the Authorize hook runs and 401 responses map to ErrUnauthorized.
+func TestPullModel_Authorize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "success"})
+	}))
+	defer server.Close()
+	defer func() { Authorize = nil }()
+
+	Authorize = func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer bad")
+		return nil
+	}
+	progressCh := make(chan tea.Msg, 1)
+	PullModel(context.Background(), "private/model", server.URL, progressCh, false, make(chan Choice))
+	msg := <-progressCh
+	assert.ErrorIs(t, msg.(ErrorMsg).Err, ErrUnauthorized)
+
+	Authorize = func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer good")
+		return nil
+	}
+	progressCh = make(chan tea.Msg, 1)
+	PullModel(context.Background(), "private/model", server.URL, progressCh, false, make(chan Choice))
+	msg = <-progressCh
+	assert.Equal(t, "success", msg.(ProgressMsg).Status)
+
+	missingKey := errors.New("no key")
+	Authorize = func(req *http.Request) error { return missingKey }
+	progressCh = make(chan tea.Msg, 1)
+	PullModel(context.Background(), "private/model", server.URL, progressCh, false, make(chan Choice))
+	msg = <-progressCh
+	assert.ErrorIs(t, msg.(ErrorMsg).Err, missingKey, "A missing key should be reported, not sent unauthenticated")
+}
+
+func TestDeleteModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		assert.Equal(t, "/api/delete", r.URL.Path)
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["model"] != "old:latest" {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	assert.NoError(t, DeleteModel(context.Background(), server.URL, "old:latest"))
+	assert.ErrorIs(t, DeleteModel(context.Background(), server.URL, "missing"), ErrModelNotFound)
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := &RetryBudget{PerModel: 2, Total: 3}
+	assert.NoError(t, b.Spend("a"))
+	assert.NoError(t, b.Spend("a"))
+	assert.ErrorIs(t, b.Spend("a"), ErrRetryBudgetExhausted, "a has used its own retries")
+	assert.NoError(t, b.Spend("b"))
+	assert.ErrorIs(t, b.Spend("b"), ErrRetryBudgetExhausted, "The batch has used all its retries")
+	assert.Equal(t, 2, b.Used("a"))
+
+	b.SetLimit("c", 0)
+	assert.ErrorIs(t, b.Spend("c"), ErrRetryBudgetExhausted, "The batch limit applies even without a per-model limit")
+
+	var unlimited *RetryBudget
+	assert.NoError(t, unlimited.Spend("a"))
+}
+
+func TestPullModel_RetryBudgetStopsAutoRetry(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusOK) // Ends every stream without "success".
+	}))
+	defer server.Close()
+
+	Retries = &RetryBudget{PerModel: 1}
+	defer func() { Retries = nil }()
+
+	progressCh := make(chan tea.Msg, 10)
+	PullModel(context.Background(), "broken", server.URL, progressCh, true, make(chan Choice))
+
+	var last tea.Msg
+	for msg := range progressCh {
+		last = msg
+	}
+	errMsg, ok := last.(ErrorMsg)
+	assert.True(t, ok, "Expected an ErrorMsg once the budget is used up, got %T", last)
+	assert.ErrorIs(t, errMsg.Err, ErrRetryBudgetExhausted)
+	assert.Equal(t, int32(2), attempts.Load(), "One retry after the first attempt")
+}
+
+func TestParseHost(t *testing.T) {
+	for host, want := range map[string]string{
+		"http://[::1]:11434":         "http://[::1]:11434",
+		"https://ollama.example.com": "https://ollama.example.com",
+		"http://proxy/ollama":        "http://proxy/ollama",
+		"::1":                        "http://[::1]:11434",
+		"[::1]:8080":                 "http://[::1]:8080",
+		"[fe80::1]":                  "http://[fe80::1]:11434",
+		"localhost":                  "http://localhost:11434",
+		"192.168.1.5:9000":           "http://192.168.1.5:9000",
+		":11434":                     "http://127.0.0.1:11434",
+		"box/ollama":                 "http://box:11434/ollama",
+	} {
+		assert.Equal(t, want, NormalizeHost(host), host)
+	}
+
+	for _, host := range []string{"http://::1:11434", "ftp://box", "http://"} {
+		_, err := ParseHost(host)
+		assert.ErrorIs(t, err, ErrInvalidHost, host)
+	}
+
+	url, err := endpoint("http://proxy/ollama/", "/api/pull")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://proxy/ollama/api/pull", url)
+}
+
+func TestIsLocal(t *testing.T) {
+	for _, host := range []string{"localhost", ":11434", "http://127.0.0.1:8080", "[::1]:11434", "0.0.0.0", "unix:///var/run/ollama.sock"} {
+		assert.True(t, IsLocal(host), host)
+	}
+	for _, host := range []string{"gpu-box", "http://10.0.0.5:11434", "https://ollama.example.com", "ftp://x"} {
+		assert.False(t, IsLocal(host), host)
+	}
+}
+
+// TestListModels_HostWithoutScheme tests that requests are built from a host given as a bare address.
+func TestListModels_HostWithoutScheme(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/tags", r.URL.Path)
+		w.Write([]byte(`{"models":[{"name":"llama3:latest"}]}`))
+	}))
+	defer server.Close()
+
+	models, err := ListModels(context.Background(), strings.TrimPrefix(server.URL, "http://"))
+	assert.NoError(t, err)
+	assert.Len(t, models, 1)
+
+	_, err = ListModels(context.Background(), "http://::1:11434")
+	assert.ErrorIs(t, err, ErrInvalidHost)
+}
+
+// TestPullModel_UnixSocket tests that a unix:// host is reached over the socket.
+func TestPullModel_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "ollama.sock")
+	ln, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/pull":
+			w.Write([]byte(`{"status":"success"}` + "\n"))
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"llama3:latest"}]}`))
+		}
+	}))
+	server.Listener = ln
+	server.Start()
+	defer server.Close()
+
+	host := "unix://" + socket
+	models, err := ListModels(context.Background(), host)
+	assert.NoError(t, err)
+	assert.Len(t, models, 1)
+
+	progressCh := make(chan tea.Msg)
+	PullModel(context.Background(), "llama3", host, progressCh, false, make(chan Choice))
+	var last tea.Msg
+	for msg := range progressCh {
+		last = msg
+	}
+	assert.Equal(t, ProgressMsg{Status: "success"}, last)
+
+	_, err = ParseHost("unix://relative.sock")
+	assert.ErrorIs(t, err, ErrInvalidHost)
+}
+
+// TestPullModel_ManifestTimeout tests that a hang while resolving the manifest fails
+// fast, while a slow download after it does not.
+func TestPullModel_ManifestTimeout(t *testing.T) {
+	oldTimeout := ManifestTimeout
+	ManifestTimeout = 50 * time.Millisecond
+	defer func() { ManifestTimeout = oldTimeout }()
+
+	var hang atomic.Bool
+	hang.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"pulling manifest"}` + "\n"))
+		w.(http.Flusher).Flush()
+		if hang.Load() {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(`{"status":"pulling abc","digest":"sha256:abc","total":10,"completed":5}` + "\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(2 * ManifestTimeout)
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	}))
+	defer server.Close()
+
+	collect := func() []tea.Msg {
+		progressCh := make(chan tea.Msg)
+		PullModel(context.Background(), "typo-model", server.URL, progressCh, false, make(chan Choice))
+		var msgs []tea.Msg
+		for msg := range progressCh {
+			msgs = append(msgs, msg)
+		}
+		return msgs
+	}
+
+	start := time.Now()
+	msgs := collect()
+	assert.Less(t, time.Since(start), RequestTimeout)
+	if assert.IsType(t, ErrorMsg{}, msgs[len(msgs)-1]) {
+		assert.ErrorIs(t, msgs[len(msgs)-1].(ErrorMsg).Err, ErrManifestTimeout)
+	}
+
+	hang.Store(false)
+	msgs = collect()
+	assert.Equal(t, ProgressMsg{Status: "success"}, msgs[len(msgs)-1])
+}
+
+func TestPull_Options(t *testing.T) {
+	var got PullRequest
+	var header, auth, agent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		header, auth, agent = r.Header.Get("X-Lab"), r.Header.Get("Authorization"), r.Header.Get("User-Agent")
+		w.Write([]byte(`{"status":"pulling manifest"}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	progressCh := make(chan tea.Msg)
+	start := time.Now()
+	Pull(context.Background(), PullOptions{
+		Model:     "llama3",
+		Host:      server.URL,
+		Progress:  progressCh,
+		Timeouts:  Timeouts{Manifest: 50 * time.Millisecond},
+		Headers:   http.Header{"X-Lab": {"room-4"}},
+		Authorize: func(req *http.Request) error { req.Header.Set("Authorization", "Bearer t"); return nil },
+		Insecure:  true,
+	})
+	var last tea.Msg
+	for msg := range progressCh {
+		last = msg
+	}
+	assert.Less(t, time.Since(start), ManifestTimeout, "Timeouts.Manifest replaces ManifestTimeout")
+	if assert.IsType(t, ErrorMsg{}, last) {
+		assert.ErrorIs(t, last.(ErrorMsg).Err, ErrManifestTimeout)
+	}
+	assert.Equal(t, PullRequest{Model: "llama3", Stream: true, Insecure: true}, got)
+	assert.Equal(t, "room-4", header)
+	assert.Equal(t, "Bearer t", auth)
+	assert.Equal(t, useragent.Value, agent)
+}
+
+// TestPull_NoLeakWhenReaderGone checks that a pull whose progress nobody reads any
+// more ends when its context is cancelled, instead of blocking on the channel.
+func TestPull_NoLeakWhenReaderGone(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := int64(1); r.Context().Err() == nil; i++ {
+			json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling layer", Digest: "sha256:a", Completed: i, Total: 1 << 40})
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	progressCh := make(chan tea.Msg)
+	Pull(ctx, PullOptions{Model: "llama3", Host: server.URL, Progress: progressCh})
+	<-progressCh // The UI reads once, then exits.
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+}
+
+// TestPull_NoLeakWhenTimeoutUnanswered checks that a pull waiting for the user's choice
+// after a timeout ends when its context is cancelled and the UI is gone.
+func TestPull_NoLeakWhenTimeoutUnanswered(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"pulling manifest"}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	Pull(ctx, PullOptions{
+		Model:    "llama3",
+		Host:     server.URL,
+		Progress: make(chan tea.Msg), // Never read.
+		Choices:  make(chan Choice),  // Never answered.
+		Timeouts: Timeouts{Manifest: 20 * time.Millisecond},
+	})
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+}
+
+// TestPull_WaitsForRestart tests that a connection dropped mid-pull is reconnected
+// after a RestartingMsg, and reported once the host stays away for RestartWait.
+func TestPull_WaitsForRestart(t *testing.T) {
+	defer func(wait, poll time.Duration) { RestartWait, restartPoll = wait, poll }(RestartWait, restartPoll)
+	RestartWait, restartPoll = 200*time.Millisecond, 10*time.Millisecond
+
+	var requests atomic.Int32
+	var drops int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= atomic.LoadInt32(&drops) {
+			w.Write([]byte(`{"status":"pulling abc","digest":"sha256:abc","total":100,"completed":10}` + "\n"))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close() // Like an Ollama that is stopped for an upgrade.
+			return
+		}
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	}))
+	defer server.Close()
+
+	collect := func() []tea.Msg {
+		progressCh := make(chan tea.Msg)
+		Pull(context.Background(), PullOptions{Model: "llama3", Host: server.URL, Progress: progressCh})
+		var msgs []tea.Msg
+		for msg := range progressCh {
+			msgs = append(msgs, msg)
+		}
+		return msgs
+	}
+
+	atomic.StoreInt32(&drops, 2)
+	msgs := collect()
+	restarts := 0
+	for _, msg := range msgs {
+		if m, ok := msg.(RestartingMsg); ok {
+			restarts++
+			assert.Equal(t, RestartWait, m.Wait)
+		}
+	}
+	assert.Equal(t, 1, restarts, "One RestartingMsg for one restart")
+	assert.Equal(t, ProgressMsg{Status: "success"}, msgs[len(msgs)-1])
+	assert.EqualValues(t, 3, requests.Load())
+
+	requests.Store(0)
+	atomic.StoreInt32(&drops, 1000)
+	msgs = collect()
+	if assert.IsType(t, ErrorMsg{}, msgs[len(msgs)-1]) {
+		assert.ErrorContains(t, msgs[len(msgs)-1].(ErrorMsg).Err, "did not come back within 200ms")
+	}
+}
+
+// TestPull_QueuesBehindRunningPull tests that a pull the host refuses because another
+// one is in progress is asked again until the host accepts it.
+func TestPull_QueuesBehindRunningPull(t *testing.T) {
+	defer func(wait, poll time.Duration) { QueueWait, queuePoll = wait, poll }(QueueWait, queuePoll)
+	QueueWait, queuePoll = 200*time.Millisecond, 10*time.Millisecond
+
+	var requests, busy atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= busy.Load() {
+			http.Error(w, `{"error":"pull already in progress"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	}))
+	defer server.Close()
+
+	collect := func() []tea.Msg {
+		progressCh := make(chan tea.Msg)
+		Pull(context.Background(), PullOptions{Model: "llama3", Host: server.URL, Progress: progressCh})
+		var msgs []tea.Msg
+		for msg := range progressCh {
+			msgs = append(msgs, msg)
+		}
+		return msgs
+	}
+
+	busy.Store(3)
+	msgs := collect()
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, QueuedMsg{Err: &APIStatusError{Code: 500, Body: `{"error":"pull already in progress"}` + "\n"}, Wait: QueueWait}, msgs[0])
+		assert.Equal(t, ProgressMsg{Status: "success"}, msgs[1])
+	}
+	assert.EqualValues(t, 4, requests.Load())
+
+	requests.Store(0)
+	busy.Store(1000)
+	msgs = collect()
+	if assert.IsType(t, ErrorMsg{}, msgs[len(msgs)-1]) {
+		err := msgs[len(msgs)-1].(ErrorMsg).Err
+		assert.ErrorIs(t, err, ErrPullInProgress)
+		assert.ErrorContains(t, err, "still busy after 200ms")
+	}
+
+	assert.ErrorIs(t, &APIStatusError{Code: http.StatusConflict}, ErrPullInProgress)
+	assert.NotErrorIs(t, &APIStatusError{Code: 500, Body: "boom"}, ErrPullInProgress)
+}
+
+// TestPull_RetriesGatewayErrors tests that 502, 503 and 504 answers from a proxy are
+// retried with a growing delay, and reported once they keep coming.
+func TestPull_RetriesGatewayErrors(t *testing.T) {
+	defer func(retries int, backoff time.Duration) { GatewayRetries, gatewayBackoff = retries, backoff }(GatewayRetries, gatewayBackoff)
+	GatewayRetries, gatewayBackoff = 3, 10*time.Millisecond
+
+	var requests, failing atomic.Int32
+	codes := []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if n <= failing.Load() {
+			http.Error(w, "<html>bad gateway</html>", codes[int(n-1)%len(codes)])
+			return
+		}
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	}))
+	defer server.Close()
+
+	collect := func(autoRetry bool) []tea.Msg {
+		progressCh := make(chan tea.Msg)
+		Pull(context.Background(), PullOptions{Model: "llama3", Host: server.URL, Progress: progressCh, AutoRetry: autoRetry})
+		var msgs []tea.Msg
+		for msg := range progressCh {
+			if _, ok := msg.(RetriedMsg); !ok { // See TestPull_RetryCauses.
+				msgs = append(msgs, msg)
+			}
+		}
+		return msgs
+	}
+
+	failing.Store(3)
+	msgs := collect(false)
+	if assert.Len(t, msgs, 4) {
+		for i, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+			if assert.IsType(t, RetryingMsg{}, msgs[i]) {
+				retry := msgs[i].(RetryingMsg)
+				assert.Equal(t, i+1, retry.Attempt)
+				assert.Equal(t, want, retry.Delay, "The delay should double with every retry")
+				assert.ErrorIs(t, retry.Err, ErrGatewayUnavailable)
+			}
+		}
+		assert.Equal(t, ProgressMsg{Status: "success"}, msgs[3])
+	}
+
+	requests.Store(0)
+	failing.Store(1000)
+	msgs = collect(false)
+	assert.Len(t, msgs, 4, "Three retries, then the error")
+	if assert.IsType(t, ErrorMsg{}, msgs[len(msgs)-1]) {
+		err := msgs[len(msgs)-1].(ErrorMsg).Err
+		assert.ErrorIs(t, err, ErrGatewayUnavailable)
+		assert.ErrorContains(t, err, "still failing after 3 retries")
+	}
+
+	// With AutoRetry only the retry budget ends the retries.
+	defer func(old *RetryBudget) { Retries = old }(Retries)
+	Retries = &RetryBudget{PerModel: 4}
+	requests.Store(0)
+	failing.Store(4)
+	msgs = collect(true)
+	assert.Equal(t, ProgressMsg{Status: "success"}, msgs[len(msgs)-1], "Four retries fit the budget")
+}
+
+func TestGatewayDelay(t *testing.T) {
+	assert.Equal(t, time.Second, gatewayDelay(0, &APIStatusError{Code: 502}))
+	assert.Equal(t, 8*time.Second, gatewayDelay(3, &APIStatusError{Code: 502}))
+	assert.Equal(t, maxGatewayBackoff, gatewayDelay(10, &APIStatusError{Code: 502}))
+	assert.Equal(t, 7*time.Second, gatewayDelay(0, &APIStatusError{Code: 503, RetryAfter: 7 * time.Second}), "Retry-After should be honored")
+	assert.Equal(t, maxGatewayBackoff, gatewayDelay(0, &APIStatusError{Code: 503, RetryAfter: time.Hour}))
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Fri, 16 Oct 2026 12:00:30 GMT", now))
+	assert.Zero(t, parseRetryAfter("", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+
+	for _, code := range []int{502, 503, 504} {
+		assert.ErrorIs(t, &APIStatusError{Code: code}, ErrGatewayUnavailable, code)
+	}
+	assert.NotErrorIs(t, &APIStatusError{Code: 500}, ErrGatewayUnavailable)
+}
+
+// TestPull_ShorterTimeout tests that ChoiceShorterTimeout retries with half the request
+// timeout, but not below MinRequestTimeout.
+func TestPull_ShorterTimeout(t *testing.T) {
+	defer func(old time.Duration) { MinRequestTimeout = old }(MinRequestTimeout)
+	MinRequestTimeout = 150 * time.Millisecond
+
+	var mu sync.Mutex
+	var attempts []time.Duration
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"pulling abc","digest":"sha256:abc","total":100,"completed":10}` + "\n"))
+		w.(http.Flusher).Flush()
+		start := time.Now()
+		<-r.Context().Done()
+		mu.Lock()
+		attempts = append(attempts, time.Since(start))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	s := NewSession()
+	Pull(context.Background(), PullOptions{
+		Model:    "llama3",
+		Host:     server.URL,
+		Progress: s.Progress(),
+		Choices:  s.Choices(),
+		Timeouts: Timeouts{Request: 400 * time.Millisecond, Manifest: time.Minute},
+	})
+	timeouts := 0
+	for msg := range s.Progress() {
+		if _, ok := msg.(TimeoutMsg); ok {
+			timeouts++
+			if timeouts < 3 {
+				s.Choose(ChoiceShorterTimeout)
+			} else {
+				s.Choose(ChoiceQuit)
+			}
+		}
+	}
+	assert.Equal(t, 3, timeouts)
+	server.Close() // Waits for the handlers to record the last attempt.
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, attempts, 3)
+	assert.Greater(t, attempts[0], 300*time.Millisecond, "The first attempt uses the full timeout")
+	assert.Less(t, attempts[1], 300*time.Millisecond, "The second attempt should use half the timeout")
+	assert.GreaterOrEqual(t, attempts[2], 100*time.Millisecond, "The timeout should not drop below MinRequestTimeout")
+}
+
+func TestChoice_String(t *testing.T) {
+	assert.Equal(t, "Continue (until next error)", ChoiceContinue.String())
+	assert.Equal(t, "Quit", ChoiceQuit.String())
+	assert.Empty(t, Choice(0).String())
+	assert.Equal(t, "Choice(42)", Choice(42).String())
+}
+
+// TestSession checks that the UI side of a session never blocks or panics, however
+// often and concurrently it quits. Run with -race.
+func TestSession(t *testing.T) {
+	s := NewSession()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Choose(ChoiceQuit)
+			s.Close()
+		}()
+	}
+	wg.Wait()
+	<-s.Done()
+	assert.Len(t, s.Choices(), choiceBuffer, "Choices beyond the buffer are dropped, not blocked on")
+}
+
+// TestPull_SessionQuitAsErrorArrives quits the UI side at the moment the pull fails,
+// which used to close the quit channel twice or block on a choice nobody reads.
+func TestPull_SessionQuitAsErrorArrives(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	s := NewSession()
+	Pull(context.Background(), PullOptions{Model: "llama3", Host: server.URL, Progress: s.Progress(), Choices: s.Choices()})
+	for msg := range s.Progress() {
+		if _, ok := msg.(ErrorMsg); ok {
+			// The user's quit and the UI's reaction to the error race each other.
+			go func() { s.Choose(ChoiceQuit); s.Close() }()
+			s.Choose(ChoiceQuit)
+			s.Close()
+		}
+	}
+	<-s.Done()
+}
+
+// TestPull_OnPanic tests that a panic in the pull goroutine reaches OnPanic.
+func TestPull_OnPanic(t *testing.T) {
+	panicked := make(chan any, 1)
+	progress := make(chan tea.Msg, 10)
+	Pull(context.Background(), PullOptions{
+		Model:     "test-model",
+		Host:      "http://127.0.0.1:1",
+		Progress:  progress,
+		Authorize: func(*http.Request) error { panic("authorize failed") },
+		OnPanic:   func(r any, stack []byte) { panicked <- r },
+	})
+	for range progress {
+	}
+	assert.Equal(t, "authorize failed", <-panicked)
+}
+
+func TestResponseInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/version", r.URL.Path)
+		w.Header().Set("Server", "nginx/1.25")
+		w.Header().Set("Via", "1.1 squid")
+		w.Header().Set("X-Unrelated", "dropped")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"version":"0.5.1"}`))
+	}))
+	defer server.Close()
+
+	info, err := ProbeResponse(context.Background(), server.URL, "/api/version")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, info.Status)
+	assert.Equal(t, "HTTP/1.1 200; Server: nginx/1.25; Via: 1.1 squid; Content-Type: application/json; Content-Length: 19", info.String())
+	assert.True(t, info.Proxied(), "Via marks a proxy")
+	assert.False(t, ResponseInfo{Header: http.Header{"Server": {"nginx"}}}.Proxied())
+	assert.Equal(t, "no diagnostic headers", ResponseInfo{}.HeaderString())
+}
+
+// TestPull_RecordsResponse tests that the headers of every attempt's response are
+// recorded, including the streamed pull's Transfer-Encoding.
+func TestPull_RecordsResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "MISS")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "success"})
+	}))
+	defer server.Close()
+
+	var recorded bytes.Buffer
+	SessionRecorder = NewRecorder(&recorded)
+	defer func() { SessionRecorder = nil }()
+	progressCh := make(chan tea.Msg, 5)
+	PullModel(context.Background(), "llama3", server.URL, progressCh, false, make(chan Choice))
+	for range progressCh {
+	}
+
+	var response *RecordedLine
+	dec := json.NewDecoder(&recorded)
+	for dec.More() {
+		var line RecordedLine
+		require.NoError(t, dec.Decode(&line))
+		if line.Event == EventResponse {
+			response = &line
+		}
+	}
+	require.NotNil(t, response, "The response should be recorded")
+	assert.Equal(t, http.StatusOK, response.Status)
+	assert.Equal(t, "HTTP/1.1", response.Line)
+	assert.Equal(t, []string{"MISS"}, response.Headers["X-Cache"])
+	assert.Equal(t, []string{"chunked"}, response.Headers["Transfer-Encoding"])
+}
+
+// TestPull_Hold tests that setting a Hold breaks off the attempt in progress, that no
+// new attempt starts while it is held, and that releasing it resumes the pull.
+func TestPull_Hold(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling 6a0746a1ec1a", Digest: "sha256:6a0746a1ec1a", Completed: 10, Total: 100})
+			w.(http.Flusher).Flush()
+			<-r.Context().Done() // Stalls until the client breaks off.
+			return
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "success"})
+	}))
+	defer server.Close()
+
+	hold := NewHold()
+	s := NewSession()
+	Pull(context.Background(), PullOptions{Model: "llama3", Host: server.URL, Progress: s.Progress(), Choices: s.Choices(), Hold: hold})
+	var paused []PausedMsg
+	succeeded := false
+	for msg := range s.Progress() {
+		switch msg := msg.(type) {
+		case ProgressMsg:
+			if msg.Completed == 10 {
+				hold.Set(true, "on battery at 15%")
+			}
+			succeeded = msg.Status == "success"
+		case PausedMsg:
+			paused = append(paused, msg)
+			assert.Equal(t, int32(1), attempts.Load(), "No attempt starts while held")
+			hold.Set(false, "")
+		case ErrorMsg, TimeoutMsg:
+			t.Fatalf("unexpected %#v", msg)
+		}
+	}
+	assert.True(t, succeeded)
+	assert.Equal(t, []PausedMsg{{Reason: "on battery at 15%"}}, paused)
+	assert.Equal(t, int32(2), attempts.Load())
+
+	var none *Hold
+	held, _, changed := none.state()
+	assert.False(t, held)
+	assert.Nil(t, changed, "A nil Hold never changes")
+}
+
+// TestPull_RetryCauses tests that every retry is reported with its cause.
+func TestPull_RetryCauses(t *testing.T) {
+	defer func(wait, poll, backoff time.Duration) {
+		RestartWait, restartPoll, gatewayBackoff = wait, poll, backoff
+	}(RestartWait, restartPoll, gatewayBackoff)
+	RestartWait, restartPoll, gatewayBackoff = time.Second, 10*time.Millisecond, 10*time.Millisecond
+
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		progress := func(completed int64) {
+			json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling 6a0746a1ec1a", Digest: "sha256:6a0746a1ec1a", Completed: completed, Total: 100})
+			w.(http.Flusher).Flush()
+		}
+		switch attempts.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2: // Advances, then runs into the deadline.
+			progress(10)
+			progress(20)
+			<-r.Context().Done()
+		case 3: // Stalls.
+			progress(20)
+			<-r.Context().Done()
+		case 4: // Dies mid-stream.
+			progress(20)
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		default:
+			json.NewEncoder(w).Encode(OllamaResponse{Status: "success"})
+		}
+	}))
+	defer server.Close()
+
+	progressCh := make(chan tea.Msg)
+	Pull(context.Background(), PullOptions{
+		Model: "llama3", Host: server.URL, Progress: progressCh, AutoRetry: true,
+		Timeouts: Timeouts{Request: 200 * time.Millisecond, Manifest: time.Minute},
+	})
+	var causes []RetryCause
+	for msg := range progressCh {
+		if retry, ok := msg.(RetriedMsg); ok {
+			causes = append(causes, retry.Cause)
+			assert.Error(t, retry.Err)
+		}
+	}
+	assert.Equal(t, []RetryCause{CauseGateway, CauseTimeout, CauseStall, CauseReset}, causes)
+}
+
+// fakeTuner hands out fixed timeouts and records the first bytes it is told about.
+type fakeTuner struct {
+	timeouts Timeouts
+	hosts    []string
+	answers  []bool
+	waits    []time.Duration
+}
+
+func (f *fakeTuner) Timeouts(host string) Timeouts {
+	f.hosts = append(f.hosts, host)
+	return f.timeouts
+}
+
+func (f *fakeTuner) FirstByte(host string, wait time.Duration, missed bool) {
+	f.answers = append(f.answers, !missed)
+	f.waits = append(f.waits, wait)
+}
+
+func TestPull_Tuner(t *testing.T) {
+	defer func(tuner TimeoutTuner) { Tuner = tuner }(Tuner)
+	tuner := &fakeTuner{timeouts: Timeouts{Request: time.Minute, Manifest: 50 * time.Millisecond}}
+	Tuner = tuner
+
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			// Never answers. The server notices the cancelled request once the body is read.
+			io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+			return
+		}
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "success"})
+	}))
+	defer server.Close()
+
+	progressCh := make(chan tea.Msg)
+	Pull(context.Background(), PullOptions{Model: "llama3", Host: server.URL, Progress: progressCh, AutoRetry: true})
+	var last tea.Msg
+	for msg := range progressCh {
+		last = msg
+	}
+	assert.Equal(t, ProgressMsg{Status: "success"}, last)
+	assert.Equal(t, []string{server.URL}, tuner.hosts)
+	assert.Equal(t, []bool{false, true}, tuner.answers, "The first attempt times out before the first byte")
+	assert.GreaterOrEqual(t, tuner.waits[0], 50*time.Millisecond, "The tuner's manifest timeout applies")
+	assert.Less(t, tuner.waits[0], ManifestTimeout)
+
+	assert.Equal(t, Timeouts{Request: time.Second, Manifest: 50 * time.Millisecond},
+		PullOptions{Host: server.URL, Timeouts: Timeouts{Request: time.Second}}.EffectiveTimeouts(), "PullOptions.Timeouts wins over the tuner")
+	Tuner = nil
+	assert.Equal(t, Timeouts{Request: RequestTimeout, Manifest: ManifestTimeout}, PullOptions{}.EffectiveTimeouts())
+}
+
+func TestPull_ErrorInStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OllamaResponse{Status: "pulling manifest"})
+		w.(http.Flusher).Flush()
+		fmt.Fprintln(w, `{"error":"pull model manifest: file does not exist"}`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // Ollama may keep the stream open.
+	}))
+	defer server.Close()
+
+	progressCh := make(chan tea.Msg)
+	Pull(context.Background(), PullOptions{Model: "lama3", Host: server.URL, Progress: progressCh, AutoRetry: true})
+	var msgs []tea.Msg
+	for msg := range progressCh {
+		msgs = append(msgs, msg)
+	}
+	require.NotEmpty(t, msgs)
+	assert.Equal(t, ProgressMsg{Status: "pulling manifest"}, msgs[0])
+	last := msgs[len(msgs)-1]
+	if assert.IsType(t, ErrorMsg{}, last, "The error line ends the pull instead of being retried") {
+		var ollamaErr *OllamaError
+		require.ErrorAs(t, last.(ErrorMsg).Err, &ollamaErr)
+		assert.Equal(t, "pull model manifest: file does not exist", ollamaErr.Message)
+		assert.ErrorIs(t, last.(ErrorMsg).Err, ErrModelNotFound)
+	}
+	assert.Len(t, msgs, 2)
+}
+
+func TestOllamaError_Is(t *testing.T) {
+	assert.ErrorIs(t, &OllamaError{Message: "pull model manifest: file does not exist"}, ErrModelNotFound)
+	assert.ErrorIs(t, &OllamaError{Message: "pull model manifest: 401: {\"errors\":[{\"code\":\"UNAUTHORIZED\"}]}"}, ErrUnauthorized)
+	assert.ErrorIs(t, &OllamaError{Message: "a pull is already in progress"}, ErrPullInProgress)
+	err := &OllamaError{Message: "max retries exceeded: unexpected EOF"}
+	assert.NotErrorIs(t, err, ErrModelNotFound)
+	assert.NotErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "ollama: max retries exceeded: unexpected EOF", err.Error())
+}
+
+func TestPull_Layers(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := json.NewEncoder(w)
+		enc.Encode(OllamaResponse{Status: "pulling manifest"})
+		enc.Encode(OllamaResponse{Status: "pulling aaa", Digest: "sha256:aaa", Completed: 10, Total: 10})
+		if attempts.Add(1) == 1 {
+			enc.Encode(OllamaResponse{Status: "pulling bbb", Digest: "sha256:bbb", Completed: 1, Total: 10})
+			return // Ends early; the retry sees the layers again.
+		}
+		enc.Encode(OllamaResponse{Status: "pulling bbb", Digest: "sha256:bbb", Completed: 10, Total: 10})
+		enc.Encode(OllamaResponse{Status: "pulling ccc", Digest: "sha256:ccc", Completed: 10, Total: 10})
+		enc.Encode(OllamaResponse{Status: "success"})
+	}))
+	defer server.Close()
+
+	for _, known := range []int{0, 3} {
+		attempts.Store(0)
+		progressCh := make(chan tea.Msg)
+		Pull(context.Background(), PullOptions{Model: "llama3", Host: server.URL, Progress: progressCh, AutoRetry: true, Layers: known})
+		var got []ProgressMsg
+		for msg := range progressCh {
+			if p, ok := msg.(ProgressMsg); ok && p.Digest != "" {
+				got = append(got, ProgressMsg{Digest: p.Digest, Layer: p.Layer, Layers: p.Layers})
+			}
+		}
+		assert.Equal(t, []ProgressMsg{
+			{Digest: "sha256:aaa", Layer: 1, Layers: known},
+			{Digest: "sha256:bbb", Layer: 2, Layers: known},
+			{Digest: "sha256:aaa", Layer: 1, Layers: known},
+			{Digest: "sha256:bbb", Layer: 2, Layers: known},
+			{Digest: "sha256:ccc", Layer: 3, Layers: known},
+		}, got, "Layers known: %d", known)
+	}
+}
+
+func TestRetryCauseOf(t *testing.T) {
+	assert.Equal(t, CauseGateway, RetryCauseOf(&APIStatusError{Code: http.StatusServiceUnavailable}))
+	assert.Equal(t, CauseStall, RetryCauseOf(ErrManifestTimeout))
+	assert.Equal(t, CauseTimeout, RetryCauseOf(fmt.Errorf("reading: %w", context.DeadlineExceeded)))
+	assert.Equal(t, CauseReset, RetryCauseOf(&StreamError{Err: io.ErrUnexpectedEOF}))
+	assert.Equal(t, CauseReset, RetryCauseOf(ErrStreamEnded))
+}
@@ -0,0 +1,60 @@
+package client
+
+import "time"
+
+// ProgressUpdateInterval is the minimum time between two progress updates for the same
+// phase. Ollama can emit hundreds of lines per second; anything faster than the terminal
+// can redraw only fills up the UI's message queue. Phase changes are always sent.
+var ProgressUpdateInterval = 100 * time.Millisecond
+
+// coalescer rate-limits ProgressMsg updates while keeping every phase change.
+type coalescer struct {
+	interval time.Duration
+	lastKey  string
+	lastSent time.Time
+	sentAny  bool
+	pending  *ProgressMsg
+}
+
+func newCoalescer(interval time.Duration) *coalescer {
+	return &coalescer{interval: interval}
+}
+
+// offer records a new update and returns the messages that should be sent right away.
+// A phase change (new status or new layer digest) flushes the pending update first so
+// the previous phase is shown at its final value.
+func (c *coalescer) offer(msg ProgressMsg, now time.Time) []ProgressMsg {
+	key := msg.Status + "\x00" + msg.Digest
+	if !c.sentAny || key != c.lastKey {
+		var out []ProgressMsg
+		if c.pending != nil {
+			out = append(out, *c.pending)
+		}
+		out = append(out, msg)
+		c.markSent(key, now)
+		return out
+	}
+	if now.Sub(c.lastSent) >= c.interval {
+		c.markSent(key, now)
+		return []ProgressMsg{msg}
+	}
+	c.pending = &msg
+	return nil
+}
+
+// flush returns the pending update if the interval has elapsed, or unconditionally when force is set.
+func (c *coalescer) flush(now time.Time, force bool) (ProgressMsg, bool) {
+	if c.pending == nil || (!force && now.Sub(c.lastSent) < c.interval) {
+		return ProgressMsg{}, false
+	}
+	msg := *c.pending
+	c.markSent(c.lastKey, now)
+	return msg, true
+}
+
+func (c *coalescer) markSent(key string, now time.Time) {
+	c.lastKey = key
+	c.lastSent = now
+	c.sentAny = true
+	c.pending = nil
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrHostUnreachable is returned when the Ollama host cannot be contacted at all,
+	// e.g. the connection was refused or the hostname did not resolve.
+	ErrHostUnreachable = errors.New("ollama host unreachable")
+	// ErrModelNotFound is returned when Ollama reports that the requested model does not exist.
+	ErrModelNotFound = errors.New("model not found")
+	// ErrUnauthorized is returned when the host rejects the request's credentials (401/403).
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrInvalidHost is returned when the Ollama host is not a usable address.
+	ErrInvalidHost = errors.New("invalid ollama host")
+	// ErrManifestTimeout is returned when Ollama stays on "pulling manifest" for longer
+	// than ManifestTimeout.
+	ErrManifestTimeout = errors.New("timed out resolving the model manifest")
+	// ErrStreamEnded is returned when the response stream closes without a "success" status.
+	ErrStreamEnded = errors.New("download stream ended unexpectedly")
+	// ErrPullInProgress is returned when the host refuses a pull because another pull is
+	// already running there, e.g. with 409 Conflict.
+	ErrPullInProgress = errors.New("another pull is in progress on the host")
+	// ErrGatewayUnavailable is returned when a reverse proxy in front of the host answers
+	// 502, 503 or 504, which usually lasts only moments, e.g. while Ollama or the proxy
+	// restarts.
+	ErrGatewayUnavailable = errors.New("gateway in front of the host unavailable")
+	// ErrDigestMismatch is returned when a model pinned by digest is, or would be,
+	// installed with another manifest digest.
+	ErrDigestMismatch = errors.New("manifest digest does not match the pin")
+
+	// errUserQuit signals that the user chose to quit while a download attempt was running.
+	errUserQuit = errors.New("user quit")
+)
+
+// APIStatusError is returned when the Ollama API responds with a non-200 status code.
+type APIStatusError struct {
+	Code int
+	Body string
+	// RetryAfter is the wait the response's Retry-After header asks for, 0 without one.
+	RetryAfter time.Duration
+}
+
+func (e *APIStatusError) Error() string {
+	return fmt.Sprintf("ollama API returned status %d: %s", e.Code, e.Body)
+}
+
+// Is lets errors.Is(err, ErrModelNotFound) match a 404 response,
+// errors.Is(err, ErrUnauthorized) a 401 or 403 response,
+// errors.Is(err, ErrPullInProgress) a 409 response or an error saying a pull is already
+// running, and errors.Is(err, ErrGatewayUnavailable) a 502, 503 or 504 response.
+func (e *APIStatusError) Is(target error) bool {
+	switch target {
+	case ErrModelNotFound:
+		return e.Code == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden
+	case ErrPullInProgress:
+		body := strings.ToLower(e.Body)
+		return e.Code == http.StatusConflict || strings.Contains(body, "in progress") || strings.Contains(body, "already pulling")
+	case ErrGatewayUnavailable:
+		return e.Code == http.StatusBadGateway || e.Code == http.StatusServiceUnavailable || e.Code == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+// OllamaError is returned when Ollama reports a failure inside the pull stream, as a
+// {"error": "..."} line, e.g. when resolving the manifest or authenticating to the
+// registry fails after the response has started.
+type OllamaError struct {
+	Message string
+}
+
+func (e *OllamaError) Error() string {
+	return "ollama: " + e.Message
+}
+
+// Is lets errors.Is(err, ErrModelNotFound) match a message saying the manifest does not
+// exist, errors.Is(err, ErrUnauthorized) one saying the registry refused the
+// credentials, and errors.Is(err, ErrPullInProgress) one saying a pull is already
+// running.
+func (e *OllamaError) Is(target error) bool {
+	msg := strings.ToLower(e.Message)
+	switch target {
+	case ErrModelNotFound:
+		return strings.Contains(msg, "file does not exist") || strings.Contains(msg, "manifest unknown") || strings.Contains(msg, "not found")
+	case ErrUnauthorized:
+		return strings.Contains(msg, "unauthorized") || strings.Contains(msg, "forbidden") || strings.Contains(msg, "authentication required")
+	case ErrPullInProgress:
+		return strings.Contains(msg, "in progress") || strings.Contains(msg, "already pulling")
+	}
+	return false
+}
+
+// StreamError is returned when reading the streamed response body fails mid-download.
+type StreamError struct {
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("error reading response stream: %v", e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// parseRetryAfter returns the wait a Retry-After header value asks for, given in seconds
+// or as an HTTP date, or 0 if there is none.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
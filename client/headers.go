@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"ollama-downloader-v2/useragent"
+)
+
+// DiagnosticHeaders are the response headers kept to diagnose what sits between this
+// client and a server: the server software, rate limits, and the marks proxies, caches
+// and CDNs leave. A buffering middlebox holds back a streamed pull, and these often tell
+// which one it is.
+var DiagnosticHeaders = []string{
+	"Server",
+	"Via",
+	"X-Cache",
+	"X-Served-By",
+	"X-Proxy-Id",
+	"X-Forwarded-For",
+	"X-Request-Id",
+	"Cf-Ray",
+	"X-Amz-Cf-Id",
+	"X-Accel-Buffering",
+	"Content-Type",
+	"Content-Length",
+	"Content-Encoding",
+	"Retry-After",
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+}
+
+// proxyHeaders are the DiagnosticHeaders only a proxy, cache or CDN sets.
+var proxyHeaders = []string{"Via", "X-Cache", "X-Served-By", "X-Proxy-Id", "X-Forwarded-For", "Cf-Ray", "X-Amz-Cf-Id"}
+
+// ResponseInfo is what a response tells about the way to the server: its protocol,
+// status and DiagnosticHeaders.
+type ResponseInfo struct {
+	Proto  string
+	Status int
+	Header http.Header
+}
+
+// NewResponseInfo returns the ResponseInfo of resp.
+func NewResponseInfo(resp *http.Response) ResponseInfo {
+	info := ResponseInfo{Proto: resp.Proto, Status: resp.StatusCode, Header: http.Header{}}
+	for _, name := range DiagnosticHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			info.Header[name] = values
+		}
+	}
+	// net/http moves Transfer-Encoding out of the headers; a proxy that buffers the
+	// stream often answers with a Content-Length instead.
+	if len(resp.TransferEncoding) > 0 {
+		info.Header["Transfer-Encoding"] = resp.TransferEncoding
+	}
+	return info
+}
+
+// Proxied reports whether a proxy, cache or CDN marked the response.
+func (r ResponseInfo) Proxied() bool {
+	for _, name := range proxyHeaders {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// HeaderString formats the headers as "Name: value" pairs, in the order of
+// DiagnosticHeaders, or "no diagnostic headers".
+func (r ResponseInfo) HeaderString() string {
+	var pairs []string
+	for _, name := range slices.Concat(DiagnosticHeaders, []string{"Transfer-Encoding"}) {
+		if values := r.Header[name]; len(values) > 0 {
+			pairs = append(pairs, name+": "+strings.Join(values, ", "))
+		}
+	}
+	if len(pairs) == 0 {
+		return "no diagnostic headers"
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// String formats r for logs and reports, e.g. "HTTP/1.1 200; Server: nginx; Via: 1.1 squid".
+func (r ResponseInfo) String() string {
+	return fmt.Sprintf("%s %d; %s", r.Proto, r.Status, r.HeaderString())
+}
+
+// ProbeResponse requests path on host with GET and returns the ResponseInfo of the
+// answer, whatever its status.
+func ProbeResponse(ctx context.Context, host, path string) (ResponseInfo, error) {
+	url, err := endpoint(host, path)
+	if err != nil {
+		return ResponseInfo{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ResponseInfo{}, fmt.Errorf("error creating request: %w", err)
+	}
+	useragent.Set(req)
+	if Authorize != nil {
+		if err := Authorize(req); err != nil {
+			return ResponseInfo{}, err
+		}
+	}
+	resp, err := httpClient(host).Do(req)
+	if err != nil {
+		return ResponseInfo{}, fmt.Errorf("%w: %w", ErrHostUnreachable, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	return NewResponseInfo(resp), nil
+}
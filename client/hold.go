@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"sync"
+)
+
+// errPaused ends an attempt that was broken off because its Hold was set.
+var errPaused = errors.New("pull paused")
+
+// PausedMsg reports that the pull is held (see Hold) and waits to be released. It is
+// sent again when the reason changes.
+type PausedMsg struct {
+	Reason string
+}
+
+// Hold pauses pulls, e.g. while a laptop runs low on battery. While it is held, a pull
+// breaks off the attempt in progress and starts no new one until it is released; Ollama
+// keeps what was downloaded, so the pull resumes where it stopped. A nil *Hold is never
+// held. It is safe for concurrent use.
+type Hold struct {
+	mu      sync.Mutex
+	held    bool
+	reason  string
+	changed chan struct{}
+}
+
+// NewHold returns a released Hold.
+func NewHold() *Hold {
+	return &Hold{changed: make(chan struct{})}
+}
+
+// Set holds the pulls, with reason saying why (e.g. "on battery at 15%"), or releases
+// them.
+func (h *Hold) Set(held bool, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !held {
+		reason = ""
+	}
+	if held == h.held && reason == h.reason {
+		return
+	}
+	h.held, h.reason = held, reason
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// state returns whether h is held and why, and a channel that is closed on the next
+// change. The channel of a nil Hold is nil, which never fires.
+func (h *Hold) state() (held bool, reason string, changed <-chan struct{}) {
+	if h == nil {
+		return false, "", nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.held, h.reason, h.changed
+}
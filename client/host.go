@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DefaultPort is the port Ollama listens on.
+const DefaultPort = "11434"
+
+// ParseHost turns an Ollama host as given to --host or OLLAMA_HOST into a base URL.
+// A host with a scheme ("http://[::1]:11434", "https://ollama.example.com") is used as
+// is. Without a scheme, like the ollama CLI, it accepts a host and port ("[::1]:11434",
+// "box:8080") or a bare name or address, IPv6 literals included ("::1", "localhost"),
+// and defaults to http on port 11434. For a Unix socket ("unix:///var/run/ollama.sock")
+// the URL's path is the socket.
+func ParseHost(host string) (*url.URL, error) {
+	s := strings.TrimSpace(host)
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", ErrInvalidHost, host, err)
+		}
+		if u.Scheme == "unix" {
+			if u.Host != "" || u.Path == "" {
+				return nil, fmt.Errorf("%w %q: want an absolute socket path, e.g. unix:///var/run/ollama.sock", ErrInvalidHost, host)
+			}
+			return u, nil
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("%w %q: unsupported scheme %q", ErrInvalidHost, host, u.Scheme)
+		}
+		if u.Hostname() == "" {
+			return nil, fmt.Errorf("%w %q: no address", ErrInvalidHost, host)
+		}
+		if !strings.HasPrefix(u.Host, "[") && strings.Count(u.Host, ":") > 1 {
+			return nil, fmt.Errorf("%w %q: IPv6 addresses in a URL need brackets, e.g. http://[::1]:11434", ErrInvalidHost, host)
+		}
+		return u, nil
+	}
+
+	hostport, path, _ := strings.Cut(s, "/")
+	name, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		// No port, or an IPv6 literal without brackets.
+		name, port = strings.Trim(hostport, "[]"), DefaultPort
+	}
+	if name == "" {
+		// ":11434" names the local host, as for the ollama CLI.
+		name = "127.0.0.1"
+	}
+	u := &url.URL{Scheme: "http", Host: net.JoinHostPort(name, port)}
+	if path != "" {
+		u.Path = "/" + path
+	}
+	return u, nil
+}
+
+// NormalizeHost returns host as a base URL, or host unchanged if it cannot be parsed;
+// requests to it then fail with ErrInvalidHost.
+func NormalizeHost(host string) string {
+	u, err := ParseHost(host)
+	if err != nil {
+		return host
+	}
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// IsLocal reports whether host is an Ollama server on this machine: a Unix socket, or a
+// loopback or unspecified address.
+func IsLocal(host string) bool {
+	u, err := ParseHost(host)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "unix" || u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+// socketBase is the URL requests over a Unix socket are addressed to; the socket
+// itself is chosen by the client's dialer.
+const socketBase = "http://localhost"
+
+// endpoint returns the URL of the API path on host.
+func endpoint(host, path string) (string, error) {
+	u, err := ParseHost(host)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "unix" {
+		return socketBase + path, nil
+	}
+	return u.JoinPath(path).String(), nil
+}
+
+// socketClients caches one client per Unix socket so connections are reused.
+var socketClients sync.Map
+
+// httpClient returns the client for requests to host: http.DefaultClient, or for a
+// unix:// host one that dials the socket.
+func httpClient(host string) *http.Client {
+	u, err := ParseHost(host)
+	if err != nil || u.Scheme != "unix" {
+		return http.DefaultClient
+	}
+	if c, ok := socketClients.Load(u.Path); ok {
+		return c.(*http.Client)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", u.Path)
+	}
+	c, _ := socketClients.LoadOrStore(u.Path, &http.Client{Transport: transport})
+	return c.(*http.Client)
+}
@@ -0,0 +1,28 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SameDigest compares two digests with or without their "sha256:" prefix; /api/tags
+// lists them without it. An empty digest matches nothing.
+func SameDigest(a, b string) bool {
+	a, b = strings.TrimPrefix(a, "sha256:"), strings.TrimPrefix(b, "sha256:")
+	return a != "" && a == b
+}
+
+// CheckDigest returns an ErrDigestMismatch unless model is installed on host with the
+// manifest digest want, and an ErrModelNotFound if it is not installed at all.
+func CheckDigest(ctx context.Context, host, model, want string) error {
+	got, err := InstalledDigest(ctx, host, model)
+	if err != nil {
+		return err
+	}
+	if !SameDigest(got, want) {
+		return fmt.Errorf("%w: %s is installed on %s as sha256:%s, pinned %s",
+			ErrDigestMismatch, model, host, strings.TrimPrefix(got, "sha256:"), want)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProgressLogInterval, when set, logs a sample of the progress lines Ollama streams: at
+// most one per interval within a phase, plus the first and last line of every phase
+// (status and layer digest), with sizes and digests. That is enough to reconstruct a
+// pull from the log without logging every one of the thousands of lines. Zero logs none.
+var ProgressLogInterval time.Duration
+
+// progressSampler picks the progress lines to log, see ProgressLogInterval.
+type progressSampler struct {
+	model     string
+	interval  time.Duration
+	lastKey   string
+	lastLog   time.Time
+	loggedAny bool
+	// skipped counts the lines since the last one logged, which the next log line gives
+	// so gaps are visible; pending is the latest of them.
+	skipped int
+	pending *OllamaResponse
+}
+
+func newProgressSampler(model string, interval time.Duration) *progressSampler {
+	return &progressSampler{model: model, interval: interval}
+}
+
+// offer records a line and returns the log lines to write for it. A phase change logs
+// the last line of the previous phase first, so its final sizes are in the log.
+func (s *progressSampler) offer(msg OllamaResponse, now time.Time) []string {
+	key := msg.Status + "\x00" + msg.Digest
+	if s.loggedAny && key == s.lastKey && now.Sub(s.lastLog) < s.interval {
+		s.pending = &msg
+		s.skipped++
+		return nil
+	}
+	var out []string
+	if key != s.lastKey {
+		out = s.flush()
+	}
+	out = append(out, s.format(msg))
+	s.lastKey, s.lastLog, s.loggedAny = key, now, true
+	s.skipped, s.pending = 0, nil
+	return out
+}
+
+// flush returns the log line for the latest line not logged yet, if any.
+func (s *progressSampler) flush() []string {
+	if s.pending == nil {
+		return nil
+	}
+	s.skipped--
+	line := s.format(*s.pending)
+	s.skipped, s.pending = 0, nil
+	return []string{line}
+}
+
+func (s *progressSampler) format(msg OllamaResponse) string {
+	line := fmt.Sprintf("Progress of %s: status=%q", s.model, msg.Status)
+	if msg.Digest != "" {
+		line += " digest=" + msg.Digest
+	}
+	if msg.Total > 0 {
+		line += fmt.Sprintf(" completed=%d total=%d", msg.Completed, msg.Total)
+	}
+	if s.skipped > 0 {
+		line += fmt.Sprintf(" skipped=%d", s.skipped)
+	}
+	return line
+}
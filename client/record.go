@@ -0,0 +1,89 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Recorded entry kinds.
+const (
+	// EventAttempt marks the start of a new /api/pull request.
+	EventAttempt = "attempt"
+	// EventLine is one raw line of the streamed response.
+	EventLine = "line"
+	// EventStatus is a non-200 response with its body in Line.
+	EventStatus = "status"
+	// EventResponse is the protocol (in Line), status and DiagnosticHeaders of the
+	// response to an attempt.
+	EventResponse = "response"
+)
+
+// RecordedLine is one entry of a recorded pull session.
+type RecordedLine struct {
+	Time    time.Time           `json:"time"`
+	Attempt int                 `json:"attempt"`
+	Event   string              `json:"event"`
+	Status  int                 `json:"status,omitempty"`
+	Line    string              `json:"line,omitempty"`
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+// Recorder writes every /api/pull response line with a timestamp as JSON lines, so a
+// session can be replayed later. A nil *Recorder records nothing.
+type Recorder struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	attempt int
+}
+
+// SessionRecorder, when set, receives every response line read by Pull.
+var SessionRecorder *Recorder
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// StartAttempt marks the beginning of a new request.
+func (r *Recorder) StartAttempt() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempt++
+	r.enc.Encode(RecordedLine{Time: time.Now(), Attempt: r.attempt, Event: EventAttempt})
+}
+
+// Record stores one raw response line of the current attempt.
+func (r *Recorder) Record(line []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(RecordedLine{Time: time.Now(), Attempt: r.attempt, Event: EventLine, Line: string(line)})
+}
+
+// RecordStatus stores a non-200 response of the current attempt.
+func (r *Recorder) RecordStatus(code int, body []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(RecordedLine{Time: time.Now(), Attempt: r.attempt, Event: EventStatus, Status: code, Line: string(body)})
+}
+
+// RecordResponse stores what the response of the current attempt tells about the way
+// to the server.
+func (r *Recorder) RecordResponse(info ResponseInfo) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(RecordedLine{Time: time.Now(), Attempt: r.attempt, Event: EventResponse, Status: info.Status, Line: info.Proto, Headers: info.Header})
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"log"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// choiceBuffer is how many choices a Session holds for a pull that is busy. The UI
+// makes at most two before it quits: ChoiceFinishLayer and a final choice.
+const choiceBuffer = 2
+
+// Session owns the channels between one pull and the UI that shows it, so neither side
+// can block the other or panic on a closed channel when they end at the same time, as
+// when the user quits just as an error arrives.
+//
+// The pull is the only sender on Progress and closes it when it ends. The UI answers
+// with Choose, which never blocks, and calls Close when it exits; both may be called
+// any number of times from any goroutine.
+type Session struct {
+	progress  chan tea.Msg
+	choices   chan Choice
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSession returns a session whose UI has not exited yet.
+func NewSession() *Session {
+	return &Session{
+		progress: make(chan tea.Msg),
+		choices:  make(chan Choice, choiceBuffer),
+		done:     make(chan struct{}),
+	}
+}
+
+// Progress is the channel to pass as PullOptions.Progress; the UI side ranges over it.
+func (s *Session) Progress() chan tea.Msg { return s.progress }
+
+// Choices is the channel to pass as PullOptions.Choices.
+func (s *Session) Choices() <-chan Choice { return s.choices }
+
+// Choose hands choice to the pull. If the pull has not taken the earlier choices yet,
+// choice is dropped: the UI is quitting and the caller cancels the pull anyway.
+func (s *Session) Choose(choice Choice) {
+	select {
+	case s.choices <- choice:
+	default:
+		log.Printf("Dropped choice %q: the pull has not read the earlier ones.", choice)
+	}
+}
+
+// Close records that the UI has exited.
+func (s *Session) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// Done is closed once Close has been called.
+func (s *Session) Done() <-chan struct{} { return s.done }
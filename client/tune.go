@@ -0,0 +1,29 @@
+package client
+
+import "time"
+
+// Tuner, when set, learns how long hosts take to answer a pull and lengthens the
+// timeouts of the ones that are regularly slow. PullOptions.Timeouts takes precedence
+// over what it picks.
+var Tuner TimeoutTuner
+
+// TimeoutTuner picks the timeouts of a host from how long it took to answer before.
+type TimeoutTuner interface {
+	// Timeouts returns the timeouts for pulls from host; zero fields keep the defaults.
+	Timeouts(host string) Timeouts
+	// FirstByte records that host answered an attempt after wait, the time from sending
+	// the request to the first line of the stream. If missed, the attempt timed out
+	// after wait without an answer, so the host needs longer than that.
+	FirstByte(host string, wait time.Duration, missed bool)
+}
+
+// or fills the zero fields of t from fallback.
+func (t Timeouts) or(fallback Timeouts) Timeouts {
+	if t.Request == 0 {
+		t.Request = fallback.Request
+	}
+	if t.Manifest == 0 {
+		t.Manifest = fallback.Manifest
+	}
+	return t
+}
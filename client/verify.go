@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"ollama-downloader-v2/ref"
+)
+
+// VerifyPolicy selects how thoroughly a pulled model is checked after the download.
+// Each policy includes the checks of the ones before it.
+type VerifyPolicy string
+
+const (
+	// VerifyDigest checks that the model is installed with the manifest digest the
+	// registry serves for it.
+	VerifyDigest VerifyPolicy = "digest"
+	// VerifyLoad additionally loads the model into memory once.
+	VerifyLoad VerifyPolicy = "load"
+	// VerifyGenerate additionally runs a short generation and expects non-empty output.
+	VerifyGenerate VerifyPolicy = "generate"
+)
+
+// verifyPrompt is the prompt used by VerifyGenerate.
+const verifyPrompt = "Reply with the single word: ok"
+
+// ParseVerifyPolicy converts a flag value into a VerifyPolicy.
+func ParseVerifyPolicy(s string) (VerifyPolicy, error) {
+	switch p := VerifyPolicy(s); p {
+	case VerifyDigest, VerifyLoad, VerifyGenerate:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown verify policy %q (want digest, load or generate)", s)
+	}
+}
+
+// VerifyError reports which verification step failed.
+type VerifyError struct {
+	Step VerifyPolicy
+	Err  error
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("verification failed at %s step: %v", e.Step, e.Err)
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+type generateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	Stream  bool           `json:"stream"`
+	Options map[string]any `json:"options,omitempty"`
+}
+
+type generateResponse struct {
+	Response string `json:"response"`
+	Done     bool   `json:"done"`
+	// LoadDuration is how long loading the model took, in nanoseconds.
+	LoadDuration int64 `json:"load_duration"`
+}
+
+// Warmup loads model on host with an empty generate request, so it is in memory and
+// known to load, and returns how long loading took. If Ollama does not report it, the
+// duration of the request is returned instead.
+func Warmup(ctx context.Context, host string, model string) (time.Duration, error) {
+	start := time.Now()
+	var res generateResponse
+	if err := postJSON(ctx, host, "/api/generate", generateRequest{Model: model}, &res); err != nil {
+		return 0, fmt.Errorf("loading %s: %w", model, err)
+	}
+	if res.LoadDuration > 0 {
+		return time.Duration(res.LoadDuration), nil
+	}
+	return time.Since(start), nil
+}
+
+// testPromptTokens caps the output of TestPrompt; the first tokens are enough to judge it.
+const testPromptTokens = 48
+
+// TestPrompt runs one short generation of prompt on model and returns the output. Empty
+// output is an error, as it usually means a broken quantization or template.
+func TestPrompt(ctx context.Context, host string, model string, prompt string) (string, error) {
+	req := generateRequest{Model: model, Prompt: prompt, Options: map[string]any{"num_predict": testPromptTokens}}
+	var res generateResponse
+	if err := postJSON(ctx, host, "/api/generate", req, &res); err != nil {
+		return "", fmt.Errorf("running test prompt on %s: %w", model, err)
+	}
+	out := strings.TrimSpace(res.Response)
+	if out == "" {
+		return "", fmt.Errorf("model %s generated no output for the test prompt", model)
+	}
+	return out, nil
+}
+
+// Verify checks a pulled model on host according to policy. want is the manifest digest
+// the registry serves for model, which the installed one must match.
+func Verify(ctx context.Context, host string, model string, want string, policy VerifyPolicy) error {
+	digest, err := InstalledDigest(ctx, host, model)
+	if err != nil {
+		return &VerifyError{Step: VerifyDigest, Err: err}
+	}
+	if digest == "" {
+		return &VerifyError{Step: VerifyDigest, Err: fmt.Errorf("model %s has no manifest digest", model)}
+	}
+	if !SameDigest(digest, want) {
+		return &VerifyError{Step: VerifyDigest, Err: fmt.Errorf("%w: %s is installed on %s as sha256:%s, the registry serves %s",
+			ErrDigestMismatch, model, host, strings.TrimPrefix(digest, "sha256:"), want)}
+	}
+	if policy == VerifyDigest {
+		return nil
+	}
+
+	var res generateResponse
+	if err := postJSON(ctx, host, "/api/generate", generateRequest{Model: model}, &res); err != nil {
+		return &VerifyError{Step: VerifyLoad, Err: err}
+	}
+	if policy == VerifyLoad {
+		return nil
+	}
+
+	req := generateRequest{Model: model, Prompt: verifyPrompt, Options: map[string]any{"num_predict": 8}}
+	if err := postJSON(ctx, host, "/api/generate", req, &res); err != nil {
+		return &VerifyError{Step: VerifyGenerate, Err: err}
+	}
+	if strings.TrimSpace(res.Response) == "" {
+		return &VerifyError{Step: VerifyGenerate, Err: fmt.Errorf("model %s generated no output", model)}
+	}
+	return nil
+}
+
+// InstalledDigest returns the manifest digest of model as listed by /api/tags, without
+// the "sha256:" prefix. A model that is not installed is an ErrModelNotFound.
+func InstalledDigest(ctx context.Context, host string, model string) (string, error) {
+	m, err := FindInstalled(ctx, host, model)
+	return m.Digest, err
+}
+
+// FindInstalled returns the /api/tags entry of model. A model that is not installed is
+// an ErrModelNotFound.
+func FindInstalled(ctx context.Context, host string, model string) (InstalledModel, error) {
+	models, err := ListModels(ctx, host)
+	if err != nil {
+		return InstalledModel{}, err
+	}
+	for _, m := range models {
+		if ref.Same(m.Name, model) || ref.Same(m.Model, model) {
+			return m, nil
+		}
+	}
+	return InstalledModel{}, fmt.Errorf("%w: %s is not installed on %s", ErrModelNotFound, model, host)
+}
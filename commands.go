@@ -0,0 +1,928 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"ollama-downloader-v2/auth"
+	"ollama-downloader-v2/bundle"
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/config"
+	"ollama-downloader-v2/control"
+	"ollama-downloader-v2/events"
+	"ollama-downloader-v2/history"
+	"ollama-downloader-v2/plan"
+	"ollama-downloader-v2/progressfile"
+	"ollama-downloader-v2/prompt"
+	"ollama-downloader-v2/ref"
+	"ollama-downloader-v2/registry"
+	"ollama-downloader-v2/stats"
+	"ollama-downloader-v2/ui"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// resolveHost returns host, or the global --host, or OLLAMA_HOST, or the default local
+// Ollama address.
+func resolveHost(host string) string {
+	if host == "" {
+		host = defaultHost
+	}
+	if host == "" {
+		host = os.Getenv("OLLAMA_HOST")
+	}
+	if host == "" {
+		host = "http://localhost:11434"
+	}
+	return client.NormalizeHost(host)
+}
+
+// checkMemory prints what model can do and roughly how much memory running it takes,
+// warning if it is an embedding model that cannot chat. If the memory exceeds limit, it
+// warns and asks whether to pull anyway, returning false if the user declined. Without a
+// terminal to ask on, or when the estimate is unavailable, it returns true.
+func checkMemory(model string, limit int64) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reg, name := registry.New(), registry.ParseName(model)
+	m, err := reg.Manifest(ctx, name)
+	if err != nil {
+		log.Printf("No memory estimate for %s: %v", model, err)
+		return true
+	}
+	cfg, err := reg.Config(ctx, name, m)
+	if err != nil {
+		log.Printf("No memory estimate for %s: %v", model, err)
+		return true
+	}
+	if caps, err := reg.Capabilities(ctx, name, cfg, m); err != nil {
+		log.Printf("No capabilities for %s: %v", model, err)
+	} else {
+		printCapabilities(os.Stdout, model, cfg.ModelFamily, caps)
+	}
+	need := registry.EstimateMemory(cfg, m)
+	if need == 0 {
+		log.Printf("No memory estimate for %s", model)
+		return true
+	}
+
+	fmt.Printf("Estimated memory to run %s: ~%s", model, ui.FormatBytes(need))
+	if cfg.ModelType != "" && cfg.FileType != "" {
+		fmt.Printf(" (%s parameters, %s)", cfg.ModelType, cfg.FileType)
+	}
+	fmt.Println()
+	if limit <= 0 || need <= limit {
+		return true
+	}
+
+	fmt.Printf("Warning: this is more than the host's %s; the model may not load.\n", ui.FormatBytes(limit))
+	if !stdinIsTerminal() {
+		return true
+	}
+	return confirm("Pull anyway?")
+}
+
+// printCapabilities prints the family and capabilities of model, with a warning if it
+// is an embedding model, which turns text into vectors and cannot chat.
+func printCapabilities(w io.Writer, model, family string, caps []string) {
+	if len(caps) == 0 {
+		return
+	}
+	if family != "" {
+		fmt.Fprintf(w, "%s is a %s model: %s.\n", model, family, strings.Join(caps, ", "))
+	} else {
+		fmt.Fprintf(w, "%s: %s.\n", model, strings.Join(caps, ", "))
+	}
+	if slices.Contains(caps, registry.CapabilityEmbedding) && !slices.Contains(caps, registry.CapabilityCompletion) {
+		fmt.Fprintf(w, "Warning: %s is an embedding model; it turns text into vectors for search and cannot chat or generate text.\n", model)
+	}
+}
+
+// showModel prints the family, size and capabilities host reports for the pulled model
+// and returns them. Errors are only logged; the summary is informational.
+func showModel(w io.Writer, host, model string) client.ModelDetails {
+	ctx, cancel := context.WithTimeout(context.Background(), client.RequestTimeout)
+	defer cancel()
+	details, err := client.ShowModel(ctx, host, model)
+	if err != nil {
+		log.Printf("No model details: %v", err)
+		return client.ModelDetails{}
+	}
+	var parts []string
+	if d := details.Details; d.Family != "" {
+		parts = append(parts, d.Family)
+	}
+	if d := details.Details; d.ParameterSize != "" && d.QuantizationLevel != "" {
+		parts = append(parts, fmt.Sprintf("%s parameters, %s", d.ParameterSize, d.QuantizationLevel))
+	}
+	if len(details.Capabilities) > 0 {
+		parts = append(parts, strings.Join(details.Capabilities, ", "))
+	}
+	if len(parts) > 0 {
+		fmt.Fprintf(w, "Installed %s: %s.\n", model, strings.Join(parts, "; "))
+	}
+	return details
+}
+
+// stdinIsTerminal reports whether someone can answer confirm.
+func stdinIsTerminal() bool {
+	st, err := os.Stdin.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks question on stdout and reports whether the answer on stdin is yes.
+func confirm(question string) bool {
+	fmt.Print(question + " [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// layerSizes looks up the size of every blob of model in the registry, so the ETA can
+// count layers not started yet. It returns nil if the manifest is unavailable, e.g. for
+// models from other registries.
+func layerSizes(model string) map[string]int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m, err := registry.New().Manifest(ctx, registry.ParseName(model))
+	if err != nil {
+		log.Printf("No layer sizes for %s: %v", model, err)
+		return nil
+	}
+	sizes := map[string]int64{m.Config.Digest: m.Config.Size}
+	for _, l := range m.Layers {
+		sizes[l.Digest] = l.Size
+	}
+	return sizes
+}
+
+// checkPulledModel loads a pulled model with warmup and runs testPrompt on it if set,
+// returning what it measured.
+func checkPulledModel(c plan.Change, warmup bool, testPrompt string) (plan.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+	defer cancel()
+	var checked plan.Result
+	if warmup {
+		loadTime, err := client.Warmup(ctx, c.Host, c.Model)
+		if err != nil {
+			return checked, err
+		}
+		checked.LoadTime = loadTime.Seconds()
+	}
+	if testPrompt != "" {
+		output, err := client.TestPrompt(ctx, c.Host, c.Model, testPrompt)
+		if err != nil {
+			return checked, err
+		}
+		checked.TestOutput = output
+	}
+	return checked, nil
+}
+
+// maxQuotedOutput is how much of a test prompt's answer is shown.
+const maxQuotedOutput = 300
+
+// quoteOutput formats generated text for the summary: indented, with each line marked,
+// and cut after maxQuotedOutput characters.
+func quoteOutput(s string) string {
+	if r := []rune(s); len(r) > maxQuotedOutput {
+		s = string(r[:maxQuotedOutput]) + "…"
+	}
+	return "  > " + strings.ReplaceAll(s, "\n", "\n  > ")
+}
+
+// runControlCommand sends command to the running instance and prints the result.
+func runControlCommand(command string) int {
+	res, err := control.Query(control.DefaultSocketPath(), command)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	st := res.Status
+	switch command {
+	case control.CommandCancel:
+		fmt.Printf("Cancelled pull of %s (pid %d).\n", st.Model, st.PID)
+	default:
+		fmt.Printf("Model:    %s\n", st.Model)
+		fmt.Printf("Host:     %s\n", st.Host)
+		fmt.Printf("Phase:    %s\n", st.Phase)
+		if st.Total > 0 {
+			fmt.Printf("Progress: %.1f%% (%d / %d bytes)\n", st.Percent(), st.Completed, st.Total)
+		}
+		if st.Speed > 0 {
+			speed := ui.FormatSpeed(st.Speed)
+			if st.ETASeconds > 0 {
+				speed += fmt.Sprintf(", %s left", time.Duration(st.ETASeconds*float64(time.Second)).Round(time.Second))
+			}
+			fmt.Printf("Speed:    %s\n", speed)
+		}
+		fmt.Printf("Running:  %s (pid %d)\n", time.Since(st.StartedAt).Round(time.Second), st.PID)
+	}
+	return 0
+}
+
+// addBarFlags adds the progress bar flags to fs. The returned function merges them with
+// progress_bar in cfg, which may be nil, and checks the result.
+func addBarFlags(fs *flag.FlagSet) func(cfg *config.Config) (ui.BarOptions, error) {
+	style := fs.String("bar-style", "", "Progress bar style: "+strings.Join(ui.BarStyles, ", ")+". Overrides progress_bar in the config.")
+	width := fs.Int("bar-width", 0, "Maximum width of the progress bar in columns")
+	hidePercentage := fs.Bool("hide-percentage", false, "Don't show the percentage after the progress bar")
+	return func(cfg *config.Config) (ui.BarOptions, error) {
+		var o ui.BarOptions
+		if cfg != nil && cfg.ProgressBar != nil {
+			o = ui.BarOptions{Style: cfg.ProgressBar.Style, MaxWidth: cfg.ProgressBar.Width, HidePercentage: cfg.ProgressBar.HidePercentage}
+		}
+		if *style != "" {
+			o.Style = *style
+		}
+		if *width != 0 {
+			o.MaxWidth = *width
+		}
+		if *hidePercentage {
+			o.HidePercentage = true
+		}
+		return o, o.Validate()
+	}
+}
+
+// loadConfig reads the user's config file from its default location.
+func loadConfig() (*config.Config, error) {
+	path, err := config.Path()
+	if err != nil {
+		return nil, err
+	}
+	return config.Load(path)
+}
+
+// oidcFromConfig returns the OIDC authenticator set up in cfg, or nil if there is none.
+func oidcFromConfig(cfg *config.Config) (*auth.OIDC, error) {
+	if cfg == nil || cfg.OIDC == nil {
+		return nil, nil
+	}
+	if cfg.OIDC.Issuer == "" || cfg.OIDC.ClientID == "" {
+		return nil, errors.New("oidc needs an issuer and a client_id")
+	}
+	path, err := config.TokenPath()
+	if err != nil {
+		return nil, err
+	}
+	return &auth.OIDC{
+		Issuer:    cfg.OIDC.Issuer,
+		ClientID:  cfg.OIDC.ClientID,
+		Scopes:    cfg.OIDC.Scopes,
+		Header:    cfg.OIDC.Header,
+		CachePath: path,
+	}, nil
+}
+
+// authorizer returns the hook for client.Authorize: the Ollama key for ollama.com (or
+// every host if always is set) followed by the `login` token if cfg sets up OIDC.
+func authorizer(cfg *config.Config, always bool) func(*http.Request) error {
+	authenticator := auth.FromEnv()
+	authenticator.Always = always
+	oidc, err := oidcFromConfig(cfg)
+	if err != nil {
+		log.Printf("Ignoring OIDC settings: %v", err)
+	}
+	if oidc == nil {
+		return authenticator.Authorize
+	}
+	return auth.Chain(authenticator.Authorize, oidc.Authorize)
+}
+
+// runLoginCommand signs in to the identity provider set up in the config with the
+// device flow and caches the token for later pulls.
+func runLoginCommand() int {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	oidc, err := oidcFromConfig(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if oidc == nil {
+		fmt.Println("Error: no identity provider configured; add an \"oidc\" section to the config file.")
+		return 1
+	}
+
+	ctx := context.Background()
+	dc, err := oidc.StartDevice(ctx)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if dc.VerificationURIComplete != "" {
+		fmt.Printf("Open %s to log in (code %s).\n", dc.VerificationURIComplete, dc.UserCode)
+	} else {
+		fmt.Printf("Open %s and enter the code %s to log in.\n", dc.VerificationURI, dc.UserCode)
+	}
+	fmt.Println("Waiting for approval...")
+	if _, err := oidc.WaitDevice(ctx, dc); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	log.Printf("Logged in to %s", oidc.Issuer)
+	fmt.Println("Logged in. The token is refreshed automatically while it can be.")
+	return 0
+}
+
+// runAliasCommand lists, adds or removes model aliases in the config file.
+func runAliasCommand(args []string) int {
+	fs := newFlagSet("alias")
+	fs.Parse(args)
+	args = fs.Args()
+	path, err := config.Path()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	switch {
+	case len(args) == 0:
+		if len(cfg.Aliases) == 0 {
+			fmt.Println("No aliases defined. Add one with: alias add <name> <model>")
+			return 0
+		}
+		for _, name := range cfg.AliasNames() {
+			fmt.Printf("%s = %s\n", name, cfg.Aliases[name])
+		}
+		return 0
+
+	case args[0] == "add" && len(args) == 3:
+		if _, err := ref.Parse(args[2]); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		cfg.SetAlias(args[1], args[2])
+
+	case args[0] == "remove" && len(args) == 2:
+		if !cfg.RemoveAlias(args[1]) {
+			fmt.Printf("Error: no alias named %q\n", args[1])
+			return 1
+		}
+
+	default:
+		fs.Usage()
+		return 1
+	}
+
+	if err := cfg.Save(path); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// tagsConcurrency bounds the manifest and config requests in flight while describing tags.
+const tagsConcurrency = 8
+
+// runTagsCommand lists the registry tags of model in a picker and returns the reference
+// the user chose to pull, or "" with an exit status if there is nothing to pull.
+func runTagsCommand(model string) (string, int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	reg := registry.New()
+	fmt.Printf("Fetching tags of %s...\n", model)
+	tags, err := reg.Tags(ctx, registry.ParseName(model))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return "", 1
+	}
+	if len(tags) == 0 {
+		fmt.Printf("No tags found for %s.\n", model)
+		return "", 1
+	}
+	infos := reg.DescribeTags(ctx, model, tags, tagsConcurrency)
+	for _, info := range infos {
+		if info.Err != nil {
+			log.Printf("Describing tag %s: %v", info.Tag, info.Err)
+		}
+	}
+
+	picker := ui.NewTagPicker(model, infos)
+	if cfg, err := loadConfig(); err != nil {
+		log.Printf("Ignoring config: %v", err)
+	} else if cost := dataCost(0, cfg); cost.PerGB > 0 {
+		picker = picker.WithCost(cost.PerGB, cost.Currency)
+	}
+	final, err := tea.NewProgram(picker).Run()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return "", 1
+	}
+	return final.(ui.TagPicker).Selected(), 0
+}
+
+// computePlan loads the manifest at file and diffs it against the models installed on
+// each host it names. Entries without their own host use host, or the manifest's host if
+// host is empty. It returns the plan and that default host.
+func computePlan(file, host string, prune bool) (plan.Plan, string, error) {
+	manifest, err := plan.Load(file)
+	if err != nil {
+		return plan.Plan{}, "", err
+	}
+	if host == "" {
+		host = manifest.Host
+	}
+	host = resolveHost(host)
+	entries := plan.WithHost(manifest.Models, host)
+	// Spellings of the same address, e.g. "box" and "http://box:11434", are one host.
+	for i := range entries {
+		entries[i].Host = client.NormalizeHost(entries[i].Host)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.RequestTimeout)
+	defer cancel()
+	installed := make(map[string][]client.InstalledModel)
+	for _, h := range plan.Hosts(entries) {
+		models, err := client.ListModels(ctx, h)
+		if err != nil {
+			return plan.Plan{}, "", fmt.Errorf("listing installed models on %s: %w", h, err)
+		}
+		installed[h] = models
+	}
+	return plan.Compute(entries, installed, prune), host, nil
+}
+
+// runPlanCommand prints what applying a models.yaml manifest would change on the host.
+func runPlanCommand(args []string) int {
+	fs := newFlagSet("plan")
+	file := fs.String("f", "models.yaml", "Manifest listing the wanted models")
+	prune := fs.Bool("prune", false, "Also plan to delete installed models that are not in the manifest")
+	host := fs.String("host", "", "Ollama API host. Overrides the manifest's host and OLLAMA_HOST.")
+	fs.Parse(args)
+
+	p, resolvedHost, err := computePlan(*file, *host, *prune)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	fmt.Printf("Comparing %s with %s:\n\n", *file, resolvedHost)
+	p.Render(os.Stdout)
+	return 0
+}
+
+// runApplyCommand pulls the models a manifest is missing, one after another with the
+// progress UI, deletes unlisted models with --prune, and writes a JSON report.
+func runApplyCommand(args []string) int {
+	fs := newFlagSet("apply")
+	file := fs.String("f", "models.yaml", "Manifest listing the wanted models")
+	prune := fs.Bool("prune", false, "Delete installed models that are not in the manifest")
+	host := fs.String("host", "", "Ollama API host. Overrides the manifest's host and OLLAMA_HOST.")
+	reportPath := fs.String("report", "apply-report.json", "Where to write the JSON report of the apply")
+	maxRetries := fs.Int("max-retries", 5, "Automatic retries one model may use before it is skipped (0 for no limit)")
+	retryBudget := fs.Int("retry-budget", 20, "Automatic retries all models together may use (0 for no limit)")
+	warmup := fs.Bool("warmup", false, "Load each pulled model once and report its load time")
+	testPrompt := fs.String("test-prompt", "", "Run this prompt on each pulled model and record the start of the answer")
+	progressPath := fs.String("progress-file", "", "Rewrite this JSON file every second with the progress of the current pull")
+	tmuxStatus := fs.Bool("tmux-status", false, "When running in tmux, show the progress of the current pull in the session's @ollama-progress option")
+	promptStatus := fs.Bool("prompt-status", false, "Keep the progress of the current pull in "+prompt.Path()+" for shell prompts")
+	eventsSpec := fs.String("events", "", "Stream JSON events for every pull, one per line: 'file:<path>' or 'socket:<path>'")
+	lockPath := fs.String("lock-file", "", "Lockfile to record the installed models in (default: the manifest's name with .lock, e.g. models.lock)")
+	barOptions := addBarFlags(fs)
+	bell := addBellFlags(fs)
+	powerOpts := addPowerFlags(fs)
+	fs.Parse(args)
+	if *lockPath == "" {
+		*lockPath = strings.TrimSuffix(*file, filepath.Ext(*file)) + ".lock"
+	}
+
+	p, resolvedHost, err := computePlan(*file, *host, *prune)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	p.Render(os.Stdout)
+	if p.Empty() {
+		if err := recordKept(*lockPath, p); err != nil {
+			fmt.Printf("Error: could not update %s: %v\n", *lockPath, err)
+			return 1
+		}
+		return 0
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Ignoring config: %v", err)
+	}
+	bar, err := barOptions(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	notifiers, err := notifiersFromConfig(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	mail, err := reportMailFromConfig(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	client.Authorize = authorizer(cfg, false)
+	client.Retries = &client.RetryBudget{PerModel: *maxRetries, Total: *retryBudget}
+	learnTimeouts()
+	var pending, deletes []plan.Change
+	for _, c := range p.Changes {
+		switch c.Action {
+		case plan.ActionPull:
+			pending = append(pending, c)
+			if c.Entry.MaxRetries != nil {
+				client.Retries.SetLimit(c.Model, *c.Entry.MaxRetries)
+			}
+		case plan.ActionDelete:
+			deletes = append(deletes, c)
+		}
+	}
+	report := plan.Report{Manifest: *file, Host: resolvedHost, Prune: *prune, StartedAt: time.Now()}
+
+	var current atomic.Pointer[tea.Program]
+	// cancelled is closed by `cancel`, which stops apply also while no pull runs.
+	cancelled := make(chan struct{})
+	var cancelOnce sync.Once
+	ctl := listenControl(&current, func() { cancelOnce.Do(func() { close(cancelled) }) })
+	if ctl != nil {
+		defer ctl.Close()
+	}
+
+	opts := pullOptions{AutoRetry: true, Control: ctl, Stats: stats.NewMeter(), Bar: bar, Hosts: configuredHosts(cfg), Notifiers: notifiers}
+	opts.Tmux = newTmuxStatus(*tmuxStatus)
+	if *promptStatus {
+		opts.Prompt = prompt.New(prompt.Path())
+	}
+	powerCtx, stopPower := context.WithCancel(context.Background())
+	defer stopPower()
+	if opts.Hold, err = powerOpts.apply(powerCtx); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	statsCtx, stopStats := context.WithCancel(context.Background())
+	defer stopStats()
+	go opts.Stats.Run(statsCtx)
+	if *eventsSpec != "" {
+		// The plan and summary are on stdout, so the stream cannot share it.
+		if events.IsStdout(*eventsSpec) {
+			fmt.Println("Error: apply cannot stream events to stdout; use file:<path> or socket:<path>")
+			return 1
+		}
+		emitter, err := events.Open(*eventsSpec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		defer emitter.Close()
+		opts.Events = emitter
+	}
+	if *progressPath != "" {
+		opts.ProgressFile = progressfile.New(*progressPath)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go opts.ProgressFile.Run(ctx)
+	}
+
+	// lock records a model in the lockfile; a lockfile that cannot be written fails the
+	// apply but not the pulls.
+	lockFailed := false
+	lock := func(c plan.Change) {
+		if err := recordLock(*lockPath, c.Host, c.Model); err != nil {
+			log.Printf("Recording %s in %s: %v", c.Model, *lockPath, err)
+			lockFailed = true
+		}
+	}
+
+	ui.SaveWindowTitle(os.Stdout)
+	progressUI := newPullUI(&current)
+	quit := false
+	for len(pending) > 0 && !quit {
+		i, opens := plan.NextPull(pending, time.Now())
+		if i < 0 {
+			// The program would hold the terminal in raw mode and swallow ctrl+c for the
+			// hours the wait may take, so it is closed until the next pull.
+			progressUI.close()
+			fmt.Printf("Waiting until %s for a download window to open...\n", opens.Format("15:04"))
+			log.Printf("No download window open, waiting until %s", opens)
+			if !waitUntil(opens, cancelled) {
+				log.Println("Stopped while waiting for a download window.")
+				quit = true
+			}
+			continue
+		}
+		c := pending[i]
+		pending = append(pending[:i], pending[i+1:]...)
+
+		if ctl != nil {
+			ctl.Update(func(s *control.Status) {
+				s.Model, s.Phase, s.Completed, s.Total = c.Model, "", 0, 0
+			})
+		}
+		// Pulls retry on their own so the batch runs unattended; the retry budget
+		// moves on to the next model when one keeps failing.
+		start := time.Now()
+		if c.Digest != "" {
+			if err := checkRegistryPin(c.Model, c.Digest); err != nil {
+				log.Printf("Skipping %s: %v", c.Model, err)
+				report.Add(c, plan.OutcomeFailed, start, err)
+				continue
+			}
+		}
+		opts.LayerSizes = layerSizes(c.Model)
+		res := progressUI.pull(c.Model, c.Host, opts)
+		recordUsage(c.Model, c.Host, opts.Stats.Downloaded(), opts.Stats.Retries(), history.ReasonApply)
+		if res.Succeeded && c.Digest != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), client.RequestTimeout)
+			if err := client.CheckDigest(ctx, c.Host, c.Model, c.Digest); err != nil {
+				res.Succeeded, res.Err = false, err
+			}
+			cancel()
+		}
+		var result *plan.Result
+		switch {
+		case res.Succeeded && (*warmup || *testPrompt != ""):
+			checked, err := checkPulledModel(c, *warmup, *testPrompt)
+			if err != nil {
+				result = report.Add(c, plan.OutcomeFailed, start, err)
+			} else {
+				result = report.Add(c, plan.OutcomeDone, start, nil)
+			}
+			result.LoadTime, result.TestOutput = checked.LoadTime, checked.TestOutput
+		case res.Succeeded:
+			result = report.Add(c, plan.OutcomeDone, start, nil)
+		case res.Quit:
+			quit = true
+			result = report.Add(c, plan.OutcomeSkipped, start, nil)
+		default:
+			err := res.Err
+			if err == nil {
+				err = errors.New("pull did not complete")
+			}
+			result = report.Add(c, plan.OutcomeFailed, start, err)
+		}
+		if result.Outcome == plan.OutcomeDone {
+			lock(c)
+		}
+		result.Retries = client.Retries.Used(c.Model)
+		result.RetryCauses = opts.Stats.Retries()
+		result.Bytes = opts.Stats.Downloaded()
+		if slowest, ok := opts.Stats.SlowestLayer(); ok && res.Succeeded {
+			result.SlowestLayer, result.SlowestLayerSpeed = slowest.Digest, slowest.Speed()
+		}
+	}
+
+	progressUI.close()
+
+	// Pulls still pending were cut short by a quit.
+	for _, c := range pending {
+		report.Add(c, plan.OutcomeSkipped, time.Time{}, nil)
+	}
+	// With every deletion on this machine, the space pruning frees can be measured.
+	store, local := localStore(resolvedHost)
+	for _, c := range deletes {
+		local = local && client.IsLocal(c.Host)
+	}
+	freeBefore := int64(-1)
+	if local && len(deletes) > 0 && !quit {
+		freeBefore = freeSpace(store)
+	}
+	for _, c := range deletes {
+		if quit {
+			report.Add(c, plan.OutcomeSkipped, time.Time{}, nil)
+			continue
+		}
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), client.RequestTimeout)
+		err := client.DeleteModel(ctx, c.Host, c.Model)
+		cancel()
+		if err != nil {
+			log.Printf("Deleting %s from %s: %v", c.Model, c.Host, err)
+			report.Add(c, plan.OutcomeFailed, start, err)
+		} else {
+			report.Add(c, plan.OutcomeDone, start, nil)
+			if err := unlock(*lockPath, c.Model); err != nil {
+				log.Printf("Removing %s from %s: %v", c.Model, *lockPath, err)
+				lockFailed = true
+			}
+		}
+	}
+	var freed string
+	if freeBefore >= 0 {
+		if after := freeSpace(store); after >= 0 {
+			freed = fmt.Sprintf("Pruning freed %s; %s free in %s.", ui.FormatBytes(max(after-freeBefore, 0)), ui.FormatBytes(after), store.Dir)
+		}
+	}
+	if err := recordKept(*lockPath, p); err != nil {
+		log.Printf("Updating %s: %v", *lockPath, err)
+		lockFailed = true
+	}
+	ui.RestoreWindowTitle(os.Stdout)
+	report.FinishedAt = time.Now()
+	if !quit {
+		bell.ring(os.Stdout)
+	}
+
+	fmt.Println()
+	for _, res := range report.Results {
+		line := fmt.Sprintf("%-8s %-6s %s", res.Outcome, res.Action, res.Model)
+		if causes := stats.RetryCounts(res.RetryCauses); causes.Total() > 0 {
+			line += fmt.Sprintf(" (%d retries: %s)", causes.Total(), causes)
+		} else if res.Retries > 0 {
+			line += fmt.Sprintf(" (%d retries)", res.Retries)
+		}
+		if res.LoadTime > 0 {
+			line += fmt.Sprintf(" (loaded in %.1fs)", res.LoadTime)
+		}
+		if res.SlowestLayer != "" {
+			line += fmt.Sprintf(" (slowest layer %s at %s)", res.SlowestLayer, ui.FormatSpeed(res.SlowestLayerSpeed))
+		}
+		if res.TestOutput != "" {
+			line += "\n" + quoteOutput(res.TestOutput)
+		}
+		if res.Error != "" {
+			line += ": " + res.Error
+		}
+		fmt.Println(line)
+	}
+	if freed != "" {
+		fmt.Println(freed)
+	}
+	mail.send(report, quit)
+	if err := report.Write(*reportPath); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	fmt.Printf("Report written to %s\n", *reportPath)
+	if lockFailed {
+		fmt.Printf("Error: could not update %s, see the log\n", *lockPath)
+		return 1
+	}
+	fmt.Printf("Lockfile updated: %s\n", *lockPath)
+	if report.Failed() > 0 || quit {
+		return 1
+	}
+	return 0
+}
+
+// waitUntil waits until t and reports whether it got there, or false if the user stopped
+// the wait with ctrl+c or `cancel`, which closes cancelled.
+func waitUntil(t time.Time, cancelled <-chan struct{}) bool {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-cancelled:
+		return false
+	}
+}
+
+// bundleProgress prints the progress of an export or import on one line, at most a few
+// times a second.
+func bundleProgress(verb string) bundle.Progress {
+	var last time.Time
+	return func(done, total int64) {
+		if done < total && time.Since(last) < 200*time.Millisecond {
+			return
+		}
+		last = time.Now()
+		percent := 100.0
+		if total > 0 {
+			percent = float64(done) / float64(total) * 100
+		}
+		fmt.Printf("\r%s: %3.0f%% (%s / %s)", verb, percent, ui.FormatBytes(done), ui.FormatBytes(total))
+		if done >= total {
+			fmt.Println()
+		}
+	}
+}
+
+// runExportCommand downloads a model from the registry into a bundle file for machines
+// without internet access.
+func runExportCommand(args []string) int {
+	fs := newFlagSet("export")
+	output := fs.String("o", "", "Bundle file to write (default <model>.tar)")
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Parse(args)
+		fs.Usage()
+		return 1
+	}
+	model := args[0]
+	fs.Parse(args[1:])
+	name := registry.ParseName(model)
+	if *output == "" {
+		*output = name.Model + "-" + name.Tag + ".tar"
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	err = bundle.Export(context.Background(), registry.New(), name, f, bundleProgress("Exporting "+name.String()))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		log.Printf("Export of %s failed: %v", model, err)
+		fmt.Println("\nError:", err)
+		return 1
+	}
+	log.Printf("Exported %s to %s", model, *output)
+	fmt.Printf("Wrote %s. Load it with: import-bundle %s\n", *output, *output)
+	return 0
+}
+
+// runImportBundleCommand loads a bundle written by export into an Ollama host.
+func runImportBundleCommand(args []string) int {
+	fs := newFlagSet("import-bundle")
+	host := fs.String("host", "", "Ollama API host. Overrides OLLAMA_HOST.")
+	as := fs.String("name", "", "Create the model under this name instead of the bundled one")
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Parse(args)
+		fs.Usage()
+		return 1
+	}
+	path := args[0]
+	fs.Parse(args[1:])
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	defer f.Close()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Ignoring config: %v", err)
+	}
+	client.Authorize = authorizer(cfg, false)
+
+	model, err := bundle.Import(context.Background(), f, resolveHost(*host), *as, bundleProgress("Importing "+path))
+	if err != nil {
+		log.Printf("Import of %s failed: %v", path, err)
+		fmt.Println("\nError:", err)
+		return 1
+	}
+	log.Printf("Imported %s from %s", model, path)
+	fmt.Printf("Created %s.\n", model)
+	return 0
+}
+
+// runTransferCommand copies a model from another machine's Ollama store, read over ssh,
+// to an Ollama host, so large models cross the LAN instead of the internet twice.
+func runTransferCommand(args []string) int {
+	fs := newFlagSet("transfer")
+	from := fs.String("from", "", "ssh destination of the machine that has the model, e.g. user@gpu-box")
+	to := fs.String("to", "", "Ollama API host to copy the model to. Defaults to OLLAMA_HOST.")
+	dir := fs.String("models-dir", bundle.DefaultModelsDir, "Ollama's models directory on the source machine (relative to the home directory unless absolute)")
+	fs.Parse(args)
+	if *from == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	name := registry.ParseName(fs.Arg(0))
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Ignoring config: %v", err)
+	}
+	client.Authorize = authorizer(cfg, false)
+
+	src := bundle.SSHSource{Dest: *from, Dir: *dir}
+	host := resolveHost(*to)
+	if err := bundle.Transfer(context.Background(), src, name, host, bundleProgress("Copying "+name.String())); err != nil {
+		log.Printf("Transfer of %s from %s failed: %v", name, *from, err)
+		fmt.Println("\nError:", err)
+		return 1
+	}
+	log.Printf("Transferred %s from %s to %s", name, *from, host)
+	fmt.Printf("Created %s on %s.\n", name, host)
+	return 0
+}
@@ -0,0 +1,213 @@
+// Package config loads and saves the user's ollama-downloader settings file.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PathEnv overrides the config file location.
+const PathEnv = "OLLAMA_DOWNLOADER_CONFIG"
+
+// Config is the content of the settings file.
+type Config struct {
+	// Aliases maps short names to full model references, e.g. "l3" -> "llama3.1:8b-instruct-q4_K_M".
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// MemoryLimit is the RAM/VRAM available to run models on the host, e.g. "24GB". Pulls
+	// of models estimated to need more ask for confirmation first.
+	MemoryLimit string `json:"memory_limit,omitempty"`
+	// OIDC sets up `login` for an Ollama host behind an identity-aware proxy.
+	OIDC *OIDC `json:"oidc,omitempty"`
+	// ProgressBar sets how the download progress is drawn; the --bar flags override it.
+	ProgressBar *ProgressBar `json:"progress_bar,omitempty"`
+	// Hosts are the Ollama hosts `pull --placement` picks from, e.g. the GPU boxes of a
+	// small cluster.
+	Hosts []string `json:"hosts,omitempty"`
+	// MonthlyCap is the data allowance per calendar month, e.g. "200GB". Pulls that would
+	// go over it ask for confirmation first.
+	MonthlyCap string `json:"monthly_cap,omitempty"`
+	// DataCost prices downloads on a metered connection, e.g. a mobile hotspot.
+	DataCost *DataCost `json:"data_cost,omitempty"`
+	// Notify lists the chat services and webhooks told when a pull succeeds or fails.
+	Notify []Notifier `json:"notify,omitempty"`
+	// Email sets up a summary by mail after every `apply`.
+	Email *Email `json:"email,omitempty"`
+}
+
+// Email is the mail server and addresses the summary of an apply is sent with.
+type Email struct {
+	// SMTP is the mail server's host and port, e.g. "smtp.example.com:587". Port 465
+	// uses implicit TLS; on other ports STARTTLS is used when the server offers it.
+	SMTP     string   `json:"smtp"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// On is "failure" to only send the summary of applies in which a change failed.
+	On string `json:"on,omitempty"`
+}
+
+// Notifier is a chat service to send a message to when a pull ends.
+type Notifier struct {
+	// Type is pushover, telegram, slack or webhook.
+	Type string `json:"type"`
+	// Token is the Pushover application token, Telegram bot token or Slack bot token,
+	// or the bearer token of a webhook, which may go without.
+	Token string `json:"token"`
+	// To is the Pushover user or group key, Telegram chat ID, Slack channel or webhook URL.
+	To string `json:"to"`
+	// On limits the messages to pulls that end in "success" or "failure"; empty sends both.
+	On string `json:"on,omitempty"`
+	// SuccessTemplate and FailureTemplate are Go templates of the messages, executed with
+	// a notify.Event. Empty uses the defaults.
+	SuccessTemplate string `json:"success_template,omitempty"`
+	FailureTemplate string `json:"failure_template,omitempty"`
+	// ContentType is the type of a webhook's body, application/json by default.
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// DataCost is what the connection charges for downloads.
+type DataCost struct {
+	// PerGB is the price of one GB (1024³ bytes, as sizes are shown).
+	PerGB float64 `json:"per_gb"`
+	// Currency is printed before amounts, e.g. "$" or "€".
+	Currency string `json:"currency,omitempty"`
+}
+
+// ProgressBar is the look of the download progress bar.
+type ProgressBar struct {
+	// Style is gradient (the default), solid, ascii, braille or percent.
+	Style string `json:"style,omitempty"`
+	// Width caps the bar's width in columns.
+	Width int `json:"width,omitempty"`
+	// HidePercentage drops the percentage after the bar.
+	HidePercentage bool `json:"hide_percentage,omitempty"`
+}
+
+// OIDC configures the identity provider `login` authenticates against.
+type OIDC struct {
+	Issuer   string   `json:"issuer"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes,omitempty"`
+	// Header carries the token, e.g. "cf-access-token" for Cloudflare Access. The
+	// default is a bearer token in Authorization.
+	Header string `json:"header,omitempty"`
+}
+
+// Path returns the config file location: $OLLAMA_DOWNLOADER_CONFIG, or
+// ollama-downloader/config.json in the user config directory.
+func Path() (string, error) {
+	if p := os.Getenv(PathEnv); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("locating config directory: %w", err)
+	}
+	return filepath.Join(dir, "ollama-downloader", "config.json"), nil
+}
+
+// TokenPath returns where the `login` token is cached: token.json next to the config file.
+func TokenPath() (string, error) {
+	path, err := Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "token.json"), nil
+}
+
+// Load reads the config at path. A missing file yields an empty config.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the config to path, replacing it atomically.
+func (c *Config) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+// ResolveAlias returns the model an alias points to, or name itself if it is not an alias.
+func (c *Config) ResolveAlias(name string) string {
+	if model, ok := c.Aliases[name]; ok {
+		return model
+	}
+	return name
+}
+
+// SetAlias adds or replaces an alias.
+func (c *Config) SetAlias(name, model string) {
+	if c.Aliases == nil {
+		c.Aliases = make(map[string]string)
+	}
+	c.Aliases[name] = model
+}
+
+// RemoveAlias deletes an alias and reports whether it existed.
+func (c *Config) RemoveAlias(name string) bool {
+	if _, ok := c.Aliases[name]; !ok {
+		return false
+	}
+	delete(c.Aliases, name)
+	return true
+}
+
+// AliasNames returns the alias names in sorted order.
+func (c *Config) AliasNames() []string {
+	names := make([]string, 0, len(c.Aliases))
+	for name := range c.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSize parses a byte size such as "512MB", "24GB", "24G" or "1.5TiB". Units are
+// powers of 1024, matching how sizes are displayed.
+func ParseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	scale := 1.0
+	if str != "" {
+		if i := strings.IndexByte("KMGT", str[len(str)-1]); i >= 0 {
+			scale = math.Pow(1024, float64(i+1))
+			str = str[:len(str)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * scale), nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad_MissingFile(t *testing.T) {
+	c, err := Load(filepath.Join(t.TempDir(), "config.json"))
+	assert.NoError(t, err)
+	assert.Empty(t, c.Aliases)
+	assert.Equal(t, "llama3", c.ResolveAlias("llama3"), "Unknown names should resolve to themselves")
+}
+
+func TestAliases_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "config.json")
+	c := &Config{}
+	c.SetAlias("l3", "llama3.1:8b-instruct-q4_K_M")
+	c.SetAlias("g", "gemma:2b")
+	assert.NoError(t, c.Save(path))
+
+	loaded, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "llama3.1:8b-instruct-q4_K_M", loaded.ResolveAlias("l3"))
+	assert.Equal(t, []string{"g", "l3"}, loaded.AliasNames())
+
+	assert.True(t, loaded.RemoveAlias("g"))
+	assert.False(t, loaded.RemoveAlias("g"), "Removing twice should report a missing alias")
+	assert.Equal(t, "g", loaded.ResolveAlias("g"))
+}
+
+func TestPath_EnvOverride(t *testing.T) {
+	t.Setenv(PathEnv, "/tmp/custom.json")
+	p, err := Path()
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/custom.json", p)
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"512":    512,
+		"24GB":   24 << 30,
+		"24g":    24 << 30,
+		"1.5TiB": 3 << 39,
+		"16 MB":  16 << 20,
+	} {
+		got, err := ParseSize(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseSize("lots")
+	assert.Error(t, err)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/stats"
+)
+
+// Environment variables read by the container mode.
+const (
+	envModels     = "OLLAMA_DL_MODELS"
+	envHost       = "OLLAMA_DL_HOST"
+	envHealthAddr = "OLLAMA_DL_HEALTH_ADDR"
+	envMaxRetries = "OLLAMA_DL_MAX_RETRIES"
+	envWait       = "OLLAMA_DL_WAIT"
+	envStay       = "OLLAMA_DL_STAY"
+)
+
+// headlessLogInterval is how often a headless pull logs progress within one phase.
+const headlessLogInterval = 5 * time.Second
+
+// runContainerCommand pulls the models listed in OLLAMA_DL_MODELS without a terminal,
+// logging JSON to stdout, for use as an init container. It waits for the Ollama host to
+// come up, and serves /healthz and /readyz on OLLAMA_DL_HEALTH_ADDR if set.
+func runContainerCommand() int {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// Route the client's log.Printf output through the JSON logger as well.
+	slog.SetDefault(logger)
+
+	cfg, err := loadContainerConfig(os.Getenv)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		return 1
+	}
+	if len(cfg.Models) == 0 {
+		logger.Error("no models to pull", "env", envModels)
+		return 1
+	}
+	models, host := cfg.Models, cfg.Host
+	client.Retries = &client.RetryBudget{PerModel: cfg.MaxRetries}
+
+	var ready atomic.Bool
+	if cfg.HealthAddr != "" {
+		go serveHealth(cfg.HealthAddr, &ready, logger)
+	}
+
+	ctx := context.Background()
+	if err := waitForHost(ctx, host, cfg.Wait); err != nil {
+		logger.Error("ollama host not reachable", "host", host, "error", err)
+		return 1
+	}
+
+	meter := stats.NewMeter()
+	statsCtx, stopStats := context.WithCancel(ctx)
+	defer stopStats()
+	go meter.Run(statsCtx)
+
+	failed := 0
+	for _, model := range models {
+		start := time.Now()
+		logger.Info("pull started", "model", model, "host", host)
+		meter.Start(0, nil)
+		if err := pullWithRetries(ctx, client.PullOptions{Model: model, Host: host}, cfg.MaxRetries, headlessHooks{Progress: logProgress(logger, model, meter), Retried: meter.Retried}); err != nil {
+			failed++
+			logger.Error("pull failed", "model", model, "error", err, "retries", meter.Retries().Strings())
+			continue
+		}
+		logger.Info("pull finished", "model", model, "seconds", time.Since(start).Seconds(), "retries", meter.Retries().Strings())
+	}
+	if failed > 0 {
+		logger.Error("provisioning incomplete", "failed", failed, "models", len(models))
+		return 1
+	}
+	ready.Store(true)
+	logger.Info("provisioning complete", "models", len(models))
+
+	if cfg.Stay {
+		select {} // Keep serving the health endpoints, e.g. as a sidecar.
+	}
+	return 0
+}
+
+// containerConfig is the configuration of the container mode, read from the environment.
+type containerConfig struct {
+	// Models are the models to pull, separated by commas, spaces or newlines.
+	Models     []string
+	Host       string
+	HealthAddr string
+	// MaxRetries is the retry budget of every model, 5 by default.
+	MaxRetries int
+	// Wait is how long to wait for the host to come up, 2 minutes by default.
+	Wait time.Duration
+	// Stay keeps the health endpoints up after the pulls, e.g. as a sidecar.
+	Stay bool
+}
+
+// loadContainerConfig reads the configuration with getenv, e.g. os.Getenv, and fills in
+// the defaults of unset variables. The host falls back like --host does.
+func loadContainerConfig(getenv func(string) string) (containerConfig, error) {
+	cfg := containerConfig{
+		Models: strings.FieldsFunc(getenv(envModels), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\n'
+		}),
+		Host:       resolveHost(getenv(envHost)),
+		HealthAddr: getenv(envHealthAddr),
+	}
+	var err error
+	if cfg.MaxRetries, err = envInt(getenv, envMaxRetries, 5); err != nil {
+		return containerConfig{}, err
+	}
+	if cfg.Wait, err = envDuration(getenv, envWait, 2*time.Minute); err != nil {
+		return containerConfig{}, err
+	}
+	cfg.Stay, _ = strconv.ParseBool(getenv(envStay))
+	return cfg, nil
+}
+
+// serveHealth answers liveness on /healthz and readiness on /readyz, which turns
+// successful once every model is pulled.
+func serveHealth(addr string, ready *atomic.Bool, logger *slog.Logger) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "pulling", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	logger.Info("health endpoints listening", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Error("health endpoints stopped", "error", err)
+	}
+}
+
+// waitForHost polls the Ollama host until it answers, with any status, or wait has passed.
+func waitForHost(ctx context.Context, host string, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		_, err := client.ListModels(reqCtx, host)
+		cancel()
+		var statusErr *client.APIStatusError
+		if err == nil || errors.As(err, &statusErr) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		slog.Info("waiting for ollama host", "host", host, "error", err.Error())
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// logProgress returns a progress callback that logs every phase change of model, and
+// within a phase at most every headlessLogInterval, with the speed and ETA from meter.
+func logProgress(logger *slog.Logger, model string, meter *stats.Meter) func(client.ProgressMsg) {
+	var phase string
+	var lastLog time.Time
+	return func(msg client.ProgressMsg) {
+		meter.Update(msg)
+		if msg.Status == phase && time.Since(lastLog) < headlessLogInterval {
+			return
+		}
+		phase, lastLog = msg.Status, time.Now()
+		attrs := []any{"model", model, "phase", msg.Status}
+		if msg.Total > 0 {
+			attrs = append(attrs, "completed", msg.Completed, "total", msg.Total,
+				"percent", float64(msg.Completed)/float64(msg.Total)*100)
+		}
+		if snap := meter.Snapshot(); snap.Speed > 0 {
+			attrs = append(attrs, "speed", snap.Speed)
+			if snap.ETAKnown {
+				attrs = append(attrs, "eta_seconds", snap.ETA.Seconds())
+			}
+		}
+		logger.Info("progress", attrs...)
+	}
+}
+
+func envInt(getenv func(string) string, name string, def int) (int, error) {
+	v := getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return n, nil
+}
+
+func envDuration(getenv func(string) string, name string, def time.Duration) (time.Duration, error) {
+	v := getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
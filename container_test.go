@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadContainerConfig(t *testing.T) {
+	t.Setenv("OLLAMA_HOST", "")
+	defaults := containerConfig{Models: []string{}, Host: "http://localhost:11434", MaxRetries: 5, Wait: 2 * time.Minute}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    containerConfig
+		wantErr string
+	}{
+		{name: "defaults", want: defaults},
+		{
+			name: "everything set",
+			env: map[string]string{
+				envModels: "llama3, qwen2:7b\nnomic-embed-text", envHost: "gpu-box", envHealthAddr: ":8080",
+				envMaxRetries: "2", envWait: "30s", envStay: "true",
+			},
+			want: containerConfig{
+				Models: []string{"llama3", "qwen2:7b", "nomic-embed-text"}, Host: "http://gpu-box:11434", HealthAddr: ":8080",
+				MaxRetries: 2, Wait: 30 * time.Second, Stay: true,
+			},
+		},
+		{name: "separators only", env: map[string]string{envModels: " ,\n, "}, want: defaults},
+		{name: "stay not a bool", env: map[string]string{envStay: "please"}, want: defaults},
+		{name: "bad retries", env: map[string]string{envMaxRetries: "many"}, wantErr: envMaxRetries},
+		{name: "bad wait", env: map[string]string{envWait: "10"}, wantErr: envWait},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadContainerConfig(func(name string) string { return tt.env[name] })
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cfg)
+		})
+	}
+}
+
+func TestWaitForHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	assert.NoError(t, waitForHost(context.Background(), server.URL, 0), "Any answer means the host is up")
+
+	server.Close()
+	assert.Error(t, waitForHost(context.Background(), server.URL, 0), "A host that does not answer within the wait is not up")
+}
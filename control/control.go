@@ -0,0 +1,211 @@
+// Package control exposes a running downloader over a local socket, so other shells can
+// inspect or cancel an in-flight pull with `ollama-downloader-v2 status` and `cancel`.
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Commands understood by the control socket.
+const (
+	CommandStatus = "status"
+	CommandCancel = "cancel"
+)
+
+// ErrAlreadyRunning is returned by Listen when another instance owns the socket.
+var ErrAlreadyRunning = errors.New("another ollama-downloader instance is already running")
+
+// ErrNotRunning is returned by Query when no instance is listening.
+var ErrNotRunning = errors.New("no running ollama-downloader instance found")
+
+// Status is the snapshot of a running pull reported to `status`.
+type Status struct {
+	PID       int       `json:"pid"`
+	Model     string    `json:"model"`
+	Host      string    `json:"host"`
+	Phase     string    `json:"phase"`
+	Completed int64     `json:"completed"`
+	Total     int64     `json:"total"`
+	StartedAt time.Time `json:"started_at"`
+	// Speed is in bytes per second; it and ETASeconds are 0 while unknown.
+	Speed      float64 `json:"speed,omitempty"`
+	ETASeconds float64 `json:"eta_seconds,omitempty"`
+}
+
+// Percent returns the download progress in the range 0-100.
+func (s Status) Percent() float64 {
+	if s.Total <= 0 {
+		return 0
+	}
+	return float64(s.Completed) / float64(s.Total) * 100
+}
+
+type request struct {
+	Command string `json:"command"`
+}
+
+// Response is the reply to a control command.
+type Response struct {
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Status Status `json:"status"`
+}
+
+// DefaultSocketPath is the per-user socket location: in $XDG_RUNTIME_DIR if it is set, and
+// otherwise in a directory of the user's own in the system temp directory, which Listen
+// creates. Unix domain sockets are also available on Windows 10 and later, so the same
+// mechanism works there.
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "ollama-downloader.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("ollama-downloader-%d", os.Getuid()), "control.sock")
+}
+
+// privateDir creates dir if it is missing and checks that only the current user can use
+// it, so no one else can put a socket in its place or connect to ours.
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if err := checkPrivate(info); err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	return nil
+}
+
+// Server answers control commands for the running instance.
+type Server struct {
+	path     string
+	ln       net.Listener
+	onCancel func()
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Listen creates the control socket at path. onCancel runs when a `cancel` command arrives.
+// A stale socket left behind by a crashed instance is removed. The socket's directory must
+// be private to the user, and the socket itself is only accessible to them.
+func Listen(path string, onCancel func()) (*Server, error) {
+	if err := privateDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("control socket directory: %w", err)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, ErrAlreadyRunning
+	}
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("creating control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("creating control socket: %w", err)
+	}
+	s := &Server{
+		path:     path,
+		ln:       ln,
+		onCancel: onCancel,
+		status:   Status{PID: os.Getpid(), StartedAt: time.Now()},
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Update changes the reported status under the server's lock.
+func (s *Server) Update(fn func(*Status)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status)
+}
+
+// Close stops listening and removes the socket file.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return // Listener closed.
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var req request
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(Response{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	s.mu.Lock()
+	res := Response{OK: true, Status: s.status}
+	s.mu.Unlock()
+
+	switch req.Command {
+	case CommandStatus:
+	case CommandCancel:
+		if s.onCancel != nil {
+			s.onCancel()
+		}
+	default:
+		res = Response{Error: fmt.Sprintf("unknown command %q", req.Command)}
+	}
+	json.NewEncoder(conn).Encode(res)
+}
+
+// Query sends command to the instance listening on path and returns its response.
+// The socket's directory must be private to the user, as for Listen.
+func Query(path string, command string) (Response, error) {
+	info, err := os.Lstat(filepath.Dir(path))
+	if err != nil || !info.IsDir() {
+		return Response{}, ErrNotRunning
+	}
+	if err := checkPrivate(info); err != nil {
+		return Response{}, fmt.Errorf("control socket directory %s: %w", filepath.Dir(path), err)
+	}
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return Response{}, ErrNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(request{Command: command}); err != nil {
+		return Response{}, fmt.Errorf("sending command: %w", err)
+	}
+	var res Response
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return Response{}, fmt.Errorf("reading response: %w", err)
+	}
+	if !res.OK {
+		return res, errors.New(res.Error)
+	}
+	return res, nil
+}
@@ -0,0 +1,96 @@
+package control
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// socketPath returns a socket path in a directory Listen creates for the user only.
+func socketPath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "run", "ctl.sock")
+}
+
+func TestServer_StatusAndCancel(t *testing.T) {
+	path := socketPath(t)
+	cancelled := make(chan struct{}, 1)
+	srv, err := Listen(path, func() { cancelled <- struct{}{} })
+	assert.NoError(t, err)
+	defer srv.Close()
+
+	srv.Update(func(s *Status) {
+		s.Model = "llama3"
+		s.Phase = "pulling abc"
+		s.Completed = 25
+		s.Total = 100
+	})
+
+	res, err := Query(path, CommandStatus)
+	assert.NoError(t, err)
+	assert.Equal(t, "llama3", res.Status.Model)
+	assert.Equal(t, "pulling abc", res.Status.Phase)
+	assert.InDelta(t, 25.0, res.Status.Percent(), 0.001)
+	assert.Empty(t, cancelled, "Status should not cancel the pull")
+
+	_, err = Query(path, CommandCancel)
+	assert.NoError(t, err)
+	assert.Len(t, cancelled, 1, "Cancel should invoke the cancel callback")
+
+	_, err = Query(path, "explode")
+	assert.ErrorContains(t, err, "unknown command")
+}
+
+func TestListen_AlreadyRunningAndStale(t *testing.T) {
+	path := socketPath(t)
+	srv, err := Listen(path, nil)
+	assert.NoError(t, err)
+
+	_, err = Listen(path, nil)
+	assert.ErrorIs(t, err, ErrAlreadyRunning)
+
+	// Simulate a crash that leaves the socket file behind.
+	srv.ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	srv.ln.Close()
+	srv, err = Listen(path, nil)
+	assert.NoError(t, err, "A stale socket should be replaced")
+	srv.Close()
+
+	_, err = Query(path, CommandStatus)
+	assert.ErrorIs(t, err, ErrNotRunning)
+}
+
+func TestDefaultSocketPath(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	assert.Equal(t, "/run/user/1000/ollama-downloader.sock", DefaultSocketPath())
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("TMPDIR", "/tmp")
+	assert.Equal(t, fmt.Sprintf("/tmp/ollama-downloader-%d/control.sock", os.Getuid()), DefaultSocketPath())
+}
+
+func TestListen_PrivateDirectory(t *testing.T) {
+	path := socketPath(t)
+	dir := filepath.Dir(path)
+	srv, err := Listen(path, nil)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm(), "The missing directory is created for the user only")
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	shared := t.TempDir()
+	require.NoError(t, os.Chmod(shared, 0777))
+	_, err = Listen(filepath.Join(shared, "ctl.sock"), nil)
+	assert.ErrorContains(t, err, "accessible to other users")
+	_, err = Query(filepath.Join(shared, "ctl.sock"), CommandStatus)
+	assert.ErrorContains(t, err, "accessible to other users")
+}
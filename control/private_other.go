@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package control
+
+import "os"
+
+// checkPrivate accepts any directory: outside Unix, file modes do not describe who may use
+// it, and the per-user temp directory is already private.
+func checkPrivate(os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build linux || darwin || freebsd
+
+package control
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func checkPrivate(info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("cannot read the owner")
+	}
+	if int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("owned by uid %d, not by the current user", st.Uid)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("accessible to other users (mode %v)", info.Mode().Perm())
+	}
+	return nil
+}
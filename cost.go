@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"ollama-downloader-v2/config"
+	"ollama-downloader-v2/stats"
+	"ollama-downloader-v2/ui"
+)
+
+// dataCost returns the price of downloads: the config's, with the price per GB replaced
+// by perGB if it is set. A zero PerGB means no cost is shown.
+func dataCost(perGB float64, cfg *config.Config) config.DataCost {
+	var cost config.DataCost
+	if cfg != nil && cfg.DataCost != nil {
+		cost = *cfg.DataCost
+	}
+	if perGB > 0 {
+		cost.PerGB = perGB
+	}
+	return cost
+}
+
+// printDownloadCost prints the size of a download with the given layer sizes and what
+// it will cost. A resumed pull costs less, as the layers on disk are not downloaded again.
+func printDownloadCost(w io.Writer, model string, sizes map[string]int64, cost config.DataCost) {
+	total := downloadSize(sizes)
+	if cost.PerGB <= 0 || total == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Download size of %s: %s, ~%s at %s/GB.\n", model, ui.FormatBytes(total),
+		ui.FormatCost(total, cost.PerGB, cost.Currency), ui.FormatCost(1<<30, cost.PerGB, cost.Currency))
+}
+
+// reportDataCost prints how much meter saw downloaded and what it cost.
+func reportDataCost(w io.Writer, meter *stats.Meter, cost config.DataCost) {
+	downloaded := meter.Downloaded()
+	if cost.PerGB <= 0 || downloaded == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Downloaded %s, ~%s at %s/GB.\n", ui.FormatBytes(downloaded),
+		ui.FormatCost(downloaded, cost.PerGB, cost.Currency), ui.FormatCost(1<<30, cost.PerGB, cost.Currency))
+}
+
+// downloadSize returns the size of a pull with the given layer sizes, 0 if unknown.
+func downloadSize(sizes map[string]int64) int64 {
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	return total
+}
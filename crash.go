@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ollama-downloader-v2/ui"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// exitCrashed is the exit status after a panic.
+const exitCrashed = 2
+
+// releaseTimeout bounds waiting for the progress UI to give the terminal back after a
+// crash.
+const releaseTimeout = 2 * time.Second
+
+var (
+	// uiProgram is the progress UI while it runs; a crash has to restore its terminal.
+	uiProgram atomic.Pointer[tea.Program]
+	// logOutput is the log file, synced before a crash exits.
+	logOutput *os.File
+	crashOnce sync.Once
+)
+
+// recoverCrash handles a panic of the calling goroutine with crash. It must be deferred
+// directly: defer recoverCrash("…").
+func recoverCrash(where string) {
+	if r := recover(); r != nil {
+		crash(where, r, debug.Stack())
+	}
+}
+
+// crash ends the program after a panic in where: it gives the terminal back from the
+// progress UI, logs the stack, writes a debug dump and prints what happened instead of
+// a bare stack trace over a terminal left in raw mode. Only the first crash is
+// reported; panics in other goroutines meanwhile wait for the exit.
+func crash(where string, r any, stack []byte) {
+	crashOnce.Do(func() {
+		if p := uiProgram.Load(); p != nil {
+			p.Kill()
+			done := make(chan struct{})
+			go func() {
+				p.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(releaseTimeout):
+			}
+		}
+		ui.RestoreWindowTitle(os.Stdout)
+
+		log.Printf("Panic in %s: %v\n%s", where, r, stack)
+		if logOutput != nil {
+			logOutput.Sync()
+		}
+		fmt.Fprintf(os.Stderr, "\nollama-downloader crashed in %s: %v\n\n", where, r)
+		fmt.Fprintln(os.Stderr, "This is a bug. Interrupted downloads resume where they stopped when you run the same pull again.")
+		writeDump(fmt.Sprintf("panic in %s: %v\n\n%s", where, r, stack))
+		fmt.Fprintln(os.Stderr, "Please open an issue with the debug dump; the full stack trace is in it and in the log.")
+		os.Exit(exitCrashed)
+	})
+	select {} // Another goroutine is reporting its crash and exiting.
+}
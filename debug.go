@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"ollama-downloader-v2/config"
+	"ollama-downloader-v2/debugdump"
+)
+
+// dumps collects what goes into a debug dump over the whole run: the log, the API
+// responses of pulls and the messages to the progress UI.
+var dumps = debugdump.New()
+
+// writeDump writes a debug dump headed by reason to the current directory and tells the
+// user where it is.
+func writeDump(reason string) {
+	var cfg any
+	var secrets []string
+	if c, err := loadConfig(); err != nil {
+		cfg = "unreadable: " + err.Error()
+	} else {
+		cfg, secrets = redactConfig(c)
+	}
+	path, err := dumps.Write(".", reason, cfg, secrets...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Debug dump written to %s; please attach it to your bug report.\n", path)
+}
+
+// redactConfig returns a copy of c for a debug dump, with the notifiers' and mail server's
+// credentials redacted, and the values it redacted. A notifier's To is the recipient or,
+// for a webhook, a URL that often holds the secret, so it is redacted too; only a webhook's
+// host is kept.
+func redactConfig(c *config.Config) (*config.Config, []string) {
+	redacted := *c
+	var secrets []string
+	redacted.Notify = append([]config.Notifier(nil), c.Notify...)
+	for i, n := range redacted.Notify {
+		secrets = append(secrets, n.Token, n.To)
+		if n.Token != "" {
+			n.Token = "[REDACTED]"
+		}
+		if n.Type == "webhook" {
+			n.To = debugdump.RedactURL(n.To)
+		} else if n.To != "" {
+			n.To = "[REDACTED]"
+		}
+		redacted.Notify[i] = n
+	}
+	if c.Email != nil {
+		email := *c.Email
+		secrets = append(secrets, email.Username, email.Password)
+		if email.Username != "" {
+			email.Username = "[REDACTED]"
+		}
+		if email.Password != "" {
+			email.Password = "[REDACTED]"
+		}
+		redacted.Email = &email
+	}
+	return &redacted, secrets
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ollama-downloader-v2/config"
+)
+
+func TestWriteDump_RedactsNotifiers(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	configPath := filepath.Join(dir, "config.json")
+	t.Setenv(config.PathEnv, configPath)
+	const (
+		webhook     = "https://hooks.slack.com/services/T0001/B0001/XXXXsecretXXXX"
+		pushoverKey = "uQiRzpo4DXghDmr9QzzfQu27cmVRsG"
+		appToken    = "azGDORePK8gMaC0QOYAMyEEuzJnyUi"
+	)
+	cfg := &config.Config{
+		Hosts: []string{"http://gpu:11434"},
+		Notify: []config.Notifier{
+			{Type: "webhook", To: webhook, On: "failure"},
+			{Type: "pushover", Token: appToken, To: pushoverKey},
+		},
+		Email: &config.Email{SMTP: "smtp.example.com:587", Username: "bot", Password: "hunter2", From: "bot@example.com", To: []string{"me@example.com"}},
+	}
+	require.NoError(t, cfg.Save(configPath))
+	dumps.Log.Add(`Notifying: Post "` + webhook + `": dial tcp: no such host`)
+
+	writeDump("requested with --debug-dump")
+
+	matches, err := filepath.Glob(filepath.Join(dir, "ollama-downloader-dump-*.txt"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	data, err := os.ReadFile(matches[0])
+	require.NoError(t, err)
+	dump := string(data)
+	for _, secret := range []string{"XXXXsecretXXXX", "services/T0001", pushoverKey, appToken, "hunter2"} {
+		assert.NotContains(t, dump, secret)
+	}
+	assert.Contains(t, dump, `"to": "https://hooks.slack.com/[REDACTED]"`, "The webhook's host is kept")
+	assert.Contains(t, dump, `"on": "failure"`)
+	assert.Contains(t, dump, "http://gpu:11434")
+	assert.Contains(t, dump, `Notifying: Post "[REDACTED]": dial tcp: no such host`)
+	assert.Equal(t, webhook, cfg.Notify[0].To, "The config itself is left alone")
+}
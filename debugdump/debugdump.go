@@ -0,0 +1,183 @@
+// Package debugdump collects what the downloader was doing, recent log lines, API
+// responses and UI messages, and writes it with the config to a file that can be attached
+// to a bug report. Secrets are redacted before anything is written.
+package debugdump
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sizes of the rings a Recorder keeps.
+const (
+	LogLines = 200
+	APILines = 100
+	UILines  = 50
+)
+
+// Ring keeps the last lines written to it. It is safe for concurrent use.
+type Ring struct {
+	mu      sync.Mutex
+	lines   []string
+	max     int
+	partial []byte
+}
+
+// NewRing returns a Ring that keeps max lines.
+func NewRing(max int) *Ring {
+	return &Ring{max: max}
+}
+
+// Write adds the complete lines in p; a trailing partial line waits for the rest.
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.partial = append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		r.add(string(r.partial[:i]))
+		r.partial = r.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Add adds one line.
+func (r *Ring) Add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(line)
+}
+
+func (r *Ring) add(line string) {
+	if len(r.lines) == r.max {
+		r.lines = append(r.lines[:0], r.lines[1:]...)
+	}
+	r.lines = append(r.lines, line)
+}
+
+// Lines returns the lines kept, oldest first.
+func (r *Ring) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.lines...)
+}
+
+// Recorder collects the contents of a dump while the program runs.
+type Recorder struct {
+	// Log receives the log output.
+	Log *Ring
+	// API receives the API response lines, as written by client.Recorder.
+	API *Ring
+	// UI receives the messages sent to the progress UI.
+	UI *Ring
+
+	mu      sync.Mutex
+	uiState string
+}
+
+// New returns an empty Recorder.
+func New() *Recorder {
+	return &Recorder{Log: NewRing(LogLines), API: NewRing(APILines), UI: NewRing(UILines)}
+}
+
+// SetUIState records the latest state of the UI, e.g. from ui.Model.DebugState.
+func (r *Recorder) SetUIState(state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uiState = state
+}
+
+// Write writes a dump, headed by reason, to a timestamped file in dir and returns its
+// path. config is included as JSON, so secrets in it that Redact cannot recognize must be
+// redacted by the caller first. Each of secrets is redacted wherever else it appears, e.g.
+// in a log line about a failed notification.
+func (r *Recorder) Write(dir, reason string, config any, secrets ...string) (string, error) {
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "ollama-downloader debug dump\n")
+	fmt.Fprintf(&b, "Time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Reason: %s\n", reason)
+	fmt.Fprintf(&b, "Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "Args: %s\n", strings.Join(os.Args, " "))
+
+	configJSON, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		configJSON = []byte(err.Error())
+	}
+	section(&b, "Config", []string{string(configJSON)})
+	r.mu.Lock()
+	uiState := r.uiState
+	r.mu.Unlock()
+	section(&b, "UI state", []string{strings.TrimRight(uiState, "\n")})
+	section(&b, "Recent UI messages", r.UI.Lines())
+	section(&b, "Recent API responses", r.API.Lines())
+	section(&b, "Recent log", r.Log.Lines())
+
+	path := filepath.Join(dir, "ollama-downloader-dump-"+now.Format("20060102-150405")+".txt")
+	dump := b.String()
+	for _, secret := range secrets {
+		if secret != "" {
+			dump = strings.ReplaceAll(dump, secret, "[REDACTED]")
+		}
+	}
+	if err := os.WriteFile(path, []byte(Redact(dump)), 0600); err != nil {
+		return "", fmt.Errorf("writing debug dump: %w", err)
+	}
+	return path, nil
+}
+
+func section(b *strings.Builder, title string, lines []string) {
+	fmt.Fprintf(b, "\n== %s ==\n", title)
+	if len(lines) == 0 || len(lines) == 1 && lines[0] == "" {
+		b.WriteString("(none)\n")
+		return
+	}
+	for _, l := range lines {
+		b.WriteString(l + "\n")
+	}
+}
+
+var (
+	// secretValue matches the value of a key that names a secret, in JSON, headers,
+	// flags and environment variables alike.
+	secretValue = regexp.MustCompile(`(?i)((?:authorization|api[_-]?key|token|secret|password)"?\s*[:=]\s*"?)(?:bearer\s+)?[^"\s,}]+`)
+	// bearerToken matches a bearer token on its own.
+	bearerToken = regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9._~+/=-]+`)
+	// urlCredentials matches the user information of a URL.
+	urlCredentials = regexp.MustCompile(`(://)[^/@\s"]+@`)
+)
+
+// Redact replaces secrets in s: values of authorization headers, API keys, tokens and
+// passwords, bearer tokens, and credentials in URLs.
+func Redact(s string) string {
+	s = secretValue.ReplaceAllString(s, "${1}[REDACTED]")
+	s = bearerToken.ReplaceAllString(s, "${1}[REDACTED]")
+	return urlCredentials.ReplaceAllString(s, "${1}[REDACTED]@")
+}
+
+// RedactURL keeps only the scheme and host of a URL, as webhook URLs often carry their
+// secret in the path or query, e.g. Slack's /services/T000/B000/XXXX. Anything that is not
+// a URL with a host is redacted completely.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "[REDACTED]"
+	}
+	redacted := u.Scheme + "://" + u.Host
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+		redacted += "/[REDACTED]"
+	}
+	return redacted
+}
@@ -0,0 +1,175 @@
+// Package demo provides an in-process fake Ollama server that streams synthetic /api/pull
+// progress, so the UI and retry flows can be previewed without downloading anything.
+package demo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/client"
+)
+
+// Layer is one synthetic blob of the fake model.
+type Layer struct {
+	Digest string
+	Size   int64
+}
+
+// Server streams fake pull progress. Progress is remembered between requests, so a retried
+// pull resumes where the previous attempt stopped, just like Ollama does.
+type Server struct {
+	Layers []Layer
+	// Speed is the simulated download rate in bytes per second.
+	Speed int64
+	// Tick is how often a progress line is written.
+	Tick time.Duration
+	// StallAt makes the first attempt hang once this fraction of the model is downloaded,
+	// which lets the client's request timeout fire. Zero disables the stall.
+	StallAt float64
+
+	mu        sync.Mutex
+	completed map[string]int64
+	stalled   bool
+
+	srv *http.Server
+}
+
+// NewServer returns a server for a model of roughly 2 GB that downloads in about 12 seconds.
+func NewServer() *Server {
+	return &Server{
+		Layers: []Layer{
+			{Digest: "sha256:6a0746a1ec1aef3e7ec53868f220ff6e389f6f8ef87a01d77c96807de94ca2aa", Size: 2019377376},
+			{Digest: "sha256:4fa551d4f938f68b8c1e6afa9d28befb70e3f33f75d0753248d530364aeea40f", Size: 12403},
+			{Digest: "sha256:8ab4849b038cf0abc5b1c9b8ee1443dca6b93a045c2272180d985126eb40bf6f", Size: 254},
+			{Digest: "sha256:577073ffcc6ce95b9981eacc77d1039568639e5638e83044994560d9ef82ce1b", Size: 110},
+		},
+		Speed: 170 * 1024 * 1024,
+		Tick:  100 * time.Millisecond,
+	}
+}
+
+// Start listens on a random loopback port and returns the base URL to use as the Ollama host.
+func (s *Server) Start() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("starting demo server: %w", err)
+	}
+	s.srv = &http.Server{Handler: s}
+	go s.srv.Serve(ln)
+	return "http://" + ln.Addr().String(), nil
+}
+
+// Close stops the server and aborts any streams in progress.
+func (s *Server) Close() error {
+	if s.srv == nil {
+		return nil
+	}
+	return s.srv.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/pull" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	var req client.PullRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	send := func(res client.OllamaResponse) error {
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	// A write error or cancellation just means the client went away.
+	s.stream(r.Context(), send)
+}
+
+// stream writes the full pull sequence: manifest, every layer, verification and success.
+func (s *Server) stream(ctx context.Context, send func(client.OllamaResponse) error) error {
+	if err := send(client.OllamaResponse{Status: "pulling manifest"}); err != nil {
+		return err
+	}
+
+	var total int64
+	for _, l := range s.Layers {
+		total += l.Size
+	}
+	step := s.Speed * int64(s.Tick) / int64(time.Second)
+	ticker := time.NewTicker(s.Tick)
+	defer ticker.Stop()
+
+	for _, l := range s.Layers {
+		status := "pulling " + shortDigest(l.Digest)
+		for {
+			done, stall := s.advance(l, step, total)
+			if err := send(client.OllamaResponse{Status: status, Digest: l.Digest, Total: l.Size, Completed: done}); err != nil {
+				return err
+			}
+			if stall {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			if done >= l.Size {
+				break
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	for _, status := range []string{"verifying sha256 digest", "writing manifest", "success"} {
+		if err := send(client.OllamaResponse{Status: status}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advance moves layer l forward by step bytes and reports whether the stream should stall now.
+func (s *Server) advance(l Layer, step, total int64) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.completed == nil {
+		s.completed = make(map[string]int64)
+	}
+	done := min(s.completed[l.Digest]+step, l.Size)
+	s.completed[l.Digest] = done
+
+	if s.StallAt > 0 && !s.stalled {
+		var sum int64
+		for _, v := range s.completed {
+			sum += v
+		}
+		if float64(sum)/float64(total) >= s.StallAt {
+			s.stalled = true
+			return done, true
+		}
+	}
+	return done, false
+}
+
+// shortDigest mirrors how Ollama names layers in its status lines.
+func shortDigest(digest string) string {
+	const prefix = "sha256:"
+	if len(digest) >= len(prefix)+12 {
+		return digest[len(prefix) : len(prefix)+12]
+	}
+	return digest
+}
@@ -0,0 +1,141 @@
+package demo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/client"
+)
+
+// Replay serves a session captured with client.Recorder: the Nth /api/pull request gets the
+// lines of the Nth recorded attempt, with the original pacing divided by Speed.
+type Replay struct {
+	// Speed divides the recorded delays; 1 replays in real time.
+	Speed float64
+
+	attempts [][]client.RecordedLine
+
+	mu   sync.Mutex
+	next int
+
+	srv *http.Server
+}
+
+// LoadReplay reads a recorded session.
+func LoadReplay(r io.Reader) (*Replay, error) {
+	rp := &Replay{Speed: 1}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), client.MaxLineSize)
+	for scanner.Scan() {
+		var entry client.RecordedLine
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid session entry: %w", err)
+		}
+		if entry.Event == client.EventAttempt || len(rp.attempts) == 0 {
+			rp.attempts = append(rp.attempts, nil)
+		}
+		last := len(rp.attempts) - 1
+		rp.attempts[last] = append(rp.attempts[last], entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading session: %w", err)
+	}
+	if len(rp.attempts) == 0 {
+		return nil, fmt.Errorf("session contains no attempts")
+	}
+	return rp, nil
+}
+
+// Attempts returns the number of recorded attempts.
+func (rp *Replay) Attempts() int {
+	return len(rp.attempts)
+}
+
+// Start listens on a random loopback port and returns the base URL to use as the Ollama host.
+func (rp *Replay) Start() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("starting replay server: %w", err)
+	}
+	rp.srv = &http.Server{Handler: rp}
+	go rp.srv.Serve(ln)
+	return "http://" + ln.Addr().String(), nil
+}
+
+// Close stops the server and aborts any streams in progress.
+func (rp *Replay) Close() error {
+	if rp.srv == nil {
+		return nil
+	}
+	return rp.srv.Close()
+}
+
+func (rp *Replay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/pull" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	rp.mu.Lock()
+	n := rp.next
+	rp.next++
+	rp.mu.Unlock()
+	if n >= len(rp.attempts) {
+		http.Error(w, "replay exhausted: no more recorded attempts", http.StatusGone)
+		return
+	}
+	entries := rp.attempts[n]
+	start := entries[0].Time
+	served := time.Now()
+	flusher, _ := w.(http.Flusher)
+
+	for _, entry := range entries {
+		if !rp.sleepUntil(r, served, entry.Time.Sub(start)) {
+			return
+		}
+		switch entry.Event {
+		case client.EventStatus:
+			w.WriteHeader(entry.Status)
+			io.WriteString(w, entry.Line)
+			return
+		case client.EventLine:
+			io.WriteString(w, entry.Line+"\n")
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	// Keep the stream open until the next attempt started in the recording, so an attempt
+	// that originally timed out times out again.
+	if n+1 < len(rp.attempts) {
+		rp.sleepUntil(r, served, rp.attempts[n+1][0].Time.Sub(start))
+	}
+}
+
+// sleepUntil waits until the recorded offset, scaled by Speed, has passed since served.
+// It returns false if the client went away.
+func (rp *Replay) sleepUntil(r *http.Request, served time.Time, offset time.Duration) bool {
+	speed := rp.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	delay := time.Duration(float64(offset)/speed) - time.Since(served)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
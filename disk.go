@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/modelstore"
+	"ollama-downloader-v2/registry"
+	"ollama-downloader-v2/ui"
+)
+
+// localStore returns the model store of host if host is an Ollama server on this
+// machine, whose files can be read directly.
+func localStore(host string) (modelstore.Store, bool) {
+	if !client.IsLocal(host) {
+		return modelstore.Store{}, false
+	}
+	store, err := modelstore.Local()
+	if err != nil {
+		log.Printf("No local model store: %v", err)
+		return modelstore.Store{}, false
+	}
+	return store, true
+}
+
+// withFreeSpace shows the free space of host's model store in view if host is local.
+func withFreeSpace(view ui.Model, host string) ui.Model {
+	store, ok := localStore(host)
+	if !ok {
+		return view.WithFreeSpace("", nil)
+	}
+	return view.WithFreeSpace(store.Dir, store.FreeSpace)
+}
+
+// checkDiskSpace prints the free space in store and reports whether a download of size
+// bytes may go ahead. If it does not fit, the user is asked; without a terminal to ask
+// on the pull goes ahead, as blobs already on disk need no space.
+func checkDiskSpace(w io.Writer, store modelstore.Store, size int64) bool {
+	free, err := store.FreeSpace()
+	if err != nil {
+		if !errors.Is(err, modelstore.ErrUnsupported) {
+			log.Printf("Free space not checked: %v", err)
+		}
+		return true
+	}
+	fmt.Fprintf(w, "Models are stored in %s (%s free).\n", store.Dir, ui.FormatBytes(free))
+	if size <= free {
+		return true
+	}
+	fmt.Fprintf(w, "Warning: the download is up to %s, more than the %s free in %s.\n", ui.FormatBytes(size), ui.FormatBytes(free), store.Dir)
+	if !stdinIsTerminal() {
+		return true
+	}
+	return confirm("Pull anyway?")
+}
+
+// checkStoredBlobs checks that every blob of the installed model is in store with the
+// size its manifest gives.
+func checkStoredBlobs(w io.Writer, store modelstore.Store, model string) error {
+	m, err := store.Manifest(registry.ParseName(model))
+	if errors.Is(err, fs.ErrNotExist) {
+		// The server may run with another OLLAMA_MODELS, e.g. as a system service.
+		fmt.Fprintf(w, "Blobs not checked: %s has no manifest of %s; set %s as for the server.\n", store.Dir, model, modelstore.Env)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	n, err := store.CheckBlobs(m)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "All %d blobs of %s are in %s.\n", n, model, store.Dir)
+	return nil
+}
+
+// freeSpace returns the free space in store, or -1 if it is unknown.
+func freeSpace(store modelstore.Store) int64 {
+	free, err := store.FreeSpace()
+	if err != nil {
+		return -1
+	}
+	return free
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/modelstore"
+	"ollama-downloader-v2/registry"
+	"ollama-downloader-v2/ui"
+)
+
+// Outcomes of a doctor check.
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+	checkSkip = "SKIP"
+)
+
+// lowDiskSpace is the free space under which doctor warns; most models are several GB.
+const lowDiskSpace = 10 << 30
+
+// certExpiryWarning is how long before a certificate expires doctor warns about it.
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// checkResult is the outcome of one doctor check and what was found.
+type checkResult struct {
+	name   string
+	status string
+	detail string
+}
+
+// runDoctorCommand checks what a pull depends on, from resolving the Ollama host to
+// reaching the registry, and prints a report. It exits with status 1 if a check failed.
+func runDoctorCommand(args []string) int {
+	fs := newFlagSet("doctor")
+	host := fs.String("host", "", "Ollama API host. Overrides the global --host and OLLAMA_HOST.")
+	timeout := fs.Duration("timeout", 10*time.Second, "How long each network check may take")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Ignoring config: %v", err)
+	}
+	client.Authorize = authorizer(cfg, false)
+	target := resolveHost(*host)
+	run := func(check func(context.Context) checkResult) checkResult {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		return check(ctx)
+	}
+
+	// Each step on the way to the host needs the one before it.
+	dns := run(func(ctx context.Context) checkResult { return checkDNS(ctx, target) })
+	connect := checkResult{name: "TCP connect", status: checkSkip, detail: "the host name did not resolve"}
+	if dns.status != checkFail {
+		connect = run(func(ctx context.Context) checkResult { return checkConnect(ctx, target) })
+	}
+	api := checkResult{name: "Ollama API", status: checkSkip, detail: "no connection to the host"}
+	hostHeaders := checkResult{name: "Host headers", status: checkSkip, detail: "no connection to the host"}
+	if connect.status != checkFail && connect.status != checkSkip {
+		api = run(func(ctx context.Context) checkResult { return checkVersion(ctx, target) })
+		hostHeaders = run(func(ctx context.Context) checkResult { return checkHostHeaders(ctx, target) })
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	reachable, chain, registryHeaders := checkRegistry(ctx, registry.New())
+	cancel()
+	results := []checkResult{dns, connect, api, hostHeaders, checkProxy(target), reachable, chain, registryHeaders, checkDisk(target)}
+
+	fmt.Printf("Checking %s and the registry at %s.\n\n", target, registry.DefaultURL)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAIL")
+	failed := 0
+	for _, r := range results {
+		if r.status == checkFail {
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.name, r.status, r.detail)
+	}
+	tw.Flush()
+	if failed > 0 {
+		fmt.Printf("\n%d of %d checks failed.\n", failed, len(results))
+		return 1
+	}
+	fmt.Println("\nNo problems found.")
+	return 0
+}
+
+// checkDNS resolves the name of host.
+func checkDNS(ctx context.Context, host string) checkResult {
+	r := checkResult{name: "DNS"}
+	u, err := client.ParseHost(host)
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		return r
+	}
+	if u.Scheme == "unix" {
+		r.status, r.detail = checkSkip, "the host is a Unix socket"
+		return r
+	}
+	name := u.Hostname()
+	if net.ParseIP(name) != nil {
+		r.status, r.detail = checkPass, name+" is an address, no lookup needed"
+		return r
+	}
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		return r
+	}
+	r.status, r.detail = checkPass, fmt.Sprintf("%s is %v (%s)", name, addrs, time.Since(start).Round(time.Millisecond))
+	return r
+}
+
+// checkConnect opens a connection to host, or to its socket.
+func checkConnect(ctx context.Context, host string) checkResult {
+	r := checkResult{name: "TCP connect"}
+	u, err := client.ParseHost(host)
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		return r
+	}
+	network, addr := "tcp", u.Host
+	if u.Scheme == "unix" {
+		r.name, network, addr = "Socket connect", "unix", u.Path
+	} else if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+			r.detail += "; a firewall may be dropping the connection"
+		}
+		return r
+	}
+	conn.Close()
+	r.status, r.detail = checkPass, fmt.Sprintf("connected to %s in %s", addr, time.Since(start).Round(time.Millisecond))
+	return r
+}
+
+// checkVersion asks host for its Ollama version, which shows it is an Ollama server.
+func checkVersion(ctx context.Context, host string) checkResult {
+	r := checkResult{name: "Ollama API"}
+	version, err := client.Version(ctx, host)
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		return r
+	}
+	r.status, r.detail = checkPass, "Ollama "+version
+	return r
+}
+
+// checkHostHeaders reports the diagnostic headers of host's answer, see
+// client.DiagnosticHeaders. Headers a proxy leaves are a warning, as a proxy in front of
+// Ollama may buffer the streamed progress.
+func checkHostHeaders(ctx context.Context, host string) checkResult {
+	r := checkResult{name: "Host headers"}
+	info, err := client.ProbeResponse(ctx, host, "/api/version")
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		return r
+	}
+	r.status, r.detail = checkPass, info.String()
+	if info.Proxied() {
+		r.status = checkWarn
+		r.detail += "; a proxy answers for the host, and one that buffers responses holds back the progress"
+	}
+	return r
+}
+
+// checkProxy reports the proxies from the environment that requests to host and the
+// registry go through. A proxy for host is a warning, as it is rarely meant for Ollama.
+func checkProxy(host string) checkResult {
+	r := checkResult{name: "Proxy", status: checkPass}
+	proxyFor := func(rawURL string) string {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			return ""
+		}
+		proxy, err := http.ProxyFromEnvironment(req)
+		if err != nil {
+			return "invalid (" + err.Error() + ")"
+		}
+		if proxy == nil {
+			return ""
+		}
+		// Proxy URLs often carry credentials.
+		return proxy.Redacted()
+	}
+	toRegistry := proxyFor(registry.DefaultURL + "/v2/")
+	toHost := ""
+	if u, err := client.ParseHost(host); err == nil && u.Scheme != "unix" {
+		toHost = proxyFor(u.String())
+	}
+	switch {
+	case toRegistry == "" && toHost == "":
+		r.detail = "none set"
+	case toRegistry == "":
+		r.detail = "none for the registry"
+	default:
+		r.detail = "registry via " + toRegistry
+	}
+	if toHost != "" {
+		r.status = checkWarn
+		r.detail += fmt.Sprintf("; the Ollama host via %s, add it to NO_PROXY if that is not intended", toHost)
+	}
+	// Ollama downloads the blobs itself, through the proxy of its own environment.
+	r.detail += "; pulls use the proxy set for the Ollama server"
+	return r
+}
+
+// checkRegistry requests the registry's version check and returns whether it is
+// reachable, whether its TLS certificate chain is trusted, and the diagnostic headers of
+// its answer. The registry sits behind a CDN, so proxy headers there are expected.
+func checkRegistry(ctx context.Context, reg *registry.Client) (reachable, chain, headers checkResult) {
+	reachable = checkResult{name: "Registry"}
+	chain = checkResult{name: "TLS chain"}
+	headers = checkResult{name: "Registry headers", status: checkSkip, detail: "no connection to the registry"}
+	start := time.Now()
+	resp, err := reg.Ping(ctx)
+	if err != nil {
+		reachable.status, reachable.detail = checkFail, err.Error()
+		var unknown x509.UnknownAuthorityError
+		var invalid x509.CertificateInvalidError
+		var hostname x509.HostnameError
+		var verify *tls.CertificateVerificationError
+		switch {
+		case errors.As(err, &unknown):
+			chain.status, chain.detail = checkFail, "signed by an unknown authority; a proxy inspecting TLS needs its CA in the system store or SSL_CERT_FILE"
+		case errors.As(err, &invalid), errors.As(err, &hostname), errors.As(err, &verify):
+			chain.status, chain.detail = checkFail, err.Error()
+		default:
+			chain.status, chain.detail = checkSkip, "no connection to the registry"
+		}
+		return reachable, chain, headers
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	headers.status, headers.detail = checkPass, client.NewResponseInfo(resp).String()
+	switch status := resp.StatusCode; status {
+	case http.StatusOK, http.StatusUnauthorized, http.StatusNotFound:
+		reachable.status, reachable.detail = checkPass, fmt.Sprintf("answered with status %d in %s", status, elapsed)
+	case http.StatusForbidden, http.StatusProxyAuthRequired:
+		reachable.status, reachable.detail = checkFail, fmt.Sprintf("status %d; a proxy or firewall may be blocking the registry", status)
+	default:
+		reachable.status, reachable.detail = checkFail, fmt.Sprintf("status %d", status)
+	}
+	if resp.TLS == nil || len(resp.TLS.VerifiedChains) == 0 {
+		chain.status, chain.detail = checkSkip, "the registry is not served over https"
+		return reachable, chain, headers
+	}
+	verified := resp.TLS.VerifiedChains[0]
+	leaf, root := verified[0], verified[len(verified)-1]
+	chain.status = checkPass
+	chain.detail = fmt.Sprintf("%s issued by %s, valid until %s", leaf.Subject.CommonName, root.Subject.CommonName, leaf.NotAfter.Format(time.DateOnly))
+	if time.Until(leaf.NotAfter) < certExpiryWarning {
+		chain.status = checkWarn
+		chain.detail += ", which is soon"
+	}
+	return reachable, chain, headers
+}
+
+// checkDisk reports the free space in host's model store if host is on this machine.
+func checkDisk(host string) checkResult {
+	r := checkResult{name: "Disk space"}
+	store, ok := localStore(host)
+	if !ok {
+		r.status, r.detail = checkSkip, "the host is not on this machine"
+		return r
+	}
+	free, err := store.FreeSpace()
+	if errors.Is(err, modelstore.ErrUnsupported) {
+		r.status, r.detail = checkSkip, err.Error()
+		return r
+	}
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		return r
+	}
+	r.status, r.detail = checkPass, fmt.Sprintf("%s free in %s", ui.FormatBytes(free), store.Dir)
+	if free < lowDiskSpace {
+		r.status = checkWarn
+		r.detail += ", too little for most models"
+	}
+	return r
+}
@@ -0,0 +1,203 @@
+// Package events writes a machine-readable stream of pull events: one self-contained
+// JSON object per line, with a schema_version field.
+//
+// The schema is stable within a version: fields may be added, but existing fields keep
+// their name, type and meaning. Consumers should ignore fields they do not know.
+// SchemaVersion changes only when that promise has to be broken.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SchemaVersion is the version of the event schema written in every event.
+const SchemaVersion = 1
+
+// Type is the kind of event.
+type Type string
+
+const (
+	// TypeStarted is emitted when a pull of a model starts.
+	TypeStarted Type = "pull_started"
+	// TypeProgress is emitted for progress updates; Phase is Ollama's status line.
+	TypeProgress Type = "progress"
+	// TypeRetry is emitted when a pull failed and is tried again after a delay, e.g. while
+	// the host is unreachable; Attempt, DelaySeconds and Error describe the retry.
+	TypeRetry Type = "retry"
+	// TypeFinished is emitted when a pull succeeds.
+	TypeFinished Type = "pull_finished"
+	// TypeFailed is emitted when a pull fails or is stopped; Error says why.
+	TypeFailed Type = "pull_failed"
+)
+
+// Event is one line of the stream.
+type Event struct {
+	SchemaVersion int       `json:"schema_version"`
+	Time          time.Time `json:"time"`
+	Type          Type      `json:"type"`
+	Model         string    `json:"model"`
+	Host          string    `json:"host,omitempty"`
+	Phase         string    `json:"phase,omitempty"`
+	// Completed and Total are the bytes of the layer a progress event is about. They are
+	// set, also to 0 at the start of a layer, while a layer is pulled, and left out for
+	// phases without a size, e.g. "pulling manifest".
+	Completed *int64 `json:"completed,omitempty"`
+	Total     *int64 `json:"total,omitempty"`
+	// Digest is the layer a progress event is about, Layer its position from 1 and
+	// Layers how many the model has; Layers is left out while unknown.
+	Digest string `json:"digest,omitempty"`
+	Layer  int    `json:"layer,omitempty"`
+	Layers int    `json:"layers,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Attempt counts the retries of a retry event from 1, and DelaySeconds is how long it
+	// waits before trying again.
+	Attempt      int     `json:"attempt,omitempty"`
+	DelaySeconds float64 `json:"delay_seconds,omitempty"`
+	// Speed is in bytes per second; it and ETASeconds are left out while unknown.
+	Speed      float64 `json:"speed,omitempty"`
+	ETASeconds float64 `json:"eta_seconds,omitempty"`
+}
+
+// clientWriteTimeout bounds how long Emit waits for a socket reader; one that stops reading
+// is disconnected instead of holding up the pull.
+const clientWriteTimeout = time.Second
+
+// Emitter writes events to a sink. A nil *Emitter discards them.
+type Emitter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+	ln      net.Listener
+	clients map[net.Conn]struct{}
+}
+
+// Open returns an Emitter for spec: "stdout", "file:<path>" (appended to) or
+// "socket:<path>" (a Unix socket every connected reader receives the stream on).
+func Open(spec string) (*Emitter, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "stdout":
+		return &Emitter{w: os.Stdout}, nil
+	case "file":
+		if arg == "" {
+			return nil, errors.New("events: file needs a path, e.g. file:/tmp/pull.jsonl")
+		}
+		f, err := os.OpenFile(arg, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("events: %w", err)
+		}
+		return &Emitter{w: f, closer: f}, nil
+	case "socket":
+		if arg == "" {
+			return nil, errors.New("events: socket needs a path, e.g. socket:/tmp/pull.sock")
+		}
+		removeStale(arg)
+		ln, err := net.Listen("unix", arg)
+		if err != nil {
+			return nil, fmt.Errorf("events: %w", err)
+		}
+		e := &Emitter{ln: ln, clients: make(map[net.Conn]struct{})}
+		go e.accept()
+		return e, nil
+	default:
+		return nil, fmt.Errorf("events: unknown sink %q (want stdout, file:<path> or socket:<path>)", spec)
+	}
+}
+
+// IsStdout reports whether spec selects standard output.
+func IsStdout(spec string) bool {
+	return spec == "stdout"
+}
+
+// Emit stamps e with the schema version and current time if unset, and writes it.
+func (em *Emitter) Emit(e Event) {
+	if em == nil {
+		return
+	}
+	e.SchemaVersion = SchemaVersion
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Encoding event: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	if em.w != nil {
+		if _, err := em.w.Write(line); err != nil {
+			log.Printf("Writing event: %v", err)
+		}
+	}
+	for conn := range em.clients {
+		conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := conn.Write(line); err != nil {
+			conn.Close()
+			delete(em.clients, conn)
+		}
+	}
+}
+
+// Close stops the sink, disconnecting socket readers.
+func (em *Emitter) Close() error {
+	if em == nil {
+		return nil
+	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	var err error
+	if em.ln != nil {
+		err = em.ln.Close()
+		for conn := range em.clients {
+			conn.Close()
+		}
+		em.clients = nil
+	}
+	if em.closer != nil {
+		err = errors.Join(err, em.closer.Close())
+	}
+	return err
+}
+
+func (em *Emitter) accept() {
+	for {
+		conn, err := em.ln.Accept()
+		if err != nil {
+			return
+		}
+		em.mu.Lock()
+		if em.clients == nil {
+			em.mu.Unlock()
+			conn.Close()
+			return
+		}
+		em.clients[conn] = struct{}{}
+		em.mu.Unlock()
+	}
+}
+
+// removeStale removes a socket file at path that was left behind by a process that is gone,
+// so the socket can be created again. Other files and sockets someone answers on are kept.
+func removeStale(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode().Type() != os.ModeSocket {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
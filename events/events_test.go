@@ -0,0 +1,130 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmitter_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	em, err := Open("file:" + path)
+	assert.NoError(t, err)
+	em.Emit(Event{Type: TypeStarted, Model: "llama3", Host: "h"})
+	completed, total := int64(5), int64(10)
+	em.Emit(Event{Type: TypeProgress, Model: "llama3", Phase: "pulling abc", Completed: &completed, Total: &total})
+	zero := int64(0)
+	em.Emit(Event{Type: TypeProgress, Model: "llama3", Phase: "pulling def", Completed: &zero, Total: &total})
+	em.Emit(Event{Type: TypeProgress, Model: "llama3", Phase: "pulling manifest"})
+	assert.NoError(t, em.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 4, "Each event should be exactly one line")
+	assert.Contains(t, lines[2], `"completed":0,"total":10`, "A layer at 0 bytes keeps its counters")
+	assert.NotContains(t, lines[3], "completed", "A phase without a size has no counters")
+
+	var e map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &e))
+	assert.Equal(t, float64(SchemaVersion), e["schema_version"])
+	assert.Equal(t, "progress", e["type"])
+	assert.Equal(t, float64(5), e["completed"])
+	assert.NotEmpty(t, e["time"])
+}
+
+func TestEmitter_Socket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.sock")
+	em, err := Open("socket:" + path)
+	assert.NoError(t, err)
+	defer em.Close()
+
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// Wait until the reader is registered, then emit.
+	assert.Eventually(t, func() bool {
+		em.mu.Lock()
+		defer em.mu.Unlock()
+		return len(em.clients) == 1
+	}, time.Second, 10*time.Millisecond)
+	em.Emit(Event{Type: TypeFinished, Model: "llama3"})
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Contains(t, line, `"type":"pull_finished"`)
+}
+
+func TestOpen_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "file", "socket:", "kafka:topic"} {
+		_, err := Open(spec)
+		assert.Error(t, err, spec)
+	}
+	var em *Emitter
+	em.Emit(Event{Type: TypeStarted})
+	assert.NoError(t, em.Close())
+}
+
+func TestEmitter_SocketReaderThatStopsReading(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.sock")
+	em, err := Open("socket:" + path)
+	assert.NoError(t, err)
+	defer em.Close()
+
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.Eventually(t, func() bool {
+		em.mu.Lock()
+		defer em.mu.Unlock()
+		return len(em.clients) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The reader never reads, so the socket buffer fills up and the next write times out.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			em.Emit(Event{Type: TypeFailed, Model: "llama3", Error: strings.Repeat("x", 1024)})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Emit blocked on a reader that stopped reading")
+	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	assert.Empty(t, em.clients, "The reader should be disconnected")
+}
+
+func TestOpen_StaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.sock")
+	em, err := Open("socket:" + path)
+	assert.NoError(t, err)
+
+	_, err = Open("socket:" + path)
+	assert.Error(t, err, "A socket someone listens on is not replaced")
+
+	// Simulate a crash that leaves the socket file behind.
+	em.ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	em.Close()
+	em, err = Open("socket:" + path)
+	assert.NoError(t, err, "A stale socket should be replaced")
+	em.Close()
+
+	file := filepath.Join(t.TempDir(), "events.jsonl")
+	assert.NoError(t, os.WriteFile(file, []byte("keep"), 0644))
+	_, err = Open("socket:" + file)
+	assert.Error(t, err)
+	data, _ := os.ReadFile(file)
+	assert.Equal(t, "keep", string(data), "A regular file is not removed")
+}
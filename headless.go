@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/control"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// headlessRetries is how often a headless pull starts over after an error.
+const headlessRetries = 5
+
+// runHeadlessPull pulls modelName without the UI, retrying on its own, and reports
+// progress only to the observers in opts. Cancelling ctx stops it.
+func runHeadlessPull(ctx context.Context, modelName, host string, opts pullOptions) pullResult {
+	opts.start(modelName, host)
+	if opts.Control != nil {
+		opts.Control.Update(func(s *control.Status) { s.Host = host })
+	}
+	req := client.PullOptions{Model: modelName, Host: host, Insecure: opts.Insecure, Hold: opts.Hold, Layers: len(opts.LayerSizes)}
+	err := pullWithRetries(ctx, req, headlessRetries, headlessHooks{
+		Progress: func(p client.ProgressMsg) { opts.observeProgress(modelName, p) },
+		Retried:  opts.Stats.Retried,
+		Retrying: func(attempt int, delay time.Duration, err error) {
+			opts.observeRetry(modelName, host, attempt, delay, err)
+		},
+	})
+	result := pullResult{Succeeded: err == nil, Host: host}
+	if err != nil && ctx.Err() != nil {
+		result.Quit = true
+	} else {
+		result.Err = err
+	}
+	opts.finish(modelName, result)
+	return result
+}
+
+// headlessHooks receive what a pull without the UI reports. Nil hooks are skipped.
+type headlessHooks struct {
+	// Progress is called with every progress update.
+	Progress func(client.ProgressMsg)
+	// Retried is told the cause of every retry, Pull's own and the restarts.
+	Retried func(client.RetryCause)
+	// Retrying is called before a restart or a gateway retry waits delay; attempt
+	// counts the retries of that kind from 1.
+	Retrying func(attempt int, delay time.Duration, err error)
+}
+
+// pullWithRetries pulls req.Model without the UI, starting over after failures that may
+// be temporary until maxRetries is used up.
+func pullWithRetries(ctx context.Context, req client.PullOptions, maxRetries int, hooks headlessHooks) error {
+	for attempt := 0; ; attempt++ {
+		err := pullHeadless(ctx, req, hooks)
+		if err == nil {
+			return nil
+		}
+		permanent := errors.Is(err, client.ErrModelNotFound) || errors.Is(err, client.ErrUnauthorized) ||
+			errors.Is(err, client.ErrRetryBudgetExhausted) || errors.Is(err, client.ErrInvalidHost)
+		if permanent || attempt >= maxRetries || ctx.Err() != nil {
+			return err
+		}
+		backoff := time.Duration(attempt+1) * 5 * time.Second
+		slog.Warn("pull attempt failed, retrying", "model", req.Model, "error", err.Error(), "retry_in", backoff.String())
+		if hooks.Retried != nil {
+			hooks.Retried(client.RetryCauseOf(err))
+		}
+		if hooks.Retrying != nil {
+			hooks.Retrying(attempt+1, backoff, err)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// pullHeadless runs one Pull with automatic retries on timeouts, passing what it reports
+// to hooks.
+func pullHeadless(ctx context.Context, req client.PullOptions, hooks headlessHooks) error {
+	progressCh := make(chan tea.Msg)
+	req.Progress = progressCh
+	req.AutoRetry = true
+	client.Pull(ctx, req)
+
+	var pullErr error
+	succeeded := false
+	for msg := range progressCh {
+		switch msg := msg.(type) {
+		case client.ProgressMsg:
+			if msg.Status == "success" {
+				succeeded = true
+			}
+			if hooks.Progress != nil {
+				hooks.Progress(msg)
+			}
+		case client.QueuedMsg:
+			slog.Info("host is busy with another pull, waiting", "model", req.Model, "error", msg.Err.Error(), "wait", msg.Wait.String())
+		case client.RetryingMsg:
+			slog.Warn("gateway error, retrying", "model", req.Model, "error", msg.Err.Error(), "attempt", msg.Attempt, "retry_in", msg.Delay.String())
+			if hooks.Retrying != nil {
+				hooks.Retrying(msg.Attempt, msg.Delay, msg.Err)
+			}
+		case client.RetriedMsg:
+			if hooks.Retried != nil {
+				hooks.Retried(msg.Cause)
+			}
+		case client.PausedMsg:
+			slog.Info("pull paused", "model", req.Model, "reason", msg.Reason)
+		case client.RestartingMsg:
+			slog.Warn("host went away, waiting for it to restart", "model", req.Model, "error", msg.Err.Error(), "wait", msg.Wait.String())
+		case client.ErrorMsg:
+			pullErr = msg.Err
+		}
+	}
+	if pullErr != nil {
+		return pullErr
+	}
+	if !succeeded {
+		return client.ErrStreamEnded
+	}
+	return nil
+}
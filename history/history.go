@@ -0,0 +1,153 @@
+// Package history keeps a record of the pulls the downloader made, one JSON object per
+// line in history.jsonl next to the config file.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"ollama-downloader-v2/config"
+)
+
+// Reasons a pull was made.
+const (
+	// ReasonPull is a pull by the pull command.
+	ReasonPull = "pull"
+	// ReasonApply is a pull by `apply` for a model its manifest lists.
+	ReasonApply = "apply"
+	// ReasonWatch is a pull by `watch` after the registry published a new version.
+	ReasonWatch = "watch"
+)
+
+// Entry is one pull.
+type Entry struct {
+	Time  time.Time `json:"time"`
+	Model string    `json:"model"`
+	Host  string    `json:"host,omitempty"`
+	// Digest is the manifest digest the pull installed.
+	Digest string `json:"digest,omitempty"`
+	// Previous is the digest that was installed before, if any.
+	Previous string `json:"previous,omitempty"`
+	// Reason says what made the pull, e.g. ReasonWatch.
+	Reason string `json:"reason,omitempty"`
+	// Bytes is how much the pull downloaded, without what a resumed pull found on disk.
+	Bytes int64 `json:"bytes,omitempty"`
+	// Retries counts the pull's retries by cause, e.g. {"timeout": 9, "reset": 1}.
+	Retries map[string]int `json:"retries,omitempty"`
+}
+
+// Path returns where the history is kept: history.jsonl next to the config file.
+func Path() (string, error) {
+	path, err := config.Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "history.jsonl"), nil
+}
+
+// Append adds e to the history at path, creating the file if needed.
+func Append(path string, e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding history entry: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating history directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	// A line cut short by a crash must not swallow this entry.
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing history: %w", err)
+	}
+	return f.Close()
+}
+
+// Load reads the history at path, oldest first. A missing file is an empty history;
+// lines that cannot be parsed, e.g. one cut short by a crash, are skipped.
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	return entries, nil
+}
+
+// Month is the data downloaded in one calendar month.
+type Month struct {
+	// Start is midnight on the first day of the month, in local time.
+	Start time.Time
+	Bytes int64
+	Pulls int
+}
+
+// Usage sums the entries by calendar month in local time, oldest first. Months without
+// pulls are left out.
+func Usage(entries []Entry) []Month {
+	var months []Month
+	index := make(map[time.Time]int)
+	for _, e := range entries {
+		start := monthStart(e.Time)
+		i, ok := index[start]
+		if !ok {
+			i = len(months)
+			index[start] = i
+			months = append(months, Month{Start: start})
+		}
+		months[i].Bytes += e.Bytes
+		months[i].Pulls++
+	}
+	slices.SortFunc(months, func(a, b Month) int { return a.Start.Compare(b.Start) })
+	return months
+}
+
+// MonthBytes returns the bytes downloaded in the calendar month of t.
+func MonthBytes(entries []Entry, t time.Time) int64 {
+	start := monthStart(t)
+	var total int64
+	for _, e := range entries {
+		if monthStart(e.Time).Equal(start) {
+			total += e.Bytes
+		}
+	}
+	return total
+}
+
+// monthStart returns midnight on the first day of t's month, in local time.
+func monthStart(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+}
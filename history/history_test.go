@@ -0,0 +1,48 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "history.jsonl")
+	entries, err := Load(path)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "A missing file is an empty history")
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, Append(path, Entry{Time: at, Model: "llama3:latest", Digest: "sha256:b", Previous: "sha256:a", Reason: ReasonWatch}))
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"time":"2024-05-01T13:00:00Z","mod`) // Cut short by a crash.
+	f.Close()
+	assert.NoError(t, Append(path, Entry{Model: "gemma:2b"}))
+
+	entries, err = Load(path)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, Entry{Time: at, Model: "llama3:latest", Digest: "sha256:b", Previous: "sha256:a", Reason: ReasonWatch}, entries[0])
+		assert.Equal(t, "gemma:2b", entries[1].Model)
+		assert.False(t, entries[1].Time.IsZero(), "Append stamps the time")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 12, 0, 0, 0, time.Local) }
+	entries := []Entry{
+		{Time: day(6, 2), Model: "a", Bytes: 300},
+		{Time: day(5, 31), Model: "b", Bytes: 100},
+		{Time: day(6, 20), Model: "c", Bytes: 50},
+		{Time: day(5, 1), Model: "d"},
+	}
+	assert.Equal(t, []Month{
+		{Start: time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local), Bytes: 100, Pulls: 2},
+		{Start: time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), Bytes: 350, Pulls: 2},
+	}, Usage(entries))
+	assert.Equal(t, int64(350), MonthBytes(entries, day(6, 30)))
+	assert.Zero(t, MonthBytes(entries, day(7, 1)))
+}
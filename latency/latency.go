@@ -0,0 +1,175 @@
+// Package latency learns how long each Ollama host takes to answer a pull, and gives the
+// hosts that are regularly slow to answer longer timeouts, e.g. one behind a proxy that
+// buffers the stream or a registry mirror that fetches the manifest first. What it
+// learned is kept in latency.json next to the config file.
+package latency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/config"
+)
+
+const (
+	// keep is how many answers are kept per host; older ones are forgotten, so a host
+	// that got faster loses its longer timeouts again.
+	keep = 10
+	// minSamples is how many answers a host needs before its timeouts are changed.
+	minSamples = 3
+	// slowAnswer is the typical wait for the first byte above which a host counts as slow.
+	slowAnswer = 5 * time.Second
+	// maxAllowance caps the time added to the timeouts of a slow host.
+	maxAllowance = 10 * time.Minute
+)
+
+// Sample is how long a host took to answer one pull attempt.
+type Sample struct {
+	Time    time.Time `json:"time"`
+	Seconds float64   `json:"seconds"`
+	// Missed is set if the attempt timed out after Seconds without an answer.
+	Missed bool `json:"missed,omitempty"`
+}
+
+// wait is how long the sample says the host needs. A host that missed its timeout needs
+// longer than that; it counts as twice the wait, so the timeouts grow quickly until the
+// host gets through.
+func (s Sample) wait() time.Duration {
+	wait := time.Duration(s.Seconds * float64(time.Second))
+	if s.Missed {
+		wait *= 2
+	}
+	return wait
+}
+
+// file is the JSON form of a Book.
+type file struct {
+	Hosts map[string][]Sample `json:"hosts"`
+}
+
+// Book records how long hosts take to answer and tunes their timeouts. It implements
+// client.TimeoutTuner and is safe for concurrent use.
+type Book struct {
+	path string
+
+	mu    sync.Mutex
+	hosts map[string][]Sample
+	// logged remembers the hosts whose tuned timeouts were logged.
+	logged map[string]bool
+}
+
+// Path returns where the book is kept: latency.json next to the config file.
+func Path() (string, error) {
+	path, err := config.Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "latency.json"), nil
+}
+
+// Load reads the book at path. A missing file is an empty book.
+func Load(path string) (*Book, error) {
+	b := &Book{path: path, hosts: make(map[string][]Sample), logged: make(map[string]bool)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading latency book: %w", err)
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for host, samples := range f.Hosts {
+		b.hosts[host] = samples
+	}
+	return b, nil
+}
+
+// Allowance is the time added to the timeouts of host: twice the median of its recent
+// waits for the first byte, rounded up to the second, or zero if it usually answers
+// within slowAnswer or has not answered minSamples times yet.
+func (b *Book) Allowance(host string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return allowance(b.hosts[client.NormalizeHost(host)])
+}
+
+func allowance(samples []Sample) time.Duration {
+	if len(samples) < minSamples {
+		return 0
+	}
+	waits := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		waits[i] = s.wait()
+	}
+	slices.Sort(waits)
+	median := waits[len(waits)/2]
+	if median < slowAnswer {
+		return 0
+	}
+	return min(time.Duration(math.Ceil((2*median).Seconds()))*time.Second, maxAllowance)
+}
+
+// Timeouts lengthens the request and manifest timeouts of host by its Allowance, so they
+// start counting once the host usually answers.
+func (b *Book) Timeouts(host string) client.Timeouts {
+	extra := b.Allowance(host)
+	if extra == 0 {
+		return client.Timeouts{}
+	}
+	b.mu.Lock()
+	if key := client.NormalizeHost(host); !b.logged[key] {
+		b.logged[key] = true
+		log.Printf("%s is slow to answer; allowing it %s more before timing out.", host, extra)
+	}
+	b.mu.Unlock()
+	return client.Timeouts{Request: client.RequestTimeout + extra, Manifest: client.ManifestTimeout + extra}
+}
+
+// FirstByte records an answer of host and saves the book. A book that cannot be saved
+// is logged; the pull goes on.
+func (b *Book) FirstByte(host string, wait time.Duration, missed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	host = client.NormalizeHost(host)
+	samples := append(b.hosts[host], Sample{Time: time.Now().UTC(), Seconds: wait.Seconds(), Missed: missed})
+	if len(samples) > keep {
+		samples = samples[len(samples)-keep:]
+	}
+	b.hosts[host] = samples
+	if err := b.save(); err != nil {
+		log.Printf("Saving the latency book: %v", err)
+	}
+}
+
+// save writes the book to its path. The file is replaced atomically, so pulls running
+// side by side never read half of it.
+func (b *Book) save() error {
+	data, err := json.MarshalIndent(file{Hosts: b.hosts}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding latency book: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return fmt.Errorf("creating latency book directory: %w", err)
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing latency book: %w", err)
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing latency book: %w", err)
+	}
+	return nil
+}
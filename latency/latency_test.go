@@ -0,0 +1,63 @@
+package latency
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ollama-downloader-v2/client"
+)
+
+func TestAllowance(t *testing.T) {
+	samples := func(seconds ...float64) []Sample {
+		var s []Sample
+		for _, sec := range seconds {
+			s = append(s, Sample{Seconds: sec})
+		}
+		return s
+	}
+	assert.Zero(t, allowance(samples(40, 40)), "Too few answers to tell")
+	assert.Zero(t, allowance(samples(0.1, 0.2, 0.1, 60)), "One slow answer is not regular")
+	assert.Equal(t, 70*time.Second, allowance(samples(0.1, 34.2, 35, 41)), "Twice the median, rounded up")
+	assert.Equal(t, maxAllowance, allowance(samples(900, 900, 900)))
+	assert.Equal(t, 40*time.Second, allowance([]Sample{{Seconds: 10, Missed: true}, {Seconds: 10, Missed: true}, {Seconds: 10, Missed: true}}),
+		"A missed timeout counts as twice its wait")
+}
+
+func TestBook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "latency.json")
+	b, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, client.Timeouts{}, b.Timeouts("gpu-box"), "An unknown host keeps the defaults")
+
+	for range keep {
+		b.FirstByte("gpu-box", 2*time.Second, false)
+	}
+	for range minSamples {
+		b.FirstByte("http://slow:11434", 30*time.Second, false)
+	}
+	assert.Equal(t, client.Timeouts{}, b.Timeouts("gpu-box"))
+	assert.Equal(t, client.Timeouts{Request: client.RequestTimeout + time.Minute, Manifest: client.ManifestTimeout + time.Minute},
+		b.Timeouts("slow"), "Hosts are told apart in their normal form")
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, loaded.Allowance("slow:11434"), "The book is saved with every answer")
+
+	for range keep {
+		loaded.FirstByte("slow", 100*time.Millisecond, false)
+	}
+	assert.Zero(t, loaded.Allowance("slow"), "Old answers are forgotten")
+	assert.Len(t, loaded.hosts[client.NormalizeHost("slow")], keep)
+}
+
+func TestLoad_Broken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "latency.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0644))
+	_, err := Load(path)
+	assert.Error(t, err)
+}
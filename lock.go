@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/lockfile"
+	"ollama-downloader-v2/plan"
+	"ollama-downloader-v2/ref"
+)
+
+// lockedDigest returns the digest model is locked to in the lockfile at path, for
+// `pull --locked`.
+func lockedDigest(path, model string) (string, error) {
+	f, err := lockfile.Load(path)
+	if err != nil {
+		return "", err
+	}
+	m, ok := f.Find(model)
+	if !ok {
+		return "", fmt.Errorf("%s is not in %s; pull it with --lock-file to add it", model, path)
+	}
+	digest, err := ref.ParseDigest(m.Digest)
+	if err != nil {
+		return "", fmt.Errorf("lockfile %s: %s: %w", path, m.Ref(), err)
+	}
+	return digest, nil
+}
+
+// recordLock records model as installed on host in the lockfile at path, creating the
+// file if needed. An entry that already has the installed digest is left as it is, so
+// pulling the same version again does not change the file.
+func recordLock(path, host, model string) error {
+	f, err := lockfile.Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		f = &lockfile.File{}
+	} else if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), client.RequestTimeout)
+	defer cancel()
+	installed, err := client.FindInstalled(ctx, host, model)
+	if err != nil {
+		return err
+	}
+	if locked, ok := f.Find(model); ok && client.SameDigest(locked.Digest, installed.Digest) {
+		return nil
+	}
+	name, tag := lockfile.Split(model)
+	f.Set(lockfile.Model{
+		Name:     name,
+		Tag:      tag,
+		Digest:   withAlgorithm(installed.Digest),
+		Size:     installed.Size,
+		PulledAt: time.Now().UTC().Truncate(time.Second),
+	})
+	return f.Write(path)
+}
+
+// unlock removes model from the lockfile at path, if the file exists.
+func unlock(path, model string) error {
+	f, err := lockfile.Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	f.Remove(model)
+	return f.Write(path)
+}
+
+// recordKept records the models plan p keeps in the lockfile at path, so the lockfile
+// covers models that were installed before it existed. It returns the first error but
+// records as many models as it can.
+func recordKept(path string, p plan.Plan) error {
+	var first error
+	for _, c := range p.Changes {
+		if c.Action != plan.ActionKeep {
+			continue
+		}
+		if err := recordLock(path, c.Host, c.Model); err != nil && first == nil {
+			first = fmt.Errorf("recording %s: %w", c.Model, err)
+		}
+	}
+	return first
+}
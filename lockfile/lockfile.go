@@ -0,0 +1,125 @@
+// Package lockfile reads and writes models.lock, which records the exact version of
+// every model a project uses, like a package manager's lockfile, so the set can be
+// reviewed in git and pulled again identically (see `pull --locked`).
+package lockfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"ollama-downloader-v2/ref"
+)
+
+// DefaultPath is the lockfile used when none is named, in the current directory.
+const DefaultPath = "models.lock"
+
+// Version is the format version written to new lockfiles.
+const Version = 1
+
+// Model is one locked model.
+type Model struct {
+	// Name is the model without its tag, e.g. "llama3.1" or "user/model".
+	Name string `json:"name"`
+	Tag  string `json:"tag"`
+	// Digest is the manifest digest, "sha256:…".
+	Digest string `json:"digest"`
+	// Size is the model's size on disk in bytes.
+	Size     int64     `json:"size"`
+	PulledAt time.Time `json:"pulled_at"`
+}
+
+// Ref returns the model as Ollama names it, "name:tag".
+func (m Model) Ref() string {
+	return m.Name + ":" + m.Tag
+}
+
+// File is the content of a lockfile. Models are kept sorted by name and tag, so
+// updates make small diffs.
+type File struct {
+	Version int     `json:"version"`
+	Models  []Model `json:"models"`
+}
+
+// Load reads the lockfile at path. A missing file is an error that matches
+// os.ErrNotExist.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lockfile: %w", err)
+	}
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing lockfile %s: %w", path, err)
+	}
+	if f.Version > Version {
+		return nil, fmt.Errorf("lockfile %s has version %d; this downloader reads up to %d", path, f.Version, Version)
+	}
+	return &f, nil
+}
+
+// Split splits a model reference into the name and tag a Model records, filling in the
+// implicit "latest" tag and dropping the default "library" namespace (see ref.Ref.Name).
+func Split(model string) (name, tag string) {
+	r := ref.Split(model)
+	return r.Name(), r.Tag
+}
+
+// Find returns the locked entry of model; a missing tag means "latest".
+func (f *File) Find(model string) (Model, bool) {
+	name, tag := Split(model)
+	for _, m := range f.Models {
+		if m.Name == name && m.Tag == tag {
+			return m, true
+		}
+	}
+	return Model{}, false
+}
+
+// Set adds m, or replaces the entry with the same name and tag.
+func (f *File) Set(m Model) {
+	for i, existing := range f.Models {
+		if existing.Name == m.Name && existing.Tag == m.Tag {
+			f.Models[i] = m
+			return
+		}
+	}
+	f.Models = append(f.Models, m)
+	sort.Slice(f.Models, func(i, j int) bool {
+		return f.Models[i].Ref() < f.Models[j].Ref()
+	})
+}
+
+// Remove drops the entry of model, if any.
+func (f *File) Remove(model string) {
+	name, tag := Split(model)
+	for i, m := range f.Models {
+		if m.Name == name && m.Tag == tag {
+			f.Models = append(f.Models[:i], f.Models[i+1:]...)
+			return
+		}
+	}
+}
+
+// Write saves the lockfile as indented JSON. The file is replaced atomically, so an
+// interrupted write never leaves half a lockfile behind.
+func (f *File) Write(path string) error {
+	if f.Version == 0 {
+		f.Version = Version
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding lockfile: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing lockfile: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing lockfile: %w", err)
+	}
+	return nil
+}
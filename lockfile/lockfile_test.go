@@ -0,0 +1,44 @@
+package lockfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultPath)
+	_, err := Load(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	pulled := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var f File
+	f.Set(Model{Name: "qwen2", Tag: "7b", Digest: "sha256:bb", Size: 2, PulledAt: pulled})
+	f.Set(Model{Name: "llama3", Tag: "latest", Digest: "sha256:aa", Size: 1, PulledAt: pulled})
+	f.Set(Model{Name: "qwen2", Tag: "7b", Digest: "sha256:cc", Size: 3, PulledAt: pulled})
+	assert.NoError(t, f.Write(path))
+
+	loaded, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, Version, loaded.Version)
+	assert.Equal(t, []Model{
+		{Name: "llama3", Tag: "latest", Digest: "sha256:aa", Size: 1, PulledAt: pulled},
+		{Name: "qwen2", Tag: "7b", Digest: "sha256:cc", Size: 3, PulledAt: pulled},
+	}, loaded.Models, "Entries are sorted and replaced in place")
+
+	m, ok := loaded.Find("llama3")
+	assert.True(t, ok, "A missing tag means latest")
+	assert.Equal(t, "llama3:latest", m.Ref())
+	_, ok = loaded.Find("qwen2")
+	assert.False(t, ok)
+	loaded.Remove("llama3")
+	_, ok = loaded.Find("llama3:latest")
+	assert.False(t, ok)
+
+	os.WriteFile(path, []byte(`{"version": 99, "models": []}`), 0644)
+	_, err = Load(path)
+	assert.ErrorContains(t, err, "version 99")
+}
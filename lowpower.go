@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/power"
+)
+
+// Settings of the low-power mode: the progress UI redraws at most lowPowerFPS times a
+// second instead of Bubble Tea's 60, and progress updates are coalesced to one per
+// lowPowerUpdateInterval.
+const (
+	lowPowerFPS            = 4
+	lowPowerUpdateInterval = time.Second
+)
+
+// powerPoll is how often the battery is checked for --pause-below.
+var powerPoll = 30 * time.Second
+
+// uiFPS caps the redraws per second of the progress UI; zero uses Bubble Tea's default.
+var uiFPS int
+
+type powerFlags struct {
+	lowPower   *bool
+	pauseBelow *int
+}
+
+func addPowerFlags(fs *flag.FlagSet) powerFlags {
+	return powerFlags{
+		lowPower:   fs.Bool("low-power", false, "Redraw the UI less often and coalesce progress updates to save battery; on by default when running on battery"),
+		pauseBelow: fs.Int("pause-below", 0, "Pause the download while on battery below this charge in percent, resuming when plugged in (0 never pauses)"),
+	}
+}
+
+// apply turns on the low-power mode if asked to or if the machine runs on battery, and
+// returns the hold that pauses pulls for --pause-below, or nil. Cancelling ctx stops
+// watching the battery.
+func (f powerFlags) apply(ctx context.Context) (*client.Hold, error) {
+	if *f.pauseBelow < 0 || *f.pauseBelow > 100 {
+		return nil, fmt.Errorf("--pause-below must be between 0 and 100, not %d", *f.pauseBelow)
+	}
+	status, err := power.Read(ctx)
+	if err != nil && !errors.Is(err, power.ErrUnsupported) {
+		log.Printf("Reading the power source: %v", err)
+	}
+	if *f.lowPower || err == nil && status.OnBattery {
+		log.Printf("Low-power mode (%s).", status)
+		uiFPS = lowPowerFPS
+		client.ProgressUpdateInterval = lowPowerUpdateInterval
+	}
+	if *f.pauseBelow == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("--pause-below: %w", err)
+	}
+	hold := client.NewHold()
+	go watchBattery(ctx, hold, *f.pauseBelow, status)
+	return hold, nil
+}
+
+// watchBattery holds pulls while the machine runs on battery below threshold percent
+// and releases them once it is plugged in, checking every powerPoll until ctx is done.
+func watchBattery(ctx context.Context, hold *client.Hold, threshold int, status power.Status) {
+	ticker := time.NewTicker(powerPoll)
+	defer ticker.Stop()
+	for {
+		if status.OnBattery && status.Percent >= 0 && status.Percent < threshold {
+			hold.Set(true, fmt.Sprintf("%s, below %d%%; resumes when plugged in", status, threshold))
+		} else if !status.OnBattery {
+			hold.Set(false, "")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		next, err := power.Read(ctx)
+		if err != nil {
+			log.Printf("Reading the power source: %v", err)
+			continue
+		}
+		status = next
+	}
+}
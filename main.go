@@ -2,125 +2,497 @@ package main
 
 import (
 	"context"
-	"errors"
-	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/config"
+	"ollama-downloader-v2/control"
+	"ollama-downloader-v2/demo"
+	"ollama-downloader-v2/events"
+	"ollama-downloader-v2/history"
+	"ollama-downloader-v2/lockfile"
+	"ollama-downloader-v2/placement"
+	"ollama-downloader-v2/progressfile"
+	"ollama-downloader-v2/prompt"
+	"ollama-downloader-v2/ref"
+	"ollama-downloader-v2/registry"
+	"ollama-downloader-v2/stats"
 	"ollama-downloader-v2/ui"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
+const (
+	// exitVerifyFailed is the exit status when the download succeeded but --verify did not.
+	exitVerifyFailed = 3
+	// exitWarmupFailed is the exit status when the download succeeded but --warmup or
+	// --test-prompt could not load or run the model.
+	exitWarmupFailed = 4
+	// exitDigestMismatch is the exit status when a model pinned by digest is not what the
+	// registry serves or what got installed.
+	exitDigestMismatch = 5
+)
+
+// warmupTimeout bounds loading a model for --warmup and --test-prompt; large models
+// take minutes.
+const warmupTimeout = 10 * time.Minute
+
 func main() {
-	logFile, err := os.OpenFile("ollama-downloader.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
-	}
-	defer logFile.Close()
-	log.SetOutput(logFile)
+	os.Exit(runCLI(os.Args[1:]))
+}
 
+// runPullCommand pulls one model with the progress UI, or headless with --events stdout,
+// and runs the checks asked for once it is in place.
+func runPullCommand(args []string) int {
+	fs := newFlagSet("pull")
 	var modelName string
 	var host string
+	var demoMode bool
+	var verify string
+	var recordPath string
+	var replayPath string
+	var replaySpeed float64
+	var probe bool
+	var alwaysAuth bool
+	var memLimit string
+	var warmup bool
+	var testPrompt string
+	var progressPath string
+	var eventsSpec string
+	var insecure bool
+	var lockPath string
+	var locked bool
+	var placementStrategy string
+	var costPerGB float64
+	var monthlyCap string
+	var restartWait time.Duration
+	var tmuxStatus bool
+	var promptStatus bool
+
+	fs.StringVar(&modelName, "model", "", "The name of the model to download (e.g., 'llama3', or 'llama3@sha256:…' to pin a digest)")
+	fs.StringVar(&modelName, "m", "", "The name of the model to download (shorthand)")
+	fs.StringVar(&host, "host", "", "Ollama API host (e.g., 'http://localhost:11434'). Overrides the global --host and OLLAMA_HOST.")
+	fs.BoolVar(&demoMode, "demo", false, "Run against a built-in fake Ollama server with synthetic progress")
+	fs.StringVar(&recordPath, "record", "", "Record every API response line with timestamps to this file (JSON lines)")
+	fs.StringVar(&replayPath, "replay", "", "Replay a session recorded with --record instead of contacting Ollama")
+	fs.Float64Var(&replaySpeed, "replay-speed", 1, "Speed-up factor for --replay (e.g. 4 plays four times faster)")
+	fs.BoolVar(&probe, "probe", false, "Measure bandwidth to the model registry before pulling to seed the ETA")
+	fs.BoolVar(&alwaysAuth, "auth", false, "Authenticate every request with OLLAMA_API_KEY or ~/.ollama/id_ed25519 (always on for ollama.com)")
+	fs.StringVar(&memLimit, "mem-limit", "", "RAM/VRAM available on the host (e.g. '24GB'); ask before pulling models estimated to need more. Overrides memory_limit in the config.")
+	fs.BoolVar(&warmup, "warmup", false, "Load the model once after a successful pull and report how long loading took")
+	fs.StringVar(&testPrompt, "test-prompt", "", "Run this prompt once after a successful pull and show the start of the answer (e.g. 'Say hi')")
+	fs.StringVar(&progressPath, "progress-file", "", "Rewrite this JSON file every second with phase, percent, speed and ETA for external watchers")
+	fs.StringVar(&eventsSpec, "events", "", "Stream JSON events, one per line: 'stdout' (replaces the UI), 'file:<path>' or 'socket:<path>'")
+	fs.BoolVar(&insecure, "insecure", false, "Let Ollama pull from a registry over plain HTTP, e.g. a cache-server")
+	fs.StringVar(&lockPath, "lock-file", "", "Record the pulled model's digest, size and time in this lockfile, created if missing (e.g. 'models.lock')")
+	fs.BoolVar(&locked, "locked", false, "Refuse to pull anything but the digest recorded in the lockfile (--lock-file, default models.lock)")
+	fs.StringVar(&placementStrategy, "placement", placement.Explicit, "How to pick the host: 'explicit' (--host) or 'least-loaded' among the config's hosts")
+	fs.Float64Var(&costPerGB, "cost-per-gb", 0, "Price of one GB of download on a metered connection; shows the projected and final data cost. Overrides data_cost in the config.")
+	fs.StringVar(&monthlyCap, "monthly-cap", "", "Data allowance per calendar month (e.g. '200GB'); ask before a pull that would go over it. Overrides monthly_cap in the config.")
+	fs.DurationVar(&restartWait, "restart-wait", client.RestartWait, "How long to wait for a host that goes away mid-pull (e.g. an Ollama upgrade) to come back before giving up; 0 gives up at once")
+	fs.BoolVar(&tmuxStatus, "tmux-status", false, "When running in tmux, show the progress in the session's @ollama-progress option for the status line")
+	fs.BoolVar(&promptStatus, "prompt-status", false, "Keep the progress in "+prompt.Path()+" for shell prompts such as starship or powerlevel10k")
+	fs.StringVar(&verify, "verify", "", "Verify the model after pulling: 'digest', 'load' (digest + load) or 'generate' (digest + load + generate)")
+	barOptions := addBarFlags(fs)
+	bell := addBellFlags(fs)
+	powerOpts := addPowerFlags(fs)
+
+	fs.Parse(args)
+	if modelName == "" && fs.NArg() > 0 {
+		// pull <model> [flags]
+		modelName = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 1
+	}
+
+	client.RestartWait = restartWait
+
+	if demoMode {
+		if modelName == "" {
+			modelName = "demo-model"
+		}
+		srv := demo.NewServer()
+		// Stall once and use a short timeout so the retry menu shows up quickly.
+		srv.StallAt = 0.3
+		client.RequestTimeout = 10 * time.Second
+		demoHost, err := srv.Start()
+		if err != nil {
+			log.Printf("Failed to start demo server: %v", err)
+			fmt.Fprintf(os.Stderr, "Error: failed to start demo server: %v\n", err)
+			return 1
+		}
+		defer srv.Close()
+		host = demoHost
+		log.Printf("Demo mode: fake Ollama server listening on %s", host)
+	}
+
+	if replayPath != "" {
+		if modelName == "" {
+			modelName = "replay"
+		}
+		f, err := os.Open(replayPath)
+		if err != nil {
+			log.Printf("Failed to open replay file: %v", err)
+			fmt.Fprintf(os.Stderr, "Error: failed to open replay file: %v\n", err)
+			return 1
+		}
+		replay, err := demo.LoadReplay(f)
+		f.Close()
+		if err != nil {
+			log.Printf("Failed to load replay file: %v", err)
+			fmt.Fprintf(os.Stderr, "Error: failed to load replay file: %v\n", err)
+			return 1
+		}
+		if replaySpeed > 0 {
+			replay.Speed = replaySpeed
+			client.RequestTimeout = time.Duration(float64(client.RequestTimeout) / replaySpeed)
+			client.ManifestTimeout = time.Duration(float64(client.ManifestTimeout) / replaySpeed)
+		}
+		replayHost, err := replay.Start()
+		if err != nil {
+			log.Printf("Failed to start replay server: %v", err)
+			fmt.Fprintf(os.Stderr, "Error: failed to start replay server: %v\n", err)
+			return 1
+		}
+		defer replay.Close()
+		host = replayHost
+		log.Printf("Replaying %d recorded attempts from %s at %.1fx", replay.Attempts(), replayPath, replay.Speed)
+	}
+
+	if recordPath != "" {
+		f, err := os.Create(recordPath)
+		if err != nil {
+			log.Printf("Failed to create record file: %v", err)
+			fmt.Fprintf(os.Stderr, "Error: failed to create record file: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		client.SessionRecorder = client.NewRecorder(io.MultiWriter(f, dumps.API))
+		log.Printf("Recording session to %s", recordPath)
+	}
 
-	flag.StringVar(&modelName, "model", "", "The name of the model to download (e.g., 'llama3')")
-	flag.StringVar(&modelName, "m", "", "The name of the model to download (shorthand)")
-	flag.StringVar(&host, "host", "", "Ollama API host (e.g., 'http://localhost:11434'). Overrides OLLAMA_HOST.")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Ignoring config: %v", err)
+	} else {
+		if resolved := cfg.ResolveAlias(modelName); resolved != modelName {
+			log.Printf("Alias %s resolves to %s", modelName, resolved)
+			modelName = resolved
+		}
+		if memLimit == "" {
+			memLimit = cfg.MemoryLimit
+		}
+		if monthlyCap == "" {
+			monthlyCap = cfg.MonthlyCap
+		}
+	}
 
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s -model <model-name> [flags]\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Flags:\n")
-		flag.PrintDefaults()
+	parsed, err := ref.Parse(modelName)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
 	}
+	modelName, _ = ref.SplitDigest(modelName)
+	pin := parsed.Digest
 
-	flag.Parse()
+	if locked {
+		if lockPath == "" {
+			lockPath = lockfile.DefaultPath
+		}
+		digest, err := lockedDigest(lockPath, modelName)
+		if err != nil {
+			log.Printf("Refusing to pull: %v", err)
+			fmt.Println("Error:", err)
+			return exitDigestMismatch
+		}
+		if pin != "" && pin != digest {
+			fmt.Printf("Error: %s is pinned to %s, but %s locks it to %s\n", modelName, pin, lockPath, digest)
+			return exitDigestMismatch
+		}
+		pin = digest
+	}
+
+	bar, err := barOptions(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		fs.Usage()
+		return 1
+	}
+	notifiers, err := notifiersFromConfig(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	if placementStrategy, err = placement.ParseStrategy(placementStrategy); err != nil {
+		fmt.Println("Error:", err)
+		fs.Usage()
+		return 1
+	}
+	if placementStrategy != placement.Explicit && host != "" {
+		fmt.Printf("Error: --placement %s picks the host; leave out --host\n", placementStrategy)
+		return 1
+	}
+
+	var verifyPolicy client.VerifyPolicy
+	if verify != "" {
+		verifyPolicy, err = client.ParseVerifyPolicy(verify)
+		if err != nil {
+			fmt.Println("Error:", err)
+			fs.Usage()
+			return 1
+		}
+	}
 
 	if modelName == "" {
 		log.Println("Error: model name is required.")
 		fmt.Println("Error: model name is required.")
-		flag.Usage()
-		os.Exit(1)
+		fs.Usage()
+		return 1
 	}
 
-	if host == "" {
-		host = os.Getenv("OLLAMA_HOST")
-		if host == "" {
-			host = "http://localhost:11434"
+	host = resolveHost(host)
+
+	// With events on stdout there is no UI, and messages for people go to stderr so the
+	// stream stays parseable.
+	headless := events.IsStdout(eventsSpec)
+	out := io.Writer(os.Stdout)
+	if headless {
+		out = os.Stderr
+	}
+	var emitter *events.Emitter
+	if eventsSpec != "" {
+		if emitter, err = events.Open(eventsSpec); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
 		}
+		defer emitter.Close()
 	}
 
-	var continueUntilComplete bool
-	var shouldQuit bool
+	client.Authorize = authorizer(cfg, alwaysAuth)
+	if !demoMode && replayPath == "" {
+		learnTimeouts()
+	}
 
-	for {
-		if shouldQuit {
-			break
+	if placementStrategy != placement.Explicit {
+		if host, err = placeHost(out, placementStrategy, cfg); err != nil {
+			log.Printf("Placement failed: %v", err)
+			fmt.Fprintln(out, "Error:", err)
+			return 1
 		}
+	}
 
-		log.Printf("Starting download for model: %s from host: %s", modelName, host)
+	installedAtPin := pin != "" && pinnedInstalled(host, modelName, pin)
+	if pin != "" && !installedAtPin {
+		if err := checkRegistryPin(modelName, pin); err != nil {
+			log.Printf("Refusing to pull: %v", err)
+			fmt.Fprintln(out, "Error:", err)
+			return exitDigestMismatch
+		}
+	}
 
-		ctx, cancel := context.WithCancel(context.Background())
+	var sizes map[string]int64
+	cost := dataCost(costPerGB, cfg)
+	if !demoMode && replayPath == "" && !headless && !installedAtPin {
+		sizes = layerSizes(modelName)
+		printDownloadCost(out, modelName, sizes, cost)
+		var limit int64
+		if memLimit != "" {
+			if limit, err = config.ParseSize(memLimit); err != nil {
+				fmt.Println("Error:", err)
+				return 1
+			}
+		}
+		if !checkMemory(modelName, limit) {
+			log.Println("Pull cancelled: model needs more memory than the host has.")
+			return 1
+		}
+		if store, ok := localStore(host); ok && !checkDiskSpace(out, store, downloadSize(sizes)) {
+			log.Println("Pull cancelled: the model does not fit in the free disk space.")
+			return 1
+		}
+	}
+	if monthlyCap != "" && !demoMode && replayPath == "" && !installedAtPin {
+		limit, err := config.ParseSize(monthlyCap)
+		if err != nil {
+			fmt.Fprintln(out, "Error: monthly cap:", err)
+			return 1
+		}
+		if !checkMonthlyCap(out, downloadSize(sizes), limit) {
+			log.Println("Pull cancelled: it would go over the monthly data cap.")
+			return 1
+		}
+	}
 
-		progressCh := make(chan tea.Msg)
-		quitUICh := make(chan struct{})
-		userChoiceCh := make(chan string) // Unbuffered channel
+	var probedSpeed float64
+	if probe && !demoMode && replayPath == "" {
+		fmt.Fprintln(out, "Measuring bandwidth to the registry...")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		probedSpeed, err = registry.New().Probe(ctx, registry.ParseName(modelName))
+		cancel()
+		if err != nil {
+			log.Printf("Bandwidth probe failed: %v", err)
+			fmt.Fprintln(out, "Bandwidth probe failed, continuing without an estimate.")
+		} else {
+			log.Printf("Bandwidth probe: %.0f bytes/s", probedSpeed)
+		}
+	}
 
-		model := ui.NewModel(modelName, host, cancel, quitUICh, userChoiceCh) // Pass userChoiceCh to UI
-		p := tea.NewProgram(model)
+	pullCtx, cancelPull := context.WithCancel(context.Background())
+	defer cancelPull()
+	var current atomic.Pointer[tea.Program]
+	ctl := listenControl(&current, cancelPull)
+	if ctl != nil {
+		defer ctl.Close()
+		ctl.Update(func(s *control.Status) { s.Model = modelName })
+	}
 
-		go client.PullModel(ctx, modelName, host, progressCh, continueUntilComplete, userChoiceCh)
+	opts := pullOptions{ProbedSpeed: probedSpeed, Control: ctl, Events: emitter, Insecure: insecure, Stats: stats.NewMeter(), Bar: bar, Hosts: configuredHosts(cfg), Notifiers: notifiers}
+	opts.LayerSizes = sizes
+	opts.Tmux = newTmuxStatus(tmuxStatus)
+	if promptStatus {
+		opts.Prompt = prompt.New(prompt.Path())
+	}
+	if opts.Hold, err = powerOpts.apply(pullCtx); err != nil {
+		fmt.Fprintln(out, "Error:", err)
+		return 1
+	}
+	statsCtx, stopStats := context.WithCancel(context.Background())
+	defer stopStats()
+	go opts.Stats.Run(statsCtx)
+	if progressPath != "" {
+		opts.ProgressFile = progressfile.New(progressPath)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go opts.ProgressFile.Run(ctx)
+	}
 
-		go func() {
-			for msg := range progressCh {
-				p.Send(msg)
-			}
-			select {
-			case <-quitUICh: // UI already quit
-			default:
-				p.Send(tea.Quit())
-			}
-		}()
+	var result pullResult
+	switch {
+	case installedAtPin:
+		fmt.Fprintf(out, "%s is already installed at %s.\n", modelName, pin)
+		result = pullResult{Succeeded: true, Host: host}
+	case headless:
+		result = runHeadlessPull(pullCtx, modelName, host, opts)
+	default:
+		ui.SaveWindowTitle(os.Stdout)
+		progressUI := newPullUI(&current)
+		result = progressUI.pull(modelName, host, opts)
+		progressUI.close()
+		ui.RestoreWindowTitle(os.Stdout)
+	}
+	log.Println("Download finished.")
+	host = result.Host
+	if result.Succeeded {
+		reportLayerSpeeds(out, opts.Stats)
+	}
+	reportRetries(out, opts.Stats)
+	reportDataCost(out, opts.Stats, cost)
+	if !demoMode && replayPath == "" && !installedAtPin {
+		recordUsage(modelName, host, opts.Stats.Downloaded(), opts.Stats.Retries(), history.ReasonPull)
+	}
+	if !result.Quit {
+		bell.ring(out)
+	}
 
-		finalModel, err := p.Run()
+	if result.Succeeded && pin != "" && !installedAtPin {
+		ctx, cancel := context.WithTimeout(context.Background(), client.RequestTimeout)
+		err := client.CheckDigest(ctx, host, modelName, pin)
+		cancel()
 		if err != nil {
-			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-				log.Printf("Program exited due to context cancellation/timeout: %v\n", err)
-			} else {
-				log.Printf("Alas, there's been an error: %v\n", err)
-				fmt.Printf("Alas, there's been an error: %v\n", err)
-				os.Exit(1)
-			}
+			log.Printf("Pinned digest check failed: %v", err)
+			fmt.Fprintf(out, "Error: %v\nThe registry changed the model during the pull; the installed version is not the pinned one.\n", err)
+			return exitDigestMismatch
 		}
+		fmt.Fprintf(out, "Installed %s at the pinned %s.\n", modelName, pin)
+	}
+	if result.Succeeded && lockPath != "" && !locked {
+		if err := recordLock(lockPath, host, modelName); err != nil {
+			log.Printf("Updating lockfile: %v", err)
+			fmt.Fprintf(out, "Could not update %s: %v\n", lockPath, err)
+			return 1
+		}
+		fmt.Fprintf(out, "Recorded %s in %s.\n", modelName, lockPath)
+	}
 
-		cancel()
+	var details client.ModelDetails
+	if result.Succeeded && !demoMode && replayPath == "" {
+		details = showModel(out, host, modelName)
+	}
+	if details.Embedding() && (warmup || testPrompt != "") {
+		fmt.Fprintf(out, "Skipping the warmup and test prompt: %s is an embedding model and cannot generate text.\n", modelName)
+		warmup, testPrompt = false, ""
+	}
+	if details.Embedding() && verifyPolicy != "" && verifyPolicy != client.VerifyDigest {
+		fmt.Fprintf(out, "Verifying only the digest: %s is an embedding model and cannot generate text.\n", modelName)
+		verifyPolicy = client.VerifyDigest
+	}
 
-		appModel := finalModel.(ui.Model)
-		selectedChoice := appModel.GetSelectedChoice()
-
-		switch selectedChoice {
-		case "Continue (until next error)":
-			continueUntilComplete = false
-			log.Println("Continuing download (single retry)...")
-		case "Continue (until download completed)":
-			continueUntilComplete = true
-			log.Println("Continuing download (until complete)....")
-		case "Quit":
-			log.Println("Quitting download.")
-			shouldQuit = true
-		default:
-			if continueUntilComplete {
-				log.Println("Download completed successfully.")
-				shouldQuit = true
-			} else {
-				log.Println("Download finished or unknown choice, quitting.")
-				shouldQuit = true
+	if result.Succeeded && verifyPolicy != "" {
+		fmt.Fprintf(out, "Verifying %s (%s)...\n", modelName, verifyPolicy)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		want, err := registry.New().ManifestDigest(ctx, registry.ParseName(modelName))
+		if err != nil {
+			err = &client.VerifyError{Step: client.VerifyDigest, Err: fmt.Errorf("reading the registry's digest: %w", err)}
+		} else {
+			err = client.Verify(ctx, host, modelName, want, verifyPolicy)
+		}
+		if err != nil {
+			log.Printf("Verification failed: %v", err)
+			fmt.Fprintf(out, "Verification failed: %v\n", err)
+			return exitVerifyFailed
+		}
+		if store, ok := localStore(host); ok && !demoMode && replayPath == "" {
+			if err := checkStoredBlobs(out, store, modelName); err != nil {
+				log.Printf("Verification failed: %v", err)
+				fmt.Fprintf(out, "Verification failed: %v\n", err)
+				return exitVerifyFailed
 			}
 		}
-		time.Sleep(100 * time.Millisecond)
+		log.Println("Verification passed.")
+		fmt.Fprintln(out, "Verification passed.")
 	}
 
-	log.Println("Download finished.")
+	if result.Succeeded && warmup {
+		fmt.Fprintf(out, "Warming up %s...\n", modelName)
+		ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+		defer cancel()
+		loadTime, err := client.Warmup(ctx, host, modelName)
+		if err != nil {
+			log.Printf("Warmup failed: %v", err)
+			fmt.Fprintf(out, "Warmup failed: %v\n", err)
+			return exitWarmupFailed
+		}
+		log.Printf("Warmup: %s loaded in %s", modelName, loadTime)
+		fmt.Fprintf(out, "Warmup: %s loaded in %s.\n", modelName, loadTime.Round(100*time.Millisecond))
+	}
+
+	if result.Succeeded && testPrompt != "" {
+		fmt.Fprintf(out, "Test prompt: %s\n", testPrompt)
+		ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+		defer cancel()
+		output, err := client.TestPrompt(ctx, host, modelName, testPrompt)
+		if err != nil {
+			log.Printf("Test prompt failed: %v", err)
+			fmt.Fprintf(out, "Test prompt failed: %v\n", err)
+			return exitWarmupFailed
+		}
+		log.Printf("Test prompt output: %q", output)
+		fmt.Fprintln(out, quoteOutput(output))
+	}
+	// A pull that failed or was stopped skipped the checks above; it must not look like
+	// one that passed them.
+	if !result.Succeeded {
+		return 1
+	}
+	return 0
 }
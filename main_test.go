@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+
+	"ollama-downloader-v2/config"
+)
+
+func TestRunPullCommand_FailedPullWithVerify(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv(config.PathEnv, filepath.Join(dir, "config.json"))
+	t.Setenv("TMPDIR", dir)
+	t.Setenv("OLLAMA_MODELS", dir)
+	defer func(options []tea.ProgramOption) { uiOptions = options }(uiOptions)
+	uiOptions = []tea.ProgramOption{tea.WithInput(nil), tea.WithOutput(io.Discard)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[]}`))
+		case "/api/pull":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"pull model manifest: file does not exist"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	status := runCLI([]string{"pull", "-m", "llama3", "--host", server.URL, "--verify", "digest"})
+	assert.Equal(t, 1, status, "A failed pull is not a verified success")
+}
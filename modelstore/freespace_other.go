@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd)
+
+package modelstore
+
+func freeSpace(string) (int64, error) {
+	return 0, ErrUnsupported
+}
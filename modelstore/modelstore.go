@@ -0,0 +1,107 @@
+// Package modelstore reads the model store of an Ollama server on this machine: the
+// manifests and blobs under OLLAMA_MODELS, and the free space of the filesystem they
+// are on. Ollama's API reports neither.
+package modelstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ollama-downloader-v2/registry"
+)
+
+// Env names the directory Ollama keeps its models in.
+const Env = "OLLAMA_MODELS"
+
+// ErrUnsupported is returned by FreeSpace on systems it cannot query.
+var ErrUnsupported = errors.New("free space is not available on this system")
+
+// Store is a model directory laid out the way Ollama writes it.
+type Store struct {
+	Dir string
+}
+
+// Local returns the store of an Ollama server on this machine: OLLAMA_MODELS as set for
+// this process, or Ollama's default ~/.ollama/models. A server started with another
+// environment, e.g. as a system service, may use a different directory.
+func Local() (Store, error) {
+	if dir := os.Getenv(Env); dir != "" {
+		return Store{Dir: dir}, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return Store{}, fmt.Errorf("finding the models directory: %w", err)
+	}
+	return Store{Dir: filepath.Join(home, ".ollama", "models")}, nil
+}
+
+// FreeSpace returns how many bytes are available on the filesystem of the store. If
+// the directory does not exist yet, the nearest existing parent is asked.
+func (s Store) FreeSpace() (int64, error) {
+	dir := s.Dir
+	for {
+		if _, err := os.Stat(dir); err == nil || !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	free, err := freeSpace(dir)
+	if err != nil {
+		return 0, fmt.Errorf("free space of %s: %w", s.Dir, err)
+	}
+	return free, nil
+}
+
+// ManifestPath is where the manifest of name is stored.
+func (s Store) ManifestPath(name registry.Name) string {
+	ns := name.Namespace
+	// Models from other registries, e.g. hf.co/user/model, start with the registry host.
+	if first, _, _ := strings.Cut(ns, "/"); !strings.Contains(first, ".") {
+		ns = "registry.ollama.ai/" + ns
+	}
+	return filepath.Join(s.Dir, "manifests", filepath.FromSlash(ns), name.Model, name.Tag)
+}
+
+// Manifest reads the manifest of the installed model name.
+func (s Store) Manifest(name registry.Name) (registry.Manifest, error) {
+	data, err := os.ReadFile(s.ManifestPath(name))
+	if err != nil {
+		return registry.Manifest{}, fmt.Errorf("reading manifest of %s: %w", name, err)
+	}
+	var m registry.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return registry.Manifest{}, fmt.Errorf("reading manifest of %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// BlobPath is where the blob with digest ("sha256:…") is stored.
+func (s Store) BlobPath(digest string) string {
+	return filepath.Join(s.Dir, "blobs", strings.Replace(digest, ":", "-", 1))
+}
+
+// CheckBlobs checks that every blob of m, its config included, is on disk with the size
+// the manifest gives, and returns how many it checked. It does not hash them; Ollama
+// verifies the digests as it pulls.
+func (s Store) CheckBlobs(m registry.Manifest) (int, error) {
+	layers := append([]registry.Layer{m.Config}, m.Layers...)
+	for _, l := range layers {
+		st, err := os.Stat(s.BlobPath(l.Digest))
+		if err != nil {
+			return 0, fmt.Errorf("blob %s: %w", l.Digest, err)
+		}
+		if st.Size() != l.Size {
+			return 0, fmt.Errorf("blob %s is %d bytes on disk, the manifest says %d", l.Digest, st.Size(), l.Size)
+		}
+	}
+	return len(layers), nil
+}
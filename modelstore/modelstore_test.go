@@ -0,0 +1,63 @@
+package modelstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ollama-downloader-v2/registry"
+)
+
+func TestLocal(t *testing.T) {
+	t.Setenv(Env, "/data/ollama")
+	s, err := Local()
+	require.NoError(t, err)
+	assert.Equal(t, "/data/ollama", s.Dir)
+
+	t.Setenv(Env, "")
+	t.Setenv("HOME", "/home/lab")
+	s, err = Local()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("/home/lab", ".ollama", "models"), s.Dir)
+}
+
+func TestStore_ManifestAndBlobs(t *testing.T) {
+	s := Store{Dir: t.TempDir()}
+	name := registry.ParseName("llama3:8b")
+	path := s.ManifestPath(name)
+	assert.Equal(t, filepath.Join(s.Dir, "manifests", "registry.ollama.ai", "library", "llama3", "8b"), path)
+	assert.Equal(t, filepath.Join(s.Dir, "blobs", "sha256-abc"), s.BlobPath("sha256:abc"))
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(`{"config":{"digest":"sha256:cfg","size":2},"layers":[{"digest":"sha256:abc","size":5}]}`), 0o644))
+	m, err := s.Manifest(name)
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(s.Dir, "blobs"), 0o755))
+	require.NoError(t, os.WriteFile(s.BlobPath("sha256:cfg"), []byte("{}"), 0o644))
+	_, err = s.CheckBlobs(m)
+	assert.ErrorIs(t, err, os.ErrNotExist, "A missing blob should fail the check")
+
+	require.NoError(t, os.WriteFile(s.BlobPath("sha256:abc"), []byte("abc"), 0o644))
+	_, err = s.CheckBlobs(m)
+	assert.ErrorContains(t, err, "is 3 bytes on disk, the manifest says 5")
+
+	require.NoError(t, os.WriteFile(s.BlobPath("sha256:abc"), []byte("abcde"), 0o644))
+	n, err := s.CheckBlobs(m)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestStore_FreeSpace(t *testing.T) {
+	s := Store{Dir: filepath.Join(t.TempDir(), "not", "created")}
+	free, err := s.FreeSpace()
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	assert.NoError(t, err)
+	assert.Positive(t, free, "A directory that does not exist yet should be measured on its parent")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/ui"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// runMonitorCommand shows the progress of a pull of a model that another client, e.g.
+// the ollama CLI, started on the host. Ollama lets a second /api/pull of the same model
+// join the running download, so monitor pulls too, but only to watch: it reconnects on
+// its own and leaves no trace in the history, and quitting it does not stop the
+// download, which Ollama runs for whichever client started it.
+func runMonitorCommand(args []string) int {
+	fs := newFlagSet("monitor")
+	host := fs.String("host", "", "Ollama API host. Overrides the global --host and OLLAMA_HOST.")
+	barOptions := addBarFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	model := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Ignoring config: %v", err)
+	} else {
+		model = cfg.ResolveAlias(model)
+	}
+	bar, err := barOptions(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	target := resolveHost(*host)
+	client.Authorize = authorizer(cfg, false)
+
+	fmt.Printf("Monitoring the pull of %s on %s. Quitting stops watching, not the pull.\n", model, target)
+	opts := pullOptions{AutoRetry: true, LayerSizes: layerSizes(model), Bar: bar}
+	var current atomic.Pointer[tea.Program]
+	ui.SaveWindowTitle(os.Stdout)
+	progressUI := newPullUI(&current)
+	result := progressUI.pull(model, target, opts)
+	progressUI.close()
+	ui.RestoreWindowTitle(os.Stdout)
+
+	switch {
+	case result.Succeeded:
+		fmt.Printf("%s is installed on %s.\n", model, result.Host)
+	case result.Quit:
+		fmt.Println("Stopped monitoring.")
+	default:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ollama-downloader-v2/config"
+	"ollama-downloader-v2/notify"
+	"ollama-downloader-v2/plan"
+	"ollama-downloader-v2/stats"
+	"ollama-downloader-v2/ui"
+)
+
+// notifyTimeout bounds sending the messages at the end of a pull, so a chat service that
+// does not answer holds up neither the next pull nor the exit.
+const notifyTimeout = 15 * time.Second
+
+// notifiersFromConfig returns the notifiers set up under "notify" in cfg.
+func notifiersFromConfig(cfg *config.Config) ([]*notify.Notifier, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	var notifiers []*notify.Notifier
+	for i, c := range cfg.Notify {
+		var sender notify.Sender
+		switch c.Type {
+		case "pushover":
+			sender = notify.Pushover{Token: c.Token, User: c.To}
+		case "telegram":
+			sender = notify.Telegram{Token: c.Token, ChatID: c.To}
+		case "slack":
+			sender = notify.Slack{Token: c.Token, Channel: c.To}
+		case "webhook":
+			sender = notify.Webhook{URL: c.To, ContentType: c.ContentType, Token: c.Token}
+		default:
+			return nil, fmt.Errorf("notifier %d: unknown type %q (want pushover, telegram, slack or webhook)", i+1, c.Type)
+		}
+		success, failure := c.SuccessTemplate, c.FailureTemplate
+		if c.Type == "webhook" {
+			if u, err := url.Parse(c.To); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return nil, fmt.Errorf("notifier %d (webhook) needs an http or https URL as its to, not %q", i+1, c.To)
+			}
+			success, failure = cmp.Or(success, notify.DefaultWebhook), cmp.Or(failure, notify.DefaultWebhook)
+		} else if c.Token == "" || c.To == "" {
+			return nil, fmt.Errorf("notifier %d (%s) needs a token and a to", i+1, c.Type)
+		}
+		n, err := notify.New(c.Type, sender, c.On, success, failure)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %d: %w", i+1, err)
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+// sendNotifications tells the notifiers how the pull of model ended, unless the user
+// stopped it. downloaded is the bytes it downloaded and elapsed how long it took, 0 if
+// unknown. Messages that cannot be sent are only logged.
+func sendNotifications(notifiers []*notify.Notifier, model string, result pullResult, downloaded int64, elapsed time.Duration) {
+	if len(notifiers) == 0 || result.Quit {
+		return
+	}
+	e := notify.Event{Model: model, Host: result.Host, Succeeded: result.Succeeded, Bytes: downloaded, Duration: elapsed, Time: time.Now()}
+	if downloaded > 0 {
+		e.Downloaded = ui.FormatBytes(downloaded)
+	}
+	switch {
+	case result.Succeeded:
+	case result.Err != nil:
+		e.Error = result.Err.Error()
+	default:
+		e.Error = "pull did not complete"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := notify.NotifyAll(ctx, notifiers, e); err != nil {
+		log.Printf("Sending notifications: %v", err)
+	}
+}
+
+// reportMail is the summary mail of an apply, see mailReport.
+type reportMail struct {
+	email notify.Email
+	// onlyFailures skips applies in which no change failed.
+	onlyFailures bool
+}
+
+// reportMailFromConfig returns the summary mail set up under "email" in cfg, or nil if
+// there is none.
+func reportMailFromConfig(cfg *config.Config) (*reportMail, error) {
+	if cfg == nil || cfg.Email == nil {
+		return nil, nil
+	}
+	e := cfg.Email
+	if e.SMTP == "" || e.From == "" || len(e.To) == 0 {
+		return nil, fmt.Errorf("email needs an smtp server, a from and a to address")
+	}
+	if e.On != "" && e.On != notify.OnFailure {
+		return nil, fmt.Errorf("email: on must be %q or empty, not %q", notify.OnFailure, e.On)
+	}
+	return &reportMail{
+		email:        notify.Email{Addr: e.SMTP, Username: e.Username, Password: e.Password, From: e.From, To: e.To},
+		onlyFailures: e.On == notify.OnFailure,
+	}, nil
+}
+
+// send mails the summary of report: every change with its outcome, duration and
+// download, and the totals. A mail that cannot be sent is logged and printed.
+func (m *reportMail) send(report plan.Report, quit bool) {
+	if m == nil || (m.onlyFailures && report.Failed() == 0 && !quit) {
+		return
+	}
+	counts := map[plan.Outcome]int{}
+	for _, res := range report.Results {
+		counts[res.Outcome]++
+	}
+	subject := fmt.Sprintf("apply %s on %s: %d done, %d failed, %d skipped", report.Manifest, report.Host,
+		counts[plan.OutcomeDone], counts[plan.OutcomeFailed], counts[plan.OutcomeSkipped])
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Apply of %s on %s", report.Manifest, report.Host)
+	if quit {
+		body.WriteString(", stopped before the end")
+	}
+	fmt.Fprintf(&body, ".\nStarted %s, took %s, downloaded %s.\n\n", report.StartedAt.Format(time.DateTime),
+		report.FinishedAt.Sub(report.StartedAt).Round(time.Second), ui.FormatBytes(report.Downloaded()))
+	tw := tabwriter.NewWriter(&body, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OUTCOME\tACTION\tMODEL\tDURATION\tDOWNLOADED\tRETRIES")
+	for _, res := range report.Results {
+		downloaded, retries := "", ""
+		if res.Bytes > 0 {
+			downloaded = ui.FormatBytes(res.Bytes)
+		}
+		if causes := stats.RetryCounts(res.RetryCauses); causes.Total() > 0 {
+			retries = causes.String()
+		}
+		duration := time.Duration(res.Duration * float64(time.Second)).Round(time.Second)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", res.Outcome, res.Action, res.Model, duration, downloaded, retries)
+	}
+	tw.Flush()
+	for _, res := range report.Results {
+		if res.Error != "" {
+			fmt.Fprintf(&body, "\n%s: %s", res.Model, res.Error)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := m.email.Send(ctx, subject, body.String()); err != nil {
+		log.Printf("Mailing the summary: %v", err)
+		fmt.Println("Could not mail the summary:", err)
+		return
+	}
+	fmt.Printf("Summary mailed to %s\n", strings.Join(m.email.To, ", "))
+}
@@ -0,0 +1,293 @@
+// Package notify sends a message to a chat service or webhook when a pull succeeds or
+// fails, for long pulls nobody watches: Pushover, Telegram, Slack and plain HTTP.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"ollama-downloader-v2/useragent"
+)
+
+// Base URLs of the services' APIs; tests point them at a local server.
+var (
+	PushoverURL = "https://api.pushover.net"
+	TelegramURL = "https://api.telegram.org"
+	SlackURL    = "https://slack.com"
+)
+
+// Default message templates, see Event for the fields.
+const (
+	DefaultSuccess = `Pulled {{.Model}} on {{.Host}}{{if .Downloaded}} ({{.Downloaded}} downloaded){{end}}.`
+	DefaultFailure = `Pull of {{.Model}} on {{.Host}} failed: {{.Error}}`
+	// DefaultWebhook is the body a Webhook posts for both outcomes.
+	DefaultWebhook = `{"model":{{json .Model}},"host":{{json .Host}},"succeeded":{{.Succeeded}},"error":{{json .Error}},` +
+		`"bytes":{{.Bytes}},"duration_seconds":{{.Duration.Seconds}},"time":{{json .Time}}}`
+)
+
+// When a Notifier sends its message.
+const (
+	OnAlways  = ""
+	OnSuccess = "success"
+	OnFailure = "failure"
+)
+
+// Event is the end of a pull, which the message templates are executed with.
+type Event struct {
+	Model string
+	Host  string
+	// Succeeded is false if the pull failed; Error then says why.
+	Succeeded bool
+	Error     string
+	// Downloaded is how much the pull downloaded, formatted for display (e.g. "4.7 GB"),
+	// or empty if it is unknown. Bytes is the same number unformatted, or 0.
+	Downloaded string
+	Bytes      int64
+	// Duration is how long the pull took, or 0 if it is unknown.
+	Duration time.Duration
+	// Time is when the pull ended.
+	Time time.Time
+}
+
+// funcs are the functions the templates can call besides Go's built-in ones: json
+// encodes a value as JSON, e.g. a string with its quotes, for webhook bodies.
+var funcs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Sender delivers a text message to one chat service.
+type Sender interface {
+	Send(ctx context.Context, text string) error
+}
+
+// Notifier sends the message for an event with its Sender.
+type Notifier struct {
+	// Name identifies the notifier in errors, e.g. "telegram".
+	Name    string
+	Sender  Sender
+	On      string
+	success *template.Template
+	failure *template.Template
+}
+
+// New returns a notifier that sends with s on the events on selects (OnAlways,
+// OnSuccess or OnFailure). Empty templates use DefaultSuccess and DefaultFailure.
+func New(name string, s Sender, on, success, failure string) (*Notifier, error) {
+	if on != OnAlways && on != OnSuccess && on != OnFailure {
+		return nil, fmt.Errorf("%s: on must be %q or %q, not %q", name, OnSuccess, OnFailure, on)
+	}
+	n := &Notifier{Name: name, Sender: s, On: on}
+	var err error
+	if n.success, err = parseTemplate(name+" success", success, DefaultSuccess); err != nil {
+		return nil, err
+	}
+	if n.failure, err = parseTemplate(name+" failure", failure, DefaultFailure); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func parseTemplate(name, text, def string) (*template.Template, error) {
+	if text == "" {
+		text = def
+	}
+	t, err := template.New(name).Option("missingkey=error").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s template: %w", name, err)
+	}
+	return t, nil
+}
+
+// Notify sends the message for e, unless the notifier is not meant for it.
+func (n *Notifier) Notify(ctx context.Context, e Event) error {
+	if (n.On == OnSuccess && !e.Succeeded) || (n.On == OnFailure && e.Succeeded) {
+		return nil
+	}
+	t := n.success
+	if !e.Succeeded {
+		t = n.failure
+	}
+	var text strings.Builder
+	if err := t.Execute(&text, e); err != nil {
+		return fmt.Errorf("%s: %w", n.Name, err)
+	}
+	if err := n.Sender.Send(ctx, text.String()); err != nil {
+		return fmt.Errorf("%s: %w", n.Name, err)
+	}
+	return nil
+}
+
+// NotifyAll sends e with every notifier and returns their errors joined.
+func NotifyAll(ctx context.Context, notifiers []*Notifier, e Event) error {
+	var errs []error
+	for _, n := range notifiers {
+		errs = append(errs, n.Notify(ctx, e))
+	}
+	return errors.Join(errs...)
+}
+
+// Pushover sends push notifications through a Pushover application.
+type Pushover struct {
+	// Token is the application's API token, User the user or group key to notify.
+	Token string
+	User  string
+}
+
+// Send implements Sender.
+func (p Pushover) Send(ctx context.Context, text string) error {
+	form := url.Values{"token": {p.Token}, "user": {p.User}, "title": {"ollama-downloader"}, "message": {text}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, PushoverURL+"/1/messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var res struct {
+		Status int      `json:"status"`
+		Errors []string `json:"errors"`
+	}
+	if err := do(req, &res); err != nil {
+		return err
+	}
+	if res.Status != 1 {
+		return fmt.Errorf("pushover refused the message: %s", strings.Join(res.Errors, "; "))
+	}
+	return nil
+}
+
+// Telegram sends messages from a Telegram bot.
+type Telegram struct {
+	// Token is the bot token from @BotFather, ChatID the chat the bot writes to.
+	Token  string
+	ChatID string
+}
+
+// Send implements Sender.
+func (t Telegram) Send(ctx context.Context, text string) error {
+	body := map[string]string{"chat_id": t.ChatID, "text": text}
+	req, err := newJSONRequest(ctx, TelegramURL+"/bot"+t.Token+"/sendMessage", body)
+	if err != nil {
+		return err
+	}
+	var res struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := do(req, &res); err != nil {
+		// The token is part of the URL, which net/http errors quote.
+		return errors.New(strings.ReplaceAll(err.Error(), t.Token, "<token>"))
+	}
+	if !res.OK {
+		return fmt.Errorf("telegram refused the message: %s", res.Description)
+	}
+	return nil
+}
+
+// Slack posts messages as a Slack app's bot user.
+type Slack struct {
+	// Token is the bot token ("xoxb-…"), Channel the channel ID or name to post in.
+	Token   string
+	Channel string
+}
+
+// Send implements Sender.
+func (s Slack) Send(ctx context.Context, text string) error {
+	body := map[string]string{"channel": s.Channel, "text": text}
+	req, err := newJSONRequest(ctx, SlackURL+"/api/chat.postMessage", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	var res struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := do(req, &res); err != nil {
+		return err
+	}
+	if !res.OK {
+		return fmt.Errorf("slack refused the message: %s", res.Error)
+	}
+	return nil
+}
+
+// Webhook posts the message as the request body to a URL, for alerting systems and
+// services without a Sender of their own. Any 2xx answer counts as delivered.
+type Webhook struct {
+	URL string
+	// ContentType is the type of the body; empty sends application/json, which suits
+	// DefaultWebhook.
+	ContentType string
+	// Token, if set, is sent as a bearer token.
+	Token string
+}
+
+// Send implements Sender.
+func (w Webhook) Send(ctx context.Context, text string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, strings.NewReader(text))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	contentType := w.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+	useragent.Set(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook refused the message: status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+func newJSONRequest(ctx context.Context, url string, body any) (*http.Request, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// do sends req and decodes the JSON answer into out. The services answer errors with a
+// JSON body too, so any status is decoded.
+func do(req *http.Request, out any) error {
+	useragent.Set(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
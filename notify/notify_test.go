@@ -0,0 +1,230 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSender records the messages it is asked to send.
+type fakeSender struct {
+	sent []string
+	err  error
+}
+
+func (f *fakeSender) Send(_ context.Context, text string) error {
+	f.sent = append(f.sent, text)
+	return f.err
+}
+
+func TestNotifier_Templates(t *testing.T) {
+	s := &fakeSender{}
+	n, err := New("test", s, OnAlways, "", "{{.Model}} broke: {{.Error}}")
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, n.Notify(ctx, Event{Model: "llama3:8b", Host: "http://gpu:11434", Succeeded: true, Downloaded: "4.7 GB"}))
+	assert.NoError(t, n.Notify(ctx, Event{Model: "llama3:8b", Host: "http://gpu:11434", Succeeded: true}))
+	assert.NoError(t, n.Notify(ctx, Event{Model: "llama3:8b", Error: "disk full"}))
+	assert.Equal(t, []string{
+		"Pulled llama3:8b on http://gpu:11434 (4.7 GB downloaded).",
+		"Pulled llama3:8b on http://gpu:11434.",
+		"llama3:8b broke: disk full",
+	}, s.sent)
+
+	_, err = New("test", s, OnAlways, "{{.Model", "")
+	assert.ErrorContains(t, err, "parsing test success template")
+	_, err = New("test", s, "sometimes", "", "")
+	assert.Error(t, err, "An unknown on should be rejected")
+
+	n, err = New("test", s, OnAlways, "{{.Size}}", "")
+	assert.NoError(t, err)
+	assert.Error(t, n.Notify(ctx, Event{Succeeded: true}), "A template naming a field Event lacks should fail")
+}
+
+func TestNotifier_On(t *testing.T) {
+	ctx := context.Background()
+	s := &fakeSender{}
+	onFailure, _ := New("test", s, OnFailure, "success", "failure")
+	onSuccess, _ := New("test", s, OnSuccess, "success", "failure")
+	for _, n := range []*Notifier{onFailure, onSuccess} {
+		n.Notify(ctx, Event{Succeeded: true})
+		n.Notify(ctx, Event{})
+	}
+	assert.Equal(t, []string{"failure", "success"}, s.sent)
+
+	broken := &fakeSender{err: errors.New("offline")}
+	n, _ := New("slack", broken, OnAlways, "", "")
+	err := NotifyAll(ctx, []*Notifier{onSuccess, n}, Event{Succeeded: true})
+	assert.EqualError(t, err, "slack: offline")
+}
+
+func TestSenders(t *testing.T) {
+	var got []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]string{"path": r.URL.Path, "auth": r.Header.Get("Authorization")}
+		if r.Header.Get("Content-Type") == "application/json" {
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			for k, v := range body {
+				req[k] = v
+			}
+		} else {
+			r.ParseForm()
+			for k := range r.PostForm {
+				req[k] = r.PostForm.Get(k)
+			}
+		}
+		got = append(got, req)
+		switch {
+		case r.URL.Path == "/1/messages.json" && req["token"] == "bad":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":0,"errors":["application token is invalid"]}`))
+		case r.URL.Path == "/1/messages.json":
+			w.Write([]byte(`{"status":1}`))
+		case r.URL.Path == "/botBAD/sendMessage":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"ok":false,"description":"Unauthorized"}`))
+		default:
+			w.Write([]byte(`{"ok":true}`))
+		}
+	}))
+	defer server.Close()
+	defer func(p, t, s string) { PushoverURL, TelegramURL, SlackURL = p, t, s }(PushoverURL, TelegramURL, SlackURL)
+	PushoverURL, TelegramURL, SlackURL = server.URL, server.URL, server.URL
+
+	ctx := context.Background()
+	assert.NoError(t, Pushover{Token: "app", User: "user"}.Send(ctx, "hi"))
+	assert.NoError(t, Telegram{Token: "123:abc", ChatID: "42"}.Send(ctx, "hi"))
+	assert.NoError(t, Slack{Token: "xoxb-1", Channel: "#models"}.Send(ctx, "hi"))
+	assert.Equal(t, []map[string]string{
+		{"path": "/1/messages.json", "auth": "", "token": "app", "user": "user", "title": "ollama-downloader", "message": "hi"},
+		{"path": "/bot123:abc/sendMessage", "auth": "", "chat_id": "42", "text": "hi"},
+		{"path": "/api/chat.postMessage", "auth": "Bearer xoxb-1", "channel": "#models", "text": "hi"},
+	}, got)
+
+	assert.EqualError(t, Pushover{Token: "bad", User: "user"}.Send(ctx, "hi"), "pushover refused the message: application token is invalid")
+	assert.EqualError(t, Telegram{Token: "BAD", ChatID: "42"}.Send(ctx, "hi"), "telegram refused the message: Unauthorized")
+
+	TelegramURL = "http://127.0.0.1:1"
+	err := Telegram{Token: "secret-token", ChatID: "42"}.Send(ctx, "hi")
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "secret-token", "The bot token should not end up in logs")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	type request struct{ contentType, auth, body string }
+	var got []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, request{r.Header.Get("Content-Type"), r.Header.Get("Authorization"), string(body)})
+		if r.URL.Path == "/down" {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	n, err := New("webhook", Webhook{URL: server.URL + "/hook", Token: "s3cret"}, OnAlways, DefaultWebhook, DefaultWebhook)
+	assert.NoError(t, err)
+	end := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, n.Notify(ctx, Event{Model: "llama3", Host: "http://gpu:11434", Error: `disk "full"`, Bytes: 4096, Duration: 90 * time.Second, Time: end}))
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "application/json", got[0].contentType)
+		assert.Equal(t, "Bearer s3cret", got[0].auth)
+		assert.JSONEq(t, `{"model":"llama3","host":"http://gpu:11434","succeeded":false,"error":"disk \"full\"",`+
+			`"bytes":4096,"duration_seconds":90,"time":"2026-10-16T12:00:00Z"}`, got[0].body)
+	}
+
+	text := Webhook{URL: server.URL, ContentType: "text/plain"}
+	assert.NoError(t, text.Send(ctx, "pulled"))
+	assert.Equal(t, request{"text/plain", "", "pulled"}, got[1])
+
+	assert.EqualError(t, Webhook{URL: server.URL + "/down"}.Send(ctx, "{}"), "webhook refused the message: status 503: maintenance")
+}
+
+// fakeSMTP accepts one mail on a local port and sends the commands and message it
+// received on the returned channel. It offers neither STARTTLS nor AUTH.
+func fakeSMTP(t *testing.T) (string, <-chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { l.Close() })
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+		for data := false; ; {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				received <- lines
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case data && line == ".":
+				data = false
+				fmt.Fprint(conn, "250 queued\r\n")
+			case data:
+			case strings.HasPrefix(line, "EHLO"):
+				fmt.Fprint(conn, "250-localhost\r\n250 8BITMIME\r\n")
+			case line == "DATA":
+				data = true
+				fmt.Fprint(conn, "354 go ahead\r\n")
+			case line == "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				received <- lines
+				return
+			default:
+				fmt.Fprint(conn, "250 ok\r\n")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestEmail_Send(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	e := Email{Addr: addr, From: "downloader@lab.example", To: []string{"admin@lab.example", "ops@lab.example"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, e.Send(ctx, "apply models.yaml: 2 done, 1 failed", "line one\nline two"))
+
+	lines := <-received
+	assert.Contains(t, lines, "MAIL FROM:<downloader@lab.example> BODY=8BITMIME")
+	assert.Contains(t, lines, "RCPT TO:<admin@lab.example>")
+	assert.Contains(t, lines, "RCPT TO:<ops@lab.example>")
+	assert.Contains(t, lines, "Subject: apply models.yaml: 2 done, 1 failed")
+	assert.Contains(t, lines, "To: admin@lab.example, ops@lab.example")
+	assert.Contains(t, lines, "line two")
+
+	assert.Error(t, Email{Addr: addr, From: "a@b"}.Send(ctx, "s", "b"), "A mail without recipients should be refused")
+}
+
+func TestEmail_Message(t *testing.T) {
+	e := Email{From: "a@example.com", To: []string{"b@example.com"}}
+	msg := string(e.message("Pulled llama3 ✓", "one\ntwo\r\n", time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)))
+	assert.Contains(t, msg, "Subject: =?utf-8?q?Pulled_llama3_=E2=9C=93?=\r\n", "A non-ASCII subject should be encoded")
+	assert.Contains(t, msg, "Date: Fri, 16 Oct 2026 12:00:00 +0000\r\n")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\none\r\ntwo\r\n"), "The body should have CRLF line endings: %q", msg)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/registry"
+)
+
+// checkRegistryPin returns an ErrDigestMismatch if the registry now serves model with
+// another manifest digest than pin, so pulling it would install something else.
+// Ollama pulls by tag only, so this is the only chance to refuse before the download.
+// A registry that cannot be asked, e.g. another one than Ollama's, is only logged; the
+// installed digest is still checked after the pull.
+func checkRegistryPin(model, pin string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	remote, err := registry.New().ManifestDigest(ctx, registry.ParseName(model))
+	if err != nil {
+		log.Printf("Cannot check the pinned digest of %s before pulling: %v", model, err)
+		return nil
+	}
+	if !client.SameDigest(remote, pin) {
+		return fmt.Errorf("%w: the registry serves %s as %s, pinned %s", client.ErrDigestMismatch, model, remote, pin)
+	}
+	return nil
+}
+
+// pinnedInstalled reports whether model is already installed on host at the digest pin,
+// so there is nothing to pull.
+func pinnedInstalled(host, model, pin string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), client.RequestTimeout)
+	defer cancel()
+	err := client.CheckDigest(ctx, host, model, pin)
+	if err != nil {
+		log.Printf("Pinned %s: %v", model, err)
+	}
+	return err == nil
+}
+
+// withAlgorithm returns digest with its "sha256:" prefix, or "" for no digest.
+func withAlgorithm(digest string) string {
+	if digest == "" || strings.HasPrefix(digest, "sha256:") {
+		return digest
+	}
+	return "sha256:" + digest
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/config"
+	"ollama-downloader-v2/placement"
+	"ollama-downloader-v2/ui"
+)
+
+// placementTimeout bounds asking the candidate hosts about their load.
+const placementTimeout = 10 * time.Second
+
+// placeHost asks the hosts listed in cfg about their load, prints what it found to w and
+// returns the host strategy picks.
+func placeHost(w io.Writer, strategy string, cfg *config.Config) (string, error) {
+	if cfg == nil || len(cfg.Hosts) == 0 {
+		return "", errors.New(`--placement needs the candidate hosts as "hosts" in the config file`)
+	}
+	hosts := configuredHosts(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), placementTimeout)
+	defer cancel()
+	probed := placement.Probe(ctx, hosts)
+	fmt.Fprintf(w, "Choosing a host (%s):\n", strategy)
+	for _, h := range probed {
+		if h.Err != nil {
+			log.Printf("Placement: %s: %v", h.URL, h.Err)
+			fmt.Fprintf(w, "  %s: unreachable\n", h.URL)
+			continue
+		}
+		log.Printf("Placement: %s runs Ollama %s with %d models loaded (%d bytes)", h.URL, h.Version, h.Running, h.Loaded)
+		fmt.Fprintf(w, "  %s: Ollama %s, %d models loaded (%s)\n", h.URL, h.Version, h.Running, ui.FormatBytes(h.Loaded))
+	}
+	picked, err := placement.Pick(strategy, probed)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(w, "Pulling to %s.\n", picked.URL)
+	return picked.URL, nil
+}
+
+// configuredHosts returns the hosts listed in cfg, normalized with client.NormalizeHost.
+func configuredHosts(cfg *config.Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	hosts := make([]string, len(cfg.Hosts))
+	for i, h := range cfg.Hosts {
+		hosts[i] = client.NormalizeHost(h)
+	}
+	return hosts
+}
@@ -0,0 +1,108 @@
+// Package placement picks which of several Ollama hosts a pull goes to, for small
+// clusters without an orchestrator.
+package placement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"ollama-downloader-v2/client"
+)
+
+// Strategies for Pick.
+const (
+	// Explicit pulls to the host given with --host, as without placement.
+	Explicit = "explicit"
+	// LeastLoaded pulls to the host with the least memory taken by running models.
+	LeastLoaded = "least-loaded"
+)
+
+// Strategies lists the strategies ParseStrategy accepts.
+var Strategies = []string{Explicit, LeastLoaded}
+
+// ParseStrategy checks a --placement value.
+func ParseStrategy(s string) (string, error) {
+	switch s {
+	case Explicit, LeastLoaded:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown placement %q (want %s)", s, strings.Join(Strategies, " or "))
+	}
+}
+
+// Host is what Probe learned about one candidate host.
+type Host struct {
+	URL     string
+	Version string
+	// Running is how many models the host has loaded, taking Loaded bytes of memory.
+	Running int
+	Loaded  int64
+	// Err is set if the host could not be queried; it is never picked.
+	Err error
+}
+
+// Probe asks every host for its version and running models, all at once.
+func Probe(ctx context.Context, hosts []string) []Host {
+	out := make([]Host, len(hosts))
+	var wg sync.WaitGroup
+	for i, url := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out[i] = probe(ctx, url)
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+func probe(ctx context.Context, url string) Host {
+	h := Host{URL: url}
+	if h.Version, h.Err = client.Version(ctx, url); h.Err != nil {
+		return h
+	}
+	running, err := client.RunningModels(ctx, url)
+	if err != nil {
+		h.Err = fmt.Errorf("listing running models: %w", err)
+		return h
+	}
+	h.Running = len(running)
+	for _, m := range running {
+		h.Loaded += m.Size
+	}
+	return h
+}
+
+// ErrNoHost is returned when none of the candidate hosts answered.
+var ErrNoHost = errors.New("no candidate host is reachable")
+
+// Pick chooses the host to pull to among the hosts that answered. For LeastLoaded that is
+// the one with the fewest bytes in memory, then the fewest running models; ties go to the
+// host listed first.
+func Pick(strategy string, hosts []Host) (Host, error) {
+	var up []Host
+	for _, h := range hosts {
+		if h.Err == nil {
+			up = append(up, h)
+		}
+	}
+	if len(up) == 0 {
+		return Host{}, ErrNoHost
+	}
+	switch strategy {
+	case LeastLoaded:
+		sort.SliceStable(up, func(i, j int) bool {
+			if up[i].Loaded != up[j].Loaded {
+				return up[i].Loaded < up[j].Loaded
+			}
+			return up[i].Running < up[j].Running
+		})
+		return up[0], nil
+	default:
+		return Host{}, fmt.Errorf("placement %q does not pick among hosts", strategy)
+	}
+}
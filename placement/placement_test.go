@@ -0,0 +1,59 @@
+package placement
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newHost returns a fake Ollama host with the given /api/ps body.
+func newHost(t *testing.T, ps string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/version":
+			w.Write([]byte(`{"version":"0.5.7"}`))
+		case "/api/ps":
+			w.Write([]byte(ps))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestProbe(t *testing.T) {
+	busy := newHost(t, `{"models":[{"name":"llama3:70b","size":40000000000,"size_vram":24000000000}]}`)
+	idle := newHost(t, `{"models":[]}`)
+
+	hosts := Probe(context.Background(), []string{busy, idle, "http://127.0.0.1:1"})
+	assert.Equal(t, Host{URL: busy, Version: "0.5.7", Running: 1, Loaded: 40000000000}, hosts[0])
+	assert.Equal(t, Host{URL: idle, Version: "0.5.7"}, hosts[1])
+	assert.Error(t, hosts[2].Err)
+}
+
+func TestPick(t *testing.T) {
+	hosts := []Host{
+		{URL: "down", Err: errors.New("unreachable")},
+		{URL: "a", Running: 2, Loaded: 8},
+		{URL: "b", Running: 1, Loaded: 8},
+		{URL: "c", Running: 1, Loaded: 8},
+	}
+	h, err := Pick(LeastLoaded, hosts)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", h.URL, "Equal memory goes to fewer models, then to the first listed")
+
+	hosts[1].Loaded = 4
+	h, _ = Pick(LeastLoaded, hosts)
+	assert.Equal(t, "a", h.URL, "Memory in use counts before the number of models")
+
+	_, err = Pick(LeastLoaded, hosts[:1])
+	assert.ErrorIs(t, err, ErrNoHost)
+
+	_, err = ParseStrategy("most-free-disk")
+	assert.ErrorContains(t, err, "want explicit or least-loaded")
+}
@@ -0,0 +1,266 @@
+// Package plan compares a declarative list of models (models.yaml) with the models
+// installed on an Ollama host and works out what to pull and what to delete.
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/ref"
+)
+
+// File is the content of a models.yaml manifest:
+//
+//	host: http://gpu-box:11434   # optional
+//	models:
+//	  - llama3.1:8b
+//	  - qwen2.5:7b@sha256:…        # pinned to a manifest digest
+//	  - name: llama3.1:70b
+//	    host: http://big-box:11434
+//	    priority: -1
+//	    max_retries: 20
+//	    window: "22:00-06:00"
+type File struct {
+	Host   string  `yaml:"host,omitempty"`
+	Models []Entry `yaml:"models"`
+}
+
+// Entry is one wanted model. It may be written as a plain string or as a mapping.
+type Entry struct {
+	Name string `yaml:"name"`
+	// Host pulls the model on another Ollama host than the manifest's.
+	Host string `yaml:"host,omitempty"`
+	// Priority orders pulls, highest first; equal priorities keep manifest order.
+	Priority int `yaml:"priority,omitempty"`
+	// MaxRetries overrides apply's --max-retries for this model.
+	MaxRetries *int `yaml:"max_retries,omitempty"`
+	// Window restricts when the pull may start, e.g. "22:00-06:00" in local time.
+	Window string `yaml:"window,omitempty"`
+}
+
+// PullWindow returns the parsed Window; Load has already validated it.
+func (e Entry) PullWindow() Window {
+	w, _ := ParseWindow(e.Window)
+	return w
+}
+
+// UnmarshalYAML accepts both "- llama3" and "- name: llama3".
+func (e *Entry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&e.Name)
+	}
+	type plain Entry
+	return node.Decode((*plain)(e))
+}
+
+// Load reads and validates a manifest file.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %w", path, err)
+	}
+	for i, e := range f.Models {
+		if e.Name == "" {
+			return nil, fmt.Errorf("parsing manifest %s: model %d has no name", path, i+1)
+		}
+		if _, err := ref.Parse(e.Name); err != nil {
+			return nil, fmt.Errorf("parsing manifest %s: %w", path, err)
+		}
+		if _, err := ParseWindow(e.Window); err != nil {
+			return nil, fmt.Errorf("parsing manifest %s: %s: %w", path, e.Name, err)
+		}
+	}
+	if len(f.Models) == 0 {
+		return nil, errors.New("manifest lists no models")
+	}
+	return &f, nil
+}
+
+// Action is what applying a plan does to one model.
+type Action string
+
+const (
+	ActionPull   Action = "pull"
+	ActionDelete Action = "delete"
+	ActionKeep   Action = "keep"
+)
+
+// Change is the planned action for one model on one host.
+type Change struct {
+	Action Action
+	Model  string
+	Host   string
+	// Digest is the manifest digest the model is pinned to, if any.
+	Digest string
+	// Entry holds the per-model options of a pull.
+	Entry Entry
+}
+
+// Plan is the ordered list of changes: pulls by priority, then deletions and kept
+// models alphabetically.
+type Plan struct {
+	Changes []Change
+}
+
+// WithHost returns the entries with an empty Host set to host.
+func WithHost(entries []Entry, host string) []Entry {
+	out := make([]Entry, len(entries))
+	for i, e := range entries {
+		if e.Host == "" {
+			e.Host = host
+		}
+		out[i] = e
+	}
+	return out
+}
+
+// Hosts returns the distinct hosts of the entries in manifest order.
+func Hosts(entries []Entry) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if !seen[e.Host] {
+			seen[e.Host] = true
+			hosts = append(hosts, e.Host)
+		}
+	}
+	return hosts
+}
+
+// Compute diffs the wanted entries, whose Host must be set (see WithHost), against the
+// models installed on each host. A model pinned to a digest is pulled again if it is
+// installed with another one. Installed models that are not wanted on their host are
+// deleted only with prune; otherwise they are left alone and not listed.
+func Compute(wanted []Entry, installed map[string][]client.InstalledModel, prune bool) Plan {
+	type key struct{ host, name string }
+	// have maps installed models to their digest.
+	have := make(map[key]string)
+	for host, models := range installed {
+		for _, m := range models {
+			have[key{host, ref.Canonical(m.Name)}] = m.Digest
+		}
+	}
+
+	var p Plan
+	var keep []Change
+	want := make(map[key]bool, len(wanted))
+	for _, e := range wanted {
+		name, _ := ref.SplitDigest(e.Name)
+		pin := ref.Split(e.Name).Digest
+		if pin != "" {
+			pin, _ = ref.ParseDigest(pin) // Load has already validated it.
+		}
+		k := key{e.Host, ref.Canonical(name)}
+		if want[k] {
+			continue
+		}
+		want[k] = true
+		digest, installed := have[k]
+		if installed && (pin == "" || client.SameDigest(digest, pin)) {
+			keep = append(keep, Change{Action: ActionKeep, Model: k.name, Host: e.Host, Digest: pin})
+		} else {
+			p.Changes = append(p.Changes, Change{Action: ActionPull, Model: name, Host: e.Host, Digest: pin, Entry: e})
+		}
+	}
+	sort.SliceStable(p.Changes, func(i, j int) bool {
+		return p.Changes[i].Entry.Priority > p.Changes[j].Entry.Priority
+	})
+
+	var remove []Change
+	if prune {
+		for k := range have {
+			if !want[k] {
+				remove = append(remove, Change{Action: ActionDelete, Model: k.name, Host: k.host})
+			}
+		}
+	}
+	byModel := func(c []Change) {
+		sort.Slice(c, func(i, j int) bool {
+			if c[i].Host != c[j].Host {
+				return c[i].Host < c[j].Host
+			}
+			return c[i].Model < c[j].Model
+		})
+	}
+	byModel(remove)
+	byModel(keep)
+	p.Changes = append(p.Changes, remove...)
+	p.Changes = append(p.Changes, keep...)
+	return p
+}
+
+// Count returns how many changes have the given action.
+func (p Plan) Count(a Action) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == a {
+			n++
+		}
+	}
+	return n
+}
+
+// Empty reports whether applying the plan would change nothing.
+func (p Plan) Empty() bool {
+	return p.Count(ActionPull) == 0 && p.Count(ActionDelete) == 0
+}
+
+var symbols = map[Action]string{ActionPull: "+", ActionDelete: "-", ActionKeep: " "}
+
+// Render prints the plan Terraform-style, one model per line followed by a summary.
+// Hosts are shown when the plan spans more than one.
+func (p Plan) Render(w io.Writer) {
+	hosts := make(map[string]bool)
+	for _, c := range p.Changes {
+		hosts[c.Host] = true
+	}
+	for _, c := range p.Changes {
+		detail := string(c.Action)
+		if len(hosts) > 1 {
+			detail += " on " + c.Host
+		}
+		if c.Digest != "" {
+			detail += ", pinned " + c.Digest[:len("sha256:")+12]
+		}
+		if c.Entry.Window != "" {
+			detail += ", window " + c.Entry.PullWindow().String()
+		}
+		fmt.Fprintf(w, "  %s %s (%s)\n", symbols[c.Action], c.Model, detail)
+	}
+	if len(p.Changes) > 0 {
+		fmt.Fprintln(w)
+	}
+	if p.Empty() {
+		fmt.Fprintln(w, "No changes. Installed models match the manifest.")
+		return
+	}
+	fmt.Fprintf(w, "Plan: %d to pull, %d to delete, %d unchanged.\n",
+		p.Count(ActionPull), p.Count(ActionDelete), p.Count(ActionKeep))
+}
+
+// NextPull picks the first pull in pending whose window is open at now and returns its
+// index. If every window is closed it returns -1 and the time the first one opens.
+func NextPull(pending []Change, now time.Time) (int, time.Time) {
+	var earliest time.Time
+	for i, c := range pending {
+		opens := c.Entry.PullWindow().Next(now)
+		if !opens.After(now) {
+			return i, now
+		}
+		if earliest.IsZero() || opens.Before(earliest) {
+			earliest = opens
+		}
+	}
+	return -1, earliest
+}
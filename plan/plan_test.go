@@ -0,0 +1,175 @@
+package plan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"ollama-downloader-v2/client"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.yaml")
+	os.WriteFile(path, []byte("host: http://gpu:11434\nmodels:\n  - llama3\n  - name: mistral:7b\n"), 0644)
+
+	f, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://gpu:11434", f.Host)
+	assert.Equal(t, []Entry{{Name: "llama3"}, {Name: "mistral:7b"}}, f.Models)
+
+	os.WriteFile(path, []byte("models:\n  - host: x\n"), 0644)
+	_, err = Load(path)
+	assert.ErrorContains(t, err, "model 1 has no name")
+}
+
+func TestCompute(t *testing.T) {
+	wanted := WithHost([]Entry{{Name: "llama3"}, {Name: "qwen2:7b"}, {Name: "llama3:latest"}}, "h")
+	installed := map[string][]client.InstalledModel{"h": {{Name: "llama3:latest"}, {Name: "old:1b"}}}
+
+	p := Compute(wanted, installed, false)
+	assert.Equal(t, []Change{
+		{Action: ActionPull, Model: "qwen2:7b", Host: "h", Entry: Entry{Name: "qwen2:7b", Host: "h"}},
+		{Action: ActionKeep, Model: "llama3:latest", Host: "h"},
+	}, p.Changes, "Without prune, unlisted models are left alone")
+
+	p = Compute(wanted, installed, true)
+	assert.Equal(t, []Change{
+		{Action: ActionPull, Model: "qwen2:7b", Host: "h", Entry: Entry{Name: "qwen2:7b", Host: "h"}},
+		{Action: ActionDelete, Model: "old:1b", Host: "h"},
+		{Action: ActionKeep, Model: "llama3:latest", Host: "h"},
+	}, p.Changes)
+
+	var out strings.Builder
+	p.Render(&out)
+	assert.Contains(t, out.String(), "  + qwen2:7b (pull)\n")
+	assert.Contains(t, out.String(), "  - old:1b (delete)\n")
+	assert.Contains(t, out.String(), "Plan: 1 to pull, 1 to delete, 1 unchanged.")
+}
+
+func TestCompute_Pinned(t *testing.T) {
+	pin := "sha256:" + strings.Repeat("ab", 32)
+	wanted := WithHost([]Entry{{Name: "llama3@" + pin}, {Name: "qwen2:7b@sha256:" + strings.ToUpper(pin[7:])}}, "h")
+	installed := map[string][]client.InstalledModel{"h": {{Name: "llama3:latest", Digest: pin[7:]}, {Name: "qwen2:7b", Digest: "0123"}}}
+
+	p := Compute(wanted, installed, false)
+	assert.Equal(t, []Change{
+		{Action: ActionPull, Model: "qwen2:7b", Host: "h", Digest: pin, Entry: wanted[1]},
+		{Action: ActionKeep, Model: "llama3:latest", Host: "h", Digest: pin},
+	}, p.Changes, "A model installed at another digest is pulled again")
+
+	var out strings.Builder
+	p.Render(&out)
+	assert.Contains(t, out.String(), "  + qwen2:7b (pull, pinned sha256:abababababab)\n")
+
+	path := filepath.Join(t.TempDir(), "models.yaml")
+	os.WriteFile(path, []byte("models:\n  - llama3@sha256:123\n"), 0644)
+	_, err := Load(path)
+	assert.ErrorContains(t, err, "invalid digest")
+}
+
+func TestPlan_Empty(t *testing.T) {
+	p := Compute([]Entry{{Name: "llama3"}}, map[string][]client.InstalledModel{"": {{Name: "llama3:latest"}}}, true)
+	assert.True(t, p.Empty())
+
+	var out strings.Builder
+	p.Render(&out)
+	assert.Contains(t, out.String(), "No changes.")
+}
+
+func TestCompute_Overrides(t *testing.T) {
+	wanted := WithHost([]Entry{
+		{Name: "small"},
+		{Name: "big:70b", Host: "big-box", Priority: -1, Window: "22:00-06:00"},
+		{Name: "urgent", Priority: 10},
+	}, "local")
+	assert.Equal(t, []string{"local", "big-box"}, Hosts(wanted))
+
+	installed := map[string][]client.InstalledModel{
+		"local":   {{Name: "big:70b"}},
+		"big-box": {{Name: "small:latest"}},
+	}
+	p := Compute(wanted, installed, true)
+
+	var got []string
+	for _, c := range p.Changes {
+		got = append(got, fmt.Sprintf("%s %s@%s", c.Action, c.Model, c.Host))
+	}
+	assert.Equal(t, []string{
+		"pull urgent@local",
+		"pull small@local",
+		"pull big:70b@big-box",
+		"delete small:latest@big-box",
+		"delete big:70b@local",
+	}, got, "Pulls run by priority and each host is pruned on its own")
+
+	var out strings.Builder
+	p.Render(&out)
+	assert.Contains(t, out.String(), "  + big:70b (pull on big-box, window 22:00-06:00)\n")
+}
+
+func TestWindow(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2024, 5, 1, h, m, 0, 0, time.Local) }
+
+	night, err := ParseWindow("22:00-06:00")
+	assert.NoError(t, err)
+	assert.True(t, night.Contains(at(23, 0)))
+	assert.True(t, night.Contains(at(5, 59)))
+	assert.False(t, night.Contains(at(12, 0)))
+	assert.Equal(t, at(22, 0), night.Next(at(12, 0)))
+	assert.Equal(t, at(1, 0), night.Next(at(1, 0)), "An open window starts now")
+
+	lunch, err := ParseWindow("12:00-13:00")
+	assert.NoError(t, err)
+	assert.Equal(t, at(12, 0).AddDate(0, 0, 1), lunch.Next(at(14, 0)), "A passed window opens tomorrow")
+
+	always, err := ParseWindow("")
+	assert.NoError(t, err)
+	assert.True(t, always.Contains(at(3, 0)))
+
+	for _, bad := range []string{"22:00", "25:00-01:00", "10:00-10:00"} {
+		_, err := ParseWindow(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNextPull(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	night := Change{Model: "big", Entry: Entry{Window: "22:00-06:00"}}
+	evening := Change{Model: "mid", Entry: Entry{Window: "18:00-20:00"}}
+
+	i, _ := NextPull([]Change{night, {Model: "small"}}, now)
+	assert.Equal(t, 1, i, "A model without a window should not wait behind a closed one")
+
+	i, opens := NextPull([]Change{night, evening}, now)
+	assert.Equal(t, -1, i)
+	assert.Equal(t, time.Date(2024, 5, 1, 18, 0, 0, 0, time.Local), opens)
+}
+
+func TestReport(t *testing.T) {
+	var r Report
+	r.Add(Change{Action: ActionPull, Model: "a:latest"}, OutcomeDone, time.Now(), nil).Bytes = 300
+	r.Add(Change{Action: ActionDelete, Model: "b:latest"}, OutcomeFailed, time.Now(), errors.New("boom"))
+	r.Add(Change{Action: ActionPull, Model: "c:latest"}, OutcomeSkipped, time.Time{}, nil)
+	r.Add(Change{Action: ActionPull, Model: "d:latest"}, OutcomeFailed, time.Now(), errors.New("timeout")).Bytes = 20
+	assert.Equal(t, 2, r.Failed())
+	assert.Equal(t, int64(320), r.Downloaded(), "Bytes of failed pulls were downloaded too")
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	assert.NoError(t, r.Write(path))
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	var decoded Report
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "boom", decoded.Results[1].Error)
+	assert.Equal(t, int64(300), decoded.Results[0].Bytes)
+	assert.Equal(t, OutcomeSkipped, decoded.Results[2].Outcome)
+	assert.Zero(t, decoded.Results[2].Duration, "Skipped changes never started")
+}
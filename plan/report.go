@@ -0,0 +1,101 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"ollama-downloader-v2/client"
+)
+
+// Outcome is how one change of an apply ended.
+type Outcome string
+
+const (
+	OutcomeDone    Outcome = "done"
+	OutcomeFailed  Outcome = "failed"
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// Result records one applied change.
+type Result struct {
+	Model  string `json:"model"`
+	Host   string `json:"host"`
+	Action Action `json:"action"`
+	// Digest is the manifest digest the model is pinned to, if any.
+	Digest  string  `json:"digest,omitempty"`
+	Outcome Outcome `json:"outcome"`
+	Error   string  `json:"error,omitempty"`
+	Retries int     `json:"retries,omitempty"`
+	// RetryCauses counts every retry of the pull by cause, including those the retry
+	// budget does not charge, e.g. gateway errors without auto-retry.
+	RetryCauses map[client.RetryCause]int `json:"retry_causes,omitempty"`
+	Duration    float64                   `json:"duration_seconds"`
+	// Bytes is what the pull downloaded, without what a resumed pull found on disk.
+	Bytes int64 `json:"bytes,omitempty"`
+	// LoadTime is how long the model took to load after the pull, with --warmup.
+	LoadTime float64 `json:"load_seconds,omitempty"`
+	// TestOutput is the model's answer to --test-prompt.
+	TestOutput string `json:"test_output,omitempty"`
+	// SlowestLayer is the short digest of the layer that downloaded slowest, at
+	// SlowestLayerSpeed bytes per second.
+	SlowestLayer      string  `json:"slowest_layer,omitempty"`
+	SlowestLayerSpeed float64 `json:"slowest_layer_speed,omitempty"`
+}
+
+// Report is the machine-readable summary written after an apply.
+type Report struct {
+	Manifest   string    `json:"manifest"`
+	Host       string    `json:"host"`
+	Prune      bool      `json:"prune"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Results    []Result  `json:"results"`
+}
+
+// Add records the outcome of change c, which started at start. err is reported only
+// for failures. The returned Result can be amended, e.g. with its retry count.
+func (r *Report) Add(c Change, outcome Outcome, start time.Time, err error) *Result {
+	res := Result{Model: c.Model, Host: c.Host, Action: c.Action, Digest: c.Digest, Outcome: outcome}
+	if !start.IsZero() {
+		res.Duration = time.Since(start).Seconds()
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	r.Results = append(r.Results, res)
+	return &r.Results[len(r.Results)-1]
+}
+
+// Failed returns how many changes failed.
+func (r *Report) Failed() int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Outcome == OutcomeFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// Downloaded returns the bytes all pulls downloaded.
+func (r *Report) Downloaded() int64 {
+	var total int64
+	for _, res := range r.Results {
+		total += res.Bytes
+	}
+	return total
+}
+
+// Write saves the report as indented JSON.
+func (r *Report) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
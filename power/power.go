@@ -0,0 +1,124 @@
+// Package power reads whether the machine runs on battery and how far it is charged,
+// for the low-power mode of laptops.
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned by Read on systems it cannot query.
+var ErrUnsupported = errors.New("the power source is not available on this system")
+
+// sysfsDir is where Linux lists the power supplies; tests point it at a fake tree.
+var sysfsDir = "/sys/class/power_supply"
+
+// Status is the power source of the machine.
+type Status struct {
+	// OnBattery is true while the machine runs on battery rather than mains power.
+	OnBattery bool
+	// Percent is the battery charge from 0 to 100, or -1 if there is no battery.
+	Percent int
+}
+
+func (s Status) String() string {
+	switch {
+	case s.Percent < 0:
+		return "on mains power, no battery"
+	case s.OnBattery:
+		return fmt.Sprintf("on battery at %d%%", s.Percent)
+	default:
+		return fmt.Sprintf("on mains power, battery at %d%%", s.Percent)
+	}
+}
+
+// Read returns the current power source.
+func Read(ctx context.Context) (Status, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return readSysfs(sysfsDir)
+	case "darwin":
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, "pmset", "-g", "batt").Output()
+		if err != nil {
+			return Status{}, fmt.Errorf("running pmset: %w", err)
+		}
+		return parsePmset(string(out))
+	default:
+		return Status{}, ErrUnsupported
+	}
+}
+
+// readSysfs reads the power supplies under dir. The machine is on battery when a
+// battery discharges, or when no mains supply is online; with several batteries the
+// charge is their average.
+func readSysfs(dir string) (Status, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return Status{}, ErrUnsupported
+	}
+	if err != nil {
+		return Status{}, err
+	}
+	read := func(supply, name string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, supply, name))
+		return strings.TrimSpace(string(data))
+	}
+	s := Status{Percent: -1}
+	hasMains, mainsOnline, discharging := false, false, false
+	batteries, charge := 0, 0
+	for _, e := range entries {
+		switch read(e.Name(), "type") {
+		case "Mains":
+			hasMains = true
+			mainsOnline = mainsOnline || read(e.Name(), "online") == "1"
+		case "Battery":
+			// Peripherals such as mice report their batteries here too.
+			if read(e.Name(), "scope") == "Device" {
+				continue
+			}
+			percent, err := strconv.Atoi(read(e.Name(), "capacity"))
+			if err != nil {
+				continue
+			}
+			batteries++
+			charge += percent
+			discharging = discharging || read(e.Name(), "status") == "Discharging"
+		}
+	}
+	if batteries == 0 {
+		return s, nil
+	}
+	s.Percent = charge / batteries
+	s.OnBattery = discharging || hasMains && !mainsOnline
+	return s, nil
+}
+
+// pmsetBattery matches the charge of a battery in the output of `pmset -g batt`.
+var pmsetBattery = regexp.MustCompile(`(\d+)%;`)
+
+// parsePmset parses the output of `pmset -g batt`, e.g.
+//
+//	Now drawing from 'Battery Power'
+//	 -InternalBattery-0 (id=1234567)	85%; discharging; 4:10 remaining present: true
+func parsePmset(out string) (Status, error) {
+	first, _, _ := strings.Cut(out, "\n")
+	if !strings.HasPrefix(first, "Now drawing from") {
+		return Status{}, fmt.Errorf("unexpected pmset output: %q", first)
+	}
+	s := Status{Percent: -1, OnBattery: strings.Contains(first, "'Battery Power'")}
+	if m := pmsetBattery.FindStringSubmatch(out); m != nil {
+		s.Percent, _ = strconv.Atoi(m[1])
+	}
+	return s, nil
+}
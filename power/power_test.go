@@ -0,0 +1,61 @@
+package power
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSupply creates a power supply with the given attribute files under dir.
+func writeSupply(t *testing.T, dir, name string, attrs map[string]string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0o755))
+	for k, v := range attrs {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name, k), []byte(v+"\n"), 0o644))
+	}
+}
+
+func TestReadSysfs(t *testing.T) {
+	dir := t.TempDir()
+	s, err := readSysfs(dir)
+	require.NoError(t, err)
+	assert.Equal(t, Status{Percent: -1}, s, "A desktop has no battery")
+
+	writeSupply(t, dir, "AC", map[string]string{"type": "Mains", "online": "1"})
+	writeSupply(t, dir, "BAT0", map[string]string{"type": "Battery", "capacity": "80", "status": "Charging"})
+	writeSupply(t, dir, "BAT1", map[string]string{"type": "Battery", "capacity": "40", "status": "Charging"})
+	writeSupply(t, dir, "hid-mouse", map[string]string{"type": "Battery", "scope": "Device", "capacity": "5", "status": "Discharging"})
+	s, err = readSysfs(dir)
+	require.NoError(t, err)
+	assert.Equal(t, Status{Percent: 60}, s, "A peripheral's battery does not count")
+	assert.Equal(t, "on mains power, battery at 60%", s.String())
+
+	writeSupply(t, dir, "AC", map[string]string{"online": "0"})
+	s, err = readSysfs(dir)
+	require.NoError(t, err)
+	assert.Equal(t, Status{OnBattery: true, Percent: 60}, s, "Without mains power the machine runs on battery")
+	assert.Equal(t, "on battery at 60%", s.String())
+
+	_, err = readSysfs(filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestParsePmset(t *testing.T) {
+	s, err := parsePmset("Now drawing from 'Battery Power'\n -InternalBattery-0 (id=1234567)\t85%; discharging; 4:10 remaining present: true\n")
+	require.NoError(t, err)
+	assert.Equal(t, Status{OnBattery: true, Percent: 85}, s)
+
+	s, err = parsePmset("Now drawing from 'AC Power'\n -InternalBattery-0 (id=1234567)\t100%; charged; 0:00 remaining present: true\n")
+	require.NoError(t, err)
+	assert.Equal(t, Status{Percent: 100}, s)
+
+	s, err = parsePmset("Now drawing from 'AC Power'\n")
+	require.NoError(t, err)
+	assert.Equal(t, Status{Percent: -1}, s, "A Mac mini has no battery")
+
+	_, err = parsePmset("pmset: unknown option\n")
+	assert.Error(t, err)
+}
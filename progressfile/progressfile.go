@@ -0,0 +1,168 @@
+// Package progressfile publishes the state of a pull as a small JSON document that is
+// rewritten atomically every second, for status bars and dashboards that cannot attach
+// to the terminal UI.
+package progressfile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/stats"
+)
+
+// States of a pull.
+const (
+	StatePulling = "pulling"
+	StateDone    = "done"
+	StateFailed  = "failed"
+	StateStopped = "stopped"
+)
+
+// Status is the document written to the file.
+type Status struct {
+	Model     string  `json:"model"`
+	Host      string  `json:"host"`
+	State     string  `json:"state"`
+	Phase     string  `json:"phase"`
+	Completed int64   `json:"completed"`
+	Total     int64   `json:"total"`
+	Percent   float64 `json:"percent"`
+	// Speed is in bytes per second.
+	Speed float64 `json:"speed"`
+	// ETA is the estimated number of seconds left in the current phase, or -1 if unknown.
+	ETA       float64   `json:"eta_seconds"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Writer keeps the latest progress and writes it to a file once a second while Run is
+// active. A nil *Writer does nothing.
+type Writer struct {
+	path string
+
+	mu     sync.Mutex
+	status Status
+	// stats computes the speed and ETA as the terminal UI does.
+	stats stats.Tracker
+}
+
+// New returns a Writer for path.
+func New(path string) *Writer {
+	return &Writer{path: path, status: Status{ETA: -1}}
+}
+
+// Start resets the status for a pull of model from host.
+func (w *Writer) Start(model, host string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status = Status{Model: model, Host: host, State: StatePulling, ETA: -1}
+	w.stats = stats.Tracker{}
+}
+
+// SetHost changes the reported host, e.g. after the user switched hosts.
+func (w *Writer) SetHost(host string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Host = host
+}
+
+// Update records a progress message.
+func (w *Writer) Update(p client.ProgressMsg) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Phase = p.Status
+	if p.Total > 0 {
+		w.status.Completed = p.Completed
+		w.status.Total = p.Total
+		w.status.Percent = float64(p.Completed) / float64(p.Total) * 100
+	}
+	w.stats.Update(p)
+}
+
+// Finish records how the pull ended and writes the file one last time.
+func (w *Writer) Finish(state string) error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	w.status.State = state
+	w.status.Speed = 0
+	w.status.ETA = -1
+	w.mu.Unlock()
+	return w.write(time.Now())
+}
+
+// Run writes the file every second until ctx is done.
+func (w *Writer) Run(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.tick()
+			if err := w.write(now); err != nil {
+				log.Printf("Progress file: %v", err)
+			}
+		}
+	}
+}
+
+// tick folds the bytes downloaded since the last tick into the speed average.
+func (w *Writer) tick() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.Tick()
+	w.status.Speed = w.stats.Speed()
+	w.status.ETA = -1
+	if eta, ok := w.stats.ETA(); ok {
+		w.status.ETA = eta.Seconds()
+	}
+}
+
+// write replaces the file atomically so readers never see a partial document.
+func (w *Writer) write(now time.Time) error {
+	w.mu.Lock()
+	w.status.UpdatedAt = now
+	data, err := json.Marshal(w.status)
+	w.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encoding progress: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), ".progress-*")
+	if err != nil {
+		return fmt.Errorf("writing progress file: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing progress file: %w", err)
+	}
+	tmp.Close()
+	// CreateTemp makes the file private; watchers may run as another user.
+	os.Chmod(tmp.Name(), 0644)
+	if err := os.Rename(tmp.Name(), w.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing progress file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package progressfile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"ollama-downloader-v2/client"
+)
+
+func readStatus(t *testing.T, path string) Status {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var s Status
+	assert.NoError(t, json.Unmarshal(data, &s))
+	return s
+}
+
+func TestWriter_SpeedAndETA(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pull.json")
+	w := New(path)
+	w.Start("llama3", "http://localhost:11434")
+
+	w.Update(client.ProgressMsg{Status: "pulling abc", Completed: 100, Total: 1000})
+	w.tick() // Baseline only.
+	w.Update(client.ProgressMsg{Status: "pulling abc", Completed: 300, Total: 1000})
+	w.tick()
+	assert.NoError(t, w.write(time.Now()))
+
+	s := readStatus(t, path)
+	assert.Equal(t, StatePulling, s.State)
+	assert.Equal(t, "pulling abc", s.Phase)
+	assert.InDelta(t, 30.0, s.Percent, 0.001)
+	assert.Equal(t, 200.0, s.Speed)
+	assert.InDelta(t, 3.5, s.ETA, 0.001)
+
+	// A new layer starts counting from zero and must not produce a negative sample.
+	w.Update(client.ProgressMsg{Status: "pulling def", Completed: 10, Total: 50})
+	w.tick()
+	assert.Equal(t, 200.0, w.status.Speed)
+
+	assert.NoError(t, w.Finish(StateDone))
+	s = readStatus(t, path)
+	assert.Equal(t, StateDone, s.State)
+	assert.Equal(t, -1.0, s.ETA)
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	assert.Len(t, entries, 1, "No temporary files should be left behind")
+}
+
+func TestWriter_Nil(t *testing.T) {
+	var w *Writer
+	w.Start("m", "h")
+	w.Update(client.ProgressMsg{Status: "x"})
+	assert.NoError(t, w.Finish(StateDone))
+}
@@ -0,0 +1,138 @@
+// Package prompt keeps the progress of a pull in a small file at a well-known path, so
+// shell prompts such as starship or powerlevel10k can show "llama3 63%" while the
+// download runs in another pane or window. The file holds shell variable assignments:
+//
+//	OLLAMA_PULL='llama3 63%'
+//	OLLAMA_PULL_STATE=pulling
+//	OLLAMA_PULL_PERCENT=63
+//	OLLAMA_PULL_PID=4242
+//
+// It is rewritten atomically, at most once a second, and keeps the final state after the
+// pull ends: "done", "failed" or "stopped".
+package prompt
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/client"
+)
+
+// minInterval is the least time between two writes of the file.
+const minInterval = time.Second
+
+// statePulling is the state while the pull runs.
+const statePulling = "pulling"
+
+// Path returns the per-user location of the file, next to the control socket in the
+// system temp directory.
+func Path() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("ollama-downloader-%d.prompt", os.Getuid()))
+}
+
+// File publishes the progress of a pull to the file at its path. A nil *File does
+// nothing, and the first write that fails turns it off.
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	model   string
+	percent int
+	// layer is the layer position shown in front of the percent, e.g. "2/5".
+	layer   string
+	last    string
+	lastSet time.Time
+	failed  bool
+}
+
+// New returns a File that writes to path.
+func New(path string) *File {
+	return &File{path: path}
+}
+
+// Start shows the start of a pull of model.
+func (f *File) Start(model string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.model, f.percent, f.layer = model, 0, ""
+	f.write(statePulling, model+" starting", time.Now(), true)
+}
+
+// Update shows p, unless the file would not change or the last write was less than a
+// second ago. Phases without a size keep the percent of the last one.
+func (f *File) Update(p client.ProgressMsg, now time.Time) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p.Total > 0 {
+		f.percent = int(p.Completed * 100 / p.Total)
+	}
+	if p.Layers > 1 {
+		f.layer = fmt.Sprintf("%d/%d ", p.Layer, p.Layers)
+	}
+	f.write(statePulling, fmt.Sprintf("%s %s%d%%", f.model, f.layer, f.percent), now, false)
+}
+
+// Finish records how the pull ended, e.g. "done", and writes the file one last time.
+func (f *File) Finish(state string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	text := f.model + " " + state
+	if state != "done" {
+		text = fmt.Sprintf("%s %s at %d%%", f.model, state, f.percent)
+	}
+	f.write(state, text, time.Now(), true)
+}
+
+// write replaces the file if its content changed, at most once per minInterval unless
+// force.
+func (f *File) write(state, text string, now time.Time, force bool) {
+	content := fmt.Sprintf("OLLAMA_PULL=%s\nOLLAMA_PULL_STATE=%s\nOLLAMA_PULL_PERCENT=%d\nOLLAMA_PULL_PID=%d\n",
+		quote(text), state, f.percent, os.Getpid())
+	if f.failed || content == f.last || !force && now.Sub(f.lastSet) < minInterval {
+		return
+	}
+	if err := replace(f.path, content); err != nil {
+		log.Printf("Turning off the prompt status: %v", err)
+		f.failed = true
+		return
+	}
+	f.last, f.lastSet = content, now
+}
+
+// quote quotes s for a POSIX shell.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// replace writes content to path atomically, so a prompt never reads half a file.
+func replace(path, content string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".prompt-*")
+	if err != nil {
+		return fmt.Errorf("writing prompt file: %w", err)
+	}
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing prompt file: %w", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing prompt file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ollama-downloader-v2/client"
+)
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestFile(t *testing.T) {
+	var nilFile *File
+	nilFile.Start("llama3")
+	nilFile.Update(client.ProgressMsg{Status: "pulling abc", Completed: 1, Total: 2}, time.Now())
+	nilFile.Finish("done")
+
+	path := filepath.Join(t.TempDir(), "prompt")
+	f := New(path)
+	pid := fmt.Sprintf("OLLAMA_PULL_PID=%d\n", os.Getpid())
+	f.Start("llama3")
+	assert.Equal(t, "OLLAMA_PULL='llama3 starting'\nOLLAMA_PULL_STATE=pulling\nOLLAMA_PULL_PERCENT=0\n"+pid, read(t, path))
+
+	now := time.Now().Add(time.Second)
+	f.Update(client.ProgressMsg{Status: "pulling abc", Digest: "sha256:abc", Completed: 63, Total: 100, Layer: 2, Layers: 5}, now)
+	assert.Equal(t, "OLLAMA_PULL='llama3 2/5 63%'\nOLLAMA_PULL_STATE=pulling\nOLLAMA_PULL_PERCENT=63\n"+pid, read(t, path))
+
+	f.Update(client.ProgressMsg{Status: "pulling abc", Completed: 70, Total: 100, Layer: 2, Layers: 5}, now.Add(100*time.Millisecond))
+	assert.Contains(t, read(t, path), "63%", "Updates within a second are skipped")
+
+	f.Update(client.ProgressMsg{Status: "verifying sha256 digest"}, now.Add(2*time.Second))
+	assert.Contains(t, read(t, path), "OLLAMA_PULL='llama3 2/5 70%'", "A phase without a size keeps the last percent")
+
+	f.Finish("failed")
+	assert.Equal(t, "OLLAMA_PULL='llama3 failed at 70%'\nOLLAMA_PULL_STATE=failed\nOLLAMA_PULL_PERCENT=70\n"+pid, read(t, path))
+	f.Finish("done")
+	assert.Contains(t, read(t, path), "OLLAMA_PULL='llama3 done'\n")
+}
+
+func TestFile_Sourceable(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell")
+	}
+	path := filepath.Join(t.TempDir(), "prompt")
+	f := New(path)
+	f.Start("it's")
+	out, err := exec.Command(sh, "-c", `. "$1" && printf '%s|%s' "$OLLAMA_PULL" "$OLLAMA_PULL_STATE"`, "sh", path).Output()
+	require.NoError(t, err)
+	assert.Equal(t, "it's starting|pulling", string(out))
+}
+
+func TestFile_WriteFails(t *testing.T) {
+	f := New(filepath.Join(t.TempDir(), "missing", "prompt"))
+	f.Start("llama3")
+	assert.True(t, f.failed, "A failed write turns the file off")
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/control"
+	"ollama-downloader-v2/events"
+	"ollama-downloader-v2/latency"
+	"ollama-downloader-v2/notify"
+	"ollama-downloader-v2/progressfile"
+	"ollama-downloader-v2/prompt"
+	"ollama-downloader-v2/stats"
+	"ollama-downloader-v2/tmux"
+	"ollama-downloader-v2/ui"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// pullResult is how an interactive pull ended.
+type pullResult struct {
+	Succeeded bool
+	// Err is the error the pull failed with.
+	Err error
+	// Quit is set when the user stopped the pull rather than it finishing or failing.
+	Quit bool
+	// Host is the host the pull ended on, which changes if the user switched hosts.
+	Host string
+}
+
+// listenControl starts the control socket, which lets `status` and `cancel` reach this
+// instance from another shell. Cancel quits the program stored in current and calls
+// onCancel, if set. It returns nil if the socket cannot be created.
+func listenControl(current *atomic.Pointer[tea.Program], onCancel func()) *control.Server {
+	ctl, err := control.Listen(control.DefaultSocketPath(), func() {
+		log.Println("Cancel requested over the control socket.")
+		if p := current.Load(); p != nil {
+			p.Send(ui.QuitMsg{})
+		}
+		if onCancel != nil {
+			onCancel()
+		}
+	})
+	if err != nil {
+		log.Printf("Control socket disabled: %v", err)
+		return nil
+	}
+	return ctl
+}
+
+// pullOptions are the optional settings of a pull with the progress UI.
+type pullOptions struct {
+	// ProbedSpeed seeds the ETA before the first second of progress, in bytes per second.
+	ProbedSpeed float64
+	// LayerSizes are the model's layer sizes by digest, for an ETA over the whole pull.
+	LayerSizes map[string]int64
+	// AutoRetry retries timeouts without asking, as after "Continue (until download completed)".
+	AutoRetry bool
+	// Control, if set, reports progress to `status`.
+	Control *control.Server
+	// ProgressFile, if set, publishes progress for external watchers.
+	ProgressFile *progressfile.Writer
+	// Events, if set, receives the pull's event stream.
+	Events *events.Emitter
+	// Insecure lets Ollama pull from a registry over plain HTTP.
+	Insecure bool
+	// Bar is the look of the progress bar.
+	Bar ui.BarOptions
+	// Hosts are the configured hosts the timeout menu offers to retry against.
+	Hosts []string
+	// Stats, if set, computes the speed and ETA reported to the control socket and event
+	// stream. It must be running (see stats.Meter.Run).
+	Stats *stats.Meter
+	// Notifiers are told when the pull succeeds or fails.
+	Notifiers []*notify.Notifier
+	// Tmux, if set, shows the progress in the tmux status line.
+	Tmux *tmux.Status
+	// Prompt, if set, keeps the progress in a file for shell prompts.
+	Prompt *prompt.File
+	// Hold, if set, pauses the pull while it is held, see --pause-below.
+	Hold *client.Hold
+}
+
+// newTmuxStatus returns the tmux status line publisher if enabled, and nil if not or
+// if the program does not run in tmux.
+func newTmuxStatus(enabled bool) *tmux.Status {
+	if !enabled {
+		return nil
+	}
+	s := tmux.New()
+	if s == nil {
+		log.Println("Not running in tmux, ignoring --tmux-status.")
+	}
+	return s
+}
+
+// learnTimeouts lets pulls learn how long each host takes to answer, and gives the hosts
+// that are regularly slow longer timeouts, see the latency package.
+func learnTimeouts() {
+	path, err := latency.Path()
+	if err != nil {
+		log.Printf("Not tuning timeouts: %v", err)
+		return
+	}
+	book, err := latency.Load(path)
+	if err != nil {
+		log.Printf("Not tuning timeouts: %v", err)
+		return
+	}
+	client.Tuner = book
+}
+
+// observeProgress passes a progress update to the control socket, progress file and
+// event stream.
+func (o pullOptions) observeProgress(model string, p client.ProgressMsg) {
+	o.ProgressFile.Update(p)
+	o.Tmux.Update(p, time.Now())
+	o.Prompt.Update(p, time.Now())
+	o.Stats.Update(p)
+	snap := o.Stats.Snapshot()
+	event := events.Event{
+		Type: events.TypeProgress, Model: model, Phase: p.Status, Speed: snap.Speed,
+		Digest: p.Digest, Layer: p.Layer, Layers: p.Layers,
+	}
+	if p.Digest != "" || p.Total > 0 {
+		event.Completed, event.Total = &p.Completed, &p.Total
+	}
+	if snap.ETAKnown {
+		event.ETASeconds = snap.ETA.Seconds()
+	}
+	o.Events.Emit(event)
+	if o.Control != nil {
+		o.Control.Update(func(s *control.Status) {
+			s.Phase = p.Status
+			if p.Total > 0 {
+				s.Completed = p.Completed
+				s.Total = p.Total
+			}
+			s.Speed, s.ETASeconds = event.Speed, event.ETASeconds
+		})
+	}
+}
+
+// observeRetry reports to the event stream that the pull of model from host failed with
+// err and is retried for the attempt-th time after delay.
+func (o pullOptions) observeRetry(model, host string, attempt int, delay time.Duration, err error) {
+	o.Events.Emit(events.Event{
+		Type: events.TypeRetry, Model: model, Host: host, Error: err.Error(), Attempt: attempt, DelaySeconds: delay.Seconds(),
+	})
+}
+
+// start reports the start of a pull of model from host to the observers.
+func (o pullOptions) start(model, host string) {
+	o.ProgressFile.Start(model, host)
+	o.Tmux.Start(model)
+	o.Prompt.Start(model)
+	o.Stats.Start(o.ProbedSpeed, o.LayerSizes)
+	o.Events.Emit(events.Event{Type: events.TypeStarted, Model: model, Host: host})
+}
+
+// finish reports how a pull ended to the observers.
+func (o pullOptions) finish(model string, result pullResult) {
+	state := progressfile.StateFailed
+	event := events.Event{Type: events.TypeFailed, Model: model, Host: result.Host}
+	switch {
+	case result.Succeeded:
+		state = progressfile.StateDone
+		event.Type = events.TypeFinished
+	case result.Quit:
+		state = progressfile.StateStopped
+		event.Error = "stopped by user"
+	case result.Err != nil:
+		event.Error = result.Err.Error()
+	default:
+		event.Error = "pull did not complete"
+	}
+	if err := o.ProgressFile.Finish(state); err != nil {
+		log.Printf("Progress file: %v", err)
+	}
+	o.Prompt.Finish(state)
+	if result.Succeeded {
+		o.Tmux.Finish("Pulled " + model)
+	} else {
+		o.Tmux.Finish(fmt.Sprintf("Pull of %s %s: %s", model, state, event.Error))
+	}
+	o.Events.Emit(event)
+	sendNotifications(o.Notifiers, model, result, o.Stats.Downloaded(), o.Stats.Elapsed())
+}
+
+// reportLayerSpeeds logs the speed of every layer of the pull measured by meter and
+// prints the slowest one, which points at a slow blob store or CDN node.
+func reportLayerSpeeds(w io.Writer, meter *stats.Meter) {
+	for _, l := range meter.Layers() {
+		log.Printf("Layer %s: %d bytes in %s (%.0f bytes/s)", l.Digest, l.Bytes, l.Elapsed.Round(time.Millisecond), l.Speed())
+	}
+	if slowest, ok := meter.SlowestLayer(); ok {
+		fmt.Fprintf(w, "Slowest layer: %s at %s (%s in %s).\n", slowest.Digest, ui.FormatSpeed(slowest.Speed()),
+			ui.FormatBytes(slowest.Bytes), slowest.Elapsed.Round(time.Second))
+	}
+}
+
+// reportRetries logs and prints how often the pull measured by meter was retried and
+// why, so chronic network problems show without digging through the log.
+func reportRetries(w io.Writer, meter *stats.Meter) {
+	retries := meter.Retries()
+	if retries.Total() == 0 {
+		return
+	}
+	log.Printf("Retries: %d (%s)", retries.Total(), retries)
+	fmt.Fprintf(w, "Retries: %d (%s).\n", retries.Total(), retries)
+}
+
+// uiOptions are added to the options of every progress program, e.g. to run it without
+// a terminal in tests.
+var uiOptions []tea.ProgramOption
+
+// pullUI shows the pulls of one command in a single progress program. The program is
+// created by the first pull and kept until close, so retries, host switches and the next
+// model of a queue are state changes of the running program instead of a new one.
+type pullUI struct {
+	program *tea.Program
+	// controller is the program's model; it is only read after done is closed.
+	controller *pullController
+	results    chan pullResult
+	done       chan struct{}
+	current    *atomic.Pointer[tea.Program]
+}
+
+// newPullUI returns a pullUI whose program is not started yet. current, if set, tracks
+// the program so the control socket can stop it.
+func newPullUI(current *atomic.Pointer[tea.Program]) *pullUI {
+	return &pullUI{results: make(chan pullResult, 1), current: current}
+}
+
+// pull downloads modelName with the progress UI, offering the retry menu on timeouts,
+// and returns once the pull succeeds, fails or the user quits.
+func (u *pullUI) pull(modelName, host string, opts pullOptions) pullResult {
+	opts.start(modelName, host)
+	start := startPullMsg{model: modelName, host: host, opts: opts}
+	if u.program == nil {
+		u.run(start)
+	} else {
+		u.program.Send(start)
+	}
+
+	var result pullResult
+	select {
+	case result = <-u.results:
+	case <-u.done:
+		// The program ended without reporting the pull, e.g. after a program error.
+		result = u.controller.result()
+	}
+	opts.finish(modelName, result)
+	return result
+}
+
+// run creates the program, starts the first pull in it and runs it in the background.
+func (u *pullUI) run(start startPullMsg) {
+	c := &pullController{ui: u}
+	u.controller = c
+	options := []tea.ProgramOption{tea.WithMouseCellMotion()}
+	if uiFPS > 0 {
+		options = append(options, tea.WithFPS(uiFPS))
+	}
+	options = append(options, uiOptions...)
+	u.program = tea.NewProgram(c, options...)
+	u.done = make(chan struct{})
+	c.begin(start)
+	if u.current != nil {
+		u.current.Store(u.program)
+	}
+	uiProgram.Store(u.program)
+	go func() {
+		defer close(u.done)
+		_, err := u.program.Run()
+		uiProgram.Store(nil)
+		if err == nil {
+			return
+		}
+		switch {
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			log.Printf("Program exited due to context cancellation/timeout: %v\n", err)
+		case errors.Is(err, tea.ErrProgramPanic):
+			// Bubble Tea has already restored the terminal and printed the stack.
+			crash("the progress UI", err, nil)
+		default:
+			log.Printf("Alas, there's been an error: %v\n", err)
+			fmt.Printf("Alas, there's been an error: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+}
+
+// println prints a line above the progress UI while it runs, or to stdout otherwise.
+func (u *pullUI) println(a ...any) {
+	if u.program == nil {
+		fmt.Println(a...)
+		return
+	}
+	u.program.Println(a...)
+}
+
+// close stops the program, leaving the last view on screen, and waits for it to exit.
+// The next pull starts a new program.
+func (u *pullUI) close() {
+	if u.program == nil {
+		return
+	}
+	u.program.Quit()
+	<-u.done
+	if u.current != nil {
+		u.current.Store(nil)
+	}
+	u.program, u.controller = nil, nil
+}
+
+// startPullMsg starts the next pull in the running program.
+type startPullMsg struct {
+	model string
+	host  string
+	opts  pullOptions
+}
+
+// pullEndedMsg reports that the pull of session closed its progress channel.
+type pullEndedMsg struct {
+	session *client.Session
+}
+
+// viewQuitMsg is what a tea.Quit from the view turns into, so that the program outlives
+// the pull the view was showing.
+type viewQuitMsg struct{}
+
+// pullController is the model of a pullUI's program. It shows the current pull with a
+// ui.Model, starts new pulls when the user switches hosts or the next one is requested,
+// and reports each pull's result to the pullUI.
+type pullController struct {
+	ui   *pullUI
+	view ui.Model
+	size tea.WindowSizeMsg
+
+	model    string
+	opts     pullOptions
+	session  *client.Session
+	cancel   context.CancelFunc
+	reported bool
+}
+
+func (c *pullController) Init() tea.Cmd {
+	return ownQuit(c.view.Init())
+}
+
+func (c *pullController) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		c.size = msg
+	case startPullMsg:
+		c.begin(msg)
+		return c, nil
+	case pullEndedMsg:
+		if msg.session == c.session {
+			c.report()
+		}
+		return c, nil
+	case viewQuitMsg:
+		if c.view.GetSelectedChoice() == client.ChoiceChangeHost {
+			host := c.view.GetHost()
+			log.Printf("Switching host to %s and restarting the pull.", host)
+			cancel, session, timeouts := c.startPull(host, c.view.RetryMode())
+			c.view = withFreeSpace(c.view.Restart(host, cancel, session).WithTimeouts(timeouts), host)
+			return c, nil
+		}
+		c.report()
+		return c, nil
+	}
+	view, cmd := c.view.Update(msg)
+	c.view = view.(ui.Model)
+	return c, ownQuit(cmd)
+}
+
+func (c *pullController) View() string {
+	return c.view.View()
+}
+
+// begin shows a new pull of msg.model in a fresh view and starts it.
+func (c *pullController) begin(msg startPullMsg) {
+	c.model, c.opts = msg.model, msg.opts
+	cancel, session, timeouts := c.startPull(msg.host, msg.opts.AutoRetry)
+	c.view = ui.NewModel(msg.model, msg.host, cancel, session).WithRetryMode(msg.opts.AutoRetry).WithTimeouts(timeouts).
+		WithInitialSpeed(msg.opts.ProbedSpeed).WithLayers(msg.opts.LayerSizes).WithBar(msg.opts.Bar).WithHosts(msg.opts.Hosts)
+	c.view = withFreeSpace(c.view, msg.host)
+	if c.size.Width > 0 {
+		view, _ := c.view.Update(c.size)
+		c.view = view.(ui.Model)
+	}
+}
+
+// startPull starts pulling the current model from host in a new session, which it
+// returns with the function that cancels the pull and the timeouts the pull starts with.
+func (c *pullController) startPull(host string, autoRetry bool) (context.CancelFunc, *client.Session, client.Timeouts) {
+	log.Printf("Starting download for model: %s from host: %s", c.model, host)
+	ctx, cancel := context.WithCancel(context.Background())
+	session := client.NewSession()
+	c.session, c.cancel, c.reported = session, cancel, false
+	if c.opts.Control != nil {
+		c.opts.Control.Update(func(s *control.Status) { s.Host = host })
+	}
+	c.opts.ProgressFile.SetHost(host)
+
+	opts := client.PullOptions{
+		Model:     c.model,
+		Host:      host,
+		Progress:  session.Progress(),
+		Choices:   session.Choices(),
+		AutoRetry: autoRetry,
+		Insecure:  c.opts.Insecure,
+		Hold:      c.opts.Hold,
+		Layers:    len(c.opts.LayerSizes),
+	}
+	client.Pull(ctx, opts)
+	go c.forward(c.model, host, c.opts, session)
+	return cancel, session, opts.EffectiveTimeouts()
+}
+
+// forward passes the messages of session's pull to the program until the pull ends.
+// Messages arriving after the view has let go of the session are dropped.
+func (c *pullController) forward(model, host string, opts pullOptions, session *client.Session) {
+	defer recoverCrash("the progress forwarder")
+	p := c.ui.program
+	for msg := range session.Progress() {
+		switch msg := msg.(type) {
+		case client.ProgressMsg:
+			opts.observeProgress(model, msg)
+		case client.RetriedMsg:
+			opts.Stats.Retried(msg.Cause)
+		case client.RetryingMsg:
+			opts.observeRetry(model, host, msg.Attempt, msg.Delay, msg.Err)
+		}
+		dumps.UI.Add(fmt.Sprintf("%s %T %+v", time.Now().Format(time.TimeOnly), msg, msg))
+		select {
+		case <-session.Done():
+		default:
+			p.Send(msg)
+		}
+	}
+	p.Send(pullEndedMsg{session: session})
+}
+
+// report sends the result of the current pull to the pullUI, once, and stops the pull.
+func (c *pullController) report() {
+	if c.reported {
+		return
+	}
+	c.reported = true
+	c.cancel()
+	c.ui.results <- c.result()
+}
+
+// result describes how the current pull ended, from the state of the view.
+func (c *pullController) result() pullResult {
+	dumps.SetUIState(c.view.DebugState())
+	result := pullResult{Succeeded: c.view.Succeeded(), Err: c.view.Err(), Host: c.view.GetHost()}
+	switch c.view.GetSelectedChoice() {
+	case client.ChoiceQuit:
+		log.Println("Quitting download.")
+		result.Quit = result.Err == nil
+	case client.ChoiceFinishLayer:
+		log.Println("Stopped after the current layer finished.")
+		result.Quit = true
+	}
+	return result
+}
+
+// ownQuit turns a tea.Quit returned by cmd, also inside a batch, into a viewQuitMsg.
+func ownQuit(cmd tea.Cmd) tea.Cmd {
+	if cmd == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		switch msg := cmd().(type) {
+		case tea.QuitMsg:
+			return viewQuitMsg{}
+		case tea.BatchMsg:
+			for i, c := range msg {
+				msg[i] = ownQuit(c)
+			}
+			return msg
+		default:
+			return msg
+		}
+	}
+}
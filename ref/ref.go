@@ -0,0 +1,169 @@
+// Package ref parses model references, "[registry/][namespace/]model[:tag][@digest]",
+// and fills in the defaults the way Ollama does, so that "llama3", "library/llama3" and
+// "registry.ollama.ai/library/llama3:latest" are known to name the same model.
+package ref
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Defaults filled in for the parts a reference leaves out.
+const (
+	DefaultRegistry  = "registry.ollama.ai"
+	DefaultNamespace = "library"
+	DefaultTag       = "latest"
+)
+
+var (
+	componentPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	tagPattern       = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+	registryPattern  = regexp.MustCompile(`^[a-zA-Z0-9.-]+(:[0-9]+)?$`)
+)
+
+// Ref is a model reference split into its parts, with the defaults filled in.
+type Ref struct {
+	// Registry is the registry host, e.g. "registry.ollama.ai" or "hf.co".
+	Registry  string
+	Namespace string
+	Model     string
+	Tag       string
+	// Digest pins the manifest, "sha256:" and 64 lower-case hex digits, or is empty.
+	Digest string
+}
+
+// Split splits s into its parts and fills in the defaults without checking them. A
+// path of more than three parts keeps the extra ones in Namespace.
+func Split(s string) Ref {
+	s, digest, _ := strings.Cut(strings.TrimSpace(s), "@")
+	r := Ref{Registry: DefaultRegistry, Namespace: DefaultNamespace, Tag: DefaultTag, Digest: digest}
+	if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		r.Tag = s[i+1:]
+		s = s[:i]
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) > 1 && isRegistry(parts[0]) {
+		r.Registry = parts[0]
+		parts = parts[1:]
+	}
+	r.Model = parts[len(parts)-1]
+	if len(parts) > 1 {
+		r.Namespace = strings.Join(parts[:len(parts)-1], "/")
+	}
+	return r
+}
+
+// isRegistry reports whether the first part of a path names a registry host rather than
+// a namespace, as Docker tells them apart: it has a dot or a port, or is "localhost".
+func isRegistry(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
+
+// Parse splits s like Split and checks every part, so a mistyped reference is reported
+// before anything is requested. The digest is returned in lower case.
+func Parse(s string) (Ref, error) {
+	r := Split(s)
+	switch {
+	case r.Model == "":
+		return Ref{}, fmt.Errorf("invalid model reference %q: no model name", s)
+	case !registryPattern.MatchString(r.Registry):
+		return Ref{}, fmt.Errorf("invalid model reference %q: bad registry %q", s, r.Registry)
+	case strings.Contains(r.Namespace, "/") || !componentPattern.MatchString(r.Namespace):
+		return Ref{}, fmt.Errorf("invalid model reference %q: bad namespace %q", s, r.Namespace)
+	case !componentPattern.MatchString(r.Model):
+		return Ref{}, fmt.Errorf("invalid model reference %q: bad model name %q", s, r.Model)
+	case !tagPattern.MatchString(r.Tag):
+		return Ref{}, fmt.Errorf("invalid model reference %q: bad tag %q", s, r.Tag)
+	}
+	if r.Digest != "" {
+		digest, err := ParseDigest(r.Digest)
+		if err != nil {
+			return Ref{}, fmt.Errorf("invalid model reference %q: %w", s, err)
+		}
+		r.Digest = digest
+	}
+	return r, nil
+}
+
+// ValidRepository reports whether repo is a repository path the way a registry names it,
+// e.g. "library/llama3": namespace and model components that Parse accepts, so none is
+// empty, "." or "..".
+func ValidRepository(repo string) bool {
+	for _, part := range strings.Split(repo, "/") {
+		if !componentPattern.MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidTag reports whether tag is a tag Parse accepts, e.g. "8b" or "latest".
+func ValidTag(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// SplitDigest splits a reference pinned to a manifest digest, "llama3:8b@sha256:…", into
+// the name as written and the digest. A reference without "@" has no digest.
+func SplitDigest(s string) (name, digest string) {
+	name, digest, _ = strings.Cut(s, "@")
+	return name, digest
+}
+
+// ParseDigest checks that s is a manifest digest, "sha256:" and 64 hex digits, and
+// returns it in lower case.
+func ParseDigest(s string) (string, error) {
+	hexDigits, ok := strings.CutPrefix(strings.ToLower(s), "sha256:")
+	if _, err := hex.DecodeString(hexDigits); !ok || err != nil || len(hexDigits) != 64 {
+		return "", fmt.Errorf("invalid digest %q (want sha256: and 64 hex digits)", s)
+	}
+	return "sha256:" + hexDigits, nil
+}
+
+// Name returns the model without tag and digest the way Ollama shows it, leaving out the
+// default registry and namespace: "llama3", "user/model" or "hf.co/user/model".
+func (r Ref) Name() string {
+	name := r.Model
+	if r.Namespace != DefaultNamespace || r.Registry != DefaultRegistry {
+		name = r.Namespace + "/" + name
+	}
+	if r.Registry != DefaultRegistry {
+		name = r.Registry + "/" + name
+	}
+	return name
+}
+
+// Tagged returns the name with its tag, "llama3:latest", as /api/tags lists models.
+func (r Ref) Tagged() string {
+	return r.Name() + ":" + r.Tag
+}
+
+// String returns the tagged name, followed by "@" and the digest if it is pinned.
+func (r Ref) String() string {
+	if r.Digest != "" {
+		return r.Tagged() + "@" + r.Digest
+	}
+	return r.Tagged()
+}
+
+// Equal reports whether r and o name the same model and tag; Ollama compares names
+// without regard to case. Digests are not compared.
+func (r Ref) Equal(o Ref) bool {
+	return strings.EqualFold(r.Registry, o.Registry) && strings.EqualFold(r.Namespace, o.Namespace) &&
+		strings.EqualFold(r.Model, o.Model) && strings.EqualFold(r.Tag, o.Tag)
+}
+
+// Canonical returns s in the form /api/tags lists models, e.g. "llama3:latest" for
+// "llama3" or "library/llama3", without a digest. Use it as a key for comparisons.
+func Canonical(s string) string {
+	r := Split(s)
+	r.Digest = ""
+	return r.Tagged()
+}
+
+// Same reports whether a and b name the same model and tag. An empty name matches
+// nothing.
+func Same(a, b string) bool {
+	return a != "" && b != "" && Split(a).Equal(Split(b))
+}
@@ -0,0 +1,107 @@
+package ref
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var digest = "sha256:" + strings.Repeat("ab", 32)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   Ref
+		tagged string
+	}{
+		{"llama3", Ref{Registry: DefaultRegistry, Namespace: "library", Model: "llama3", Tag: "latest"}, "llama3:latest"},
+		{"llama3.1:8b", Ref{Registry: DefaultRegistry, Namespace: "library", Model: "llama3.1", Tag: "8b"}, "llama3.1:8b"},
+		{"library/llama3:8b", Ref{Registry: DefaultRegistry, Namespace: "library", Model: "llama3", Tag: "8b"}, "llama3:8b"},
+		{"registry.ollama.ai/library/llama3", Ref{Registry: DefaultRegistry, Namespace: "library", Model: "llama3", Tag: "latest"}, "llama3:latest"},
+		{"user/model:q4_K_M", Ref{Registry: DefaultRegistry, Namespace: "user", Model: "model", Tag: "q4_K_M"}, "user/model:q4_K_M"},
+		{"hf.co/bartowski/Llama-3.2-1B-Instruct-GGUF:Q4_K_M", Ref{Registry: "hf.co", Namespace: "bartowski", Model: "Llama-3.2-1B-Instruct-GGUF", Tag: "Q4_K_M"}, "hf.co/bartowski/Llama-3.2-1B-Instruct-GGUF:Q4_K_M"},
+		{"localhost:5000/model", Ref{Registry: "localhost:5000", Namespace: "library", Model: "model", Tag: "latest"}, "localhost:5000/library/model:latest"},
+		{"localhost/team/model:v2", Ref{Registry: "localhost", Namespace: "team", Model: "model", Tag: "v2"}, "localhost/team/model:v2"},
+		{" llama3:8b@" + strings.ToUpper(digest), Ref{Registry: DefaultRegistry, Namespace: "library", Model: "llama3", Tag: "8b", Digest: digest}, "llama3:8b"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if assert.NoError(t, err, tt.in) {
+			assert.Equal(t, tt.want, got, tt.in)
+			assert.Equal(t, tt.tagged, got.Tagged(), tt.in)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		":8b",
+		"llama3:",
+		"-llama3",
+		"llama 3",
+		"a/b/c/d",
+		"user/model:" + strings.Repeat("x", 129),
+		"llama3@sha256:abc",
+		"llama3@md5:" + strings.Repeat("ab", 32),
+		"llama3:8b:q4",
+		"bad_host!.com/user/model",
+	} {
+		_, err := Parse(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestValidRepositoryAndTag(t *testing.T) {
+	assert.True(t, ValidRepository("library/llama3"))
+	assert.True(t, ValidRepository("user/Model-1.5_b"))
+	for _, repo := range []string{"", "library/", "library/..", "./llama3", "library//llama3", "-x/llama3"} {
+		assert.False(t, ValidRepository(repo), repo)
+	}
+	assert.True(t, ValidTag("8b-instruct-q4_K_M"))
+	for _, tag := range []string{"", ".", "..", ".hidden", "-x", strings.Repeat("a", 129)} {
+		assert.False(t, ValidTag(tag), tag)
+	}
+}
+
+func TestRef_String(t *testing.T) {
+	r, _ := Parse("llama3@" + digest)
+	assert.Equal(t, "llama3", r.Name())
+	assert.Equal(t, "llama3:latest@"+digest, r.String())
+	r, _ = Parse("hf.co/user/model")
+	assert.Equal(t, "hf.co/user/model", r.Name())
+	assert.Equal(t, "hf.co/user/model:latest", r.String())
+}
+
+func TestSplitDigest(t *testing.T) {
+	name, d := SplitDigest("llama3:8b@sha256:ABC")
+	assert.Equal(t, "llama3:8b", name)
+	assert.Equal(t, "sha256:ABC", d)
+	_, d = SplitDigest("llama3")
+	assert.Empty(t, d)
+}
+
+func TestParseDigest(t *testing.T) {
+	parsed, err := ParseDigest(strings.ToUpper(digest))
+	assert.NoError(t, err)
+	assert.Equal(t, digest, parsed)
+	for _, bad := range []string{strings.Repeat("ab", 32), "sha256:abc", "md5:" + strings.Repeat("ab", 32), "sha256:" + strings.Repeat("zz", 32)} {
+		_, err := ParseDigest(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestCanonicalAndSame(t *testing.T) {
+	assert.Equal(t, "llama3:latest", Canonical("llama3"))
+	assert.Equal(t, "llama3:latest", Canonical("registry.ollama.ai/library/llama3:latest"))
+	assert.Equal(t, "user/model:v1", Canonical("user/model:v1@"+digest))
+
+	assert.True(t, Same("llama3", "llama3:latest"))
+	assert.True(t, Same("library/llama3:8b", "LLaMA3:8B"), "Ollama compares names without regard to case")
+	assert.True(t, Same("registry.ollama.ai/library/llama3", "llama3"))
+	assert.False(t, Same("llama3", "llama3:8b"))
+	assert.False(t, Same("user/llama3", "llama3"))
+	assert.False(t, Same("hf.co/user/model", "user/model"))
+	assert.False(t, Same("", ""))
+}
@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Capabilities a model can have, named the way Ollama's /api/show reports them.
+const (
+	CapabilityCompletion = "completion"
+	CapabilityEmbedding  = "embedding"
+	CapabilityVision     = "vision"
+	CapabilityTools      = "tools"
+)
+
+const (
+	projectorMediaType = "application/vnd.ollama.image.projector"
+	templateMediaType  = "application/vnd.ollama.image.template"
+)
+
+// maxTemplateSize caps how much of a template blob is read; chat templates are a few KB.
+const maxTemplateSize = 1 << 20
+
+// embeddingFamilies are the model families that only turn text into vectors.
+var embeddingFamilies = []string{"bert", "nomic-bert", "jina-bert-v2", "xlm-roberta"}
+
+// visionFamilies are the model families of image encoders bundled with a language model.
+var visionFamilies = []string{"clip", "mllama"}
+
+// Capabilities guesses what a model can do from its config, manifest and chat template,
+// before it is pulled. Vision is only detected for models with a separate projector or
+// a known image encoder family; Ollama's /api/show is authoritative once installed.
+func Capabilities(cfg ModelConfig, m Manifest, template string) []string {
+	families := append([]string{cfg.ModelFamily}, cfg.ModelFamilies...)
+	var caps []string
+	if slices.ContainsFunc(families, func(f string) bool { return slices.Contains(embeddingFamilies, f) }) {
+		caps = append(caps, CapabilityEmbedding)
+	} else {
+		caps = append(caps, CapabilityCompletion)
+	}
+	if slices.ContainsFunc(m.Layers, func(l Layer) bool { return l.MediaType == projectorMediaType }) ||
+		slices.ContainsFunc(families, func(f string) bool { return slices.Contains(visionFamilies, f) }) {
+		caps = append(caps, CapabilityVision)
+	}
+	if strings.Contains(template, ".Tools") {
+		caps = append(caps, CapabilityTools)
+	}
+	return caps
+}
+
+// Capabilities fetches the chat template of the model with manifest m, if it has one,
+// and returns the model's capabilities; see the Capabilities function.
+func (c *Client) Capabilities(ctx context.Context, name Name, cfg ModelConfig, m Manifest) ([]string, error) {
+	i := slices.IndexFunc(m.Layers, func(l Layer) bool { return l.MediaType == templateMediaType })
+	if i < 0 {
+		return Capabilities(cfg, m, ""), nil
+	}
+	blob, err := c.Blob(ctx, name, m.Layers[i].Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	template, err := io.ReadAll(io.LimitReader(blob, maxTemplateSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading template of %s: %w", name, err)
+	}
+	return Capabilities(cfg, m, string(template)), nil
+}
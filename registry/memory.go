@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"strconv"
+	"strings"
+)
+
+const modelMediaType = "application/vnd.ollama.image.model"
+
+// memoryOverhead covers the KV cache at the default context size and runtime buffers on
+// top of the weights. It is a rough figure; actual use depends on context length and
+// GPU offload.
+const memoryOverhead = 1.2
+
+// bitsPerWeight is the average storage per weight of common GGUF file types.
+var bitsPerWeight = map[string]float64{
+	"F32":    32,
+	"F16":    16,
+	"BF16":   16,
+	"Q8_0":   8.5,
+	"Q6_K":   6.56,
+	"Q5_K_M": 5.69,
+	"Q5_K_S": 5.54,
+	"Q5_1":   6,
+	"Q5_0":   5.5,
+	"Q4_K_M": 4.85,
+	"Q4_K_S": 4.58,
+	"Q4_1":   5,
+	"Q4_0":   4.5,
+	"Q3_K_L": 4.27,
+	"Q3_K_M": 3.91,
+	"Q3_K_S": 3.5,
+	"Q2_K":   3.35,
+}
+
+// ParseParameters parses a parameter count such as "8.0B", "137M" or "1.5T".
+func ParseParameters(s string) (float64, bool) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" {
+		return 0, false
+	}
+	scale := 1.0
+	switch s[len(s)-1] {
+	case 'K':
+		scale = 1e3
+	case 'M':
+		scale = 1e6
+	case 'B':
+		scale = 1e9
+	case 'T':
+		scale = 1e12
+	}
+	if scale != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n * scale, true
+}
+
+// EstimateMemory returns roughly how many bytes of RAM/VRAM running the model takes:
+// its weights, from parameter count and quantization when both are known and from the
+// size of its model layer otherwise, plus overhead. It returns 0 if neither is known.
+func EstimateMemory(cfg ModelConfig, m Manifest) int64 {
+	var weights float64
+	params, ok := ParseParameters(cfg.ModelType)
+	if bits, known := bitsPerWeight[strings.ToUpper(cfg.FileType)]; ok && known {
+		weights = params * bits / 8
+	} else {
+		for _, l := range m.Layers {
+			if l.MediaType == modelMediaType {
+				weights += float64(l.Size)
+			}
+		}
+	}
+	return int64(weights * memoryOverhead)
+}
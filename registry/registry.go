@@ -0,0 +1,318 @@
+// Package registry talks directly to the Ollama model registry (registry.ollama.ai),
+// for information Ollama's own API does not expose before a pull.
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/ref"
+	"ollama-downloader-v2/useragent"
+)
+
+// DefaultURL is the public Ollama registry.
+var DefaultURL = "https://registry.ollama.ai"
+
+const manifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+// Name is a model reference split into its registry path components.
+type Name struct {
+	Namespace string
+	Model     string
+	Tag       string
+}
+
+// ParseName splits "llama3", "llama3:8b" or "user/model:tag" into a Name, filling in the
+// "library" namespace and "latest" tag the way Ollama does (see ref.Split). A registry
+// other than the default stays in front of the namespace, e.g. "hf.co/user".
+func ParseName(s string) Name {
+	r := ref.Split(s)
+	n := Name{Namespace: r.Namespace, Model: r.Model, Tag: r.Tag}
+	if r.Registry != ref.DefaultRegistry {
+		n.Namespace = r.Registry + "/" + n.Namespace
+	}
+	return n
+}
+
+// String returns the name the way Ollama shows it, without the "library" namespace.
+func (n Name) String() string {
+	if n.Namespace == "library" {
+		return n.Model + ":" + n.Tag
+	}
+	return n.Namespace + "/" + n.Model + ":" + n.Tag
+}
+
+// Layer is one blob referenced by a manifest.
+type Layer struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Manifest is the registry manifest of one model tag.
+type Manifest struct {
+	Config Layer   `json:"config"`
+	Layers []Layer `json:"layers"`
+}
+
+// TotalSize returns the size of all blobs in the manifest.
+func (m Manifest) TotalSize() int64 {
+	total := m.Config.Size
+	for _, l := range m.Layers {
+		total += l.Size
+	}
+	return total
+}
+
+// Client fetches manifests and blobs from a registry.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// New returns a client for the registry at DefaultURL.
+func New() *Client {
+	return &Client{BaseURL: DefaultURL, HTTPClient: http.DefaultClient}
+}
+
+// Manifest fetches the manifest of a model tag.
+func (c *Client) Manifest(ctx context.Context, name Name) (Manifest, error) {
+	url := fmt.Sprintf("%s/v2/%s/%s/manifests/%s", c.BaseURL, name.Namespace, name.Model, name.Tag)
+	var m Manifest
+	if err := c.getJSON(ctx, url, manifestMediaType, &m); err != nil {
+		return Manifest{}, fmt.Errorf("fetching manifest of %s/%s:%s: %w", name.Namespace, name.Model, name.Tag, err)
+	}
+	return m, nil
+}
+
+// ManifestDigest returns the digest ("sha256:…") of a model tag's manifest, which
+// changes whenever the tag is republished. Ollama reports the same digest for the model
+// once it is installed.
+func (c *Client) ManifestDigest(ctx context.Context, name Name) (string, error) {
+	url := fmt.Sprintf("%s/v2/%s/%s/manifests/%s", c.BaseURL, name.Namespace, name.Model, name.Tag)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Accept", manifestMediaType)
+	resp, err := c.do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching manifest of %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("fetching manifest of %s: registry returned status %d: %s", name, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	// The digest is of the manifest exactly as served, so hash the body rather than
+	// trusting a Docker-Content-Digest header that proxies may drop.
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", fmt.Errorf("fetching manifest of %s: %w", name, err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Tags lists every tag of a model.
+func (c *Client) Tags(ctx context.Context, name Name) ([]string, error) {
+	url := fmt.Sprintf("%s/v2/%s/%s/tags/list", c.BaseURL, name.Namespace, name.Model)
+	var res struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.getJSON(ctx, url, "", &res); err != nil {
+		return nil, fmt.Errorf("listing tags of %s/%s: %w", name.Namespace, name.Model, err)
+	}
+	return res.Tags, nil
+}
+
+// Ping requests /v2/, the version check every registry answers, and returns the
+// response with its body read and closed; its TLS field is nil over plain http.
+// Registries that need a token answer 401, which still shows they are reachable.
+func (c *Client) Ping(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/v2/", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	return resp, nil
+}
+
+// ModelConfig is the config blob of a model, which describes its weights.
+type ModelConfig struct {
+	ModelFormat string `json:"model_format"`
+	ModelFamily string `json:"model_family"`
+	// ModelFamilies lists every family in the model, e.g. "llama" and "clip" for a
+	// vision model with a separate image encoder.
+	ModelFamilies []string `json:"model_families"`
+	// ModelType is the parameter count, e.g. "8.0B".
+	ModelType string `json:"model_type"`
+	// FileType is the quantization, e.g. "Q4_K_M".
+	FileType string `json:"file_type"`
+}
+
+// Config fetches the config blob referenced by a manifest.
+func (c *Client) Config(ctx context.Context, name Name, m Manifest) (ModelConfig, error) {
+	var cfg ModelConfig
+	if err := c.getJSON(ctx, c.blobURL(name, m.Config.Digest), "", &cfg); err != nil {
+		return ModelConfig{}, fmt.Errorf("fetching config of %s: %w", name.Tag, err)
+	}
+	return cfg, nil
+}
+
+// Blob opens the blob with digest. The caller closes it.
+func (c *Client) Blob(ctx context.Context, name Name, digest string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.blobURL(name, digest), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching blob %s: %w", digest, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("registry returned status %d for blob %s", resp.StatusCode, digest)
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) blobURL(name Name, digest string) string {
+	return fmt.Sprintf("%s/v2/%s/%s/blobs/%s", c.BaseURL, name.Namespace, name.Model, digest)
+}
+
+// TagInfo summarizes one tag for display.
+type TagInfo struct {
+	Tag          string
+	Size         int64
+	Parameters   string
+	Quantization string
+	// Memory is the estimated RAM/VRAM needed to run the tag, see EstimateMemory.
+	Memory int64
+	Err    error
+}
+
+// DescribeTags fetches size and quantization for each tag, with at most concurrency
+// requests in flight. Per-tag failures are reported in TagInfo.Err.
+func (c *Client) DescribeTags(ctx context.Context, model string, tags []string, concurrency int) []TagInfo {
+	base := ParseName(model)
+	infos := make([]TagInfo, len(tags))
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, tag := range tags {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			name := base
+			name.Tag = tag
+			info := TagInfo{Tag: tag}
+			m, err := c.Manifest(ctx, name)
+			if err == nil {
+				info.Size = m.TotalSize()
+				var cfg ModelConfig
+				cfg, err = c.Config(ctx, name, m)
+				info.Parameters = cfg.ModelType
+				info.Quantization = cfg.FileType
+				info.Memory = EstimateMemory(cfg, m)
+			}
+			info.Err = err
+			infos[i] = info
+		}()
+	}
+	wg.Wait()
+	return infos
+}
+
+// do sends req, naming the downloader in its User-Agent.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	useragent.Set(req)
+	return c.HTTPClient.Do(req)
+}
+
+// getJSON fetches url and decodes the JSON response into out.
+func (c *Client) getJSON(ctx context.Context, url string, accept string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("registry returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// ProbeSize is how much of the largest layer Probe downloads.
+const ProbeSize = 8 * 1024 * 1024
+
+// Probe estimates download bandwidth in bytes per second by fetching the first ProbeSize
+// bytes of the model's largest layer. It measures this machine's link to the registry,
+// which is usually the same link the Ollama host pulls through.
+func (c *Client) Probe(ctx context.Context, name Name) (float64, error) {
+	m, err := c.Manifest(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	var largest Layer
+	for _, l := range m.Layers {
+		if l.Size > largest.Size {
+			largest = l
+		}
+	}
+	if largest.Digest == "" {
+		return 0, fmt.Errorf("manifest of %s has no layers", name.Model)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.blobURL(name, largest.Digest), nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", ProbeSize-1))
+
+	start := time.Now()
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, fmt.Errorf("fetching probe range: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("registry returned status %d for blob %s", resp.StatusCode, largest.Digest)
+	}
+
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, ProbeSize))
+	elapsed := time.Since(start)
+	if n == 0 || elapsed <= 0 {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, fmt.Errorf("reading probe range: %w", err)
+	}
+	// A deadline cutting the probe short still leaves a usable measurement.
+	return float64(n) / elapsed.Seconds(), nil
+}
@@ -0,0 +1,180 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Name
+	}{
+		{"llama3", Name{Namespace: "library", Model: "llama3", Tag: "latest"}},
+		{"llama3:8b", Name{Namespace: "library", Model: "llama3", Tag: "8b"}},
+		{"user/model:q4_K_M", Name{Namespace: "user", Model: "model", Tag: "q4_K_M"}},
+		{"user/model", Name{Namespace: "user", Model: "model", Tag: "latest"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ParseName(tt.in), tt.in)
+	}
+}
+
+// newRegistry serves a manifest for library/llama3:latest and its blobs.
+func newRegistry(t *testing.T) *httptest.Server {
+	manifest := Manifest{
+		Config: Layer{Digest: "sha256:cfg", Size: 10},
+		Layers: []Layer{{Digest: "sha256:small", Size: 100}, {Digest: "sha256:big", Size: 1 << 30}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/library/llama3/tags/list":
+			w.Write([]byte(`{"name":"library/llama3","tags":["latest","broken"]}`))
+		case r.URL.Path == "/v2/library/llama3/blobs/sha256:cfg":
+			w.Write([]byte(`{"model_format":"gguf","model_family":"llama","model_type":"8.0B","file_type":"Q4_0"}`))
+		case r.URL.Path == "/v2/library/llama3/manifests/latest":
+			assert.Equal(t, manifestMediaType, r.Header.Get("Accept"))
+			json.NewEncoder(w).Encode(manifest)
+		case r.URL.Path == "/v2/library/llama3/blobs/sha256:big":
+			assert.Equal(t, "bytes=0-8388607", r.Header.Get("Range"))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(strings.Repeat("x", 4096)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient_Manifest(t *testing.T) {
+	c := &Client{BaseURL: newRegistry(t).URL, HTTPClient: http.DefaultClient}
+
+	m, err := c.Manifest(context.Background(), ParseName("llama3"))
+	assert.NoError(t, err)
+	assert.Len(t, m.Layers, 2)
+	assert.Equal(t, int64(10+100+1<<30), m.TotalSize())
+
+	_, err = c.Manifest(context.Background(), ParseName("missing"))
+	assert.ErrorContains(t, err, "registry returned status 404")
+}
+
+func TestClient_ManifestDigest(t *testing.T) {
+	c := &Client{BaseURL: newRegistry(t).URL, HTTPClient: http.DefaultClient}
+	m, _ := c.Manifest(context.Background(), ParseName("llama3"))
+	body, _ := json.Marshal(m)
+	body = append(body, '\n') // As written by json.Encoder.
+
+	digest, err := c.ManifestDigest(context.Background(), ParseName("llama3"))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(body)), digest)
+
+	_, err = c.ManifestDigest(context.Background(), ParseName("missing"))
+	assert.ErrorContains(t, err, "registry returned status 404")
+}
+
+func TestClient_Probe(t *testing.T) {
+	c := &Client{BaseURL: newRegistry(t).URL, HTTPClient: http.DefaultClient}
+
+	speed, err := c.Probe(context.Background(), ParseName("llama3"))
+	assert.NoError(t, err)
+	assert.Greater(t, speed, 0.0, "Probe should measure a positive speed from the largest layer")
+}
+
+func TestClient_Ping(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/", r.URL.Path)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	c := &Client{BaseURL: server.URL, HTTPClient: server.Client()}
+
+	resp, err := c.Ping(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	if assert.NotNil(t, resp.TLS) {
+		assert.NotEmpty(t, resp.TLS.VerifiedChains, "The server's certificate should have been verified")
+	}
+
+	c.HTTPClient = http.DefaultClient
+	_, err = c.Ping(context.Background())
+	assert.Error(t, err, "A certificate the client does not trust should fail the ping")
+}
+
+func TestClient_TagsAndDescribe(t *testing.T) {
+	c := &Client{BaseURL: newRegistry(t).URL, HTTPClient: http.DefaultClient}
+
+	tags, err := c.Tags(context.Background(), ParseName("llama3"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"latest", "broken"}, tags)
+
+	infos := c.DescribeTags(context.Background(), "llama3", tags, 2)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, "latest", infos[0].Tag)
+	assert.NoError(t, infos[0].Err)
+	assert.Equal(t, int64(10+100+1<<30), infos[0].Size)
+	assert.Equal(t, "8.0B", infos[0].Parameters)
+	assert.Equal(t, "Q4_0", infos[0].Quantization)
+	assert.Error(t, infos[1].Err, "A tag without a manifest should report its error")
+}
+
+func TestParseParameters(t *testing.T) {
+	n, ok := ParseParameters("8.0B")
+	assert.True(t, ok)
+	assert.Equal(t, 8e9, n)
+	n, _ = ParseParameters("137M")
+	assert.Equal(t, 137e6, n)
+	_, ok = ParseParameters("")
+	assert.False(t, ok)
+}
+
+func TestEstimateMemory(t *testing.T) {
+	m := Manifest{Layers: []Layer{
+		{MediaType: modelMediaType, Size: 4_000_000_000},
+		{MediaType: "application/vnd.ollama.image.template", Size: 1000},
+	}}
+
+	est := EstimateMemory(ModelConfig{ModelType: "8.0B", FileType: "Q4_0"}, m)
+	assert.Equal(t, int64(8e9*4.5/8*memoryOverhead), est, "Known quantization should estimate from parameters")
+
+	est = EstimateMemory(ModelConfig{ModelType: "8.0B", FileType: "IQ1_XS"}, m)
+	assert.Equal(t, int64(4e9*memoryOverhead), est, "Unknown quantization should fall back to the model layer")
+
+	assert.Zero(t, EstimateMemory(ModelConfig{}, Manifest{}))
+}
+
+func TestCapabilities(t *testing.T) {
+	chat := Manifest{Layers: []Layer{{MediaType: modelMediaType}}}
+	assert.Equal(t, []string{CapabilityCompletion}, Capabilities(ModelConfig{ModelFamily: "llama"}, chat, "{{ .Prompt }}"))
+	assert.Equal(t, []string{CapabilityCompletion, CapabilityTools},
+		Capabilities(ModelConfig{ModelFamily: "llama"}, chat, "{{ if .Tools }}{{ .Tools }}{{ end }}"))
+	assert.Equal(t, []string{CapabilityEmbedding}, Capabilities(ModelConfig{ModelFamily: "nomic-bert"}, chat, ""))
+
+	llava := Manifest{Layers: []Layer{{MediaType: modelMediaType}, {MediaType: projectorMediaType}}}
+	assert.Equal(t, []string{CapabilityCompletion, CapabilityVision}, Capabilities(ModelConfig{ModelFamily: "llama"}, llava, ""))
+	assert.Equal(t, []string{CapabilityCompletion, CapabilityVision},
+		Capabilities(ModelConfig{ModelFamily: "llama", ModelFamilies: []string{"llama", "clip"}}, chat, ""), "The clip family alone should mean vision")
+}
+
+func TestClient_Capabilities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/library/llama3/blobs/sha256:tmpl", r.URL.Path)
+		w.Write([]byte("{{ range .Tools }}{{ . }}{{ end }}"))
+	}))
+	defer server.Close()
+	c := &Client{BaseURL: server.URL, HTTPClient: http.DefaultClient}
+
+	m := Manifest{Layers: []Layer{{MediaType: modelMediaType}, {MediaType: templateMediaType, Digest: "sha256:tmpl"}}}
+	caps, err := c.Capabilities(context.Background(), ParseName("llama3"), ModelConfig{ModelFamily: "llama"}, m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{CapabilityCompletion, CapabilityTools}, caps)
+}
@@ -0,0 +1,101 @@
+package stats
+
+import (
+	"time"
+
+	"ollama-downloader-v2/client"
+)
+
+// minLayerSample is how long a layer must have been downloading for its speed to count
+// towards the slowest layer; small layers finish within one update and have no
+// meaningful speed.
+const minLayerSample = time.Second
+
+// LayerSpeed is how fast one layer downloaded.
+type LayerSpeed struct {
+	// Digest is the short digest Ollama shows in "pulling <digest>".
+	Digest string
+	// Bytes is how much of the layer was downloaded while it was watched, without the
+	// bytes a resumed pull found on disk.
+	Bytes   int64
+	Elapsed time.Duration
+}
+
+// Speed is the average speed of the layer in bytes per second.
+func (l LayerSpeed) Speed() float64 {
+	if l.Elapsed <= 0 {
+		return 0
+	}
+	return float64(l.Bytes) / l.Elapsed.Seconds()
+}
+
+// Layers records the throughput of every layer of a pull. The zero value is ready to
+// use; Layers is not safe for concurrent use (Meter is).
+type Layers struct {
+	byDigest map[string]*layerSample
+	// order lists the digests as they were first seen.
+	order []string
+}
+
+type layerSample struct {
+	firstSeen, lastSeen time.Time
+	completed           int64
+	bytes               int64
+}
+
+// Observe records a progress message received at now.
+func (l *Layers) Observe(msg client.ProgressMsg, now time.Time) {
+	digest, ok := layerKey(msg)
+	if !ok || msg.Total <= 0 {
+		return
+	}
+	if l.byDigest == nil {
+		l.byDigest = make(map[string]*layerSample)
+	}
+	s := l.byDigest[digest]
+	if s == nil {
+		// The first update is a baseline: a resumed layer starts with bytes on disk.
+		l.byDigest[digest] = &layerSample{firstSeen: now, lastSeen: now, completed: msg.Completed}
+		l.order = append(l.order, digest)
+		return
+	}
+	// A retry may restart the count; only growth is downloaded bytes.
+	if msg.Completed > s.completed {
+		s.bytes += msg.Completed - s.completed
+	}
+	s.completed = msg.Completed
+	s.lastSeen = now
+}
+
+// Downloaded returns the bytes downloaded across all layers (see LayerSpeed.Bytes).
+func (l *Layers) Downloaded() int64 {
+	var total int64
+	for _, s := range l.byDigest {
+		total += s.bytes
+	}
+	return total
+}
+
+// Speeds returns the speed of every layer in the order they started.
+func (l *Layers) Speeds() []LayerSpeed {
+	speeds := make([]LayerSpeed, 0, len(l.order))
+	for _, digest := range l.order {
+		s := l.byDigest[digest]
+		speeds = append(speeds, LayerSpeed{Digest: digest, Bytes: s.bytes, Elapsed: s.lastSeen.Sub(s.firstSeen)})
+	}
+	return speeds
+}
+
+// Slowest returns the layer with the lowest average speed among those downloading for
+// at least a second; ok is false if there is none.
+func (l *Layers) Slowest() (slowest LayerSpeed, ok bool) {
+	for _, s := range l.Speeds() {
+		if s.Elapsed < minLayerSample || s.Bytes == 0 {
+			continue
+		}
+		if !ok || s.Speed() < slowest.Speed() {
+			slowest, ok = s, true
+		}
+	}
+	return slowest, ok
+}
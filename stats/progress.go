@@ -0,0 +1,100 @@
+package stats
+
+import (
+	"context"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"ollama-downloader-v2/client"
+)
+
+// ProgressTracker runs a pull and keeps its progress for callers that poll, such as
+// another Bubble Tea program or a web handler, instead of reading the pull's channel
+// as fast as it is written. It is safe for concurrent use.
+type ProgressTracker struct {
+	meter *Meter
+	done  chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// TrackPull starts pulling opts.Model in the background and returns its tracker.
+// layerSizes, which may be nil, are the layer sizes by digest (see Tracker.SetLayers)
+// for an ETA over the whole download. opts.Progress is replaced by the tracker's own
+// channel, and timeouts are retried on their own as nobody answers them; cancel ctx to
+// stop the pull.
+func TrackPull(ctx context.Context, opts client.PullOptions, layerSizes map[string]int64) *ProgressTracker {
+	t := &ProgressTracker{meter: NewMeter(), done: make(chan struct{})}
+	t.meter.Start(0, layerSizes)
+	progress := make(chan tea.Msg)
+	opts.Progress, opts.AutoRetry = progress, true
+	client.Pull(ctx, opts)
+
+	tickCtx, stopTicking := context.WithCancel(ctx)
+	go t.meter.Run(tickCtx)
+	go func() {
+		defer close(t.done)
+		defer stopTicking()
+		succeeded := false
+		for msg := range progress {
+			switch msg := msg.(type) {
+			case client.ProgressMsg:
+				t.meter.Update(msg)
+				succeeded = msg.Status == "success"
+			case client.RetriedMsg:
+				t.meter.Retried(msg.Cause)
+			case client.ErrorMsg:
+				t.setErr(msg.Err)
+			}
+		}
+		if !succeeded && ctx.Err() != nil {
+			t.setErr(ctx.Err())
+		} else if !succeeded {
+			t.setErr(client.ErrStreamEnded)
+		}
+	}()
+	return t
+}
+
+func (t *ProgressTracker) setErr(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// Retries returns how often the pull was retried so far, by cause.
+func (t *ProgressTracker) Retries() RetryCounts {
+	return t.meter.Retries()
+}
+
+// Snapshot returns the progress of the pull so far.
+func (t *ProgressTracker) Snapshot() Snapshot {
+	return t.meter.Snapshot()
+}
+
+// Done is closed when the pull has ended; Err then tells how.
+func (t *ProgressTracker) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns why the pull failed, or nil while it runs and once it succeeded. A
+// cancelled pull reports ctx's error.
+func (t *ProgressTracker) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Wait blocks until the pull has ended or ctx is done and returns the pull's error.
+func (t *ProgressTracker) Wait(ctx context.Context) error {
+	select {
+	case <-t.done:
+		return t.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
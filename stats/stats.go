@@ -0,0 +1,412 @@
+// Package stats turns the progress of a pull into a speed and an ETA, the same way for
+// the terminal UI and for the outputs without one: the event stream, the progress file
+// and `status`. It also records how fast each layer downloaded, for the summary.
+package stats
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/client"
+)
+
+// Smoothing is the weight of the latest one-second sample in the speed average, so the
+// ETA doesn't jump around with per-second jitter.
+const Smoothing = 0.3
+
+// Tracker follows the progress of one pull. Feed it every ProgressMsg with Update and
+// call Tick once a second. The zero value is ready to use; a Tracker is not safe for
+// concurrent use (see Meter).
+type Tracker struct {
+	completed int64
+	total     int64
+	// bytesAtTick is completed at the last tick.
+	bytesAtTick int64
+	speed       float64
+
+	// Sizes of all layers, keyed by the short digest Ollama shows in "pulling <digest>",
+	// so the ETA covers layers not started yet.
+	layerSizes   map[string]int64
+	currentLayer string
+	doneLayers   map[string]bool
+}
+
+// Seed sets the speed (bytes per second) before the first tick, e.g. from a bandwidth
+// probe, so an ETA is known from the start.
+func (t *Tracker) Seed(speed float64) {
+	t.speed = speed
+}
+
+// SetLayers gives the size of every layer by digest ("sha256:…"), so the ETA covers
+// the whole download instead of only the layer in progress.
+func (t *Tracker) SetLayers(sizes map[string]int64) {
+	if len(sizes) == 0 {
+		return
+	}
+	t.layerSizes = make(map[string]int64, len(sizes))
+	for digest, size := range sizes {
+		t.layerSizes[ShortDigest(digest)] = size
+	}
+	t.doneLayers = make(map[string]bool)
+}
+
+// ShortDigest returns the form of digest Ollama uses in status lines: the first 12 hex
+// digits.
+func ShortDigest(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return hex
+}
+
+// layerKey returns the short digest of the layer msg is about: from its Digest, or from
+// Ollama's "pulling <digest>" status if the message carries none. ok is false for
+// messages about no layer, e.g. "pulling manifest".
+func layerKey(msg client.ProgressMsg) (digest string, ok bool) {
+	if msg.Digest != "" {
+		return ShortDigest(msg.Digest), true
+	}
+	digest, ok = strings.CutPrefix(msg.Status, "pulling ")
+	return digest, ok && digest != "manifest"
+}
+
+// Update records a progress message. Verification after the download is not part of
+// the download and is ignored.
+func (t *Tracker) Update(msg client.ProgressMsg) {
+	if strings.HasPrefix(msg.Status, "verifying") {
+		return
+	}
+	if msg.Total > 0 {
+		t.total = msg.Total
+	}
+	t.completed = msg.Completed
+	t.trackLayer(msg)
+}
+
+// trackLayer notes which layer a progress message belongs to; a layer counts as done
+// once a later one starts or it reports all its bytes.
+func (t *Tracker) trackLayer(msg client.ProgressMsg) {
+	if t.layerSizes == nil {
+		return
+	}
+	layer, ok := layerKey(msg)
+	if _, known := t.layerSizes[layer]; !ok || !known {
+		return
+	}
+	if t.currentLayer != "" && t.currentLayer != layer {
+		t.doneLayers[t.currentLayer] = true
+	}
+	t.currentLayer = layer
+	if msg.Total > 0 && msg.Completed >= msg.Total {
+		t.doneLayers[layer] = true
+	}
+}
+
+// Tick folds the bytes downloaded in the last second into the speed average.
+func (t *Tracker) Tick() {
+	delta := t.completed - t.bytesAtTick
+	switch {
+	case t.bytesAtTick == 0 || delta < 0:
+		// The first sample is only a baseline (a resumed pull starts with bytes already
+		// on disk) and a new layer restarts the counter, so neither is a speed.
+	case t.speed == 0:
+		t.speed = float64(delta)
+	default:
+		t.speed = Smoothing*float64(delta) + (1-Smoothing)*t.speed
+	}
+	t.bytesAtTick = t.completed
+	if t.Done() {
+		t.speed = 0
+	}
+}
+
+// Completed is the number of bytes of the layer in progress downloaded so far.
+func (t *Tracker) Completed() int64 { return t.completed }
+
+// Total is the size of the layer in progress, 0 until it is known.
+func (t *Tracker) Total() int64 { return t.total }
+
+// Done reports whether the layer in progress has all its bytes.
+func (t *Tracker) Done() bool {
+	return t.total > 0 && t.completed >= t.total
+}
+
+// Speed is the smoothed download speed in bytes per second, 0 until known.
+func (t *Tracker) Speed() float64 { return t.speed }
+
+// Remaining is what is left of the layer in progress plus, if the layer sizes are
+// known, every layer not started yet.
+func (t *Tracker) Remaining() int64 {
+	remaining := max(t.total-t.completed, 0)
+	if t.currentLayer == "" {
+		return remaining
+	}
+	for layer, size := range t.layerSizes {
+		if layer != t.currentLayer && !t.doneLayers[layer] {
+			remaining += size
+		}
+	}
+	return remaining
+}
+
+// ETA is the time left at the current speed; ok is false while it is unknown.
+func (t *Tracker) ETA() (eta time.Duration, ok bool) {
+	remaining := t.Remaining()
+	if t.speed <= 0 || remaining <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(remaining) / t.speed * float64(time.Second)), true
+}
+
+// Snapshot is the state of a Meter at one moment.
+type Snapshot struct {
+	Completed int64
+	Total     int64
+	// Speed is in bytes per second, 0 while unknown.
+	Speed float64
+	// ETA is the time left; ETAKnown is false while it cannot be estimated.
+	ETA      time.Duration
+	ETAKnown bool
+	// Phase is Ollama's latest status, e.g. "pulling manifest", "pulling 6a0746a1ec1a" or
+	// "verifying sha256 digest".
+	Phase string
+	// Layers holds the progress of every layer seen so far, keyed by the short digest
+	// Ollama shows in its status (see ShortDigest). It is a copy the caller may keep.
+	Layers map[string]LayerProgress
+}
+
+// LayerProgress is how far one layer has downloaded.
+type LayerProgress struct {
+	Completed int64
+	Total     int64
+}
+
+// Done reports whether the layer has all its bytes.
+func (l LayerProgress) Done() bool {
+	return l.Total > 0 && l.Completed >= l.Total
+}
+
+// ETASeconds returns the ETA in seconds, or -1 while it is unknown.
+func (s Snapshot) ETASeconds() float64 {
+	if !s.ETAKnown {
+		return -1
+	}
+	return s.ETA.Seconds()
+}
+
+// Meter is a Tracker that is safe for concurrent use and ticks itself while Run is
+// active. A nil *Meter does nothing.
+type Meter struct {
+	mu       sync.Mutex
+	t        Tracker
+	layers   Layers
+	phase    string
+	progress map[string]LayerProgress
+	retries  RetryCounts
+	started  time.Time
+}
+
+// NewMeter returns an empty Meter.
+func NewMeter() *Meter {
+	return &Meter{}
+}
+
+// Start forgets the previous pull and starts one with the given seed speed and layer
+// sizes, either of which may be zero.
+func (m *Meter) Start(seed float64, layers map[string]int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.t = Tracker{}
+	m.layers = Layers{}
+	m.phase, m.progress, m.retries = "", nil, nil
+	m.started = time.Now()
+	m.t.Seed(seed)
+	m.t.SetLayers(layers)
+}
+
+// Update records a progress message.
+func (m *Meter) Update(msg client.ProgressMsg) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.t.Update(msg)
+	m.layers.Observe(msg, time.Now())
+	m.phase = msg.Status
+	if digest, ok := layerKey(msg); ok && msg.Total > 0 {
+		if m.progress == nil {
+			m.progress = make(map[string]LayerProgress)
+		}
+		m.progress[digest] = LayerProgress{Completed: msg.Completed, Total: msg.Total}
+	}
+}
+
+// Retried records a retry of the pull for cause.
+func (m *Meter) Retried(cause client.RetryCause) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.retries == nil {
+		m.retries = make(RetryCounts)
+	}
+	m.retries[cause]++
+}
+
+// Retries returns how often the pull was retried so far, by cause.
+func (m *Meter) Retries() RetryCounts {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.retries)
+}
+
+// Tick folds the last second into the speed average.
+func (m *Meter) Tick() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.t.Tick()
+}
+
+// Snapshot returns the current figures.
+func (m *Meter) Snapshot() Snapshot {
+	if m == nil {
+		return Snapshot{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	eta, ok := m.t.ETA()
+	return Snapshot{
+		Completed: m.t.Completed(), Total: m.t.Total(), Speed: m.t.Speed(), ETA: eta, ETAKnown: ok,
+		Phase: m.phase, Layers: maps.Clone(m.progress),
+	}
+}
+
+// Layers returns the speed of every layer of the pull so far.
+func (m *Meter) Layers() []LayerSpeed {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.layers.Speeds()
+}
+
+// Elapsed returns the time since Start, or 0 before it.
+func (m *Meter) Elapsed() time.Duration {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started.IsZero() {
+		return 0
+	}
+	return time.Since(m.started)
+}
+
+// Downloaded returns the bytes the pull downloaded so far, without those a resumed pull
+// found on disk.
+func (m *Meter) Downloaded() int64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.layers.Downloaded()
+}
+
+// SlowestLayer returns the slowest layer of the pull so far (see Layers.Slowest).
+func (m *Meter) SlowestLayer() (LayerSpeed, bool) {
+	if m == nil {
+		return LayerSpeed{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.layers.Slowest()
+}
+
+// RetryCounts counts the retries of a pull by cause.
+type RetryCounts map[client.RetryCause]int
+
+// retryCauses orders the causes for display, the ones that point at a problem first.
+var retryCauses = []struct {
+	cause        client.RetryCause
+	one, several string
+}{
+	{client.CauseStall, "stall", "stalls"},
+	{client.CauseReset, "reset", "resets"},
+	{client.CauseGateway, "gateway error", "gateway errors"},
+	{client.CauseTimeout, "timeout", "timeouts"},
+}
+
+// Total returns the number of retries.
+func (c RetryCounts) Total() int {
+	total := 0
+	for _, n := range c {
+		total += n
+	}
+	return total
+}
+
+// String formats the counts for people, e.g. "2 stalls, 1 reset, 9 timeouts", or "none".
+func (c RetryCounts) String() string {
+	var parts []string
+	for _, rc := range retryCauses {
+		switch n := c[rc.cause]; n {
+		case 0:
+		case 1:
+			parts = append(parts, "1 "+rc.one)
+		default:
+			parts = append(parts, fmt.Sprintf("%d %s", n, rc.several))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Strings returns the counts keyed by the cause's name, e.g. for JSON.
+func (c RetryCounts) Strings() map[string]int {
+	if len(c) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(c))
+	for cause, n := range c {
+		out[string(cause)] = n
+	}
+	return out
+}
+
+// Run ticks once a second until ctx is done.
+func (m *Meter) Run(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Tick()
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"ollama-downloader-v2/client"
+)
+
+func TestTracker_SpeedFromTicks(t *testing.T) {
+	var tr Tracker
+	tr.Seed(1000)
+	tr.Update(client.ProgressMsg{Status: "pulling abc", Total: 100000})
+	eta, ok := tr.ETA()
+	assert.True(t, ok, "A seeded speed should give an ETA before any progress")
+	assert.Equal(t, 100*time.Second, eta)
+
+	// A resumed pull reports bytes already on disk; the first tick only records a baseline.
+	tr.Update(client.ProgressMsg{Status: "pulling abc", Completed: 50000, Total: 100000})
+	tr.Tick()
+	assert.InDelta(t, 1000, tr.Speed(), 0.001, "First tick should not treat existing bytes as speed")
+
+	tr.Update(client.ProgressMsg{Status: "pulling abc", Completed: 52000, Total: 100000})
+	tr.Tick()
+	assert.InDelta(t, Smoothing*2000+(1-Smoothing)*1000, tr.Speed(), 0.001, "Speed should be smoothed")
+
+	tr.Update(client.ProgressMsg{Status: "pulling def", Completed: 10, Total: 100000}) // New layer restarted the counter.
+	tr.Tick()
+	assert.Greater(t, tr.Speed(), 0.0, "A layer switch should not produce a negative speed")
+
+	tr.Update(client.ProgressMsg{Status: "pulling def", Completed: 100000, Total: 100000})
+	tr.Tick()
+	assert.Zero(t, tr.Speed(), "A finished download has no speed")
+	_, ok = tr.ETA()
+	assert.False(t, ok)
+}
+
+func TestTracker_ETAOverLayers(t *testing.T) {
+	var tr Tracker
+	tr.Seed(1000)
+	tr.SetLayers(map[string]int64{
+		"sha256:aaaaaaaaaaaa1111": 10000,
+		"sha256:bbbbbbbbbbbb2222": 50000,
+		"sha256:cccccccccccc3333": 100,
+	})
+
+	tr.Update(client.ProgressMsg{Status: "pulling aaaaaaaaaaaa", Completed: 4000, Total: 10000})
+	assert.Equal(t, int64(6000+50000+100), tr.Remaining(), "Layers not started yet count towards the ETA")
+	eta, _ := tr.ETA()
+	assert.Equal(t, 56100*time.Millisecond, eta)
+
+	tr.Update(client.ProgressMsg{Status: "pulling bbbbbbbbbbbb", Completed: 0, Total: 50000})
+	assert.Equal(t, int64(50000+100), tr.Remaining(), "A finished layer no longer counts")
+
+	tr.Update(client.ProgressMsg{Status: "pulling bbbbbbbbbbbb", Completed: 50000, Total: 50000})
+	tr.Update(client.ProgressMsg{Status: "verifying sha256 digest", Completed: 10, Total: 100})
+	assert.Equal(t, int64(100), tr.Remaining(), "Verification is not part of the download")
+
+	// Without layer sizes only the current layer is known.
+	var plain Tracker
+	plain.Update(client.ProgressMsg{Status: "pulling aaaaaaaaaaaa", Completed: 4000, Total: 10000})
+	assert.Equal(t, int64(6000), plain.Remaining())
+}
+
+func TestTracker_LayersByDigest(t *testing.T) {
+	var tr Tracker
+	tr.SetLayers(map[string]int64{"sha256:aaaaaaaaaaaa1111": 10000, "sha256:bbbbbbbbbbbb2222": 50000})
+
+	// The digest identifies the layer whatever the status says.
+	tr.Update(client.ProgressMsg{Status: "downloading", Digest: "sha256:aaaaaaaaaaaa1111", Completed: 4000, Total: 10000})
+	assert.Equal(t, int64(6000+50000), tr.Remaining())
+	tr.Update(client.ProgressMsg{Status: "downloading", Digest: "sha256:bbbbbbbbbbbb2222", Completed: 0, Total: 50000})
+	assert.Equal(t, int64(50000), tr.Remaining(), "A finished layer no longer counts")
+
+	m := NewMeter()
+	m.Start(0, nil)
+	m.Update(client.ProgressMsg{Status: "downloading", Digest: "sha256:aaaaaaaaaaaa1111", Completed: 1000, Total: 10000})
+	assert.Equal(t, map[string]LayerProgress{"aaaaaaaaaaaa": {Completed: 1000, Total: 10000}}, m.Snapshot().Layers)
+}
+
+func TestMeter(t *testing.T) {
+	var nilMeter *Meter
+	nilMeter.Update(client.ProgressMsg{Status: "pulling abc", Completed: 1, Total: 2})
+	assert.Equal(t, Snapshot{}, nilMeter.Snapshot(), "A nil Meter does nothing")
+	assert.Zero(t, nilMeter.Elapsed())
+
+	m := NewMeter()
+	assert.Zero(t, m.Elapsed(), "No time passes before Start")
+	m.Start(0, nil)
+	assert.Positive(t, m.Elapsed())
+	m.Update(client.ProgressMsg{Status: "pulling abc", Completed: 1000, Total: 5000})
+	m.Tick()
+	snap := m.Snapshot()
+	assert.Equal(t, -1.0, snap.ETASeconds(), "The ETA is unknown until a speed is measured")
+
+	m.Update(client.ProgressMsg{Status: "pulling abc", Completed: 3000, Total: 5000})
+	m.Tick()
+	snap = m.Snapshot()
+	assert.Equal(t, Snapshot{
+		Completed: 3000, Total: 5000, Speed: 2000, ETA: time.Second, ETAKnown: true,
+		Phase: "pulling abc", Layers: map[string]LayerProgress{"abc": {Completed: 3000, Total: 5000}},
+	}, snap)
+	assert.Equal(t, 1.0, snap.ETASeconds())
+
+	m.Start(500, nil)
+	assert.Equal(t, Snapshot{Speed: 500}, m.Snapshot(), "Start forgets the previous pull")
+}
+
+func TestMeter_Retries(t *testing.T) {
+	var nilMeter *Meter
+	nilMeter.Retried(client.CauseStall)
+	assert.Nil(t, nilMeter.Retries())
+
+	m := NewMeter()
+	assert.Equal(t, "none", m.Retries().String())
+	for _, cause := range []client.RetryCause{client.CauseTimeout, client.CauseStall, client.CauseTimeout, client.CauseReset, client.CauseStall} {
+		m.Retried(cause)
+	}
+	retries := m.Retries()
+	assert.Equal(t, 5, retries.Total())
+	assert.Equal(t, "2 stalls, 1 reset, 2 timeouts", retries.String())
+	assert.Equal(t, map[string]int{"timeout": 2, "stall": 2, "reset": 1}, retries.Strings())
+
+	retries[client.CauseGateway] = 9
+	assert.Equal(t, 5, m.Retries().Total(), "Retries returns a copy")
+
+	m.Start(0, nil)
+	assert.Nil(t, m.Retries().Strings(), "Start forgets the previous pull")
+}
+
+func TestTrackPull(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+		fmt.Fprintln(w, `{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":100,"completed":40}`)
+		fmt.Fprintln(w, `{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":100,"completed":100}`)
+		fmt.Fprintln(w, `{"status":"pulling 4fa551d4f938","digest":"sha256:4fa551d4f938","total":10,"completed":3}`)
+		fmt.Fprintln(w, `{"status":"success"}`)
+	}))
+	defer server.Close()
+
+	tracker := TrackPull(context.Background(), client.PullOptions{Model: "llama3", Host: server.URL}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, tracker.Wait(ctx))
+	snap := tracker.Snapshot()
+	assert.Equal(t, "success", snap.Phase)
+	assert.Equal(t, map[string]LayerProgress{
+		"6a0746a1ec1a": {Completed: 100, Total: 100},
+		"4fa551d4f938": {Completed: 3, Total: 10},
+	}, snap.Layers)
+	assert.True(t, snap.Layers["6a0746a1ec1a"].Done())
+	assert.False(t, snap.Layers["4fa551d4f938"].Done())
+
+	snap.Layers["6a0746a1ec1a"] = LayerProgress{}
+	assert.True(t, tracker.Snapshot().Layers["6a0746a1ec1a"].Done(), "A snapshot should not share its map with the tracker")
+}
+
+func TestTrackPull_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"pull model manifest: file does not exist"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	tracker := TrackPull(context.Background(), client.PullOptions{Model: "missing", Host: server.URL}, nil)
+	<-tracker.Done()
+	assert.ErrorContains(t, tracker.Err(), "file does not exist")
+}
+
+func TestLayers(t *testing.T) {
+	var l Layers
+	start := time.Now()
+	at := func(seconds float64) time.Time { return start.Add(time.Duration(seconds * float64(time.Second))) }
+
+	l.Observe(client.ProgressMsg{Status: "pulling manifest"}, at(0))
+	// A resumed layer: the bytes already on disk are not downloaded bytes.
+	l.Observe(client.ProgressMsg{Status: "pulling aaaaaaaaaaaa", Completed: 5000, Total: 100000}, at(0))
+	l.Observe(client.ProgressMsg{Status: "pulling aaaaaaaaaaaa", Completed: 45000, Total: 100000}, at(2))
+	// A retry restarts the count.
+	l.Observe(client.ProgressMsg{Status: "pulling aaaaaaaaaaaa", Completed: 40000, Total: 100000}, at(3))
+	l.Observe(client.ProgressMsg{Status: "pulling aaaaaaaaaaaa", Completed: 100000, Total: 100000}, at(4))
+	l.Observe(client.ProgressMsg{Status: "pulling bbbbbbbbbbbb", Completed: 0, Total: 10000}, at(4))
+	l.Observe(client.ProgressMsg{Status: "pulling bbbbbbbbbbbb", Completed: 10000, Total: 10000}, at(6))
+	// Too short to have a speed.
+	l.Observe(client.ProgressMsg{Status: "pulling cccccccccccc", Completed: 0, Total: 100}, at(6))
+	l.Observe(client.ProgressMsg{Status: "pulling cccccccccccc", Completed: 100, Total: 100}, at(6))
+
+	assert.Equal(t, []LayerSpeed{
+		{Digest: "aaaaaaaaaaaa", Bytes: 100000, Elapsed: 4 * time.Second},
+		{Digest: "bbbbbbbbbbbb", Bytes: 10000, Elapsed: 2 * time.Second},
+		{Digest: "cccccccccccc", Bytes: 100, Elapsed: 0},
+	}, l.Speeds())
+
+	slowest, ok := l.Slowest()
+	assert.True(t, ok)
+	assert.Equal(t, "bbbbbbbbbbbb", slowest.Digest)
+	assert.Equal(t, 5000.0, slowest.Speed())
+
+	var empty Layers
+	_, ok = empty.Slowest()
+	assert.False(t, ok)
+}
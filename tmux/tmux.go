@@ -0,0 +1,130 @@
+// Package tmux shows the progress of a pull in the tmux status line, so it stays in
+// view while the downloader's pane or window is hidden. The progress is kept in a
+// session user option, which a status line shows with e.g.
+//
+//	set -g status-right '#{@ollama-progress}'
+//	set -g status-interval 1
+//
+// tmux redraws the status line every status-interval seconds, 15 by default. Asking it
+// to redraw sooner fails while no client is attached, so Status leaves that to tmux.
+package tmux
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-downloader-v2/client"
+)
+
+// Option is the session user option that holds the progress, e.g. "llama3 42%".
+const Option = "@ollama-progress"
+
+// minInterval is the least time between two updates of Option; each one runs tmux.
+const minInterval = time.Second
+
+// Inside reports whether the program runs in a tmux pane.
+func Inside() bool {
+	return os.Getenv("TMUX") != ""
+}
+
+// Status publishes the progress of a pull to tmux. A nil *Status does nothing, and
+// the first tmux command that fails turns it off.
+type Status struct {
+	// pane is the pane the program runs in, from TMUX_PANE; its session gets Option.
+	pane string
+	run  func(args ...string) error
+
+	mu      sync.Mutex
+	model   string
+	last    string
+	lastSet time.Time
+	failed  bool
+}
+
+// New returns a Status for the pane the program runs in, or nil outside tmux.
+func New() *Status {
+	if !Inside() {
+		return nil
+	}
+	return &Status{pane: os.Getenv("TMUX_PANE"), run: runTmux}
+}
+
+func runTmux(args ...string) error {
+	out, err := exec.Command("tmux", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tmux %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Start shows the start of a pull of model.
+func (s *Status) Start(model string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.model = model
+	s.set(model+" starting", time.Now(), true)
+}
+
+// Update shows p, unless the text would not change or the last update was less than
+// a second ago.
+func (s *Status) Update(p client.ProgressMsg, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text := s.model + " " + p.Status
+	if p.Total > 0 {
+		text = fmt.Sprintf("%s %d%%", s.model, p.Completed*100/p.Total)
+	}
+	s.set(text, now, false)
+}
+
+// Finish removes the progress from the status line and shows message, e.g. "Pulled
+// llama3", in the client's message line.
+func (s *Status) Finish(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	args := append([]string{"set-option", "-q", "-u"}, s.target()...)
+	args = append(args, Option, ";", "display-message")
+	s.command(append(append(args, s.target()...), message)...)
+	s.last = ""
+}
+
+// set sets Option to text if it changed, at most once per minInterval unless force.
+func (s *Status) set(text string, now time.Time, force bool) {
+	if text == s.last || !force && now.Sub(s.lastSet) < minInterval {
+		return
+	}
+	s.command(append(append([]string{"set-option", "-q"}, s.target()...), Option, text)...)
+	s.last, s.lastSet = text, now
+}
+
+// target selects the session of the program's pane, or tmux's current one.
+func (s *Status) target() []string {
+	if s.pane == "" {
+		return nil
+	}
+	return []string{"-t", s.pane}
+}
+
+func (s *Status) command(args ...string) {
+	if s.failed {
+		return
+	}
+	if err := s.run(args...); err != nil {
+		log.Printf("Turning off the tmux status: %v", err)
+		s.failed = true
+	}
+}
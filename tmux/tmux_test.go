@@ -0,0 +1,59 @@
+package tmux
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"ollama-downloader-v2/client"
+)
+
+func TestStatus(t *testing.T) {
+	var commands []string
+	s := &Status{pane: "%3", run: func(args ...string) error {
+		commands = append(commands, strings.Join(args, " "))
+		return nil
+	}}
+	start := time.Now()
+	s.Start("llama3")
+	s.Update(client.ProgressMsg{Status: "pulling 6a0746a1ec1a", Completed: 10, Total: 100}, start.Add(100*time.Millisecond))
+	s.Update(client.ProgressMsg{Status: "pulling 6a0746a1ec1a", Completed: 42, Total: 100}, start.Add(time.Second))
+	s.Update(client.ProgressMsg{Status: "pulling 6a0746a1ec1a", Completed: 42, Total: 100}, start.Add(3*time.Second))
+	s.Finish("Pulled llama3")
+
+	assert.Equal(t, []string{
+		"set-option -q -t %3 @ollama-progress llama3 starting",
+		"set-option -q -t %3 @ollama-progress llama3 42%",
+		"set-option -q -u -t %3 @ollama-progress ; display-message -t %3 Pulled llama3",
+	}, commands, "Updates within a second and unchanged text are skipped")
+}
+
+func TestStatus_Failure(t *testing.T) {
+	calls := 0
+	s := &Status{run: func(args ...string) error {
+		calls++
+		return errors.New("no server running")
+	}}
+	s.Start("llama3")
+	s.Update(client.ProgressMsg{Status: "pulling manifest"}, time.Now().Add(time.Minute))
+	s.Finish("Pulled llama3")
+	assert.Equal(t, 1, calls, "A failing tmux turns the status off")
+
+	var none *Status
+	none.Start("llama3")
+	none.Finish("Pulled llama3")
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("TMUX", "")
+	assert.Nil(t, New(), "Outside tmux there is nothing to publish to")
+	t.Setenv("TMUX", "/tmp/tmux-1000/default,123,0")
+	t.Setenv("TMUX_PANE", "%7")
+	s := New()
+	if assert.NotNil(t, s) {
+		assert.Equal(t, "%7", s.pane)
+	}
+}
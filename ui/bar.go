@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/progress"
+)
+
+// Styles of the download progress bar, for BarOptions.
+const (
+	// BarGradient is the default purple-to-pink gradient.
+	BarGradient = "gradient"
+	// BarSolid fills the bar with one colour, for terminals that render gradients poorly.
+	BarSolid = "solid"
+	// BarASCII draws the bar with '#' and '-', for terminals and fonts without block
+	// characters.
+	BarASCII = "ascii"
+	// BarBraille draws a thin bar with braille dots.
+	BarBraille = "braille"
+	// BarPercent shows only the percentage, without a bar.
+	BarPercent = "percent"
+)
+
+// BarStyles lists the styles BarOptions accepts.
+var BarStyles = []string{BarGradient, BarSolid, BarASCII, BarBraille, BarPercent}
+
+// barColor is the fill of the solid and ASCII bars, the start of the default gradient.
+const barColor = "#5A56E0"
+
+// BarOptions sets how the download progress is drawn.
+type BarOptions struct {
+	// Style is one of BarStyles; empty means BarGradient.
+	Style string
+	// MaxWidth caps the bar's width in columns; 0 keeps the default cap.
+	MaxWidth int
+	// HidePercentage drops the percentage after the bar.
+	HidePercentage bool
+}
+
+// Validate reports options that cannot be drawn.
+func (o BarOptions) Validate() error {
+	switch o.Style {
+	case "", BarGradient, BarSolid, BarASCII, BarBraille:
+	case BarPercent:
+		if o.HidePercentage {
+			return fmt.Errorf("bar style %q has nothing to show without the percentage", o.Style)
+		}
+	default:
+		return fmt.Errorf("unknown bar style %q (want %s)", o.Style, strings.Join(BarStyles, ", "))
+	}
+	if o.MaxWidth < 0 {
+		return fmt.Errorf("bar width %d is negative", o.MaxWidth)
+	}
+	return nil
+}
+
+// WithBar draws the download progress as o describes. o must be valid.
+func (m Model) WithBar(o BarOptions) Model {
+	var opts []progress.Option
+	switch o.Style {
+	case BarSolid:
+		opts = append(opts, progress.WithSolidFill(barColor))
+	case BarASCII:
+		opts = append(opts, progress.WithSolidFill(barColor), progress.WithFillCharacters('#', '-'))
+	case BarBraille:
+		opts = append(opts, progress.WithDefaultGradient(), progress.WithFillCharacters('⣿', '⣀'))
+	default:
+		opts = append(opts, progress.WithDefaultGradient())
+	}
+	if o.HidePercentage {
+		opts = append(opts, progress.WithoutPercentage())
+	}
+	width := m.progress.Width
+	m.progress = progress.New(opts...)
+	m.bar = o
+	m.progress.Width = min(width, m.barMaxWidth())
+	return m
+}
+
+// barMaxWidth is the widest the bar may be drawn.
+func (m Model) barMaxWidth() int {
+	if m.bar.MaxWidth > 0 {
+		return min(m.bar.MaxWidth, maxWidth)
+	}
+	return maxWidth
+}
+
+// barView draws the download progress.
+func (m Model) barView() string {
+	if m.bar.Style == BarPercent {
+		return fmt.Sprintf("%.0f%%", m.percent*100)
+	}
+	return m.progress.ViewAs(m.percent)
+}
@@ -0,0 +1,64 @@
+package ui
+
+import "github.com/charmbracelet/bubbles/key"
+
+// keyMap is the single definition of every keybinding. Update matches against it and the
+// help overlay renders it, so the two can't drift apart.
+type keyMap struct {
+	Quit        key.Binding
+	FinishLayer key.Binding
+	ChangeHost  key.Binding
+	Help        key.Binding
+	Up          key.Binding
+	Down        key.Binding
+	Select      key.Binding
+	Back        key.Binding
+}
+
+var keys = keyMap{
+	Quit: key.NewBinding(
+		key.WithKeys("q", "ctrl+c"),
+		key.WithHelp("q", "quit"),
+	),
+	FinishLayer: key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "finish current layer, then quit"),
+	),
+	ChangeHost: key.NewBinding(
+		key.WithKeys("h"),
+		key.WithHelp("h", "switch Ollama host"),
+	),
+	Help: key.NewBinding(
+		key.WithKeys("?"),
+		key.WithHelp("?", "toggle help"),
+	),
+	Up: key.NewBinding(
+		key.WithKeys("up", "k"),
+		key.WithHelp("↑/k", "move up (menu)"),
+	),
+	Down: key.NewBinding(
+		key.WithKeys("down", "j"),
+		key.WithHelp("↓/j", "move down (menu)"),
+	),
+	Select: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "choose option / confirm"),
+	),
+	Back: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "close input or help"),
+	),
+}
+
+// ShortHelp implements help.KeyMap.
+func (k keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Help, k.Quit}
+}
+
+// FullHelp implements help.KeyMap.
+func (k keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Quit, k.FinishLayer, k.ChangeHost, k.Help},
+		{k.Up, k.Down, k.Select, k.Back},
+	}
+}
@@ -0,0 +1,120 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"ollama-downloader-v2/registry"
+)
+
+// tagItem is one row of the tag picker.
+type tagItem struct {
+	registry.TagInfo
+	// cost is the price of downloading the tag, or "" if no cost is set.
+	cost string
+}
+
+func (t tagItem) Title() string { return t.Tag }
+
+func (t tagItem) Description() string {
+	if t.Err != nil {
+		return "details unavailable"
+	}
+	parts := []string{FormatBytes(t.Size)}
+	if t.cost != "" {
+		parts[0] += " (~" + t.cost + ")"
+	}
+	if t.Parameters != "" {
+		parts = append(parts, t.Parameters)
+	}
+	if t.Quantization != "" {
+		parts = append(parts, t.Quantization)
+	}
+	if t.Memory > 0 {
+		parts = append(parts, "~"+FormatBytes(t.Memory)+" to run")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// FilterValue lets "/" match on tag name and quantization alike, e.g. "q4" or "70b".
+func (t tagItem) FilterValue() string { return t.Tag + " " + t.Quantization }
+
+// TagPicker lists the tags of a model and lets the user choose one to pull.
+type TagPicker struct {
+	model    string
+	list     list.Model
+	selected string
+}
+
+// NewTagPicker returns a filterable list of tags for model.
+func NewTagPicker(model string, tags []registry.TagInfo) TagPicker {
+	items := make([]list.Item, len(tags))
+	for i, t := range tags {
+		items[i] = tagItem{TagInfo: t}
+	}
+	l := list.New(items, list.NewDefaultDelegate(), maxWidth, listHeight+6)
+	l.Title = fmt.Sprintf("Tags of %s — enter to pull, / to filter", model)
+	l.Styles.Title = titleStyle
+	l.Styles.PaginationStyle = paginationStyle
+	l.Styles.HelpStyle = helpStyle
+	return TagPicker{model: model, list: l}
+}
+
+// WithCost shows the price of downloading each tag at perGB per GB, with currency in front.
+func (m TagPicker) WithCost(perGB float64, currency string) TagPicker {
+	items := m.list.Items()
+	for i, it := range items {
+		t := it.(tagItem)
+		t.cost = FormatCost(t.Size, perGB, currency)
+		items[i] = t
+	}
+	m.list.SetItems(items)
+	return m
+}
+
+func (m TagPicker) Init() tea.Cmd {
+	return nil
+}
+
+func (m TagPicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.list.SetSize(msg.Width, msg.Height-1)
+		return m, nil
+	case tea.KeyMsg:
+		// While typing a filter, enter and q belong to the filter input.
+		if m.list.FilterState() != list.Filtering {
+			switch msg.String() {
+			case "enter":
+				if t, ok := m.list.SelectedItem().(tagItem); ok {
+					m.selected = t.Tag
+				}
+				return m, tea.Quit
+			case "q", "ctrl+c":
+				return m, tea.Quit
+			}
+		}
+	}
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+func (m TagPicker) View() string {
+	return "\n" + m.list.View()
+}
+
+// Selected returns the chosen reference, e.g. "llama3:8b", or "" if the user quit.
+func (m TagPicker) Selected() string {
+	if m.selected == "" {
+		return ""
+	}
+	name := m.model
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name + ":" + m.selected
+}
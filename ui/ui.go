@@ -1,24 +1,41 @@
 package ui
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 
+	"ollama-downloader-v2/auth"
 	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/stats"
 )
 
 const (
 	padding    = 2
 	maxWidth   = 80
 	listHeight = 14
+	// narrowWidth is the terminal width below which the details are stacked on separate
+	// rows instead of sharing one line.
+	narrowWidth = 60
+	// minBarWidth keeps the progress bar usable in very narrow terminals.
+	minBarWidth = 10
+	// listItemsTop is the screen row of the first menu item: the leading newline of View
+	// plus the list's title bar (title line and its bottom padding).
+	listItemsTop = 3
 )
 
 var (
@@ -31,7 +48,15 @@ var (
 	detailsStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("240")).MarginLeft(2)
 )
 
-type item string
+// verifyColor sets the verification bar apart from the download gradient.
+const verifyColor = "#04B575"
+
+// QuitMsg asks the program to quit as if the user pressed q, e.g. on a `cancel` from
+// the control socket.
+type QuitMsg struct{}
+
+// item is a timeout menu option, labelled with the choice's String.
+type item client.Choice
 
 func (i item) FilterValue() string { return "" }
 
@@ -45,7 +70,7 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 	if !ok {
 		return
 	}
-	str := fmt.Sprintf("%d. %s", index+1, i)
+	str := fmt.Sprintf("%d. %s", index+1, client.Choice(i))
 	fn := itemStyle.Render
 	if index == m.Index() {
 		fn = func(s ...string) string {
@@ -65,48 +90,181 @@ type Model struct {
 
 	list           list.Model
 	quitting       bool
-	selectedChoice string
+	succeeded      bool
+	err            error
+	selectedChoice client.Choice
 	showList       bool
-	quitUICh       chan struct{}
-	userChoiceCh   chan string
-
-	// --- CORRECTED FIELDS for speed/ETA calculation ---
-	// Total size of the download
-	totalBytes int64
-	// The most up-to-date record of bytes downloaded
-	lastCompletedBytes int64
-	// A snapshot of bytes downloaded at the last 1-second tick
-	bytesAtLastTick int64
-	// Calculated speed in bytes per second
-	speed float64
+	session        *client.Session
+	finishingLayer bool
+
+	// Host switching: the input is shown while editingHost is set.
+	hostInput   textinput.Model
+	editingHost bool
+	// hosts are the configured hosts "Change host and retry" cycles through.
+	hosts []string
+
+	// pressedItem is the menu item under a mouse press, or -1.
+	pressedItem int
+
+	// width is the terminal width, 0 until the first WindowSizeMsg.
+	width int
+	// plain drops colour and keeps width fixed, for golden tests (see WithFixedLayout).
+	plain bool
+	// bar is the look of the download progress bar (see WithBar).
+	bar BarOptions
+
+	// Help overlay and the settings it shows.
+	help                  help.Model
+	showHelp              bool
+	continueUntilComplete bool
+	// timeouts are the pull's timeouts (see WithTimeouts); zero fields show the defaults.
+	timeouts client.Timeouts
+
+	// stats computes the speed and ETA from the progress messages and ticks.
+	stats stats.Tracker
+
+	// The model store of a local host and its free space, refreshed every tick (see
+	// WithFreeSpace). free is -1 while unknown.
+	storeDir  string
+	freeSpace func() (int64, error)
+	free      int64
+
+	// Digest verification after the download has its own bar and counters, so the
+	// download figures above stay at their final values.
+	verifyProgress  progress.Model
+	verifying       bool
+	verifyCompleted int64
+	verifyTotal     int64
 }
 
-func NewModel(modelToPull string, host string, cancel context.CancelFunc, quitUICh chan struct{}, userChoiceCh chan string) Model {
-	items := []list.Item{
-		item("Continue (until next error)"),
-		item("Continue (until download completed)"),
-		item("Quit"),
-	}
-
-	l := list.New(items, itemDelegate{}, maxWidth, listHeight)
-	l.Title = "Connection timed out or context deadline exceeded. Choose an option:"
+// NewModel returns the UI of a pull of modelToPull. It answers the pull and reports
+// that it exited through session; cancel stops the pull when the host changes.
+func NewModel(modelToPull string, host string, cancel context.CancelFunc, session *client.Session) Model {
+	l := list.New(menuItems(false), itemDelegate{}, maxWidth, listHeight)
+	l.Title = timeoutTitle(client.TimeoutMsg{}, client.RequestTimeout)
 	l.SetShowStatusBar(false)
 	l.SetFilteringEnabled(false)
 	l.Styles.Title = titleStyle
 	l.Styles.PaginationStyle = paginationStyle
 	l.Styles.HelpStyle = helpStyle
 
+	hi := textinput.New()
+	hi.Prompt = "Host: "
+	hi.Placeholder = "http://localhost:11434"
+	hi.CharLimit = 256
+
 	return Model{
-		progress:     progress.New(progress.WithDefaultGradient()),
-		status:       "Connecting to Ollama...",
-		modelToPull:  modelToPull,
-		host:         host,
-		cancel:       cancel,
-		list:         l,
-		showList:     false,
-		quitUICh:     quitUICh,
-		userChoiceCh: userChoiceCh,
+		progress:       progress.New(progress.WithDefaultGradient()),
+		verifyProgress: progress.New(progress.WithSolidFill(verifyColor)),
+		status:         "Connecting to Ollama...",
+		modelToPull:    modelToPull,
+		host:           host,
+		cancel:         cancel,
+		list:           l,
+		showList:       false,
+		session:        session,
+		hostInput:      hi,
+		help:           help.New(),
+		pressedItem:    -1,
+	}
+}
+
+// WithInitialSpeed seeds the speed (bytes per second) with a probe measurement, so an
+// ETA is shown from the start instead of "--".
+func (m Model) WithInitialSpeed(speed float64) Model {
+	m.stats.Seed(speed)
+	return m
+}
+
+// WithLayers gives the model the size of every layer by digest ("sha256:…"), so the
+// ETA covers the whole download instead of only the layer in progress.
+func (m Model) WithLayers(sizes map[string]int64) Model {
+	m.stats.SetLayers(sizes)
+	return m
+}
+
+// WithFixedLayout renders every view at width columns and without colour, whatever the
+// terminal reports, so the output is the same on every machine and can be compared
+// with golden files.
+func (m Model) WithFixedLayout(width int) Model {
+	m.setWidth(width)
+	m.plain = true
+	return m
+}
+
+// setWidth sizes the bars, help and menu for a terminal width columns wide.
+func (m *Model) setWidth(width int) {
+	m.width = width
+	m.progress.Width = min(max(width-padding*2-4, minBarWidth), m.barMaxWidth())
+	m.verifyProgress.Width = m.progress.Width
+	m.help.Width = width - padding*2
+	m.list.SetWidth(width)
+}
+
+// WithRetryMode records whether the session retries automatically until the download
+// completes, for display in the help overlay.
+func (m Model) WithRetryMode(continueUntilComplete bool) Model {
+	m.continueUntilComplete = continueUntilComplete
+	return m
+}
+
+// WithTimeouts gives the model the timeouts the pull runs with, e.g. from
+// client.PullOptions.EffectiveTimeouts, for the help overlay and error messages.
+func (m Model) WithTimeouts(timeouts client.Timeouts) Model {
+	m.timeouts = timeouts
+	return m
+}
+
+// requestTimeout is the pull's request timeout.
+func (m Model) requestTimeout() time.Duration {
+	return cmp.Or(m.timeouts.Request, client.RequestTimeout)
+}
+
+// manifestTimeout is the pull's manifest timeout.
+func (m Model) manifestTimeout() time.Duration {
+	return cmp.Or(m.timeouts.Manifest, client.ManifestTimeout)
+}
+
+// WithHosts gives the model the configured hosts, normalized with client.NormalizeHost,
+// which the timeout menu offers to retry against.
+func (m Model) WithHosts(hosts []string) Model {
+	m.hosts = hosts
+	return m
+}
+
+// WithFreeSpace shows the space left in dir, the model store of a host on this machine,
+// as reported by free every second. A nil free shows nothing, for remote hosts.
+func (m Model) WithFreeSpace(dir string, free func() (int64, error)) Model {
+	m.storeDir, m.freeSpace, m.free = dir, free, -1
+	m.refreshFreeSpace()
+	return m
+}
+
+func (m *Model) refreshFreeSpace() {
+	if m.freeSpace == nil {
+		return
 	}
+	if n, err := m.freeSpace(); err == nil {
+		m.free = n
+	} else {
+		m.free = -1
+	}
+}
+
+// RetryMode reports whether timeouts are retried without asking, which the user turns on
+// by choosing "Continue (until download completed)".
+func (m Model) RetryMode() bool {
+	return m.continueUntilComplete
+}
+
+// Restart points the model at a new pull of the same model from host, after the user
+// switched hosts. The speed history and statistics are kept.
+func (m Model) Restart(host string, cancel context.CancelFunc, session *client.Session) Model {
+	m.host, m.cancel, m.session = host, cancel, session
+	m.status = "Connecting to Ollama..."
+	m.selectedChoice, m.err = 0, nil
+	m.quitting, m.succeeded, m.showList, m.finishingLayer, m.verifying = false, false, false, false, false
+	return m
 }
 
 // A ticker is used to create a stable 1-second interval for speed calculation.
@@ -117,31 +275,47 @@ func (m Model) Init() tea.Cmd {
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
-		m.progress.Width = msg.Width - padding*2 - 4
-		if m.progress.Width > maxWidth {
-			m.progress.Width = maxWidth
+		if m.plain {
+			return m, nil
 		}
-		m.list.SetWidth(msg.Width)
+		m.setWidth(msg.Width)
 		return m, nil
 
 	case tea.KeyMsg:
-		switch keypress := msg.String(); keypress {
-		case "q", "ctrl+c":
-			m.quitting = true
-			m.selectedChoice = "Quit"
-			close(m.quitUICh)
-			m.userChoiceCh <- m.selectedChoice
-			return m, tea.Quit
-
-		case "enter":
+		if m.editingHost {
+			return m.updateHostInput(msg)
+		}
+
+		switch {
+		case key.Matches(msg, keys.Quit):
+			return m.quit()
+
+		case key.Matches(msg, keys.Help):
+			m.showHelp = !m.showHelp
+			return m, nil
+
+		case m.showHelp && key.Matches(msg, keys.Back):
+			m.showHelp = false
+			return m, nil
+
+		case key.Matches(msg, keys.ChangeHost):
+			m.showHelp = false
+			m.editingHost = true
+			m.hostInput.SetValue(m.host)
+			m.hostInput.CursorEnd()
+			return m, m.hostInput.Focus()
+
+		case key.Matches(msg, keys.FinishLayer):
+			if !m.showList && !m.finishingLayer {
+				m.finishingLayer = true
+				m.selectedChoice = client.ChoiceFinishLayer
+				m.session.Choose(client.ChoiceFinishLayer)
+				return m, nil
+			}
+
+		case key.Matches(msg, keys.Select):
 			if m.showList {
-				i, ok := m.list.SelectedItem().(item)
-				if ok {
-					m.selectedChoice = string(i)
-				}
-				close(m.quitUICh)
-				m.userChoiceCh <- m.selectedChoice
-				return m, tea.Quit
+				return m.confirmSelection()
 			}
 		}
 		var cmd tea.Cmd
@@ -150,27 +324,68 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, cmd
 
+	case QuitMsg:
+		return m.quit()
+
+	case tea.MouseMsg:
+		if m.showList && !m.showHelp {
+			return m.updateMouse(msg)
+		}
+		return m, nil
+
 	case client.ProgressMsg:
 		// This message now ONLY updates the state. Speed calculation is moved.
 		m.status = msg.Status
+		switch {
+		case msg.Layers > 0:
+			m.status += fmt.Sprintf(" (layer %d of %d)", msg.Layer, msg.Layers)
+		case msg.Layer > 0:
+			m.status += fmt.Sprintf(" (layer %d)", msg.Layer)
+		}
+		if msg.Status == "success" {
+			m.succeeded = true
+		}
+		m.verifying = strings.HasPrefix(msg.Status, "verifying")
+		if m.verifying {
+			m.verifyCompleted = msg.Completed
+			m.verifyTotal = msg.Total
+			return m, nil
+		}
 		if msg.Total > 0 {
-			m.totalBytes = msg.Total
 			m.percent = float64(msg.Completed) / float64(msg.Total)
 		} else {
 			m.percent = 0
 		}
-		m.lastCompletedBytes = msg.Completed
+		m.stats.Update(msg)
 		return m, nil
 
 	case client.TimeoutMsg:
 		m.showList = true
+		m.list.Title = timeoutTitle(msg, m.requestTimeout())
+		return m, m.list.SetItems(menuItems(m.nextHost() != ""))
+
+	case client.QueuedMsg:
+		m.status = fmt.Sprintf("Another pull is running on the host; waiting to join it for up to %s", msg.Wait)
+		return m, nil
+
+	case client.PausedMsg:
+		m.status = "Paused: " + msg.Reason
+		return m, nil
+
+	case client.RetryingMsg:
+		m.status = fmt.Sprintf("Gateway error (%s), retrying in %s", gatewayStatus(msg.Err), msg.Delay)
+		return m, nil
+
+	case client.RestartingMsg:
+		m.status = fmt.Sprintf("Server restarting… reconnecting for up to %s", msg.Wait)
 		return m, nil
 
 	case client.ErrorMsg:
-		m.status = fmt.Sprintf("Error: %s", msg.Err)
-		m.selectedChoice = "Quit"
-		close(m.quitUICh)
-		m.userChoiceCh <- "Quit"
+		m.err = msg.Err
+		m.status = fmt.Sprintf("Error: %s", m.describeError(msg.Err))
+		m.selectedChoice = client.ChoiceQuit
+		m.session.Choose(client.ChoiceQuit)
+		m.session.Close()
 		return m, tea.Quit
 
 	case progress.FrameMsg:
@@ -181,28 +396,237 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// --- CORRECTED LOGIC ---
 	// This is the 1-second tick message.
 	case time.Time:
-		// Calculate how many bytes were downloaded in the last second.
-		bytesInLastSecond := m.lastCompletedBytes - m.bytesAtLastTick
-		m.speed = float64(bytesInLastSecond) // Since the interval is 1s, this is bytes/sec.
+		m.stats.Tick()
+		m.refreshFreeSpace()
 
-		// Update the snapshot for the next tick's calculation.
-		m.bytesAtLastTick = m.lastCompletedBytes
+		// Re-issue the tick command to continue the 1-second loop.
+		return m, tea.Batch(
+			tea.Tick(time.Second, func(t time.Time) tea.Msg { return t }),
+			tea.SetWindowTitle(m.windowTitle()),
+		)
 
-		// If the download is finished, stop calculating speed.
-		if m.percent >= 1.0 {
-			m.speed = 0
+	default:
+		return m, nil
+	}
+}
+
+// quit stops the pull and the program.
+func (m Model) quit() (tea.Model, tea.Cmd) {
+	m.quitting = true
+	m.selectedChoice = client.ChoiceQuit
+	m.session.Choose(m.selectedChoice)
+	m.session.Close()
+	return m, tea.Quit
+}
+
+// confirmSelection reports the highlighted menu option. Continuing hands the retry to
+// the pull, which keeps running in the same session, and goes back to the progress view;
+// any other option quits the program.
+func (m Model) confirmSelection() (tea.Model, tea.Cmd) {
+	if i, ok := m.list.SelectedItem().(item); ok {
+		m.selectedChoice = client.Choice(i)
+	}
+	m.session.Choose(m.selectedChoice)
+	switch m.selectedChoice {
+	case client.ChoiceContinue, client.ChoiceContinueUntilComplete, client.ChoiceShorterTimeout:
+		if m.selectedChoice == client.ChoiceContinueUntilComplete {
+			m.continueUntilComplete = true
 		}
+		if m.selectedChoice == client.ChoiceShorterTimeout {
+			m.timeouts.Request = client.ShortenTimeout(m.requestTimeout())
+		}
+		m.selectedChoice = 0
+		m.showList = false
+		m.status = "Retrying..."
+		return m, nil
+	case client.ChoiceChangeHost:
+		return m.switchHost(m.nextHost())
+	}
+	m.session.Close()
+	return m, tea.Quit
+}
 
-		// Re-issue the tick command to continue the 1-second loop.
-		return m, tea.Tick(time.Second, func(t time.Time) tea.Msg { return t })
+// switchHost points the model at host, cancels the running pull and quits the program
+// so the caller can restart the pull against host.
+func (m Model) switchHost(host string) (tea.Model, tea.Cmd) {
+	m.host = host
+	m.selectedChoice = client.ChoiceChangeHost
+	m.status = fmt.Sprintf("Switching to %s...", host)
+	m.cancel()
+	m.session.Close()
+	return m, tea.Quit
+}
 
-	default:
+// nextHost returns the configured host after the current one, wrapping around, or ""
+// if no other host is configured.
+func (m Model) nextHost() string {
+	current := client.NormalizeHost(m.host)
+	start := slices.Index(m.hosts, current)
+	for i := 1; i <= len(m.hosts); i++ {
+		if h := m.hosts[(start+i)%len(m.hosts)]; h != current {
+			return h
+		}
+	}
+	return ""
+}
+
+// menuItems returns the options of the timeout menu. "Change host and retry" is only
+// offered when another host is configured.
+func menuItems(changeHost bool) []list.Item {
+	choices := []client.Choice{client.ChoiceContinue, client.ChoiceContinueUntilComplete, client.ChoiceShorterTimeout}
+	if changeHost {
+		choices = append(choices, client.ChoiceChangeHost)
+	}
+	choices = append(choices, client.ChoiceQuit)
+	items := make([]list.Item, len(choices))
+	for i, c := range choices {
+		items[i] = item(c)
+	}
+	return items
+}
+
+// itemAt returns the index of the menu item on screen row y, or -1 if there is none.
+func (m Model) itemAt(y int) int {
+	row := y - listItemsTop
+	if row < 0 || row >= m.list.Paginator.ItemsOnPage(len(m.list.Items())) {
+		return -1
+	}
+	return m.list.Paginator.Page*m.list.Paginator.PerPage + row
+}
+
+// updateMouse lets the user pick menu options by clicking, drag the highlight across
+// options, and scroll with the wheel. An option is chosen when the button is released
+// over the same option it was pressed on.
+func (m Model) updateMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.Button == tea.MouseButtonWheelUp:
+		m.list.CursorUp()
+
+	case msg.Button == tea.MouseButtonWheelDown:
+		m.list.CursorDown()
+
+	case msg.Action == tea.MouseActionPress && msg.Button == tea.MouseButtonLeft:
+		m.pressedItem = m.itemAt(msg.Y)
+		if m.pressedItem >= 0 {
+			m.list.Select(m.pressedItem)
+		}
+
+	case msg.Action == tea.MouseActionMotion && msg.Button == tea.MouseButtonLeft:
+		if i := m.itemAt(msg.Y); i >= 0 {
+			m.list.Select(i)
+		} else if msg.Y < listItemsTop {
+			m.list.CursorUp()
+		} else {
+			m.list.CursorDown()
+		}
+
+	case msg.Action == tea.MouseActionRelease:
+		pressed := m.pressedItem
+		m.pressedItem = -1
+		if pressed >= 0 && m.itemAt(msg.Y) == pressed {
+			return m.confirmSelection()
+		}
+	}
+	return m, nil
+}
+
+// updateHostInput handles key presses while the host input is open. Confirming a new host
+// cancels the running pull and quits the program so the caller can restart against it.
+func (m Model) updateHostInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.Type == tea.KeyCtrlC:
+		m.editingHost = false
+		return m.Update(msg)
+
+	case key.Matches(msg, keys.Back):
+		m.editingHost = false
+		m.hostInput.Blur()
 		return m, nil
+
+	case key.Matches(msg, keys.Select):
+		newHost := strings.TrimSpace(m.hostInput.Value())
+		m.editingHost = false
+		m.hostInput.Blur()
+		if newHost == "" || newHost == m.host {
+			return m, nil
+		}
+		return m.switchHost(newHost)
+	}
+
+	var cmd tea.Cmd
+	m.hostInput, cmd = m.hostInput.Update(msg)
+	return m, cmd
+}
+
+// helpView renders the keybindings from the central keymap followed by the current settings.
+func (m Model) helpView() string {
+	retryMode := "ask on timeout"
+	if m.continueUntilComplete {
+		retryMode = "retry until complete"
+	}
+	settings := detailsStyle.Render(fmt.Sprintf(
+		"Model: %s\nHost: %s\nRetry mode: %s\nRequest timeout: %s",
+		m.modelToPull, m.host, retryMode, m.requestTimeout(),
+	))
+
+	h := m.help
+	h.ShowAll = true
+	return fmt.Sprintf("Keybindings\n\n%s\n\nSettings\n\n%s\n\n%s",
+		h.View(keys), settings, h.ShortHelpView([]key.Binding{keys.Help}))
+}
+
+// timeoutTitle heads the retry menu with why the attempt ended: this client giving up
+// after timeout, the server breaking off, or the stream ending without success.
+func timeoutTitle(msg client.TimeoutMsg, timeout time.Duration) string {
+	switch msg.Reason {
+	case client.ReasonServerClosed:
+		return "The server closed the connection mid-download. Choose an option:"
+	case client.ReasonStreamEnded:
+		return "The download stream ended without success. Choose an option:"
+	default:
+		return fmt.Sprintf("Timed out after %s on this side (client deadline). Choose an option:", timeout)
+	}
+}
+
+// gatewayStatus names the HTTP status of a gateway error, e.g. "HTTP 502".
+func gatewayStatus(err error) string {
+	var statusErr *client.APIStatusError
+	if errors.As(err, &statusErr) {
+		return fmt.Sprintf("HTTP %d", statusErr.Code)
+	}
+	return err.Error()
+}
+
+// describeError turns client errors into a message that tells the user what to check.
+func (m Model) describeError(err error) string {
+	var statusErr *client.APIStatusError
+	var ollamaErr *client.OllamaError
+	switch {
+	case errors.Is(err, client.ErrHostUnreachable):
+		return fmt.Sprintf("could not reach Ollama at %s. Is the server running?", m.host)
+	case errors.Is(err, auth.ErrNoKey):
+		return err.Error()
+	case errors.Is(err, client.ErrUnauthorized):
+		return fmt.Sprintf("%s rejected the credentials. Check OLLAMA_API_KEY or that your ~/.ollama/id_ed25519.pub is added to your ollama.com account", m.host)
+	case errors.Is(err, client.ErrModelNotFound):
+		return fmt.Sprintf("model %q was not found", m.modelToPull)
+	case errors.Is(err, client.ErrManifestTimeout):
+		return fmt.Sprintf("no manifest for %q after %s. Check the model name, or the registry may be down", m.modelToPull, m.manifestTimeout())
+	case errors.Is(err, client.ErrGatewayUnavailable):
+		return fmt.Sprintf("the proxy in front of %s keeps answering %s. Check the proxy, and that Ollama is running behind it", m.host, gatewayStatus(err))
+	case errors.Is(err, client.ErrPullInProgress):
+		return fmt.Sprintf("%s is still busy with another pull after %s. Try again once it finishes", m.host, client.QueueWait)
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("Ollama returned HTTP %d: %s", statusErr.Code, strings.TrimSpace(statusErr.Body))
+	case errors.As(err, &ollamaErr):
+		return "Ollama reported an error: " + ollamaErr.Message
+	default:
+		return err.Error()
 	}
 }
 
-// formatBytes is a helper to display byte counts in a human-readable way.
-func formatBytes(b int64) string {
+// FormatBytes displays a byte count in a human-readable way, e.g. "4.7 GB".
+func FormatBytes(b int64) string {
 	const unit = 1024
 	if b < unit {
 		return fmt.Sprintf("%d B", b)
@@ -215,28 +639,78 @@ func formatBytes(b int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
 }
 
+// FormatSpeed displays a bytes-per-second rate in KB/s or MB/s.
+func FormatSpeed(speed float64) string {
+	if speed < 1024*1024 {
+		return fmt.Sprintf("%.1f KB/s", speed/1024)
+	}
+	return fmt.Sprintf("%.1f MB/s", speed/1024/1024)
+}
+
+// FormatCost returns the price of b bytes at perGB per GB (1024³ bytes, as FormatBytes
+// counts them) with currency in front, e.g. "$4.70".
+func FormatCost(b int64, perGB float64, currency string) string {
+	return fmt.Sprintf("%s%.2f", currency, float64(b)/(1<<30)*perGB)
+}
+
+// windowTitle summarizes progress for the terminal title, e.g. "ollama-downloader: llama3 42% ↓18.0 MB/s".
+func (m Model) windowTitle() string {
+	title := "ollama-downloader: " + m.modelToPull
+	switch {
+	case m.succeeded:
+		return title + " done"
+	case m.stats.Total() > 0:
+		title += fmt.Sprintf(" %d%%", int(m.percent*100))
+		if speed := m.stats.Speed(); speed > 0 {
+			title += " ↓" + FormatSpeed(speed)
+		}
+	}
+	return title
+}
+
+// SaveWindowTitle pushes the current terminal title onto the xterm title stack so
+// RestoreWindowTitle can put it back on exit. Terminals without a title stack ignore both.
+func SaveWindowTitle(w io.Writer) {
+	fmt.Fprint(w, "\x1b[22;0t")
+}
+
+// RestoreWindowTitle pops the title saved by SaveWindowTitle.
+func RestoreWindowTitle(w io.Writer) {
+	fmt.Fprint(w, "\x1b[23;0t")
+}
+
 func (m Model) View() string {
+	if m.plain {
+		return ansi.Strip(m.view())
+	}
+	return m.view()
+}
+
+func (m Model) view() string {
 	pad := lipgloss.NewStyle().Padding(1, 2)
 
+	if m.showHelp {
+		return pad.Render(m.helpView())
+	}
+
+	if m.editingHost {
+		return pad.Render(fmt.Sprintf("Switch Ollama host (current: %s)\n\n%s\n\n%s",
+			m.host, m.hostInput.View(), detailsStyle.Render("enter to switch • esc to cancel")))
+	}
+
 	if m.showList {
 		return "\n" + m.list.View()
 	}
 
 	var details string
-	if m.totalBytes > 0 {
-		speedStr := fmt.Sprintf("%.1f MB/s", m.speed/1024/1024)
-		if m.speed < 1024*1024 {
-			speedStr = fmt.Sprintf("%.1f KB/s", m.speed/1024)
-		}
+	if m.stats.Total() > 0 {
+		speedStr := FormatSpeed(m.stats.Speed())
 
-		downloadedStr := fmt.Sprintf("%s / %s", formatBytes(m.lastCompletedBytes), formatBytes(m.totalBytes))
+		downloadedStr := fmt.Sprintf("%s / %s", FormatBytes(m.stats.Completed()), FormatBytes(m.stats.Total()))
 
 		etaStr := "--"
-		if m.speed > 0 && m.totalBytes > m.lastCompletedBytes {
-			remainingBytes := m.totalBytes - m.lastCompletedBytes
-			etaSeconds := float64(remainingBytes) / m.speed
-			eta := time.Duration(etaSeconds) * time.Second
-			etaStr = fmt.Sprintf("%s left", eta.Round(time.Second))
+		if eta, ok := m.stats.ETA(); ok {
+			etaStr = fmt.Sprintf("%s left", eta.Truncate(time.Second))
 		}
 
 		if m.percent >= 1.0 {
@@ -244,23 +718,114 @@ func (m Model) View() string {
 			etaStr = "Complete"
 		}
 
-		details = detailsStyle.Render(lipgloss.JoinHorizontal(
-			lipgloss.Top,
-			downloadedStr,
-			"  •  ",
-			speedStr,
-			"  •  ",
-			etaStr,
-		))
+		if m.narrow() {
+			// One row per figure rather than a line that wraps and flickers.
+			rows := []string{downloadedStr}
+			if speedStr != "" {
+				rows = append(rows, speedStr)
+			}
+			details = detailsStyle.Render(lipgloss.JoinVertical(lipgloss.Left, append(rows, etaStr)...))
+		} else {
+			details = detailsStyle.Render(lipgloss.JoinHorizontal(
+				lipgloss.Top,
+				downloadedStr,
+				"  •  ",
+				speedStr,
+				"  •  ",
+				etaStr,
+			))
+		}
+	}
+
+	if m.freeSpace != nil && m.free >= 0 {
+		details += "\n" + detailsStyle.Render(m.fit(fmt.Sprintf("%s free in %s", FormatBytes(m.free), m.storeDir)))
+	}
+
+	if m.finishingLayer {
+		details += "\n" + detailsStyle.Render("Finishing current layer, then quitting...")
 	}
 
-	if m.percent == 0 && m.totalBytes == 0 {
-		return pad.Render(m.status)
+	if m.verifying && m.verifyTotal > 0 {
+		verifyPercent := float64(m.verifyCompleted) / float64(m.verifyTotal)
+		details += "\n\n" + m.verifyProgress.ViewAs(verifyPercent) + "\n" + detailsStyle.Render(
+			fmt.Sprintf("Verified %s / %s", FormatBytes(m.verifyCompleted), FormatBytes(m.verifyTotal)))
 	}
 
-	return pad.Render(fmt.Sprintf("%s\n%s\n%s", m.status, m.progress.ViewAs(m.percent), details))
+	shortHelp := m.help.ShortHelpView(keys.ShortHelp())
+	status := m.fit(m.status)
+
+	if m.percent == 0 && m.stats.Total() == 0 {
+		return pad.Render(fmt.Sprintf("%s\n\n%s", status, shortHelp))
+	}
+
+	return pad.Render(fmt.Sprintf("%s\n%s\n%s\n\n%s", status, m.barView(), details, shortHelp))
 }
 
-func (m Model) GetSelectedChoice() string {
+// narrow reports whether the terminal is too narrow for the details on one line.
+func (m Model) narrow() bool {
+	return m.width > 0 && m.width < narrowWidth
+}
+
+// fit shortens s with an ellipsis to fit the terminal inside the padding, so long
+// model names and errors do not wrap.
+func (m Model) fit(s string) string {
+	if m.width == 0 {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = truncate(l, m.width-padding*2)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// truncate shortens the plain text s to at most width columns, ending in "…".
+func truncate(s string, width int) string {
+	if lipgloss.Width(s) <= width {
+		return s
+	}
+	if width < 1 {
+		return ""
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && lipgloss.Width(string(runes))+1 > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// GetSelectedChoice returns the choice that ended the pull, or 0 if none did.
+func (m Model) GetSelectedChoice() client.Choice {
 	return m.selectedChoice
 }
+
+// Succeeded reports whether Ollama confirmed the pull with a "success" status.
+func (m Model) Succeeded() bool {
+	return m.succeeded
+}
+
+// Err returns the error that ended the pull, if any.
+func (m Model) Err() error {
+	return m.err
+}
+
+// DebugState describes the state of the UI in a few lines, for debug dumps.
+func (m Model) DebugState() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "model: %s\nhost: %s\nstatus: %q\npercent: %.1f\n", m.modelToPull, m.host, m.status, m.percent*100)
+	fmt.Fprintf(&b, "completed: %d, total: %d, speed: %.0f B/s\n", m.stats.Completed(), m.stats.Total(), m.stats.Speed())
+	fmt.Fprintf(&b, "menu shown: %t, selected: %q, quitting: %t, succeeded: %t\n", m.showList, m.selectedChoice, m.quitting, m.succeeded)
+	fmt.Fprintf(&b, "auto-retry: %t, finishing layer: %t, editing host: %t, verifying: %t\n",
+		m.continueUntilComplete, m.finishingLayer, m.editingHost, m.verifying)
+	fmt.Fprintf(&b, "width: %d, bar style: %q\n", m.width, m.bar.Style)
+	if m.err != nil {
+		fmt.Fprintf(&b, "error: %v\n", m.err)
+	}
+	return b.String()
+}
+
+// GetHost returns the Ollama host the model is pointed at, which changes after
+// client.ChoiceChangeHost.
+func (m Model) GetHost() string {
+	return m.host
+}
@@ -1,29 +1,32 @@
 package ui
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/stretchr/testify/assert"
 
 	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/registry"
 )
 
 // Helper function to create a new Model for testing
-func newTestModel() (Model, chan struct{}, chan string) {
-	quitUICh := make(chan struct{}, 1)
-	userChoiceCh := make(chan string, 1)
+func newTestModel() (Model, *client.Session) {
+	session := client.NewSession()
 	cancel := func() {}
-	model := NewModel("test-model", "http://localhost:11434", cancel, quitUICh, userChoiceCh)
-	return model, quitUICh, userChoiceCh
+	model := NewModel("test-model", "http://localhost:11434", cancel, session)
+	return model, session
 }
 
 // --- CORRECTED TEST ---
 func TestModel_Init(t *testing.T) {
-	m, _, _ := newTestModel()
+	m, _ := newTestModel()
 	cmd := m.Init()
 	// Init now returns a tea.Tick command to handle the ETA timer.
 	// The test should confirm that a command is returned, not that it's nil.
@@ -31,7 +34,7 @@ func TestModel_Init(t *testing.T) {
 }
 
 func TestModel_Update_WindowSizeMsg(t *testing.T) {
-	m, _, _ := newTestModel()
+	m, _ := newTestModel()
 	msg := tea.WindowSizeMsg{Width: 80, Height: 20}
 	updatedModel, cmd := m.Update(msg)
 
@@ -50,7 +53,7 @@ func TestModel_Update_WindowSizeMsg(t *testing.T) {
 }
 
 func TestModel_Update_KeyMsg_Quit(t *testing.T) {
-	m, quitUICh, userChoiceCh := newTestModel()
+	m, session := newTestModel()
 	msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'q'}}
 	updatedModel, cmd := m.Update(msg)
 
@@ -60,26 +63,26 @@ func TestModel_Update_KeyMsg_Quit(t *testing.T) {
 
 	model := updatedModel.(Model)
 	assert.True(t, model.quitting, "Model should be in quitting state")
-	assert.Equal(t, "Quit", model.selectedChoice, "Selected choice should be 'Quit'")
+	assert.Equal(t, client.ChoiceQuit, model.selectedChoice, "Selected choice should be 'Quit'")
 
 	select {
-	case <-quitUICh:
+	case <-session.Done():
 		// Expected
 	case <-time.After(100 * time.Millisecond):
-		t.Fatal("quitUICh was not closed")
+		t.Fatal("session was not closed")
 	}
 	select {
-	case choice := <-userChoiceCh:
-		assert.Equal(t, "Quit", choice, "User choice should be 'Quit'")
+	case choice := <-session.Choices():
+		assert.Equal(t, client.ChoiceQuit, choice, "User choice should be 'Quit'")
 	case <-time.After(100 * time.Millisecond):
-		t.Fatal("userChoiceCh did not receive 'Quit'")
+		t.Fatal("session did not receive 'Quit'")
 	}
 }
 
 func TestModel_Update_KeyMsg_Enter_ListShown(t *testing.T) {
-	m, quitUICh, userChoiceCh := newTestModel()
+	m, session := newTestModel()
 	m.showList = true
-	m.list.SetItems([]list.Item{item("Option 1"), item("Option 2")})
+	m.list.SetItems([]list.Item{item(client.ChoiceQuit), item(client.ChoiceContinue)})
 	m.list.Select(0)
 
 	msg := tea.KeyMsg{Type: tea.KeyEnter}
@@ -90,24 +93,24 @@ func TestModel_Update_KeyMsg_Enter_ListShown(t *testing.T) {
 	assert.Equal(t, tea.Quit(), cmd(), "Command should be tea.Quit")
 
 	model := updatedModel.(Model)
-	assert.Equal(t, "Option 1", model.selectedChoice, "Selected choice should be 'Option 1'")
+	assert.Equal(t, client.ChoiceQuit, model.selectedChoice, "Selected choice should be 'Quit'")
 
 	select {
-	case <-quitUICh:
+	case <-session.Done():
 		// Expected
 	case <-time.After(100 * time.Millisecond):
-		t.Fatal("quitUICh was not closed")
+		t.Fatal("session was not closed")
 	}
 	select {
-	case choice := <-userChoiceCh:
-		assert.Equal(t, "Option 1", choice, "User choice should be 'Option 1'")
+	case choice := <-session.Choices():
+		assert.Equal(t, client.ChoiceQuit, choice, "User choice should be 'Quit'")
 	case <-time.After(100 * time.Millisecond):
-		t.Fatal("userChoiceCh did not receive 'Option 1'")
+		t.Fatal("session did not receive 'Quit'")
 	}
 }
 
 func TestModel_Update_ProgressMsg(t *testing.T) {
-	m, _, _ := newTestModel()
+	m, _ := newTestModel()
 	msg := client.ProgressMsg{Status: "downloading", Completed: 50, Total: 100}
 	updatedModel, cmd := m.Update(msg)
 
@@ -123,10 +126,15 @@ func TestModel_Update_ProgressMsg(t *testing.T) {
 	model = updatedModel.(Model)
 	assert.Equal(t, "starting", model.status, "Status should be updated")
 	assert.InDelta(t, 0.0, model.percent, 0.001, "Percent should be 0 when total is 0")
+
+	updatedModel, _ = m.Update(client.ProgressMsg{Status: "pulling 6a0746a1ec1a", Digest: "sha256:6a0746a1ec1a", Layer: 2, Layers: 5})
+	assert.Equal(t, "pulling 6a0746a1ec1a (layer 2 of 5)", updatedModel.(Model).status)
+	updatedModel, _ = m.Update(client.ProgressMsg{Status: "pulling 6a0746a1ec1a", Digest: "sha256:6a0746a1ec1a", Layer: 2})
+	assert.Equal(t, "pulling 6a0746a1ec1a (layer 2)", updatedModel.(Model).status, "Without a layer count only the position is shown")
 }
 
 func TestModel_Update_TimeoutMsg(t *testing.T) {
-	m, _, _ := newTestModel()
+	m, _ := newTestModel()
 	msg := client.TimeoutMsg{}
 	updatedModel, cmd := m.Update(msg)
 
@@ -137,8 +145,63 @@ func TestModel_Update_TimeoutMsg(t *testing.T) {
 	assert.True(t, model.showList, "showList should be true after TimeoutMsg")
 }
 
+func TestModel_Update_TimeoutMsg_Reason(t *testing.T) {
+	tests := []struct {
+		reason client.TimeoutReason
+		want   string
+	}{
+		{client.ReasonDeadline, "Timed out after 30s on this side"},
+		{client.ReasonServerClosed, "server closed the connection"},
+		{client.ReasonStreamEnded, "stream ended without success"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason.String(), func(t *testing.T) {
+			m, _ := newTestModel()
+			updated, _ := m.Update(client.TimeoutMsg{Reason: tt.reason})
+			assert.Contains(t, updated.View(), tt.want)
+		})
+	}
+}
+
+func TestModel_Update_ShorterTimeout(t *testing.T) {
+	m, session := newTestModel()
+	m = m.WithTimeouts(client.Timeouts{Request: time.Minute})
+	updated, _ := m.Update(client.TimeoutMsg{})
+	m = updated.(Model)
+	assert.Contains(t, m.View(), "Timed out after 1m0s")
+
+	m.list.Select(2)
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, client.ChoiceShorterTimeout, <-session.Choices())
+	updated, _ = updated.Update(client.TimeoutMsg{})
+	assert.Contains(t, updated.View(), "Timed out after 30s", "The menu quotes the shortened timeout")
+	assert.Equal(t, 30*time.Second, updated.(Model).requestTimeout())
+}
+
+func TestModel_Update_PausedMsg(t *testing.T) {
+	m, _ := newTestModel()
+	updated, cmd := m.Update(client.PausedMsg{Reason: "on battery at 15%, below 20%; resumes when plugged in"})
+	assert.Nil(t, cmd)
+	assert.Equal(t, "Paused: on battery at 15%, below 20%; resumes when plugged in", updated.(Model).status)
+}
+
+func TestModel_Update_RestartingMsg(t *testing.T) {
+	m, _ := newTestModel()
+	updated, cmd := m.Update(client.RestartingMsg{Err: errors.New("connection reset"), Wait: 2 * time.Minute})
+	assert.Nil(t, cmd, "Waiting for a restart should neither quit nor show the menu")
+	assert.Equal(t, "Server restarting… reconnecting for up to 2m0s", updated.(Model).status)
+	assert.False(t, updated.(Model).showList)
+}
+
+func TestModel_Update_RetryingMsg(t *testing.T) {
+	m, _ := newTestModel()
+	updated, cmd := m.Update(client.RetryingMsg{Err: &client.APIStatusError{Code: 503}, Attempt: 2, Delay: 4 * time.Second})
+	assert.Nil(t, cmd, "Retrying a gateway error should neither quit nor show the menu")
+	assert.Equal(t, "Gateway error (HTTP 503), retrying in 4s", updated.(Model).status)
+}
+
 func TestModel_Update_ErrorMsg(t *testing.T) {
-	m, quitUICh, userChoiceCh := newTestModel()
+	m, session := newTestModel()
 	msg := client.ErrorMsg{Err: assert.AnError}
 	updatedModel, cmd := m.Update(msg)
 
@@ -148,24 +211,41 @@ func TestModel_Update_ErrorMsg(t *testing.T) {
 
 	model := updatedModel.(Model)
 	assert.True(t, strings.Contains(model.status, "Error:"), "Status should indicate an error")
-	assert.Equal(t, "Quit", model.selectedChoice, "Selected choice should be 'Quit'")
+	assert.ErrorIs(t, model.Err(), assert.AnError, "Err should report the error that ended the pull")
+	assert.Equal(t, client.ChoiceQuit, model.selectedChoice, "Selected choice should be 'Quit'")
 
 	select {
-	case <-quitUICh:
+	case <-session.Done():
 		// Expected
 	case <-time.After(100 * time.Millisecond):
-		t.Fatal("quitUICh was not closed")
+		t.Fatal("session was not closed")
 	}
 	select {
-	case choice := <-userChoiceCh:
-		assert.Equal(t, "Quit", choice, "User choice should be 'Quit'")
+	case choice := <-session.Choices():
+		assert.Equal(t, client.ChoiceQuit, choice, "User choice should be 'Quit'")
 	case <-time.After(100 * time.Millisecond):
-		t.Fatal("userChoiceCh did not receive 'Quit'")
+		t.Fatal("session did not receive 'Quit'")
 	}
 }
 
+func TestModel_WithFreeSpace(t *testing.T) {
+	m, _ := newTestModel()
+	free := int64(20 << 30)
+	m = m.WithFreeSpace("/data/ollama", func() (int64, error) { return free, nil })
+	updated, _ := m.Update(client.ProgressMsg{Status: "pulling abc", Completed: 10, Total: 100})
+	assert.Contains(t, updated.View(), "20.0 GB free in /data/ollama")
+
+	free = 19 << 30
+	updated, _ = updated.Update(time.Now())
+	assert.Contains(t, updated.View(), "19.0 GB free in /data/ollama", "Free space should refresh every tick")
+
+	m = m.WithFreeSpace("", nil)
+	updated, _ = m.Update(client.ProgressMsg{Status: "pulling abc", Completed: 10, Total: 100})
+	assert.NotContains(t, updated.View(), "free in", "A remote host shows no free space")
+}
+
 func TestModel_View_ShowListFalse(t *testing.T) {
-	m, _, _ := newTestModel()
+	m, _ := newTestModel()
 	m.status = "Downloading..."
 	m.percent = 0.75
 	m.progress.Width = 50
@@ -175,20 +255,353 @@ func TestModel_View_ShowListFalse(t *testing.T) {
 	assert.True(t, strings.Contains(viewOutput, "75%"), "View output should contain percentage in progress bar")
 }
 
+func TestModel_View_Narrow(t *testing.T) {
+	m, _ := newTestModel()
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 40, Height: 24})
+	m = updated.(Model)
+	m.status = "Error: model 'some-organisation/a-very-long-model-name:70b-instruct' not found"
+	m.percent = 0.5
+	m.stats.Update(client.ProgressMsg{Status: "pulling abc", Completed: 1 << 30, Total: 2 << 30})
+	m.stats.Seed(10 << 20)
+
+	view := m.View()
+	for _, line := range strings.Split(view, "\n") {
+		assert.LessOrEqual(t, lipgloss.Width(line), 40, "Line %q is wider than the terminal", line)
+	}
+	assert.Contains(t, view, "Error: model 'some-organisation/a-v…", "The status is cut to the width inside the padding")
+	assert.Regexp(t, `1\.0 GB / 2\.0 GB\s*\n\s*10\.0 MB/s\s*\n\s*\S+ left`, view, "The details are stacked")
+
+	updated, _ = m.Update(tea.WindowSizeMsg{Width: 12, Height: 24})
+	assert.Equal(t, minBarWidth, updated.(Model).progress.Width, "The bar shrinks no further than minBarWidth")
+	assert.Equal(t, "abc…", truncate("abcdef", 4))
+	assert.Equal(t, "abc", truncate("abc", 3))
+}
+
 func TestModel_View_ShowListTrue(t *testing.T) {
-	m, _, _ := newTestModel()
+	m, _ := newTestModel()
 	m.showList = true
-	m.list.SetItems([]list.Item{item("Option A"), item("Option B")})
+	m.list.SetItems([]list.Item{item(client.ChoiceContinue), item(client.ChoiceQuit)})
 	m.list.Select(0)
 
 	viewOutput := m.View()
-	assert.True(t, strings.Contains(viewOutput, "Connection timed out or context deadline exceeded. Choose an option:"), "View output should contain list title")
-	assert.True(t, strings.Contains(viewOutput, "> 1. Option A"), "View output should contain selected list item")
-	assert.True(t, strings.Contains(viewOutput, "2. Option B"), "View output should contain other list item")
+	assert.True(t, strings.Contains(viewOutput, "Timed out after 30s on this side (client deadline). Choose an option:"), "View output should contain list title")
+	assert.True(t, strings.Contains(viewOutput, "> 1. Continue (until next error)"), "View output should contain selected list item")
+	assert.True(t, strings.Contains(viewOutput, "2. Quit"), "View output should contain other list item")
 }
 
 func TestModel_GetSelectedChoice(t *testing.T) {
-	m, _, _ := newTestModel()
-	m.selectedChoice = "Test Choice"
-	assert.Equal(t, "Test Choice", m.GetSelectedChoice(), "GetSelectedChoice should return the correct choice")
+	m, _ := newTestModel()
+	m.selectedChoice = client.ChoiceShorterTimeout
+	assert.Equal(t, client.ChoiceShorterTimeout, m.GetSelectedChoice(), "GetSelectedChoice should return the correct choice")
+}
+
+func TestModel_DescribeError(t *testing.T) {
+	m, _ := newTestModel()
+
+	assert.Contains(t, m.describeError(fmt.Errorf("%w: dial tcp", client.ErrHostUnreachable)), "could not reach Ollama at http://localhost:11434")
+	assert.Contains(t, m.describeError(&client.APIStatusError{Code: 404, Body: "not found"}), `model "test-model" was not found`)
+	assert.Contains(t, m.describeError(&client.APIStatusError{Code: 500, Body: "boom\n"}), "Ollama returned HTTP 500: boom")
+	assert.Contains(t, m.describeError(&client.APIStatusError{Code: 409, Body: "busy"}), "still busy with another pull")
+	assert.Contains(t, m.describeError(&client.OllamaError{Message: "pull model manifest: file does not exist"}), `model "test-model" was not found`)
+	assert.Equal(t, "Ollama reported an error: disk full", m.describeError(&client.OllamaError{Message: "disk full"}))
+	assert.Equal(t, "something else", m.describeError(errors.New("something else")))
+
+	assert.Contains(t, m.describeError(client.ErrManifestTimeout), "after "+client.ManifestTimeout.String())
+	m = m.WithTimeouts(client.Timeouts{Manifest: 3 * time.Minute})
+	assert.Contains(t, m.describeError(client.ErrManifestTimeout), "after 3m0s", "The pull's own timeout is quoted")
+}
+
+func TestModel_Update_KeyMsg_FinishLayer(t *testing.T) {
+	m, session := newTestModel()
+	m.stats.Update(client.ProgressMsg{Status: "pulling abc", Total: 100})
+	msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'s'}}
+	updatedModel, cmd := m.Update(msg)
+
+	model := updatedModel.(Model)
+	assert.True(t, model.finishingLayer, "Model should be finishing the current layer")
+	assert.Equal(t, client.ChoiceFinishLayer, model.GetSelectedChoice())
+	assert.Contains(t, model.View(), "Finishing current layer")
+
+	assert.Nil(t, cmd, "Choose never blocks, so Update can hand over the choice itself")
+	assert.Equal(t, client.ChoiceFinishLayer, <-session.Choices())
+
+	_, cmd = model.Update(msg)
+	assert.Nil(t, cmd, "Pressing 's' twice should not send the choice again")
+}
+
+func TestModel_Update_ChangeHost(t *testing.T) {
+	session := client.NewSession()
+	cancelled := false
+	m := NewModel("test-model", "http://localhost:11434", func() { cancelled = true }, session)
+
+	updatedModel, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'h'}})
+	model := updatedModel.(Model)
+	assert.True(t, model.editingHost, "'h' should open the host input")
+	assert.Contains(t, model.View(), "Switch Ollama host")
+
+	// 'q' is text while the input is open, not a quit request.
+	updatedModel, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'q'}})
+	model = updatedModel.(Model)
+	assert.False(t, model.quitting, "Typing 'q' in the host input should not quit")
+
+	model.hostInput.SetValue("http://gpu-box:11434")
+	updatedModel, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	model = updatedModel.(Model)
+
+	assert.Equal(t, tea.Quit(), cmd(), "Switching host should quit the current program")
+	assert.True(t, cancelled, "Switching host should cancel the running pull")
+	assert.Equal(t, client.ChoiceChangeHost, model.GetSelectedChoice())
+	assert.Equal(t, "http://gpu-box:11434", model.GetHost())
+	select {
+	case <-session.Done():
+		// Expected
+	default:
+		t.Fatal("session was not closed")
+	}
+}
+
+func TestModel_TimeoutMenu_ChangeHost(t *testing.T) {
+	m, session := newTestModel()
+	updatedModel, _ := m.Update(client.TimeoutMsg{})
+	assert.NotContains(t, updatedModel.View(), "Change host and retry", "Without other hosts there is nothing to change to")
+
+	cancelled := false
+	m = NewModel("test-model", "localhost:11434", func() { cancelled = true }, session).
+		WithHosts([]string{"http://localhost:11434", "http://gpu-box:11434"})
+	updatedModel, _ = m.Update(client.TimeoutMsg{})
+	model := updatedModel.(Model)
+	assert.Contains(t, model.View(), "4. Change host and retry")
+
+	model.list.Select(3)
+	updatedModel, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	model = updatedModel.(Model)
+	assert.Equal(t, tea.Quit(), cmd())
+	assert.True(t, cancelled, "Changing host should cancel the running pull")
+	assert.Equal(t, client.ChoiceChangeHost, model.GetSelectedChoice())
+	assert.Equal(t, "http://gpu-box:11434", model.GetHost(), "The next configured host should be picked")
+	assert.Equal(t, client.ChoiceChangeHost, <-session.Choices())
+
+	model = model.Restart(model.GetHost(), func() {}, client.NewSession())
+	assert.Equal(t, "http://localhost:11434", model.nextHost(), "The configured hosts should wrap around")
+}
+
+func TestModel_Restart(t *testing.T) {
+	m, _ := newTestModel()
+	updatedModel, _ := m.Update(client.ProgressMsg{Status: "pulling abc", Completed: 50, Total: 200})
+	updatedModel, _ = updatedModel.Update(client.ErrorMsg{Err: errors.New("connection refused")})
+
+	session := client.NewSession()
+	model := updatedModel.(Model).Restart("http://gpu-box:11434", func() {}, session)
+	assert.Equal(t, "http://gpu-box:11434", model.GetHost())
+	assert.Empty(t, model.GetSelectedChoice(), "A restarted pull has no choice yet")
+	assert.NoError(t, model.Err())
+	assert.Equal(t, int64(50), model.stats.Completed(), "Restarting should keep the statistics")
+
+	model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'q'}})
+	assert.Equal(t, client.ChoiceQuit, <-session.Choices(), "The restarted model should answer the new session")
+}
+
+func TestModel_Update_ChangeHost_Esc(t *testing.T) {
+	m, _ := newTestModel()
+	updatedModel, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'h'}})
+	updatedModel, cmd := updatedModel.(Model).Update(tea.KeyMsg{Type: tea.KeyEsc})
+	model := updatedModel.(Model)
+
+	assert.Nil(t, cmd, "Esc should only close the input")
+	assert.False(t, model.editingHost, "Esc should close the host input")
+	assert.Equal(t, "http://localhost:11434", model.GetHost(), "Host should be unchanged")
+}
+
+func TestModel_Succeeded(t *testing.T) {
+	m, _ := newTestModel()
+	updatedModel, _ := m.Update(client.ProgressMsg{Status: "writing manifest"})
+	assert.False(t, updatedModel.(Model).Succeeded(), "Pull should not be successful before the success status")
+
+	updatedModel, _ = updatedModel.(Model).Update(client.ProgressMsg{Status: "success"})
+	assert.True(t, updatedModel.(Model).Succeeded(), "Pull should be successful after the success status")
+}
+
+func TestModel_Update_HelpOverlay(t *testing.T) {
+	m, _ := newTestModel()
+	m = m.WithRetryMode(true).WithTimeouts(client.Timeouts{Request: 95 * time.Second})
+	assert.Contains(t, m.View(), "? toggle help", "Progress view should hint at the help key")
+
+	updatedModel, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'?'}})
+	assert.Nil(t, cmd)
+	model := updatedModel.(Model)
+	assert.True(t, model.showHelp, "'?' should open the help overlay")
+
+	view := model.View()
+	for _, b := range keys.FullHelp() {
+		for _, binding := range b {
+			assert.Contains(t, view, binding.Help().Desc, "Help overlay should list every keybinding")
+		}
+	}
+	assert.Contains(t, view, "Host: http://localhost:11434")
+	assert.Contains(t, view, "Retry mode: retry until complete")
+	assert.Contains(t, view, "Request timeout: 1m35s")
+
+	updatedModel, _ = model.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.False(t, updatedModel.(Model).showHelp, "Esc should close the help overlay")
+}
+
+func TestModel_Update_MouseClickSelectsOption(t *testing.T) {
+	m, session := newTestModel()
+	m.showList = true
+
+	// The click coordinates rely on where the first item is drawn.
+	lines := strings.Split(m.View(), "\n")
+	assert.Contains(t, lines[listItemsTop], "1. Continue (until next error)", "First item should be drawn at listItemsTop")
+
+	press := tea.MouseMsg{X: 5, Y: listItemsTop + 1, Action: tea.MouseActionPress, Button: tea.MouseButtonLeft}
+	updatedModel, cmd := m.Update(press)
+	assert.Nil(t, cmd)
+	model := updatedModel.(Model)
+	assert.Equal(t, 1, model.list.Index(), "Pressing should highlight the item under the pointer")
+
+	release := tea.MouseMsg{X: 5, Y: listItemsTop + 1, Action: tea.MouseActionRelease}
+	updatedModel, cmd = model.Update(release)
+	assert.Nil(t, cmd, "Continuing should keep the program running")
+	assert.Equal(t, client.ChoiceContinueUntilComplete, <-session.Choices(), "Releasing over the pressed item should choose it")
+	model = updatedModel.(Model)
+	assert.False(t, model.showList, "Continuing should go back to the progress view")
+	assert.True(t, model.continueUntilComplete)
+	assert.Empty(t, model.GetSelectedChoice(), "Continuing should not end the pull")
+	select {
+	case <-session.Done():
+		t.Fatal("session was closed")
+	default:
+	}
+}
+
+func TestModel_Update_MouseDragAndWheel(t *testing.T) {
+	m, _ := newTestModel()
+	m.showList = true
+
+	updatedModel, _ := m.Update(tea.MouseMsg{Y: listItemsTop, Action: tea.MouseActionPress, Button: tea.MouseButtonLeft})
+	updatedModel, _ = updatedModel.(Model).Update(tea.MouseMsg{Y: listItemsTop + 2, Action: tea.MouseActionMotion, Button: tea.MouseButtonLeft})
+	model := updatedModel.(Model)
+	assert.Equal(t, 2, model.list.Index(), "Dragging should move the highlight")
+
+	updatedModel, cmd := model.Update(tea.MouseMsg{Y: listItemsTop + 2, Action: tea.MouseActionRelease})
+	assert.Nil(t, cmd, "Releasing on a different item than pressed should not choose it")
+
+	updatedModel, _ = updatedModel.(Model).Update(tea.MouseMsg{Action: tea.MouseActionPress, Button: tea.MouseButtonWheelUp})
+	assert.Equal(t, 1, updatedModel.(Model).list.Index(), "Wheel up should move the highlight up")
+}
+
+func TestModel_Update_VerificationProgress(t *testing.T) {
+	m, _ := newTestModel()
+	m.progress.Width = 40
+	m.verifyProgress.Width = 40
+
+	updatedModel, _ := m.Update(client.ProgressMsg{Status: "pulling abc", Completed: 100, Total: 100})
+	updatedModel, _ = updatedModel.(Model).Update(client.ProgressMsg{Status: "verifying sha256 digest", Completed: 25, Total: 100})
+	model := updatedModel.(Model)
+
+	assert.True(t, model.verifying, "Verifying status should switch to the verification phase")
+	assert.InDelta(t, 1.0, model.percent, 0.001, "Download percent should stay complete while verifying")
+	assert.Equal(t, int64(100), model.stats.Completed(), "Download counters should not be overwritten by verification")
+
+	view := model.View()
+	assert.Contains(t, view, "Verified 25 B / 100 B")
+	assert.Contains(t, view, "Complete", "Download details should still show completion")
+
+	updatedModel, _ = model.Update(client.ProgressMsg{Status: "writing manifest"})
+	model = updatedModel.(Model)
+	assert.False(t, model.verifying, "Later phases should leave the verification phase")
+	assert.NotContains(t, model.View(), "Verified")
+}
+
+func TestModel_WindowTitle(t *testing.T) {
+	m, _ := newTestModel()
+	assert.Equal(t, "ollama-downloader: test-model", m.windowTitle())
+
+	m.stats.Update(client.ProgressMsg{Status: "pulling abc", Completed: 42, Total: 100})
+	m.percent = 0.42
+	m.stats.Seed(18 * 1024 * 1024)
+	assert.Equal(t, "ollama-downloader: test-model 42% ↓18.0 MB/s", m.windowTitle())
+
+	m.succeeded = true
+	assert.Equal(t, "ollama-downloader: test-model done", m.windowTitle())
+}
+
+func TestModel_Update_SpeedFromTicks(t *testing.T) {
+	m, _ := newTestModel()
+	m = m.WithInitialSpeed(1000)
+	updatedModel, _ := m.Update(client.ProgressMsg{Status: "pulling abc", Completed: 0, Total: 100000})
+	assert.Contains(t, updatedModel.(Model).View(), "1m40s left", "A probed speed should give an ETA before any progress")
+
+	updatedModel, _ = updatedModel.(Model).Update(client.ProgressMsg{Status: "pulling abc", Completed: 50000, Total: 100000})
+	updatedModel, _ = updatedModel.(Model).Update(time.Now())
+	updatedModel, _ = updatedModel.(Model).Update(client.ProgressMsg{Status: "pulling abc", Completed: 52000, Total: 100000})
+	updatedModel, _ = updatedModel.(Model).Update(time.Now())
+	assert.Contains(t, updatedModel.(Model).View(), "1.3 KB/s", "Ticks should update the speed shown")
+}
+
+func TestModel_ETAOverLayers(t *testing.T) {
+	m, _ := newTestModel()
+	m = m.WithInitialSpeed(1000).WithLayers(map[string]int64{
+		"sha256:aaaaaaaaaaaa1111": 10000,
+		"sha256:bbbbbbbbbbbb2222": 50000,
+		"sha256:cccccccccccc3333": 100,
+	})
+	updated, _ := m.Update(client.ProgressMsg{Status: "pulling aaaaaaaaaaaa", Completed: 4000, Total: 10000})
+	assert.Contains(t, updated.(Model).View(), "56s left", "Layers not started yet count towards the ETA")
+}
+
+func TestTagPicker_WithCost(t *testing.T) {
+	picker := NewTagPicker("llama3", []registry.TagInfo{{Tag: "8b", Size: 4 << 30, Parameters: "8.0B"}}).WithCost(1.5, "$")
+	assert.Contains(t, picker.View(), "4.0 GB (~$6.00) · 8.0B")
+	assert.Equal(t, "€0.75", FormatCost(512<<20, 1.5, "€"))
+}
+
+func TestTagPicker_SelectAndFilter(t *testing.T) {
+	picker := NewTagPicker("llama3:latest", []registry.TagInfo{
+		{Tag: "8b", Size: 4 << 30, Parameters: "8.0B", Quantization: "Q4_0"},
+		{Tag: "70b-q8_0", Size: 70 << 30, Parameters: "70.6B", Quantization: "Q8_0"},
+	})
+	assert.Contains(t, picker.View(), "4.0 GB · 8.0B · Q4_0")
+
+	var m tea.Model = picker
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	m, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.NotNil(t, cmd)
+	assert.Equal(t, "llama3:70b-q8_0", m.(TagPicker).Selected(), "The selected tag should replace the given one")
+
+	m, _ = NewTagPicker("llama3", nil).Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'q'}})
+	assert.Empty(t, m.(TagPicker).Selected(), "Quitting should select nothing")
+}
+
+func TestModel_Update_QuitThenError(t *testing.T) {
+	m, session := newTestModel()
+	updatedModel, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'q'}})
+	assert.NotPanics(t, func() {
+		updatedModel.Update(client.ErrorMsg{Err: errors.New("connection reset")})
+	}, "An error arriving after the user quit should not close the session again")
+	<-session.Done()
+	assert.Equal(t, client.ChoiceQuit, <-session.Choices())
+}
+
+func TestBarOptions_Validate(t *testing.T) {
+	for _, style := range append(BarStyles, "") {
+		assert.NoError(t, BarOptions{Style: style}.Validate(), style)
+	}
+	assert.ErrorContains(t, BarOptions{Style: "fancy"}.Validate(), "unknown bar style")
+	assert.Error(t, BarOptions{Style: BarPercent, HidePercentage: true}.Validate(), "A percentage-only bar needs its percentage")
+	assert.Error(t, BarOptions{MaxWidth: -1}.Validate())
+
+	m, _ := newTestModel()
+	updated, _ := m.WithBar(BarOptions{MaxWidth: 30}).Update(tea.WindowSizeMsg{Width: 120, Height: 20})
+	assert.Equal(t, 30, updated.(Model).progress.Width, "The bar width is capped")
+}
+
+func TestModel_DebugState(t *testing.T) {
+	m, _ := newTestModel()
+	updated, _ := m.Update(client.ProgressMsg{Status: "pulling abc", Completed: 50, Total: 200})
+	updated, _ = updated.Update(client.ErrorMsg{Err: errors.New("connection reset")})
+	state := updated.(Model).DebugState()
+	assert.Contains(t, state, "model: test-model\nhost: http://localhost:11434\n")
+	assert.Contains(t, state, "completed: 50, total: 200")
+	assert.Contains(t, state, "error: connection reset\n")
 }
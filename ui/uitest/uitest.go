@@ -0,0 +1,100 @@
+// Package uitest drives the downloader's views without a terminal, for golden tests in
+// this repository and in downstream packages. Build a model with ui.NewModel and
+// WithFixedLayout, feed it messages with Run and compare the view with Golden:
+//
+//	m := ui.NewModel("llama3", host, cancel, client.NewSession()).WithFixedLayout(80)
+//	view := uitest.Run(m, uitest.Progress("pulling 6a0746a1ec1a", 512<<20, 4<<30)).View()
+//	uitest.Golden(t, "testdata/pulling.golden", view)
+//
+// Set UPDATE_GOLDEN=1 to write the golden files instead of comparing with them.
+package uitest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"ollama-downloader-v2/client"
+)
+
+// Run passes msgs to m in order and returns the resulting model. Commands the model
+// returns are not run, so nothing is scheduled or waits for input.
+func Run(m tea.Model, msgs ...tea.Msg) tea.Model {
+	for _, msg := range msgs {
+		m, _ = m.Update(msg)
+	}
+	return m
+}
+
+// Progress is a progress update from the pull.
+func Progress(status string, completed, total int64) tea.Msg {
+	return client.ProgressMsg{Status: status, Completed: completed, Total: total}
+}
+
+// Timeout reports a timed-out request, which shows the retry menu.
+func Timeout() tea.Msg {
+	return client.TimeoutMsg{Reason: client.ReasonDeadline, Err: context.DeadlineExceeded}
+}
+
+// Error reports the error that ends the pull. The model sends "Quit" on its choice
+// channel, so give it one with room for a value.
+func Error(err error) tea.Msg {
+	return client.ErrorMsg{Err: err}
+}
+
+// Tick is the once-a-second tick the speed and ETA are computed on.
+func Tick() tea.Msg {
+	return time.Time{}
+}
+
+// Resize reports a new terminal size. Models built WithFixedLayout ignore it.
+func Resize(width, height int) tea.Msg {
+	return tea.WindowSizeMsg{Width: width, Height: height}
+}
+
+// Key is a key press: a name such as "enter", "esc", "up", "down", "tab" or "ctrl+c",
+// or the characters typed, e.g. "q".
+func Key(k string) tea.Msg {
+	if t, ok := keyTypes[k]; ok {
+		return tea.KeyMsg{Type: t}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+}
+
+var keyTypes = map[string]tea.KeyType{
+	"enter":     tea.KeyEnter,
+	"esc":       tea.KeyEsc,
+	"up":        tea.KeyUp,
+	"down":      tea.KeyDown,
+	"left":      tea.KeyLeft,
+	"right":     tea.KeyRight,
+	"tab":       tea.KeyTab,
+	"backspace": tea.KeyBackspace,
+	"ctrl+c":    tea.KeyCtrlC,
+}
+
+// Golden compares got with the file at path, or writes it there when UPDATE_GOLDEN is
+// set.
+func Golden(t testing.TB, path, got string) {
+	t.Helper()
+	if os.Getenv("UPDATE_GOLDEN") != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (set UPDATE_GOLDEN=1 to create it): %v", err)
+	}
+	if string(want) != got {
+		t.Errorf("view does not match %s (set UPDATE_GOLDEN=1 to update it)\n--- want\n%s\n--- got\n%s", path, want, got)
+	}
+}
@@ -0,0 +1,64 @@
+package uitest
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/ui"
+)
+
+func newModel(width int) ui.Model {
+	return ui.NewModel("llama3", "http://localhost:11434", func() {}, client.NewSession()).WithFixedLayout(width)
+}
+
+func TestViews(t *testing.T) {
+	const gib = 1 << 30
+	pulling := []tea.Msg{Progress("pulling 6a0746a1ec1a", gib, 4*gib), Tick(), Progress("pulling 6a0746a1ec1a", gib+64<<20, 4*gib), Tick()}
+	for _, tt := range []struct {
+		name  string
+		width int
+		msgs  []tea.Msg
+	}{
+		{"starting", 80, []tea.Msg{Progress("pulling manifest", 0, 0)}},
+		{"pulling", 80, pulling},
+		{"pulling-narrow", 40, pulling},
+		{"resized", 80, append([]tea.Msg{Resize(30, 10)}, pulling...)},
+		{"retry-menu", 80, append(pulling, Timeout())},
+		{"help", 80, append(pulling, Key("?"))},
+		{"error", 60, []tea.Msg{Error(errors.New("pull model manifest: file does not exist"))}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Run(newModel(tt.width), tt.msgs...)
+			view := m.View()
+			Golden(t, filepath.Join("testdata", tt.name+".golden"), view)
+			assert.Equal(t, view, m.View(), "Rendering is deterministic")
+		})
+	}
+}
+
+func TestBarStyles(t *testing.T) {
+	const gib = 1 << 30
+	for _, tt := range []struct {
+		name string
+		bar  ui.BarOptions
+	}{
+		{"bar-ascii", ui.BarOptions{Style: ui.BarASCII, MaxWidth: 40}},
+		{"bar-percent", ui.BarOptions{Style: ui.BarPercent}},
+		{"bar-no-percentage", ui.BarOptions{Style: ui.BarSolid, HidePercentage: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Run(newModel(80).WithBar(tt.bar), Progress("pulling 6a0746a1ec1a", gib, 4*gib))
+			Golden(t, filepath.Join("testdata", tt.name+".golden"), m.View())
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "enter", Key("enter").(tea.KeyMsg).String())
+	assert.Equal(t, "q", Key("q").(tea.KeyMsg).String())
+}
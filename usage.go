@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ollama-downloader-v2/config"
+	"ollama-downloader-v2/history"
+	"ollama-downloader-v2/stats"
+	"ollama-downloader-v2/ui"
+)
+
+// runUsageCommand prints how much data the pulls downloaded in each calendar month, from
+// the history.
+func runUsageCommand(args []string) int {
+	fs := newFlagSet("usage")
+	monthlyCap := fs.String("monthly-cap", "", "Data allowance per month (e.g. '200GB') to show this month's share of. Overrides monthly_cap in the config.")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+	limit, err := monthlyLimit(*monthlyCap)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	path, err := history.Path()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	entries, err := history.Load(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	months := history.Usage(entries)
+	if len(months) == 0 {
+		fmt.Println("No pulls recorded yet.")
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "MONTH\tDOWNLOADED\tPULLS")
+	for _, m := range months {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", m.Start.Format("2006-01"), ui.FormatBytes(m.Bytes), m.Pulls)
+	}
+	tw.Flush()
+	if limit > 0 {
+		used := history.MonthBytes(entries, time.Now())
+		fmt.Printf("\nThis month: %s of the %s cap (%.0f%%).\n", ui.FormatBytes(used), ui.FormatBytes(limit), float64(used)/float64(limit)*100)
+	}
+	return 0
+}
+
+// monthlyLimit parses the monthly data cap given as a flag, falling back to the config's.
+// It returns 0 if no cap is set.
+func monthlyLimit(flag string) (int64, error) {
+	if flag == "" {
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Printf("Ignoring config: %v", err)
+			return 0, nil
+		}
+		flag = cfg.MonthlyCap
+	}
+	if flag == "" {
+		return 0, nil
+	}
+	limit, err := config.ParseSize(flag)
+	if err != nil {
+		return 0, fmt.Errorf("monthly cap: %w", err)
+	}
+	return limit, nil
+}
+
+// checkMonthlyCap reports whether a download of size bytes may go ahead under the monthly
+// data cap limit. If it would go over, the user is asked; without a terminal to ask on,
+// the pull is refused.
+func checkMonthlyCap(w io.Writer, size, limit int64) bool {
+	path, err := history.Path()
+	if err != nil {
+		log.Printf("Monthly cap not checked: %v", err)
+		return true
+	}
+	entries, err := history.Load(path)
+	if err != nil {
+		log.Printf("Monthly cap not checked: %v", err)
+		return true
+	}
+	ask := confirm
+	if !stdinIsTerminal() {
+		ask = nil
+	}
+	return capAllows(w, entries, size, limit, time.Now(), ask)
+}
+
+// capAllows reports whether a download of size bytes, 0 if unknown, may go ahead under
+// the monthly cap limit, counting the entries of the history in the calendar month of
+// now. A pull of unknown size counts as over once the cap is reached. If it would go
+// over, capAllows warns on w and asks with ask; a nil ask refuses the pull.
+func capAllows(w io.Writer, entries []history.Entry, size, limit int64, now time.Time, ask func(question string) bool) bool {
+	used := history.MonthBytes(entries, now)
+	if size > 0 && used+size <= limit || size == 0 && used < limit {
+		return true
+	}
+	if size > 0 {
+		fmt.Fprintf(w, "Warning: %s downloaded this month; this pull of up to %s would go over the %s monthly cap.\n",
+			ui.FormatBytes(used), ui.FormatBytes(size), ui.FormatBytes(limit))
+	} else {
+		fmt.Fprintf(w, "Warning: %s downloaded this month, which reaches the %s monthly cap.\n", ui.FormatBytes(used), ui.FormatBytes(limit))
+	}
+	if ask == nil {
+		fmt.Fprintln(w, "Not pulling without confirmation; raise --monthly-cap to allow it.")
+		return false
+	}
+	return ask("Pull anyway?")
+}
+
+// recordUsage adds a pull of model to host that downloaded bytes after retries to the
+// history, so `usage` and the monthly cap can count it.
+func recordUsage(model, host string, bytes int64, retries stats.RetryCounts, reason string) {
+	path, err := history.Path()
+	if err != nil {
+		log.Printf("Recording history: %v", err)
+		return
+	}
+	entry := history.Entry{Model: model, Host: host, Reason: reason, Bytes: bytes, Retries: retries.Strings()}
+	if err := history.Append(path, entry); err != nil {
+		log.Printf("Recording history: %v", err)
+	}
+}
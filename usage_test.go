@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"ollama-downloader-v2/history"
+)
+
+func TestCapAllows(t *testing.T) {
+	const gb = 1 << 30
+	lastOfMay := time.Date(2024, 5, 31, 23, 59, 0, 0, time.Local)
+	firstOfJune := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	entries := []history.Entry{
+		{Time: time.Date(2024, 4, 30, 12, 0, 0, 0, time.Local), Bytes: 500 * gb},
+		{Time: time.Date(2024, 5, 2, 12, 0, 0, 0, time.Local), Bytes: 60 * gb},
+		{Time: lastOfMay.Add(-time.Hour), Bytes: 30 * gb},
+	}
+	tests := []struct {
+		name  string
+		size  int64
+		now   time.Time
+		allow bool
+		warn  string
+	}{
+		{name: "fits", size: 10 * gb, now: lastOfMay, allow: true},
+		{name: "would go over", size: 11 * gb, now: lastOfMay, warn: "90.0 GB downloaded this month; this pull of up to 11.0 GB would go over the 100.0 GB monthly cap"},
+		{name: "new month starts from zero", size: 100 * gb, now: firstOfJune, allow: true},
+		{name: "unknown size under the cap", now: lastOfMay, allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			assert.Equal(t, tt.allow, capAllows(&out, entries, tt.size, 100*gb, tt.now, nil))
+			if tt.warn == "" {
+				assert.Empty(t, out.String())
+				return
+			}
+			assert.Contains(t, out.String(), tt.warn)
+			assert.Contains(t, out.String(), "Not pulling without confirmation")
+		})
+	}
+}
+
+func TestCapAllows_Reached(t *testing.T) {
+	const gb = 1 << 30
+	now := time.Date(2024, 5, 20, 12, 0, 0, 0, time.Local)
+	entries := []history.Entry{{Time: now.Add(-time.Hour), Bytes: 100 * gb}}
+
+	var out strings.Builder
+	assert.False(t, capAllows(&out, entries, 0, 100*gb, now, nil), "A pull of unknown size is refused once the cap is reached")
+	assert.Contains(t, out.String(), "which reaches the 100.0 GB monthly cap")
+
+	var asked []string
+	ask := func(question string) bool { asked = append(asked, question); return true }
+	assert.True(t, capAllows(&out, entries, gb, 100*gb, now, ask), "The user may pull anyway")
+	assert.Equal(t, []string{"Pull anyway?"}, asked)
+}
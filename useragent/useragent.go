@@ -0,0 +1,39 @@
+// Package useragent names the downloader in the HTTP requests it sends, so server logs
+// and proxies can tell its traffic apart from that of other Go clients.
+package useragent
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// TagHeader carries Tag.
+const TagHeader = "X-Request-Tag"
+
+// Value is sent as the User-Agent of every request.
+var Value = Default()
+
+// Tag, when set, is sent in TagHeader with every request, e.g. to tell the traffic of
+// one lab or CI job from another's.
+var Tag string
+
+// Default returns "ollama-downloader-v2/<version> (<os>/<arch>)". The version is that
+// of the module as built by `go install`, or "dev" for a local build.
+func Default() string {
+	version := "dev"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	return "ollama-downloader-v2/" + version + " (" + runtime.GOOS + "/" + runtime.GOARCH + ")"
+}
+
+// Set adds the User-Agent and, if set, the request tag to req.
+func Set(req *http.Request) {
+	if Value != "" {
+		req.Header.Set("User-Agent", Value)
+	}
+	if Tag != "" {
+		req.Header.Set(TagHeader, Tag)
+	}
+}
@@ -0,0 +1,22 @@
+package useragent
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSet(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	Set(req)
+	assert.True(t, strings.HasPrefix(req.Header.Get("User-Agent"), "ollama-downloader-v2/"), req.Header.Get("User-Agent"))
+	assert.Empty(t, req.Header.Get(TagHeader), "No tag header without a tag")
+
+	defer func(value, tag string) { Value, Tag = value, tag }(Value, Tag)
+	Value, Tag = "lab-sync/1.0", "room-4"
+	Set(req)
+	assert.Equal(t, "lab-sync/1.0", req.Header.Get("User-Agent"))
+	assert.Equal(t, "room-4", req.Header.Get(TagHeader))
+}
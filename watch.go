@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"ollama-downloader-v2/client"
+	"ollama-downloader-v2/history"
+	"ollama-downloader-v2/registry"
+	"ollama-downloader-v2/stats"
+)
+
+// minWatchInterval keeps watch from hammering the registry.
+const minWatchInterval = time.Minute
+
+// runWatchCommand checks the registry for new versions of the given models every
+// interval and pulls them when one is published, so tags like "latest" stay current.
+func runWatchCommand(args []string) int {
+	fs := newFlagSet("watch")
+	host := fs.String("host", "", "Ollama API host. Overrides the global --host and OLLAMA_HOST.")
+	interval := fs.Duration("interval", 6*time.Hour, "How often to check the registry for new versions")
+	once := fs.Bool("once", false, "Check once and exit, e.g. from cron")
+	bell := addBellFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	if *interval < minWatchInterval {
+		fmt.Printf("Error: --interval must be at least %s\n", minWatchInterval)
+		return 1
+	}
+
+	models := fs.Args()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Ignoring config: %v", err)
+	} else {
+		for i, m := range models {
+			models[i] = cfg.ResolveAlias(m)
+		}
+	}
+	notifiers, err := notifiersFromConfig(cfg)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	historyPath, err := history.Path()
+	if err != nil {
+		log.Printf("History disabled: %v", err)
+	}
+	learnTimeouts()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	target := resolveHost(*host)
+	reg := registry.New()
+	for {
+		status := 0
+		for _, model := range models {
+			updated, err := watchModel(ctx, reg, target, model, historyPath)
+			if ctx.Err() != nil {
+				return 0
+			}
+			if err != nil {
+				log.Printf("Watch of %s: %v", model, err)
+				fmt.Printf("%s  %s: %v\n", time.Now().Format(time.DateTime), model, err)
+				status = 1
+				sendNotifications(notifiers, model, pullResult{Err: err, Host: target}, 0, 0)
+			}
+			if updated {
+				bell.ring(os.Stdout)
+			}
+			if updated && err == nil {
+				sendNotifications(notifiers, model, pullResult{Succeeded: true, Host: target}, 0, 0)
+			}
+		}
+		if *once {
+			return status
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// watchModel pulls model to host if the registry has a different version than the one
+// installed, or it is not installed at all, and records the update in the history at
+// historyPath. It reports whether it pulled.
+func watchModel(ctx context.Context, reg *registry.Client, host, model, historyPath string) (bool, error) {
+	name := registry.ParseName(model)
+	remote, err := reg.ManifestDigest(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	local, err := installedDigest(ctx, host, name.String())
+	if err != nil {
+		return false, err
+	}
+	action := decideWatch(local, remote)
+	fmt.Printf("%s  %s\n", time.Now().Format(time.DateTime), action.describe(name.String(), local, remote))
+	if action == watchUpToDate {
+		log.Printf("%s is up to date (%s)", name, remote)
+		return false, nil
+	}
+	log.Printf("Watch: pulling %s %s over %s", name, remote, local)
+
+	meter := stats.NewMeter()
+	meter.Start(0, nil)
+	if err := pullWithRetries(ctx, client.PullOptions{Model: name.String(), Host: host}, headlessRetries, headlessHooks{Progress: meter.Update, Retried: meter.Retried}); err != nil {
+		return false, fmt.Errorf("pulling the new version: %w", err)
+	}
+	installed, err := installedDigest(ctx, host, name.String())
+	if err != nil {
+		return true, err
+	}
+	if !client.SameDigest(installed, remote) {
+		// The tag moved again during the pull; the next check catches up.
+		log.Printf("Watch: %s installed as %s, registry had %s when the pull started", name, installed, remote)
+	}
+	fmt.Printf("%s  Updated %s to %s.\n", time.Now().Format(time.DateTime), name, stats.ShortDigest(installed))
+	reportRetries(os.Stdout, meter)
+	if historyPath != "" {
+		entry := history.Entry{Model: name.String(), Host: host, Digest: withAlgorithm(installed), Previous: withAlgorithm(local), Reason: history.ReasonWatch, Bytes: meter.Downloaded(), Retries: meter.Retries().Strings()}
+		if err := history.Append(historyPath, entry); err != nil {
+			log.Printf("Recording history: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// watchAction is what a check of a watched model decided to do.
+type watchAction int
+
+const (
+	// watchUpToDate leaves the model alone: the installed version is the registry's.
+	watchUpToDate watchAction = iota
+	// watchInstall pulls a model that is not installed.
+	watchInstall
+	// watchUpdate pulls the new version the registry published.
+	watchUpdate
+)
+
+// decideWatch decides what to do about a model installed as the manifest digest local,
+// "" if it is not installed, whose tag points to remote in the registry.
+func decideWatch(local, remote string) watchAction {
+	switch {
+	case local == "":
+		return watchInstall
+	case client.SameDigest(local, remote):
+		return watchUpToDate
+	default:
+		return watchUpdate
+	}
+}
+
+// describe tells the user what a watch of model does, e.g. "llama3 is up to date
+// (365c0bd3c000)."
+func (a watchAction) describe(model, local, remote string) string {
+	switch a {
+	case watchUpToDate:
+		return fmt.Sprintf("%s is up to date (%s).", model, stats.ShortDigest(remote))
+	case watchInstall:
+		return fmt.Sprintf("%s is not installed, pulling %s...", model, stats.ShortDigest(remote))
+	default:
+		return fmt.Sprintf("%s has a new version %s (installed: %s), pulling...", model, stats.ShortDigest(remote), stats.ShortDigest(local))
+	}
+}
+
+// installedDigest returns the manifest digest of model on host, or "" if it is not
+// installed.
+func installedDigest(ctx context.Context, host, model string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.RequestTimeout)
+	defer cancel()
+	digest, err := client.InstalledDigest(ctx, host, model)
+	switch {
+	case errors.Is(err, client.ErrModelNotFound):
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("listing installed models: %w", err)
+	case digest == "":
+		return "", errors.New("the host does not report model digests")
+	}
+	return digest, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecideWatch(t *testing.T) {
+	const (
+		installed = "sha256:365c0bd3c000a25d28ddbf732fe1c6add414de7275464c4e4d1c3b5fcb5d8ad1"
+		published = "sha256:a6eb4748fd2990ad2952b2335a95a7f952d1a06119a0aa6a2df6cd052a93a3fa"
+	)
+	tests := []struct {
+		name          string
+		local, remote string
+		want          watchAction
+		message       string
+	}{
+		{"same version", installed, installed, watchUpToDate, "llama3 is up to date (365c0bd3c000)."},
+		{"digest without algorithm", "365c0bd3c000a25d28ddbf732fe1c6add414de7275464c4e4d1c3b5fcb5d8ad1", installed, watchUpToDate, "llama3 is up to date (365c0bd3c000)."},
+		{"not installed", "", published, watchInstall, "llama3 is not installed, pulling a6eb4748fd29..."},
+		{"new version", installed, published, watchUpdate, "llama3 has a new version a6eb4748fd29 (installed: 365c0bd3c000), pulling..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := decideWatch(tt.local, tt.remote)
+			assert.Equal(t, tt.want, action)
+			assert.Equal(t, tt.message, action.describe("llama3", tt.local, tt.remote))
+		})
+	}
+}